@@ -27,6 +27,13 @@ func (p *Plugin) OnActivate() error {
 		return err
 	}
 
+	if err := p.loadRuntimeState(); err != nil {
+		return errors.Wrap(err, "failed to load runtime state")
+	}
+
+	if p.metrics == nil {
+		p.metrics = newMetrics()
+	}
 	p.initializeAPI()
 	p.initializeSessionTracking()
 
@@ -58,13 +65,57 @@ func (p *Plugin) OnActivate() error {
 		p.API,
 		"BackgroundJob",
 		cluster.MakeWaitForRoundedInterval(15*time.Minute),
-		p.BackgroundJob,
+		p.metrics.instrumentJob("BackgroundJob", p.BackgroundJob),
 	)
 	if cronErr != nil {
 		return errors.Wrap(cronErr, "failed to schedule background job")
 	}
 	p.backgroundJob = job
 
+	webhookRetryJob, cronErr := cluster.Schedule(
+		p.API,
+		"WebhookRetryJob",
+		cluster.MakeWaitForInterval(webhookRetryInterval),
+		p.metrics.instrumentJob("WebhookRetryJob", p.WebhookRetryJob),
+	)
+	if cronErr != nil {
+		return errors.Wrap(cronErr, "failed to schedule webhook retry job")
+	}
+	p.webhookRetryJob = webhookRetryJob
+
+	digestJob, cronErr := cluster.Schedule(
+		p.API,
+		"DigestJob",
+		p.digestWaitInterval,
+		p.metrics.instrumentJob("DigestJob", p.DigestJob),
+	)
+	if cronErr != nil {
+		return errors.Wrap(cronErr, "failed to schedule digest job")
+	}
+	p.digestJob = digestJob
+
+	scheduledPostsJob, cronErr := cluster.Schedule(
+		p.API,
+		"ScheduledPostsJob",
+		cluster.MakeWaitForInterval(scheduledPostsInterval),
+		p.metrics.instrumentJob("ScheduledPostsJob", p.ScheduledPostsJob),
+	)
+	if cronErr != nil {
+		return errors.Wrap(cronErr, "failed to schedule scheduled posts job")
+	}
+	p.scheduledPostsJob = scheduledPostsJob
+
+	remindersJob, cronErr := cluster.Schedule(
+		p.API,
+		"RemindersJob",
+		cluster.MakeWaitForInterval(remindersInterval),
+		p.metrics.instrumentJob("RemindersJob", p.RemindersJob),
+	)
+	if cronErr != nil {
+		return errors.Wrap(cronErr, "failed to schedule reminders job")
+	}
+	p.remindersJob = remindersJob
+
 	return nil
 }
 
@@ -81,6 +132,30 @@ func (p *Plugin) OnDeactivate() error {
 		}
 	}
 
+	if p.webhookRetryJob != nil {
+		if err := p.webhookRetryJob.Close(); err != nil {
+			p.API.LogError("Failed to close webhook retry job", "err", err)
+		}
+	}
+
+	if p.digestJob != nil {
+		if err := p.digestJob.Close(); err != nil {
+			p.API.LogError("Failed to close digest job", "err", err)
+		}
+	}
+
+	if p.scheduledPostsJob != nil {
+		if err := p.scheduledPostsJob.Close(); err != nil {
+			p.API.LogError("Failed to close scheduled posts job", "err", err)
+		}
+	}
+
+	if p.remindersJob != nil {
+		if err := p.remindersJob.Close(); err != nil {
+			p.API.LogError("Failed to close reminders job", "err", err)
+		}
+	}
+
 	teams, err := p.API.GetTeams()
 	if err != nil {
 		return errors.Wrap(err, "failed to query teams OnDeactivate")
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// autoResponderFallbackKeyword is the keyword of the rule used when no other rule matches.
+const autoResponderFallbackKeyword = "*"
+
+// autoResponderRule is a rule of the auto-responder, replying with the template to the messages
+// containing the keyword.
+type autoResponderRule struct {
+	keyword  string
+	template *template.Template
+}
+
+// parseAutoResponderRules parses the AutoResponderRules setting, one rule per line as the
+// keyword, a colon and the template of the reply, e.g.
+//
+//	hello: Hi @{{.User.Username}}!
+//
+// Keywords are matched case insensitively, in order, the * keyword matching any message when
+// no other rule does. Templates are executed with the same data as the hook templates.
+func parseAutoResponderRules(setting string) ([]autoResponderRule, error) {
+	var rules []autoResponderRule
+	keywords := make(map[string]bool)
+	for i, line := range strings.Split(setting, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		keyword, text, ok := strings.Cut(line, ":")
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if !ok || keyword == "" {
+			return nil, errors.Errorf("line %d: expected <keyword>: <reply>", i+1)
+		}
+		if keywords[keyword] {
+			return nil, errors.Errorf("line %d: duplicate rule for %q", i+1, keyword)
+		}
+		keywords[keyword] = true
+
+		tmpl, err := parseAutoResponderTemplate(keyword, text)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", i+1)
+		}
+
+		rules = append(rules, autoResponderRule{keyword: keyword, template: tmpl})
+	}
+
+	return rules, nil
+}
+
+// parseAutoResponderTemplate parses the template of a reply, validated against the sample data of
+// MessageHasBeenPosted, which replies are rendered with. Team is nil in direct messages.
+func parseAutoResponderTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(strings.TrimSpace(text))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tmpl.Execute(io.Discard, hookTemplateSamples[hookMessageHasBeenPosted]); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// matchAutoResponderRule returns the template of the first rule whose keyword the message
+// contains, or of the fallback rule, if any.
+func matchAutoResponderRule(rules []autoResponderRule, message string) *template.Template {
+	message = strings.ToLower(message)
+
+	var fallback *template.Template
+	for _, rule := range rules {
+		if rule.keyword == autoResponderFallbackKeyword {
+			fallback = rule.template
+			continue
+		}
+		if strings.Contains(message, rule.keyword) {
+			return rule.template
+		}
+	}
+
+	return fallback
+}
+
+// activeHours are the days and hours the demo user is available, in a time zone.
+type activeHours struct {
+	days     [7]bool
+	start    int
+	end      int
+	location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseActiveHours parses the AutoResponderActiveHours setting, as optional days, a time range
+// and an optional IANA time zone, e.g. "Mon-Fri 09:00-17:30 Europe/Paris". Days are a comma
+// separated list of days or ranges of days, every day by default. The time range may span
+// midnight, e.g. "22:00-06:00", and times are in UTC by default. It returns nil when the setting
+// is empty, the demo user being always available.
+func parseActiveHours(setting string) (*activeHours, error) {
+	fields := strings.Fields(setting)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	hours := &activeHours{location: time.UTC}
+	for i := range hours.days {
+		hours.days[i] = true
+	}
+
+	if !strings.Contains(fields[0], ":") {
+		days, err := parseWeekdays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		hours.days = days
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, errors.Errorf("expected [days] <HH:MM-HH:MM> [time zone], got %q", setting)
+	}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return nil, errors.Errorf("invalid time range %q, expected HH:MM-HH:MM", fields[0])
+	}
+	var err error
+	if hours.start, err = parseClock(start); err != nil {
+		return nil, err
+	}
+	if hours.end, err = parseClock(end); err != nil {
+		return nil, err
+	}
+	if hours.start == hours.end {
+		return nil, errors.Errorf("empty time range %q", fields[0])
+	}
+
+	if len(fields) == 2 {
+		location, err := time.LoadLocation(fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time zone %q", fields[1])
+		}
+		hours.location = location
+	}
+
+	return hours, nil
+}
+
+// parseWeekdays parses a comma separated list of days or ranges of days, e.g. "Mon-Fri,Sun".
+func parseWeekdays(value string) ([7]bool, error) {
+	var days [7]bool
+	for _, item := range strings.Split(strings.ToLower(value), ",") {
+		first, last, isRange := strings.Cut(item, "-")
+		from, ok := weekdays[first]
+		if !ok {
+			return days, errors.Errorf("unknown day %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdays[last]; !ok {
+				return days, errors.Errorf("unknown day %q", last)
+			}
+		}
+
+		// Ranges may wrap around the week, e.g. Fri-Mon.
+		for day := from; ; day = (day + 1) % 7 {
+			days[day] = true
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return days, nil
+}
+
+// parseClock parses a time of the day as HH:MM, returning the number of minutes since midnight.
+func parseClock(value string) (int, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, hErr := strconv.Atoi(hours)
+	m, mErr := strconv.Atoi(minutes)
+	if !ok || hErr != nil || mErr != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, errors.Errorf("invalid time %q, expected HH:MM", value)
+	}
+
+	return h*60 + m, nil
+}
+
+// contains reports whether the given time is within the active hours.
+func (h *activeHours) contains(t time.Time) bool {
+	t = t.In(h.location)
+	minutes := t.Hour()*60 + t.Minute()
+
+	if h.start < h.end {
+		return h.days[t.Weekday()] && minutes >= h.start && minutes < h.end
+	}
+
+	// The range spans midnight, the hours after midnight belonging to the previous day.
+	if minutes >= h.start {
+		return h.days[t.Weekday()]
+	}
+	return minutes < h.end && h.days[(t.Weekday()+6)%7]
+}
+
+// isDemoUserAddressed reports whether the post mentions the demo user or is a direct message to
+// the demo user.
+func (p *Plugin) isDemoUserAddressed(post *model.Post, channel *model.Channel) bool {
+	configuration := p.getConfiguration()
+	if strings.Contains(post.Message, fmt.Sprintf("@%s", configuration.Username)) {
+		return true
+	}
+
+	return channel.Type == model.ChannelTypeDirect && strings.Contains(channel.Name, configuration.demoUserID)
+}
+
+// autoRespond replies in the thread of the post as the demo user, with the reply of the first
+// matching rule, or with the away message outside of the active hours. The team is looked up
+// when the data doesn't include it.
+func (p *Plugin) autoRespond(post *model.Post, teamID string, data hookTemplateData) error {
+	configuration := p.getConfiguration()
+
+	tmpl := matchAutoResponderRule(configuration.autoResponderRules, post.Message)
+	if configuration.autoResponderActiveHours != nil && !configuration.autoResponderActiveHours.contains(time.Now()) {
+		tmpl = configuration.autoResponderAwayTemplate
+	}
+	if tmpl == nil {
+		return nil
+	}
+
+	if data.Team == nil && teamID != "" {
+		if team, appErr := p.API.GetTeam(teamID); appErr == nil {
+			data.Team = newTemplateTeam(team)
+		}
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return errors.Wrap(err, "failed to execute auto-responder template")
+	}
+	if strings.TrimSpace(sb.String()) == "" {
+		return nil
+	}
+
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = post.Id
+	}
+
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    configuration.demoUserID,
+		ChannelId: post.ChannelId,
+		RootId:    rootID,
+		Message:   sb.String(),
+	}); appErr != nil {
+		return errors.Wrap(appErr, "failed to post auto-responder reply")
+	}
+
+	return nil
+}
+
+// parseAutoResponder parses the auto-responder settings into the configuration.
+func (c *configuration) parseAutoResponder() error {
+	rules, err := parseAutoResponderRules(c.AutoResponderRules)
+	if err != nil {
+		return errors.Wrap(err, "invalid Auto-Responder Rules")
+	}
+
+	activeHours, err := parseActiveHours(c.AutoResponderActiveHours)
+	if err != nil {
+		return errors.Wrap(err, "invalid Auto-Responder Active Hours")
+	}
+
+	var awayTemplate *template.Template
+	if strings.TrimSpace(c.AutoResponderAwayMessage) != "" {
+		if awayTemplate, err = parseAutoResponderTemplate("away", c.AutoResponderAwayMessage); err != nil {
+			return errors.Wrap(err, "invalid Auto-Responder Away Message")
+		}
+	}
+
+	c.autoResponderRules = rules
+	c.autoResponderActiveHours = activeHours
+	c.autoResponderAwayTemplate = awayTemplate
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseAutoResponderRules(t *testing.T) {
+	rules, err := parseAutoResponderRules("*: Hi, I'm the demo user.\n\nHello: Hi @{{.User.Username}}!\nhelp: Try saying hello.")
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+
+	render := func(message string) string {
+		tmpl := matchAutoResponderRule(rules, message)
+		require.NotNil(t, tmpl)
+		var sb strings.Builder
+		require.NoError(t, tmpl.Execute(&sb, hookTemplateSamples[hookMessageHasBeenPosted]))
+		return sb.String()
+	}
+
+	assert.Equal(t, "Hi @username!", render("HELLO there"))
+	assert.Equal(t, "Try saying hello.", render("help me"))
+	assert.Equal(t, "Hi, I'm the demo user.", render("what's up?"), "the fallback rule matches last")
+
+	rules, err = parseAutoResponderRules("hello: Hi!")
+	require.NoError(t, err)
+	assert.Nil(t, matchAutoResponderRule(rules, "what's up?"))
+
+	for name, setting := range map[string]string{
+		"missing colon":     "hello",
+		"missing keyword":   ": Hi!",
+		"duplicate keyword": "hello: Hi!\nHELLO: Hey!",
+		"invalid template":  "hello: {{.User.Username",
+		"unknown field":     "hello: {{.User.Email}}",
+		"unset field":       "hello: {{.FileName}} in {{.Team.Name}}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseAutoResponderRules(setting)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestActiveHours(t *testing.T) {
+	at := func(value string) time.Time {
+		tm, err := time.Parse(time.RFC3339, value)
+		require.NoError(t, err)
+		return tm
+	}
+
+	hours, err := parseActiveHours("")
+	require.NoError(t, err)
+	assert.Nil(t, hours)
+
+	hours, err = parseActiveHours("Mon-Fri 09:00-17:30 Europe/Paris")
+	require.NoError(t, err)
+	assert.True(t, hours.contains(at("2024-01-15T08:00:00Z")), "Monday 9:00 in Paris")
+	assert.False(t, hours.contains(at("2024-01-15T07:59:00Z")))
+	assert.False(t, hours.contains(at("2024-01-15T16:30:00Z")), "the range excludes its end")
+	assert.False(t, hours.contains(at("2024-01-13T12:00:00Z")), "Saturday")
+
+	hours, err = parseActiveHours("Fri-Sun,Wed 22:00-06:00")
+	require.NoError(t, err)
+	assert.True(t, hours.contains(at("2024-01-19T23:00:00Z")), "Friday night")
+	assert.True(t, hours.contains(at("2024-01-22T05:00:00Z")), "Monday early morning belongs to Sunday")
+	assert.False(t, hours.contains(at("2024-01-22T23:00:00Z")), "Monday night")
+	assert.True(t, hours.contains(at("2024-01-17T22:00:00Z")), "Wednesday night")
+	assert.False(t, hours.contains(at("2024-01-19T12:00:00Z")))
+
+	for _, setting := range []string{
+		"Mon-Fri",
+		"Mon-Fri 9-17",
+		"Mon-Fro 09:00-17:00",
+		"09:00-25:00",
+		"09:00-09:00",
+		"09:00-17:00 Mars/Olympus",
+		"Mon 09:00-17:00 UTC extra",
+	} {
+		_, err := parseActiveHours(setting)
+		assert.Error(t, err, setting)
+	}
+}
+
+func TestAutoRespond(t *testing.T) {
+	cluster := newFakeCluster()
+	node := cluster.addNode(t)
+	configuration := node.getConfiguration().Clone()
+	configuration.Username = "demo_plugin"
+	configuration.demoUserID = "demouser"
+	configuration.AutoResponderRules = "hello: Hi @{{.User.Username}} from {{with .Team}}{{.Name}}{{end}}!"
+	configuration.AutoResponderActiveHours = "00:00-24:00"
+	require.NoError(t, configuration.parseAutoResponder())
+	node.setConfiguration(configuration)
+
+	api := node.API.(*fakeNodeAPI).API
+	api.On("GetTeam", "team1").Return(&model.Team{Id: "team1", Name: "team"}, nil)
+	var reply *model.Post
+	api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) { reply = args.Get(0).(*model.Post) }).Return(&model.Post{}, nil)
+
+	channel := &model.Channel{Id: "channel1", Type: model.ChannelTypeOpen}
+	post := &model.Post{Id: "post1", ChannelId: "channel1", Message: "hello @demo_plugin"}
+	assert.True(t, node.isDemoUserAddressed(post, channel))
+	assert.True(t, node.isDemoUserAddressed(&model.Post{Message: "hello"}, &model.Channel{Type: model.ChannelTypeDirect, Name: "demouser__user1"}))
+	assert.False(t, node.isDemoUserAddressed(&model.Post{Message: "hello"}, channel))
+
+	data := hookTemplateData{User: &templateUser{Username: "jane"}, Channel: newTemplateChannel(channel)}
+	require.NoError(t, node.autoRespond(post, "team1", data))
+	require.NotNil(t, reply)
+	assert.Equal(t, "demouser", reply.UserId)
+	assert.Equal(t, "post1", reply.RootId)
+	assert.Equal(t, "Hi @jane from team!", reply.Message)
+
+	reply = nil
+	require.NoError(t, node.autoRespond(&model.Post{Id: "post2", ChannelId: "channel1", Message: "bye"}, "team1", data))
+	assert.Nil(t, reply, "no reply without a matching rule")
+}
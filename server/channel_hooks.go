@@ -9,21 +9,48 @@ import (
 
 // ChannelHasBeenCreated is invoked after the channel has been committed to the database.
 //
-// This demo implementation logs a message to the demo channel whenever a channel is created.
+// This demo implementation logs a message to the demo channel whenever a channel is created,
+// and holds the channel to the channel policy of its team.
 func (p *Plugin) ChannelHasBeenCreated(c *plugin.Context, channel *model.Channel) {
+	event := newHookEvent(hookChannelHasBeenCreated)
+	event.TeamID = channel.TeamId
+	event.ChannelID = channel.Id
+	event.UserID = channel.CreatorId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookChannelHasBeenCreated) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
+	enforced, err := p.applyChannelPolicy(channel)
+	if err != nil {
+		p.API.LogError(
+			"Failed to apply channel policy",
+			"channel_id", channel.Id,
+			"error", err.Error(),
+		)
+		event.fail(err)
+	}
+
+	data := hookTemplateData{Channel: newTemplateChannel(channel)}
 	msg := fmt.Sprintf("ChannelHasBeenCreated: ~%s", channel.Name)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	switch enforced {
+	case channelPolicyFlag:
+		msg += " (flagged by the channel policy)"
+	case channelPolicyArchive:
+		msg += " (archived by the channel policy)"
+		event.reject("channel policy")
+	}
+	if err := p.postHookMessage(hookChannelHasBeenCreated, channel.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post ChannelHasBeenCreated message",
 			"channel_id", channel.Id,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
 
@@ -32,9 +59,15 @@ func (p *Plugin) ChannelHasBeenCreated(c *plugin.Context, channel *model.Channel
 //
 // This demo implementation logs a message to the demo channel whenever a user joins a channel.
 func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	event := newHookEvent(hookUserHasJoinedChannel)
+	event.ChannelID = channelMember.ChannelId
+	event.UserID = channelMember.UserId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookUserHasJoinedChannel) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -45,6 +78,7 @@ func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.Ch
 			"user_id", channelMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -55,16 +89,20 @@ func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.Ch
 			"channel_id", channelMember.ChannelId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
+	event.TeamID = channel.TeamId
 
+	data := hookTemplateData{User: newTemplateUser(user), Channel: newTemplateChannel(channel)}
 	msg := fmt.Sprintf("UserHasJoinedChannel: @%s, ~%s", user.Username, channel.Name)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookUserHasJoinedChannel, channel.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post UserHasJoinedChannel message",
 			"user_id", channelMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
 
@@ -74,9 +112,15 @@ func (p *Plugin) UserHasJoinedChannel(c *plugin.Context, channelMember *model.Ch
 // This demo implementation logs a message to the demo channel whenever a user leaves a
 // channel.
 func (p *Plugin) UserHasLeftChannel(c *plugin.Context, channelMember *model.ChannelMember, actor *model.User) {
+	event := newHookEvent(hookUserHasLeftChannel)
+	event.ChannelID = channelMember.ChannelId
+	event.UserID = channelMember.UserId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookUserHasLeftChannel) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -87,6 +131,7 @@ func (p *Plugin) UserHasLeftChannel(c *plugin.Context, channelMember *model.Chan
 			"user_id", channelMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -97,15 +142,19 @@ func (p *Plugin) UserHasLeftChannel(c *plugin.Context, channelMember *model.Chan
 			"channel_id", channelMember.ChannelId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
+	event.TeamID = channel.TeamId
 
+	data := hookTemplateData{User: newTemplateUser(user), Channel: newTemplateChannel(channel)}
 	msg := fmt.Sprintf("UserHasLeftChannel: @%s, ~%s", user.Username, channel.Name)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookUserHasLeftChannel, channel.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post UserHasLeftChannel message",
 			"user_id", channelMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
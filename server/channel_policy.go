@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// channelPolicyKeyPrefix prefixes the KV keys of the channel policy of each team.
+	channelPolicyKeyPrefix = "channel_policy_"
+
+	// channelPolicyTeamsKey is the KV key of the ids of the teams with a channel policy, so that
+	// the policies are listed without scanning the KV store.
+	channelPolicyTeamsKey = "channel_policy_teams"
+
+	// channelPolicyFlag flags the channels whose names don't follow the conventions with a post
+	// in the channel and a direct message to the creator.
+	channelPolicyFlag = "flag"
+
+	// channelPolicyArchive archives the channels whose names don't follow the conventions and
+	// lets the creator know with a direct message.
+	channelPolicyArchive = "archive"
+
+	maxChannelPolicyPatterns = 20
+	maxChannelPolicyMembers  = 20
+)
+
+// channelPolicy is the policy the channels created in a team are held to.
+type channelPolicy struct {
+	// Patterns are the naming conventions, glob patterns such as proj-* matched against the
+	// names of the channels. Patterns without wildcards are prefixes. Any name is accepted when
+	// there are none.
+	Patterns []string `json:"patterns,omitempty"`
+
+	// Enforcement is channelPolicyFlag or channelPolicyArchive, flag by default.
+	Enforcement string `json:"enforcement,omitempty"`
+
+	// HeaderTemplate and PurposeTemplate are the templates of the header and the purpose of the
+	// channels created without one, executed with a hookTemplateData.
+	HeaderTemplate  string `json:"header_template,omitempty"`
+	PurposeTemplate string `json:"purpose_template,omitempty"`
+
+	// AddBot adds the bot to the public channels created.
+	AddBot bool `json:"add_bot,omitempty"`
+
+	// MemberIDs are the users added to the public channels created. Private channels are left to
+	// their creators, whose members may not expect anyone else to read them.
+	MemberIDs []string `json:"member_ids,omitempty"`
+
+	UpdatedBy string `json:"updated_by,omitempty"`
+	UpdatedAt int64  `json:"updated_at,omitempty"`
+}
+
+// matchesName reports whether the channel name follows one of the naming conventions.
+func (c *channelPolicy) matchesName(name string) bool {
+	if len(c.Patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range c.Patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			if strings.HasPrefix(name, pattern) {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateChannelPolicyPattern checks that the pattern can match channel names, which are made
+// of lowercase letters, digits, dashes and underscores.
+func validateChannelPolicyPattern(pattern string) error {
+	if pattern == "" || pattern != strings.ToLower(pattern) || strings.Contains(pattern, "/") {
+		return errors.Errorf("invalid pattern %q, expected a lowercase prefix such as proj- or a glob such as proj-*", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return errors.Errorf("invalid pattern %q", pattern)
+	}
+
+	return nil
+}
+
+// channelPolicyTemplateSamples holds the data the header and purpose templates may be executed
+// with: the creator of the channel isn't set when it can't be looked up.
+var channelPolicyTemplateSamples = []hookTemplateData{
+	{User: sampleTemplateUser, Channel: sampleTemplateChannel, Team: sampleTemplateTeam},
+	{Channel: sampleTemplateChannel, Team: sampleTemplateTeam},
+}
+
+// parseChannelPolicyTemplate parses a header or purpose template, checking that it only refers
+// to known fields, and to the creator only if set, e.g. {{with .User}}@{{.Username}}{{end}}.
+func parseChannelPolicyTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range channelPolicyTemplateSamples {
+		if err := tmpl.Execute(io.Discard, data); err != nil {
+			return nil, err
+		}
+	}
+
+	return tmpl, nil
+}
+
+// renderChannelPolicyTemplate renders the template, returning an empty string if it is empty
+// or fails.
+func (p *Plugin) renderChannelPolicyTemplate(name, text string, data hookTemplateData) string {
+	if text == "" {
+		return ""
+	}
+
+	tmpl, err := parseChannelPolicyTemplate(name, text)
+	if err != nil {
+		p.API.LogWarn("Failed to parse channel policy template", "template", name, "err", err.Error())
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		p.API.LogWarn("Failed to execute channel policy template", "template", name, "err", err.Error())
+		return ""
+	}
+
+	return strings.TrimSpace(buf.String())
+}
+
+// getChannelPolicy returns the channel policy of the team, or nil if it has none. Channels are
+// created rarely enough for the policy to be read from the KV store every time.
+func (p *Plugin) getChannelPolicy(teamID string) (*channelPolicy, error) {
+	var policy *channelPolicy
+	if err := p.client.KV.Get(channelPolicyKeyPrefix+teamID, &policy); err != nil {
+		return nil, errors.Wrap(err, "failed to get channel policy")
+	}
+
+	return policy, nil
+}
+
+// listChannelPolicies returns the channel policies of the teams by team id.
+func (p *Plugin) listChannelPolicies() (map[string]*channelPolicy, error) {
+	var teamIDs []string
+	if err := p.client.KV.Get(channelPolicyTeamsKey, &teamIDs); err != nil {
+		return nil, errors.Wrap(err, "failed to get channel policy teams")
+	}
+
+	policies := make(map[string]*channelPolicy, len(teamIDs))
+	for _, teamID := range teamIDs {
+		policy, err := p.getChannelPolicy(teamID)
+		if err != nil {
+			return nil, err
+		}
+		if policy != nil {
+			policies[teamID] = policy
+		}
+	}
+
+	return policies, nil
+}
+
+// updateChannelPolicyTeams atomically adds the team to, or removes it from, the index of the teams
+// with a channel policy.
+func (p *Plugin) updateChannelPolicyTeams(teamID string, add bool) error {
+	return p.updateKV(channelPolicyTeamsKey, 0, func(oldValue []byte) (any, error) {
+		var teamIDs []string
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &teamIDs); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal channel policy teams")
+			}
+		}
+
+		teamIDs = slices.DeleteFunc(teamIDs, func(id string) bool { return id == teamID })
+		if add {
+			teamIDs = append(teamIDs, teamID)
+		}
+		if len(teamIDs) == 0 {
+			return nil, nil
+		}
+
+		return teamIDs, nil
+	})
+}
+
+// updateChannelPolicy atomically applies the given change to the channel policy of the team, and
+// indexes the team.
+func (p *Plugin) updateChannelPolicy(teamID, userID string, change func(policy *channelPolicy) error) error {
+	err := p.updateKV(channelPolicyKeyPrefix+teamID, 0, func(oldValue []byte) (any, error) {
+		policy := &channelPolicy{}
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, policy); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal channel policy")
+			}
+		}
+
+		if err := change(policy); err != nil {
+			return nil, err
+		}
+		policy.UpdatedBy = userID
+		policy.UpdatedAt = model.GetMillis()
+
+		return policy, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.updateChannelPolicyTeams(teamID, true)
+}
+
+// applyChannelPolicy holds the channel to the policy of its team, if any. Channels whose names
+// don't follow the conventions are flagged or archived, and the creator is told why. The
+// channels that aren't archived get the header and purpose templates, when created without
+// them, and the members listed. It returns channelPolicyFlag or channelPolicyArchive if the
+// channel was flagged or archived. Direct messages and the channels created by the system or the
+// bot are exempt.
+func (p *Plugin) applyChannelPolicy(channel *model.Channel) (string, error) {
+	if channel.TeamId == "" || channel.CreatorId == "" || channel.CreatorId == p.botID {
+		return "", nil
+	}
+
+	policy, err := p.getChannelPolicy(channel.TeamId)
+	if err != nil || policy == nil {
+		return "", err
+	}
+
+	team, appErr := p.API.GetTeam(channel.TeamId)
+	if appErr != nil {
+		return "", errors.Wrap(appErr, "failed to get team")
+	}
+
+	var enforced string
+	if !policy.matchesName(channel.Name) {
+		violation := fmt.Sprintf("the name `%s` doesn't follow the naming conventions of %s: %s", channel.Name, team.DisplayName, formatChannelPolicyPatterns(policy.Patterns))
+
+		if policy.Enforcement == channelPolicyArchive {
+			if appErr := p.API.DeleteChannel(channel.Id); appErr != nil {
+				return "", errors.Wrap(appErr, "failed to archive channel")
+			}
+			p.notifyChannelPolicyViolation(channel, fmt.Sprintf("The channel ~%s you created was archived because %s. Please create it again with a name that follows them.", channel.Name, violation))
+			return channelPolicyArchive, nil
+		}
+
+		if _, appErr := p.API.CreatePost(&model.Post{
+			UserId:    p.botID,
+			ChannelId: channel.Id,
+			Message:   fmt.Sprintf(":warning: This channel was flagged because %s. A channel admin can rename it.", violation),
+		}); appErr != nil {
+			p.API.LogWarn("Failed to flag channel", "channel_id", channel.Id, "err", appErr.Error())
+		}
+		p.notifyChannelPolicyViolation(channel, fmt.Sprintf("The channel ~%s you created was flagged because %s. Please rename it.", channel.Name, violation))
+		enforced = channelPolicyFlag
+	}
+
+	data := hookTemplateData{Channel: newTemplateChannel(channel), Team: newTemplateTeam(team)}
+	if creator, appErr := p.API.GetUser(channel.CreatorId); appErr == nil {
+		data.User = newTemplateUser(creator)
+	}
+
+	updated := false
+	if channel.Header == "" {
+		if header := p.renderChannelPolicyTemplate("header", policy.HeaderTemplate, data); header != "" {
+			channel.Header = header
+			updated = true
+		}
+	}
+	if channel.Purpose == "" {
+		if purpose := p.renderChannelPolicyTemplate("purpose", policy.PurposeTemplate, data); purpose != "" {
+			channel.Purpose = purpose
+			updated = true
+		}
+	}
+	if updated {
+		if _, appErr := p.API.UpdateChannel(channel); appErr != nil {
+			return enforced, errors.Wrap(appErr, "failed to apply channel templates")
+		}
+	}
+
+	if channel.Type != model.ChannelTypeOpen {
+		return enforced, nil
+	}
+
+	memberIDs := policy.MemberIDs
+	if policy.AddBot {
+		memberIDs = append([]string{p.botID}, memberIDs...)
+	}
+	for _, userID := range memberIDs {
+		if userID == channel.CreatorId {
+			continue
+		}
+		if _, appErr := p.API.AddChannelMember(channel.Id, userID); appErr != nil {
+			p.API.LogWarn("Failed to add channel member", "channel_id", channel.Id, "user_id", userID, "err", appErr.Error())
+		}
+	}
+
+	return enforced, nil
+}
+
+// notifyChannelPolicyViolation sends a direct message from the bot to the creator of the channel.
+func (p *Plugin) notifyChannelPolicyViolation(channel *model.Channel, message string) {
+	dm, appErr := p.API.GetDirectChannel(channel.CreatorId, p.botID)
+	if appErr != nil {
+		p.API.LogWarn("Failed to get direct channel", "user_id", channel.CreatorId, "err", appErr.Error())
+		return
+	}
+
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: dm.Id,
+		Message:   message,
+	}); appErr != nil {
+		p.API.LogWarn("Failed to notify channel creator", "user_id", channel.CreatorId, "err", appErr.Error())
+	}
+}
+
+func formatChannelPolicyPatterns(patterns []string) string {
+	quoted := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		quoted = append(quoted, "`"+pattern+"`")
+	}
+
+	return strings.Join(quoted, ", ")
+}
+
+// executeCommandNaming shows and changes the channel policy of the team. Anyone can see it, and
+// team admins can change it.
+func (p *Plugin) executeCommandNaming(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if len(params) == 0 || (params[0] == "show" && len(params) == 1) {
+		policy, err := p.getChannelPolicy(args.TeamId)
+		if err != nil {
+			p.API.LogError("Failed to get channel policy", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to get the channel policy of the team.")
+		}
+		return respond(p.channelPolicySummary(policy))
+	}
+
+	if !p.API.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionManageTeam) {
+		return respond("Only team admins can change the channel policy of the team.")
+	}
+
+	action, values := params[0], params[1:]
+
+	if action == "clear" {
+		if len(values) > 0 {
+			return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+		}
+		if err := p.client.KV.Delete(channelPolicyKeyPrefix + args.TeamId); err != nil {
+			p.API.LogError("Failed to delete channel policy", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to clear the channel policy of the team.")
+		}
+		if err := p.updateChannelPolicyTeams(args.TeamId, false); err != nil {
+			p.API.LogWarn("Failed to unindex channel policy", "team_id", args.TeamId, "err", err.Error())
+		}
+		return respond("Cleared the channel policy of the team.")
+	}
+
+	var change func(policy *channelPolicy) error
+	var done string
+
+	switch action {
+	case "patterns":
+		if len(values) > maxChannelPolicyPatterns {
+			return respond(fmt.Sprintf("There can't be more than %d patterns.", maxChannelPolicyPatterns))
+		}
+		for _, pattern := range values {
+			if err := validateChannelPolicyPattern(pattern); err != nil {
+				return respond(fmt.Sprintf("Invalid channel policy: %s.", err.Error()))
+			}
+		}
+		change = func(policy *channelPolicy) error {
+			policy.Patterns = values
+			return nil
+		}
+		done = "Channel names can be anything."
+		if len(values) > 0 {
+			done = "Channel names must now match " + formatChannelPolicyPatterns(values) + "."
+		}
+
+	case "enforce":
+		if len(values) != 1 || (values[0] != channelPolicyFlag && values[0] != channelPolicyArchive) {
+			return respond("Usage: `/demo_plugin naming enforce <flag|archive>`.")
+		}
+		change = func(policy *channelPolicy) error {
+			policy.Enforcement = values[0]
+			return nil
+		}
+		done = "Channels whose names don't follow the conventions will be flagged."
+		if values[0] == channelPolicyArchive {
+			done = "Channels whose names don't follow the conventions will be archived."
+		}
+
+	case "header", "purpose":
+		text := strings.Join(values, " ")
+		if _, err := parseChannelPolicyTemplate(action, text); err != nil {
+			return respond(fmt.Sprintf("Invalid %s template: %s.", action, err.Error()))
+		}
+		change = func(policy *channelPolicy) error {
+			if action == "header" {
+				policy.HeaderTemplate = text
+			} else {
+				policy.PurposeTemplate = text
+			}
+			return nil
+		}
+		done = fmt.Sprintf("Removed the %s template.", action)
+		if text != "" {
+			done = fmt.Sprintf("Channels created without a %s will get `%s`.", action, text)
+		}
+
+	case "members":
+		if len(values) > maxChannelPolicyMembers {
+			return respond(fmt.Sprintf("There can't be more than %d members.", maxChannelPolicyMembers))
+		}
+		addBot := false
+		var memberIDs, usernames []string
+		for _, value := range values {
+			if value == "bot" {
+				addBot = true
+				usernames = append(usernames, "the bot")
+				continue
+			}
+			user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(value, "@"))
+			if appErr != nil {
+				return respond(fmt.Sprintf("Unknown user %s.", value))
+			}
+			memberIDs = append(memberIDs, user.Id)
+			usernames = append(usernames, "@"+user.Username)
+		}
+		change = func(policy *channelPolicy) error {
+			policy.AddBot = addBot
+			policy.MemberIDs = memberIDs
+			return nil
+		}
+		done = "Nobody will be added to the channels created."
+		if len(usernames) > 0 {
+			done = fmt.Sprintf("The public channels created will get these members: %s.", strings.Join(usernames, ", "))
+		}
+
+	default:
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+
+	if err := p.updateChannelPolicy(args.TeamId, args.UserId, change); err != nil {
+		p.API.LogError("Failed to update channel policy", "team_id", args.TeamId, "err", err.Error())
+		return respond("Failed to update the channel policy of the team.")
+	}
+
+	return respond(done)
+}
+
+// channelPolicySummary describes the channel policy in Markdown.
+func (p *Plugin) channelPolicySummary(policy *channelPolicy) string {
+	if policy == nil {
+		return "This team has no channel policy. Team admins can set one with `/demo_plugin naming patterns proj- inc-`."
+	}
+
+	enforcement := channelPolicyFlag
+	if policy.Enforcement != "" {
+		enforcement = policy.Enforcement
+	}
+	patterns := "any"
+	if len(policy.Patterns) > 0 {
+		patterns = formatChannelPolicyPatterns(policy.Patterns)
+	}
+	templateText := func(text string) string {
+		if text == "" {
+			return "none"
+		}
+		return "`" + text + "`"
+	}
+	var members []string
+	if policy.AddBot {
+		members = append(members, "the bot")
+	}
+	for _, userID := range policy.MemberIDs {
+		member := userID
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			member = "@" + user.Username
+		}
+		members = append(members, member)
+	}
+	if len(members) == 0 {
+		members = append(members, "none")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Channel policy of this team:\n\n| Setting | Value |\n|:--------|:------|\n")
+	fmt.Fprintf(&sb, "| Name patterns | %s |\n", patterns)
+	fmt.Fprintf(&sb, "| Violations | %s |\n", enforcement)
+	fmt.Fprintf(&sb, "| Header template | %s |\n", templateText(policy.HeaderTemplate))
+	fmt.Fprintf(&sb, "| Purpose template | %s |\n", templateText(policy.PurposeTemplate))
+	fmt.Fprintf(&sb, "| Members added to public channels | %s |\n", strings.Join(members, ", "))
+
+	return sb.String()
+}
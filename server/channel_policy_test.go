@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestChannelPolicyMatchesName(t *testing.T) {
+	policy := &channelPolicy{}
+	assert.True(t, policy.matchesName("anything"))
+
+	policy.Patterns = []string{"proj-", "inc-*-20??", "team-[a-c]*"}
+	assert.True(t, policy.matchesName("proj-apollo"))
+	assert.True(t, policy.matchesName("inc-outage-2024"))
+	assert.True(t, policy.matchesName("team-backend"))
+	assert.False(t, policy.matchesName("inc-outage"))
+	assert.False(t, policy.matchesName("team-design"))
+	assert.False(t, policy.matchesName("random"))
+
+	assert.NoError(t, validateChannelPolicyPattern("proj-*"))
+	for _, pattern := range []string{"", "Proj-", "proj/", "proj-["} {
+		assert.Error(t, validateChannelPolicyPattern(pattern), pattern)
+	}
+}
+
+func TestChannelPolicy(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	var posts []*model.Post
+	var updated []*model.Channel
+	var archived, added []string
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		api := node.API.(*fakeNodeAPI).API
+		api.On("HasPermissionToTeam", "admin", "team1", model.PermissionManageTeam).Return(true)
+		api.On("HasPermissionToTeam", mock.Anything, "team1", model.PermissionManageTeam).Return(false)
+		api.On("GetTeam", "team1").Return(&model.Team{Id: "team1", Name: "eng", DisplayName: "Engineering"}, nil)
+		api.On("GetUser", "creator").Return(&model.User{Id: "creator", Username: "jane"}, nil)
+		api.On("GetUserByUsername", "john").Return(&model.User{Id: "user2", Username: "john"}, nil)
+		api.On("GetUser", "user2").Return(&model.User{Id: "user2", Username: "john"}, nil)
+		api.On("GetDirectChannel", "creator", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+		api.On("UpdateChannel", mock.Anything).Run(func(args mock.Arguments) {
+			updated = append(updated, args.Get(0).(*model.Channel))
+		}).Return(&model.Channel{}, nil)
+		api.On("DeleteChannel", mock.Anything).Run(func(args mock.Arguments) {
+			archived = append(archived, args.String(0))
+		}).Return(nil)
+		api.On("AddChannelMember", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			added = append(added, args.String(1))
+		}).Return(&model.ChannelMember{}, nil)
+	}
+
+	command := func(node *Plugin, userID, command string) string {
+		args := &model.CommandArgs{UserId: userID, TeamId: "team1", Command: command}
+		return node.executeCommandHooks(args).Text
+	}
+
+	assert.Contains(t, command(node1, "creator", "/demo_plugin naming patterns proj-"), "Only team admins")
+	assert.Contains(t, command(node1, "admin", "/demo_plugin naming patterns Proj-"), "Invalid channel policy")
+	assert.Equal(t, "Channel names must now match `proj-`, `inc-*`.", command(node1, "admin", "/demo_plugin naming patterns proj- inc-*"))
+	assert.Contains(t, command(node2, "admin", "/demo_plugin naming purpose Created by @{{.User.Nope}}"), "Invalid purpose template")
+	assert.Contains(t, command(node2, "admin", "/demo_plugin naming purpose Created by @{{.User.Username}}"), "Invalid purpose template", "the creator may not be found")
+	assert.Contains(t, command(node2, "admin", "/demo_plugin naming purpose Created {{with .User}}by @{{.Username}} {{end}}in {{.Team.DisplayName}}"), "will get")
+	assert.Equal(t, "The public channels created will get these members: the bot, @john.", command(node1, "admin", "/demo_plugin naming members bot @john"))
+	assert.Contains(t, command(node2, "creator", "/demo_plugin naming"), "| Name patterns | `proj-`, `inc-*` |")
+	assert.Contains(t, command(node2, "creator", "/demo_plugin naming"), "| Members added to public channels | the bot, @john |")
+
+	t.Run("list", func(t *testing.T) {
+		kvLists := cluster.kvLists
+		defer func() { assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned") }()
+
+		policies, err := node1.listChannelPolicies()
+		require.NoError(t, err)
+		require.Len(t, policies, 1)
+		assert.Equal(t, []string{"user2"}, policies["team1"].MemberIDs)
+	})
+
+	t.Run("compliant channel", func(t *testing.T) {
+		enforced, err := node2.applyChannelPolicy(&model.Channel{Id: "channel1", TeamId: "team1", Type: model.ChannelTypeOpen, Name: "proj-apollo", CreatorId: "creator", Header: "Apollo"})
+		require.NoError(t, err)
+		assert.Empty(t, enforced)
+		assert.Empty(t, posts)
+
+		require.Len(t, updated, 1)
+		assert.Equal(t, "Apollo", updated[0].Header)
+		assert.Equal(t, "Created by @jane in Engineering", updated[0].Purpose)
+		assert.Equal(t, []string{"bot", "user2"}, added)
+	})
+
+	t.Run("flagged channel", func(t *testing.T) {
+		posts, added = nil, nil
+		enforced, err := node1.applyChannelPolicy(&model.Channel{Id: "channel2", TeamId: "team1", Type: model.ChannelTypeOpen, Name: "random", CreatorId: "creator"})
+		require.NoError(t, err)
+		assert.Equal(t, channelPolicyFlag, enforced)
+
+		require.Len(t, posts, 2)
+		assert.Equal(t, "channel2", posts[0].ChannelId)
+		assert.Contains(t, posts[0].Message, "flagged because the name `random` doesn't follow the naming conventions of Engineering")
+		assert.Equal(t, "dm", posts[1].ChannelId)
+		assert.Len(t, added, 2)
+	})
+
+	t.Run("private channel", func(t *testing.T) {
+		posts, added, updated = nil, nil, nil
+		enforced, err := node1.applyChannelPolicy(&model.Channel{Id: "channel5", TeamId: "team1", Type: model.ChannelTypePrivate, Name: "proj-secret", CreatorId: "creator"})
+		require.NoError(t, err)
+		assert.Empty(t, enforced)
+		require.Len(t, updated, 1, "templates apply")
+		assert.Empty(t, added, "nobody is added to private channels")
+	})
+
+	t.Run("archived channel", func(t *testing.T) {
+		assert.Contains(t, command(node1, "admin", "/demo_plugin naming enforce archive"), "will be archived")
+
+		posts, added = nil, nil
+		enforced, err := node2.applyChannelPolicy(&model.Channel{Id: "channel3", TeamId: "team1", Name: "random", CreatorId: "creator"})
+		require.NoError(t, err)
+		assert.Equal(t, channelPolicyArchive, enforced)
+		assert.Equal(t, []string{"channel3"}, archived)
+
+		require.Len(t, posts, 1)
+		assert.Equal(t, "dm", posts[0].ChannelId)
+		assert.Contains(t, posts[0].Message, "~random you created was archived")
+		assert.Empty(t, added)
+	})
+
+	t.Run("exempt channels", func(t *testing.T) {
+		posts = nil
+		for _, channel := range []*model.Channel{
+			{Id: "dm", Name: "random", CreatorId: "creator"},
+			{Id: "demo", TeamId: "team1", Name: "demo_plugin"},
+			{Id: "bot", TeamId: "team1", Name: "random", CreatorId: "bot"},
+		} {
+			enforced, err := node1.applyChannelPolicy(channel)
+			require.NoError(t, err)
+			assert.Empty(t, enforced)
+		}
+		assert.Empty(t, posts)
+
+		assert.Equal(t, "Cleared the channel policy of the team.", command(node2, "admin", "/demo_plugin naming clear"))
+		enforced, err := node1.applyChannelPolicy(&model.Channel{Id: "channel4", TeamId: "team1", Name: "random", CreatorId: "creator"})
+		require.NoError(t, err)
+		assert.Empty(t, enforced)
+
+		policies, err := node2.listChannelPolicies()
+		require.NoError(t, err)
+		assert.Empty(t, policies)
+	})
+}
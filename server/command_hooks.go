@@ -58,7 +58,7 @@ func (p *Plugin) registerCommands() error {
 
 		Trigger:          commandTriggerHooks,
 		AutoComplete:     true,
-		AutoCompleteHint: "(true|false)",
+		AutoCompleteHint: "(true|false|hooks|webhooks)",
 		AutoCompleteDesc: "Enables or disables the demo plugin hooks.",
 		AutocompleteData: getCommandHooksAutocompleteData(),
 	}); err != nil {
@@ -145,6 +145,42 @@ func (p *Plugin) registerCommands() error {
 		return errors.Wrapf(err, "failed to register %s command", commandTriggerToast)
 	}
 
+	if err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTriggerSchedule,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Schedules a post in this channel.",
+		AutocompleteData: getCommandScheduleAutocompleteData(),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to register %s command", commandTriggerSchedule)
+	}
+
+	if err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTriggerRemind,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Sets a reminder for you, another user or a channel.",
+		AutocompleteData: getCommandRemindAutocompleteData(),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to register %s command", commandTriggerRemind)
+	}
+
+	if err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTriggerPoll,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Posts a poll voted on with reactions.",
+		AutocompleteData: getCommandPollAutocompleteData(),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to register %s command", commandTriggerPoll)
+	}
+
+	if err := p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTriggerKarma,
+		AutoComplete:     true,
+		AutoCompleteDesc: "Shows the karma earned with reactions in this team.",
+		AutocompleteData: getCommandKarmaAutocompleteData(),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to register %s command", commandTriggerKarma)
+	}
+
 	return nil
 }
 
@@ -153,20 +189,166 @@ func (p *Plugin) emitStatusChange() {
 
 	p.API.PublishWebSocketEvent("status_change", map[string]interface{}{
 		"enabled": !configuration.disabled,
+		"hooks":   configuration.hookMatrix(),
 	}, &model.WebsocketBroadcast{})
 }
 
 func getCommandHooksAutocompleteData() *model.AutocompleteData {
 	command := model.NewAutocompleteData(commandTriggerHooks, "", "Enables or disables the demo plugin hooks.")
-	command.AddStaticListArgument("", true, []model.AutocompleteListItem{
-		{
-			Item:     "true",
-			HelpText: "Enable demo plugin hooks",
-		}, {
-			Item:     "false",
-			HelpText: "Disable demo plugin hooks",
-		},
+
+	enable := model.NewAutocompleteData("true", "", "Enable demo plugin hooks")
+	command.AddCommand(enable)
+
+	disable := model.NewAutocompleteData("false", "", "Disable demo plugin hooks")
+	command.AddCommand(disable)
+
+	hooks := model.NewAutocompleteData("hooks", "[list|enable|disable] [hook]", "List, enable or disable individual demo plugin hooks.")
+	hooksList := model.NewAutocompleteData("list", "", "List whether each hook is enabled.")
+	hooks.AddCommand(hooksList)
+	hookItems := make([]model.AutocompleteListItem, 0, len(toggleableHooks))
+	for _, hook := range toggleableHooks {
+		hookItems = append(hookItems, model.AutocompleteListItem{Item: hook})
+	}
+	hooksEnable := model.NewAutocompleteData("enable", "<hook>", "Enable a hook.")
+	hooksEnable.AddStaticListArgument("Hook to enable", true, hookItems)
+	hooks.AddCommand(hooksEnable)
+	hooksDisable := model.NewAutocompleteData("disable", "<hook>", "Disable a hook.")
+	hooksDisable.AddStaticListArgument("Hook to disable", true, hookItems)
+	hooks.AddCommand(hooksDisable)
+	command.AddCommand(hooks)
+
+	webhooks := model.NewAutocompleteData("webhooks", "[retry <id>]", "Show the event webhook endpoints, queue and dead letters.")
+	webhooksRetry := model.NewAutocompleteData("retry", "<id>", "Queue a dead letter for delivery again.")
+	webhooksRetry.AddTextArgument("ID of the dead letter", "<id>", "")
+	webhooks.AddCommand(webhooksRetry)
+	command.AddCommand(webhooks)
+
+	rules := model.NewAutocompleteData("rules", "[list|add|remove|move|dryrun]", "Manage the moderation rules applied to new and edited posts.")
+	rulesList := model.NewAutocompleteData("list", "", "List the moderation rules in evaluation order.")
+	rules.AddCommand(rulesList)
+	rulesAdd := model.NewAutocompleteData("add", "<action> [key=value ...]", "Add a moderation rule, evaluated after the existing ones.")
+	actionItems := make([]model.AutocompleteListItem, 0, len(moderationActions))
+	for _, action := range moderationActions {
+		actionItems = append(actionItems, model.AutocompleteListItem{Item: action})
+	}
+	rulesAdd.AddStaticListArgument("Action of the rule", true, actionItems)
+	rulesAdd.AddTextArgument("Conditions and options: pattern, channel, team, role, attachments, message, replacement, review_channel, dry_run", `[pattern="regex" ...]`, "")
+	rules.AddCommand(rulesAdd)
+	rulesRemove := model.NewAutocompleteData("remove", "<id>", "Remove a moderation rule.")
+	rulesRemove.AddTextArgument("ID of the rule", "<id>", "")
+	rules.AddCommand(rulesRemove)
+	rulesMove := model.NewAutocompleteData("move", "<id> <position>", "Move a moderation rule to the given position, starting at 1.")
+	rulesMove.AddTextArgument("ID of the rule", "<id>", "")
+	rulesMove.AddTextArgument("New position of the rule", "<position>", "")
+	rules.AddCommand(rulesMove)
+	rulesDryRun := model.NewAutocompleteData("dryrun", "<id> <on|off>", "Only log what a moderation rule would do.")
+	rulesDryRun.AddTextArgument("ID of the rule", "<id>", "")
+	rulesDryRun.AddStaticListArgument("Dry run", true, []model.AutocompleteListItem{{Item: "on"}, {Item: "off"}})
+	rules.AddCommand(rulesDryRun)
+	command.AddCommand(rules)
+
+	secure := model.NewAutocompleteData("secure", "[status|rotate]", "Manage the encryption keys of [SECURE] posts in the current channel.")
+	secureStatus := model.NewAutocompleteData("status", "", "List the encryption keys of the current channel.")
+	secure.AddCommand(secureStatus)
+	secureRotate := model.NewAutocompleteData("rotate", "", "Encrypt new [SECURE] posts in the current channel with a new key.")
+	secure.AddCommand(secureRotate)
+	command.AddCommand(secure)
+
+	redaction := model.NewAutocompleteData("redaction", "[show|set|audit]", "Manage the redaction of personal data in the posts and dialog submissions of the team.")
+	redactionShow := model.NewAutocompleteData("show", "", "Show the redaction policy of the team.")
+	redaction.AddCommand(redactionShow)
+	redactionSet := model.NewAutocompleteData("set", "[key=value ...]", "Update the redaction policy of the team.")
+	redactionSet.AddTextArgument("Options: action (off, mask, reject, route), detectors (all or email, credit_card, iban, phone), compliance_channel, pattern.<name>", `[action=mask ...]`, "")
+	redaction.AddCommand(redactionSet)
+	redactionAudit := model.NewAutocompleteData("audit", "", "List the latest redactions in the team.")
+	redaction.AddCommand(redactionAudit)
+	command.AddCommand(redaction)
+
+	deleted := model.NewAutocompleteData("deleted", "[list|restore]", "Manage the archive of deleted posts.")
+	deletedList := model.NewAutocompleteData("list", "[channel]", "List the latest deleted posts, optionally of a channel.")
+	deletedList.AddTextArgument("Channel name or id", "[channel]", "")
+	deleted.AddCommand(deletedList)
+	deletedRestore := model.NewAutocompleteData("restore", "<post_id>", "Re-create a deleted post as the bot.")
+	deletedRestore.AddTextArgument("ID of the deleted post", "<post_id>", "")
+	deleted.AddCommand(deletedRestore)
+	command.AddCommand(deleted)
+
+	secrets := model.NewAutocompleteData("secrets", "[leaderboard|rotate|reveal]", "Play the secret hunt of the team.")
+	secretsLeaderboard := model.NewAutocompleteData("leaderboard", "", "Show the users who found the most secrets in the team.")
+	secrets.AddCommand(secretsLeaderboard)
+	secretsRotate := model.NewAutocompleteData("rotate", "[phrase|number]", "Hide new secrets in the team.")
+	secretsRotate.AddStaticListArgument("Secret to rotate", false, []model.AutocompleteListItem{
+		{Item: secretKindPhrase, HelpText: "Only hide a new secret phrase."},
+		{Item: secretKindNumber, HelpText: "Only hide a new secret number."},
+	})
+	secrets.AddCommand(secretsRotate)
+	secretsReveal := model.NewAutocompleteData("reveal", "", "Show the current secrets of the team.")
+	secrets.AddCommand(secretsReveal)
+	command.AddCommand(secrets)
+
+	watch := model.NewAutocompleteData("watch", "[list|add|remove]", "Get a direct message when a keyword is posted in a channel you are a member of.")
+	watchList := model.NewAutocompleteData("list", "", "List your keyword subscriptions.")
+	watch.AddCommand(watchList)
+	watchAdd := model.NewAutocompleteData("add", "<pattern> [~channel]", "Watch a keyword, or a regular expression between slashes, optionally only in a channel.")
+	watchAdd.AddTextArgument("Keyword or /regular expression/, optionally followed by a channel", "<pattern> [~channel]", "")
+	watch.AddCommand(watchAdd)
+	watchRemove := model.NewAutocompleteData("remove", "<pattern> [~channel]", "Stop watching a keyword.")
+	watchRemove.AddTextArgument("Keyword or /regular expression/, optionally followed by a channel", "<pattern> [~channel]", "")
+	watch.AddCommand(watchRemove)
+	command.AddCommand(watch)
+
+	slowMode := model.NewAutocompleteData("slowmode", "[~channel] [interval|off]", "Show or set the minimum interval between two posts of a user in a channel.")
+	slowMode.AddTextArgument("Channel, the current one by default", "[~channel]", "")
+	slowMode.AddTextArgument("Interval such as 30s or 5m, or off", "[interval|off]", "")
+	command.AddCommand(slowMode)
+
+	reactions := model.NewAutocompleteData("reactions", "[list|map|unmap]", "Map emoji to actions run when they are added to a post.")
+	reactionsList := model.NewAutocompleteData("list", "", "List the emoji mapped to actions.")
+	reactions.AddCommand(reactionsList)
+	reactionsMap := model.NewAutocompleteData("map", "<:emoji:> <action> [args] [--channel [~channel]]", "Map an emoji to an action, in every channel or only in a channel.")
+	reactionsMap.AddTextArgument("Emoji", "<:emoji:>", "")
+	reactionActionItems := make([]model.AutocompleteListItem, 0, len(reactionActions))
+	for _, action := range reactionActions {
+		reactionActionItems = append(reactionActionItems, model.AutocompleteListItem{Item: action})
+	}
+	reactionsMap.AddStaticListArgument("Action", true, reactionActionItems)
+	reactionsMap.AddTextArgument("copy ~channel, notify @user, remind [delay] or checklist item; item", "[args] [--channel [~channel]]", "")
+	reactions.AddCommand(reactionsMap)
+	reactionsUnmap := model.NewAutocompleteData("unmap", "<:emoji:> [--channel [~channel]]", "Unmap an emoji.")
+	reactionsUnmap.AddTextArgument("Emoji, optionally followed by the channel of the mapping", "<:emoji:> [--channel [~channel]]", "")
+	reactions.AddCommand(reactionsUnmap)
+	command.AddCommand(reactions)
+
+	naming := model.NewAutocompleteData("naming", "[show|patterns|enforce|header|purpose|members|clear]", "Show or set the naming conventions and defaults of the channels created in this team.")
+	namingShow := model.NewAutocompleteData("show", "", "Show the channel policy of the team.")
+	naming.AddCommand(namingShow)
+	namingPatterns := model.NewAutocompleteData("patterns", "[pattern...]", "Set the patterns channel names must match, such as proj- or inc-*, or accept any name.")
+	namingPatterns.AddTextArgument("Prefixes or glob patterns separated by spaces", "[pattern...]", "")
+	naming.AddCommand(namingPatterns)
+	namingEnforce := model.NewAutocompleteData("enforce", "<flag|archive>", "Flag or archive the channels whose names don't match.")
+	namingEnforce.AddStaticListArgument("Enforcement", true, []model.AutocompleteListItem{
+		{Item: channelPolicyFlag, HelpText: "Post a warning in the channel and let the creator know"},
+		{Item: channelPolicyArchive, HelpText: "Archive the channel and let the creator know"},
 	})
+	naming.AddCommand(namingEnforce)
+	namingHeader := model.NewAutocompleteData("header", "[template]", "Set the header of the channels created without one.")
+	namingHeader.AddTextArgument("Template such as {{.Team.DisplayName}} project channel, or nothing to remove it", "[template]", "")
+	naming.AddCommand(namingHeader)
+	namingPurpose := model.NewAutocompleteData("purpose", "[template]", "Set the purpose of the channels created without one.")
+	namingPurpose.AddTextArgument("Template such as Created by @{{.User.Username}}, or nothing to remove it", "[template]", "")
+	naming.AddCommand(namingPurpose)
+	namingMembers := model.NewAutocompleteData("members", "[bot] [@user...]", "Set the bot and users added to the channels created.")
+	namingMembers.AddTextArgument("bot and usernames separated by spaces, or nothing to add nobody", "[bot] [@user...]", "")
+	naming.AddCommand(namingMembers)
+	namingClear := model.NewAutocompleteData("clear", "", "Remove the channel policy of the team.")
+	naming.AddCommand(namingClear)
+	command.AddCommand(naming)
+
+	onboarding := model.NewAutocompleteData("onboarding", "status", "Show the onboarding of the users who joined this team.")
+	onboardingStatus := model.NewAutocompleteData("status", "", "Show who completed their onboarding in this team.")
+	onboarding.AddCommand(onboardingStatus)
+	command.AddCommand(onboarding)
+
 	return command
 }
 
@@ -278,12 +460,18 @@ func getCommandToastAutocompleteData() *model.AutocompleteData {
 // This demo implementation responds to a /demo_plugin command, allowing the user to enable
 // or disable the demo plugin's hooks functionality (but leave the command and webapp enabled).
 func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	start := time.Now()
+
 	delay := p.getConfiguration().IntegrationRequestDelay
 	if delay > 0 {
 		time.Sleep(time.Duration(delay) * time.Second)
 	}
 
 	trigger := strings.TrimPrefix(strings.Fields(args.Command)[0], "/")
+	defer func() {
+		p.metrics.observeCommand(trigger, time.Since(start))
+	}()
+
 	switch trigger {
 	case commandTriggerCrash:
 		return p.executeCommandCrash(), nil
@@ -305,6 +493,14 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		return p.executeAutocompleteTest(args), nil
 	case commandTriggerToast:
 		return p.executeCommandToast(c, args), nil
+	case commandTriggerSchedule:
+		return p.executeCommandSchedule(args), nil
+	case commandTriggerRemind:
+		return p.executeCommandRemind(args), nil
+	case commandTriggerPoll:
+		return p.executeCommandPoll(args), nil
+	case commandTriggerKarma:
+		return p.executeCommandKarma(args), nil
 
 	default:
 		return &model.CommandResponse{
@@ -323,6 +519,36 @@ func (p *Plugin) executeCommandCrash() *model.CommandResponse {
 }
 
 func (p *Plugin) executeCommandHooks(args *model.CommandArgs) *model.CommandResponse {
+	fields := strings.Fields(args.Command)
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "hooks":
+			return p.executeCommandHookToggles(args, fields[2:])
+		case "webhooks":
+			return p.executeCommandWebhooks(args, fields[2:])
+		case "rules":
+			return p.executeCommandRules(args, fields[2:])
+		case "secure":
+			return p.executeCommandSecure(args, fields[2:])
+		case "redaction":
+			return p.executeCommandRedaction(args, fields[2:])
+		case "deleted":
+			return p.executeCommandDeleted(args, fields[2:])
+		case "secrets":
+			return p.executeCommandSecrets(args, fields[2:])
+		case "watch":
+			return p.executeCommandWatch(args, fields[2:])
+		case "slowmode":
+			return p.executeCommandSlowMode(args, fields[2:])
+		case "reactions":
+			return p.executeCommandReactions(args, fields[2:])
+		case "naming":
+			return p.executeCommandNaming(args, fields[2:])
+		case "onboarding":
+			return p.executeCommandOnboarding(args, fields[2:])
+		}
+	}
+
 	configuration := p.getConfiguration()
 
 	if strings.HasSuffix(args.Command, "true") {
@@ -333,7 +559,13 @@ func (p *Plugin) executeCommandHooks(args *model.CommandArgs) *model.CommandResp
 			}
 		}
 
-		p.setEnabled(true)
+		if err := p.setEnabled(true); err != nil {
+			p.API.LogError("Failed to enable demo plugin hooks", "err", err.Error())
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         "Failed to enable demo plugin hooks.",
+			}
+		}
 		p.emitStatusChange()
 
 		return &model.CommandResponse{
@@ -350,7 +582,13 @@ func (p *Plugin) executeCommandHooks(args *model.CommandArgs) *model.CommandResp
 			}
 		}
 
-		p.setEnabled(false)
+		if err := p.setEnabled(false); err != nil {
+			p.API.LogError("Failed to disable demo plugin hooks", "err", err.Error())
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         "Failed to disable demo plugin hooks.",
+			}
+		}
 		p.emitStatusChange()
 
 		return &model.CommandResponse{
@@ -365,6 +603,57 @@ func (p *Plugin) executeCommandHooks(args *model.CommandArgs) *model.CommandResp
 	}
 }
 
+// isSystemAdmin reports whether the given user is allowed to manage the system.
+func (p *Plugin) isSystemAdmin(userID string) bool {
+	return p.API.HasPermissionTo(userID, model.PermissionManageSystem)
+}
+
+func (p *Plugin) executeCommandWebhooks(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "Only system admins can manage the event webhooks.",
+		}
+	}
+
+	if len(params) == 2 && params[0] == "retry" {
+		if err := p.retryDeadLetter(params[1]); err != nil {
+			p.API.LogError("Failed to retry dead letter", "err", err.Error())
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         fmt.Sprintf("Failed to retry dead letter: %s", err.Error()),
+			}
+		}
+
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("Queued dead letter `%s` for delivery.", params[1]),
+		}
+	}
+
+	if len(params) != 0 {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("Unknown command action: %s", args.Command),
+		}
+	}
+
+	summary, err := p.webhooksSummary()
+	if err != nil {
+		const errorMessage = "Failed to list event webhooks"
+		p.API.LogError(errorMessage, "err", err.Error())
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         errorMessage,
+		}
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         summary,
+	}
+}
+
 func (p *Plugin) executeCommandEphemeral(args *model.CommandArgs) *model.CommandResponse {
 	siteURL := *p.API.GetConfig().ServiceSettings.SiteURL
 
@@ -709,7 +998,7 @@ func (p *Plugin) executeCommandToast(c *plugin.Context, args *model.CommandArgs)
 	// Default values
 	position := "bottom-right"
 	message := "This is a demo toast notification!"
-	connectionID := ""
+	connectionIDs := []string{""}
 	allSessions := false
 
 	// Check if --all-sessions flag is present in the first position
@@ -719,12 +1008,15 @@ func (p *Plugin) executeCommandToast(c *plugin.Context, args *model.CommandArgs)
 		startIndex = 2
 	}
 
-	// If --all-sessions is NOT set, use the session ID
+	// If --all-sessions is NOT set, target every connection of the session
 	if !allSessions {
-		var found bool
-		connectionID, found = p.GetConnectionIDForSession(c.SessionId)
-		if !found {
-			p.API.LogWarn("Failed to get connection ID for session", "session_id", c.SessionId)
+		sessionConnectionIDs, err := p.GetConnectionIDsForSession(c.SessionId)
+		if err != nil {
+			p.API.LogWarn("Failed to get connection IDs for session", "session_id", c.SessionId, "err", err.Error())
+		} else if len(sessionConnectionIDs) == 0 {
+			p.API.LogWarn("No connection tracked for session", "session_id", c.SessionId)
+		} else {
+			connectionIDs = sessionConnectionIDs
 		}
 	}
 
@@ -742,12 +1034,14 @@ func (p *Plugin) executeCommandToast(c *plugin.Context, args *model.CommandArgs)
 		Position: position,
 	}
 
-	if err := p.client.Frontend.SendToastMessage(args.UserId, connectionID, message, options); err != nil {
-		errorMessage := "Failed to send toast notification"
-		p.API.LogError(errorMessage, "err", err.Error())
-		return &model.CommandResponse{
-			ResponseType: model.CommandResponseTypeEphemeral,
-			Text:         errorMessage,
+	for _, connectionID := range connectionIDs {
+		if err := p.client.Frontend.SendToastMessage(args.UserId, connectionID, message, options); err != nil {
+			errorMessage := "Failed to send toast notification"
+			p.API.LogError(errorMessage, "connection_id", connectionID, "err", err.Error())
+			return &model.CommandResponse{
+				ResponseType: model.CommandResponseTypeEphemeral,
+				Text:         errorMessage,
+			}
 		}
 	}
 
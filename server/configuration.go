@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"text/template"
 
 	"github.com/pkg/errors"
 
@@ -32,10 +33,11 @@ type configuration struct {
 	// TextStyle controls the text style of the messages posted by the demo user.
 	TextStyle string
 
-	// RandomSecret is a generated key that, when mentioned in a message by a user, will trigger the demo user to post the 'SecretMessage'.
+	// RandomSecret is a generated key from which the secrets hidden in each team are derived. When a user is the first to
+	// mention one in a message, the demo user posts the 'SecretMessage' and a new secret is hidden.
 	RandomSecret string
 
-	// SecretMessage is the message posted to the demo channel when the 'RandomSecret' is pasted somewhere in the team.
+	// SecretMessage is the message posted to the demo channel when the secret phrase of the team is found.
 	SecretMessage string
 
 	// EnableMentionUser controls whether the 'MentionUser' is prepended to all demo messages or not.
@@ -44,9 +46,46 @@ type configuration struct {
 	// MentionUser is the user that is prepended to demo messages when enabled.
 	MentionUser string
 
-	// SecretNumber is an integer that, when mentioned in a message by a user, will trigger the demo user to post a message.
+	// SecretNumber is the upper bound of the secret numbers hidden in each team. There is no secret number when it is
+	// zero.
 	SecretNumber int
 
+	// SecretRotationHours is the number of hours after which a secret nobody found is replaced. Secrets are only
+	// replaced once found when it is zero.
+	SecretRotationHours int
+
+	// EnableAutoResponder controls whether posts mentioning the demo user and direct messages to the demo user are
+	// allowed, the demo user replying in their thread according to 'AutoResponderRules'.
+	EnableAutoResponder bool
+
+	// AutoResponderRules defines the replies of the demo user, one per line as a keyword, a colon and the text/template
+	// of the reply.
+	AutoResponderRules string
+
+	// AutoResponderActiveHours are the days and hours the demo user replies according to the rules, e.g.
+	// "Mon-Fri 09:00-17:00 Europe/Paris". The demo user is always active when it is empty.
+	AutoResponderActiveHours string
+
+	// AutoResponderAwayMessage is the text/template of the reply of the demo user outside of the active hours.
+	AutoResponderAwayMessage string
+
+	// KarmaEmojiWeights lists the weight of the emoji counted as karma, separated by commas or newlines as the emoji,
+	// an equal sign and the weight, "*" being the weight of the other emoji.
+	KarmaEmojiWeights string
+
+	// EnableOnboarding controls whether users joining a team are welcomed by the bot and onboarded with a dialog
+	// instead of being announced in the demo channel.
+	EnableOnboarding bool
+
+	// OnboardingChannels lists the roles and interests offered during onboarding, one per line as "role" or
+	// "interest", the name, a colon and the channels users choosing it join.
+	OnboardingChannels string
+
+	// OffboardingReportChannel is the channel the offboarding reports of the users deactivated are posted to, as the
+	// name of a team and the name of a channel separated by a slash. Deactivations are announced in the demo channels
+	// when it is empty.
+	OffboardingReportChannel string
+
 	// A deplay in seconds that is applied to Slash Command responses, Post Actions responses and Interactive Dialog responses.
 	// It's useful for testing.
 	IntegrationRequestDelay int
@@ -71,14 +110,79 @@ type configuration struct {
 	// When enabled, public link file downloads will be rejected with an error message.
 	RejectPublicLinkDownloads bool
 
-	// disabled tracks whether or not the plugin has been disabled after activation. It always starts enabled.
+	// EventLogRetentionDays is the number of days recorded hook events are kept in the event
+	// log. Hook events are not recorded when it is zero.
+	EventLogRetentionDays int
+
+	// DeletedPostRetentionDays is the number of days deleted posts are kept in the archive. Deleted
+	// posts are not archived when it is zero.
+	DeletedPostRetentionDays int
+
+	// EventWebhooks lists the HTTP endpoints hook events are forwarded to, one per line as the URL
+	// followed by the secret used to sign the deliveries.
+	EventWebhooks string
+
+	// WebhookMaxAttempts is the number of times a webhook delivery is attempted before it is
+	// moved to the dead letters.
+	WebhookMaxAttempts int
+
+	// DisabledHooks is a comma separated list of hooks that are disabled, unless enabled at
+	// runtime with the /demo_plugin hooks command.
+	DisabledHooks string
+
+	// EnableDigest controls whether hook notifications are buffered and posted as a periodic
+	// digest instead of one message per event.
+	EnableDigest bool
+
+	// DigestIntervalMinutes is the number of minutes between two digests.
+	DigestIntervalMinutes int
+
+	// HookTemplates defines a text/template per hook, one per line as the name of the hook, a
+	// colon and the template, used instead of the default notification.
+	HookTemplates string
+
+	// EnableSecureEncryption controls whether the message of [SECURE] posts is encrypted at rest
+	// with a per channel key, and only decrypted when delivered to clients.
+	EnableSecureEncryption bool
+
+	// EnableEditHistory controls whether every version of edited posts is kept in the KV store.
+	EnableEditHistory bool
+
+	// disabled tracks whether or not the plugin has been disabled with /demo_plugin false. It is
+	// part of the runtime state shared by the plugin instances.
 	disabled bool
 
+	// disabledHooks is the set of hooks parsed from DisabledHooks.
+	disabledHooks map[string]bool
+
+	// hookOverrides tracks the hooks enabled or disabled with /demo_plugin hooks, taking
+	// precedence over DisabledHooks. It is part of the runtime state shared by the plugin
+	// instances.
+	hookOverrides map[string]bool
+
+	// runtimeRevision is the revision of the runtime state last applied.
+	runtimeRevision int64
+
 	// demoUserID is the id of the user specified above.
 	demoUserID string
 
 	// demoChannelIDs maps team ids to the channels created for each using the channel name above.
 	demoChannelIDs map[string]string
+
+	// webhookEndpoints are the endpoints parsed from EventWebhooks.
+	webhookEndpoints []webhookEndpoint
+
+	// hookTemplates are the templates parsed from HookTemplates, by hook.
+	hookTemplates map[string]*template.Template
+
+	// autoResponderRules, autoResponderActiveHours and autoResponderAwayTemplate are parsed from
+	// the auto-responder settings.
+	autoResponderRules        []autoResponderRule
+	autoResponderActiveHours  *activeHours
+	autoResponderAwayTemplate *template.Template
+
+	// karmaWeights are the weights parsed from KarmaEmojiWeights, by emoji.
+	karmaWeights map[string]int
 }
 
 // Clone deep copies the configuration. Your implementation may only require a shallow copy if
@@ -90,6 +194,27 @@ func (c *configuration) Clone() *configuration {
 		demoChannelIDs[key] = value
 	}
 
+	// Deep copy disabledHooks and hookOverrides, also reference types.
+	disabledHooks := make(map[string]bool)
+	for key, value := range c.disabledHooks {
+		disabledHooks[key] = value
+	}
+	hookOverrides := make(map[string]bool)
+	for key, value := range c.hookOverrides {
+		hookOverrides[key] = value
+	}
+
+	// Parsed templates are safe to execute concurrently, so only the map is copied.
+	hookTemplates := make(map[string]*template.Template)
+	for key, value := range c.hookTemplates {
+		hookTemplates[key] = value
+	}
+
+	karmaWeights := make(map[string]int)
+	for key, value := range c.karmaWeights {
+		karmaWeights[key] = value
+	}
+
 	return &configuration{
 		Username:                  c.Username,
 		ChannelName:               c.ChannelName,
@@ -100,15 +225,43 @@ func (c *configuration) Clone() *configuration {
 		EnableMentionUser:         c.EnableMentionUser,
 		MentionUser:               c.MentionUser,
 		SecretNumber:              c.SecretNumber,
+		SecretRotationHours:       c.SecretRotationHours,
+		EnableAutoResponder:       c.EnableAutoResponder,
+		AutoResponderRules:        c.AutoResponderRules,
+		AutoResponderActiveHours:  c.AutoResponderActiveHours,
+		AutoResponderAwayMessage:  c.AutoResponderAwayMessage,
+		KarmaEmojiWeights:         c.KarmaEmojiWeights,
+		EnableOnboarding:          c.EnableOnboarding,
+		OnboardingChannels:        c.OnboardingChannels,
+		OffboardingReportChannel:  c.OffboardingReportChannel,
 		IntegrationRequestDelay:   c.IntegrationRequestDelay,
 		ServiceAPIKey:             c.ServiceAPIKey,
 		RejectFileDownloads:       c.RejectFileDownloads,
 		RejectThumbDownloads:      c.RejectThumbDownloads,
 		RejectPreviewDownloads:    c.RejectPreviewDownloads,
 		RejectPublicLinkDownloads: c.RejectPublicLinkDownloads,
+		EventLogRetentionDays:     c.EventLogRetentionDays,
+		DeletedPostRetentionDays:  c.DeletedPostRetentionDays,
+		EventWebhooks:             c.EventWebhooks,
+		WebhookMaxAttempts:        c.WebhookMaxAttempts,
+		DisabledHooks:             c.DisabledHooks,
+		EnableDigest:              c.EnableDigest,
+		DigestIntervalMinutes:     c.DigestIntervalMinutes,
+		HookTemplates:             c.HookTemplates,
+		EnableSecureEncryption:    c.EnableSecureEncryption,
+		EnableEditHistory:         c.EnableEditHistory,
 		disabled:                  c.disabled,
+		disabledHooks:             disabledHooks,
+		hookOverrides:             hookOverrides,
+		runtimeRevision:           c.runtimeRevision,
 		demoUserID:                c.demoUserID,
 		demoChannelIDs:            demoChannelIDs,
+		webhookEndpoints:          append([]webhookEndpoint(nil), c.webhookEndpoints...),
+		hookTemplates:             hookTemplates,
+		autoResponderRules:        append([]autoResponderRule(nil), c.autoResponderRules...),
+		autoResponderActiveHours:  c.autoResponderActiveHours,
+		autoResponderAwayTemplate: c.autoResponderAwayTemplate,
+		karmaWeights:              karmaWeights,
 	}
 }
 
@@ -175,11 +328,38 @@ func (p *Plugin) diffConfiguration(newConfiguration *configuration) {
 		configurationDiff["mention_user"] = newConfiguration.MentionUser
 	}
 	if newConfiguration.SecretNumber != oldConfiguration.SecretNumber {
-		configurationDiff["secret_number"] = newConfiguration.SecretNumber
+		configurationDiff["secret_number"] = "<HIDDEN>"
+	}
+	if newConfiguration.SecretRotationHours != oldConfiguration.SecretRotationHours {
+		configurationDiff["secret_rotation_hours"] = newConfiguration.SecretRotationHours
 	}
 	if newConfiguration.ServiceAPIKey != oldConfiguration.ServiceAPIKey {
 		configurationDiff["service_api_key"] = "<HIDDEN>"
 	}
+	if newConfiguration.EnableAutoResponder != oldConfiguration.EnableAutoResponder {
+		configurationDiff["enable_auto_responder"] = newConfiguration.EnableAutoResponder
+	}
+	if newConfiguration.AutoResponderRules != oldConfiguration.AutoResponderRules {
+		configurationDiff["auto_responder_rules"] = newConfiguration.AutoResponderRules
+	}
+	if newConfiguration.AutoResponderActiveHours != oldConfiguration.AutoResponderActiveHours {
+		configurationDiff["auto_responder_active_hours"] = newConfiguration.AutoResponderActiveHours
+	}
+	if newConfiguration.AutoResponderAwayMessage != oldConfiguration.AutoResponderAwayMessage {
+		configurationDiff["auto_responder_away_message"] = newConfiguration.AutoResponderAwayMessage
+	}
+	if newConfiguration.KarmaEmojiWeights != oldConfiguration.KarmaEmojiWeights {
+		configurationDiff["karma_emoji_weights"] = newConfiguration.KarmaEmojiWeights
+	}
+	if newConfiguration.EnableOnboarding != oldConfiguration.EnableOnboarding {
+		configurationDiff["enable_onboarding"] = newConfiguration.EnableOnboarding
+	}
+	if newConfiguration.OnboardingChannels != oldConfiguration.OnboardingChannels {
+		configurationDiff["onboarding_channels"] = newConfiguration.OnboardingChannels
+	}
+	if newConfiguration.OffboardingReportChannel != oldConfiguration.OffboardingReportChannel {
+		configurationDiff["offboarding_report_channel"] = newConfiguration.OffboardingReportChannel
+	}
 	if newConfiguration.IntegrationRequestDelay != oldConfiguration.IntegrationRequestDelay {
 		configurationDiff["integration_request_delay"] = newConfiguration.IntegrationRequestDelay
 	}
@@ -195,6 +375,36 @@ func (p *Plugin) diffConfiguration(newConfiguration *configuration) {
 	if newConfiguration.RejectPublicLinkDownloads != oldConfiguration.RejectPublicLinkDownloads {
 		configurationDiff["reject_public_link_downloads"] = newConfiguration.RejectPublicLinkDownloads
 	}
+	if newConfiguration.EventLogRetentionDays != oldConfiguration.EventLogRetentionDays {
+		configurationDiff["event_log_retention_days"] = newConfiguration.EventLogRetentionDays
+	}
+	if newConfiguration.DeletedPostRetentionDays != oldConfiguration.DeletedPostRetentionDays {
+		configurationDiff["deleted_post_retention_days"] = newConfiguration.DeletedPostRetentionDays
+	}
+	if newConfiguration.EventWebhooks != oldConfiguration.EventWebhooks {
+		configurationDiff["event_webhooks"] = "<HIDDEN>"
+	}
+	if newConfiguration.WebhookMaxAttempts != oldConfiguration.WebhookMaxAttempts {
+		configurationDiff["webhook_max_attempts"] = newConfiguration.WebhookMaxAttempts
+	}
+	if newConfiguration.DisabledHooks != oldConfiguration.DisabledHooks {
+		configurationDiff["disabled_hooks"] = newConfiguration.DisabledHooks
+	}
+	if newConfiguration.EnableDigest != oldConfiguration.EnableDigest {
+		configurationDiff["enable_digest"] = newConfiguration.EnableDigest
+	}
+	if newConfiguration.DigestIntervalMinutes != oldConfiguration.DigestIntervalMinutes {
+		configurationDiff["digest_interval_minutes"] = newConfiguration.DigestIntervalMinutes
+	}
+	if newConfiguration.HookTemplates != oldConfiguration.HookTemplates {
+		configurationDiff["hook_templates"] = newConfiguration.HookTemplates
+	}
+	if newConfiguration.EnableSecureEncryption != oldConfiguration.EnableSecureEncryption {
+		configurationDiff["enable_secure_encryption"] = newConfiguration.EnableSecureEncryption
+	}
+	if newConfiguration.EnableEditHistory != oldConfiguration.EnableEditHistory {
+		configurationDiff["enable_edit_history"] = newConfiguration.EnableEditHistory
+	}
 
 	if len(configurationDiff) == 0 {
 		return
@@ -255,6 +465,42 @@ func (p *Plugin) OnConfigurationChange() error {
 		return errors.Wrap(loadConfigErr, "failed to load plugin configuration")
 	}
 
+	webhookEndpoints, err := parseWebhookEndpoints(configuration.EventWebhooks)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse event webhooks")
+	}
+	configuration.webhookEndpoints = webhookEndpoints
+
+	disabledHooks, err := parseDisabledHooks(configuration.DisabledHooks)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse disabled hooks")
+	}
+	configuration.disabledHooks = disabledHooks
+
+	hookTemplates, err := parseHookTemplates(configuration.HookTemplates)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse hook templates")
+	}
+	configuration.hookTemplates = hookTemplates
+
+	if err := configuration.parseAutoResponder(); err != nil {
+		return errors.Wrap(err, "failed to parse auto-responder settings")
+	}
+
+	karmaWeights, err := parseKarmaWeights(configuration.KarmaEmojiWeights)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse karma emoji weights")
+	}
+	configuration.karmaWeights = karmaWeights
+
+	if _, err := parseOnboardingChannels(configuration.OnboardingChannels); err != nil {
+		return errors.Wrap(err, "failed to parse onboarding channels")
+	}
+
+	if _, _, err := parseOffboardingReportChannel(configuration.OffboardingReportChannel); err != nil {
+		return errors.Wrap(err, "failed to parse offboarding report channel")
+	}
+
 	demoUserID, err := p.ensureDemoUser(configuration)
 	if err != nil {
 		return errors.Wrap(err, "failed to ensure demo user")
@@ -279,7 +525,7 @@ func (p *Plugin) OnConfigurationChange() error {
 
 	p.diffConfiguration(configuration)
 
-	p.setConfiguration(configuration)
+	p.setLoadedConfiguration(configuration)
 
 	return nil
 }
@@ -296,20 +542,11 @@ func (p *Plugin) OnConfigurationChange() error {
 // returned, resulting in the config not getting saved.
 // If the Username config option is set to "replaceme" the config value will be
 // replaced with "replaced".
+// Invalid event webhooks, disabled hooks, hook templates and auto-responder settings are rejected
+// as well. They are rejected even while the hooks are disabled, since OnConfigurationChange fails
+// to load them.
 func (p *Plugin) ConfigurationWillBeSaved(newCfg *model.Config) (*model.Config, error) {
-	cfg := p.getConfiguration()
-	if cfg.disabled {
-		return nil, nil
-	}
-
-	teams, appErr := p.API.GetTeams()
-	if appErr != nil {
-		p.API.LogError(
-			"Failed to query teams ConfigurationWillBeSaved",
-			"error", appErr.Error(),
-		)
-		return nil, nil
-	}
+	cfg := p.getConfiguration().Clone()
 
 	msg := "Configuration will be saved"
 
@@ -337,24 +574,40 @@ func (p *Plugin) ConfigurationWillBeSaved(newCfg *model.Config) (*model.Config,
 
 	invalidUsernameUsed := cfg.Username == "invalid"
 	replaceUsernameUsed := cfg.Username == "replaceme"
+	_, invalidWebhooksErr := parseWebhookEndpoints(cfg.EventWebhooks)
+	_, invalidHooksErr := parseDisabledHooks(cfg.DisabledHooks)
+	_, invalidTemplatesErr := parseHookTemplates(cfg.HookTemplates)
+	invalidAutoResponderErr := cfg.parseAutoResponder()
+	_, invalidKarmaWeightsErr := parseKarmaWeights(cfg.KarmaEmojiWeights)
+	_, invalidOnboardingChannelsErr := parseOnboardingChannels(cfg.OnboardingChannels)
+	_, _, invalidOffboardingReportChannelErr := parseOffboardingReportChannel(cfg.OffboardingReportChannel)
 
 	if invalidUsernameUsed {
 		msg = "Configuration won't be saved, invalid Username value used"
+	} else if invalidWebhooksErr != nil {
+		msg = fmt.Sprintf("Configuration won't be saved, invalid Event Webhooks value used: %s", invalidWebhooksErr.Error())
+	} else if invalidHooksErr != nil {
+		msg = fmt.Sprintf("Configuration won't be saved, invalid Disabled Hooks value used: %s", invalidHooksErr.Error())
+	} else if invalidTemplatesErr != nil {
+		msg = fmt.Sprintf("Configuration won't be saved, invalid Hook Templates value used: %s", invalidTemplatesErr.Error())
+	} else if invalidAutoResponderErr != nil {
+		msg = fmt.Sprintf("Configuration won't be saved, %s", invalidAutoResponderErr.Error())
+	} else if invalidKarmaWeightsErr != nil {
+		msg = fmt.Sprintf("Configuration won't be saved, invalid Karma Emoji Weights value used: %s", invalidKarmaWeightsErr.Error())
+	} else if invalidOnboardingChannelsErr != nil {
+		msg = fmt.Sprintf("Configuration won't be saved, invalid Onboarding Channels value used: %s", invalidOnboardingChannelsErr.Error())
+	} else if invalidOffboardingReportChannelErr != nil {
+		msg = fmt.Sprintf("Configuration won't be saved, invalid Offboarding Report Channel value used: %s", invalidOffboardingReportChannelErr.Error())
 	} else if replaceUsernameUsed {
 		msg = "Configuration will be save, replacing Username value"
 	}
 
-	for _, team := range teams {
-		if err := p.postPluginMessage(team.Id, msg); err != nil {
-			p.API.LogError(
-				"Failed to post ConfigurationWillBeSaved message",
-				"channel_id", cfg.demoChannelIDs[team.Id],
-				"error", err.Error(),
-			)
-		}
+	if !cfg.disabled {
+		p.postConfigurationWillBeSavedMessage(cfg, msg)
 	}
 
-	if invalidUsernameUsed {
+	if invalidUsernameUsed || invalidWebhooksErr != nil || invalidHooksErr != nil || invalidTemplatesErr != nil || invalidAutoResponderErr != nil || invalidKarmaWeightsErr != nil ||
+		invalidOnboardingChannelsErr != nil || invalidOffboardingReportChannelErr != nil {
 		return nil, errors.New(msg)
 	}
 
@@ -366,6 +619,27 @@ func (p *Plugin) ConfigurationWillBeSaved(newCfg *model.Config) (*model.Config,
 	return nil, nil
 }
 
+func (p *Plugin) postConfigurationWillBeSavedMessage(cfg *configuration, msg string) {
+	teams, appErr := p.API.GetTeams()
+	if appErr != nil {
+		p.API.LogError(
+			"Failed to query teams ConfigurationWillBeSaved",
+			"error", appErr.Error(),
+		)
+		return
+	}
+
+	for _, team := range teams {
+		if err := p.postPluginMessage(team.Id, msg); err != nil {
+			p.API.LogError(
+				"Failed to post ConfigurationWillBeSaved message",
+				"channel_id", cfg.demoChannelIDs[team.Id],
+				"error", err.Error(),
+			)
+		}
+	}
+}
+
 func (p *Plugin) ensureDemoUser(configuration *configuration) (string, error) {
 	user, err := p.API.GetUserByUsername(configuration.Username)
 	if err != nil {
@@ -449,10 +723,10 @@ func (p *Plugin) ensureDemoChannels(configuration *configuration) (map[string]st
 	return demoChannelIDs, nil
 }
 
-// setEnabled wraps setConfiguration to configure if the plugin is enabled.
-func (p *Plugin) setEnabled(enabled bool) {
-	var configuration = p.getConfiguration().Clone()
-	configuration.disabled = !enabled
-
-	p.setConfiguration(configuration)
+// setEnabled updates the runtime state to configure if the plugin is enabled on every plugin
+// instance.
+func (p *Plugin) setEnabled(enabled bool) error {
+	return p.updateRuntimeState(func(state *runtimeState) {
+		state.Disabled = !enabled
+	})
 }
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
 )
 
 func TestConfiguration(t *testing.T) {
@@ -49,3 +54,94 @@ func TestConfiguration(t *testing.T) {
 		assert.NotEqual(t, configuration1, plugin.getConfiguration())
 	})
 }
+
+func TestIsHookEnabled(t *testing.T) {
+	disabledHooks, err := parseDisabledHooks("messagehasbeenposted, UserHasLoggedIn")
+	require.NoError(t, err)
+
+	configuration := &configuration{
+		disabledHooks: disabledHooks,
+		hookOverrides: map[string]bool{
+			hookUserHasLoggedIn:   true,
+			hookUserHasJoinedTeam: false,
+		},
+	}
+
+	assert.False(t, configuration.isHookEnabled(hookMessageHasBeenPosted))
+	assert.True(t, configuration.isHookEnabled(hookUserHasLoggedIn))
+	assert.False(t, configuration.isHookEnabled(hookUserHasJoinedTeam))
+	assert.True(t, configuration.isHookEnabled(hookReactionHasBeenAdded))
+
+	configuration.disabled = true
+	assert.False(t, configuration.isHookEnabled(hookUserHasLoggedIn))
+	assert.False(t, configuration.isHookEnabled(hookReactionHasBeenAdded))
+
+	_, err = parseDisabledHooks("MessageHasBeenPosted, NotAHook")
+	assert.Error(t, err)
+}
+
+func TestParseHookTemplates(t *testing.T) {
+	t.Run("valid templates", func(t *testing.T) {
+		templates, err := parseHookTemplates("messagehasbeenposted: {{.User.Username}} posted {{.Post.Excerpt}}\n\nReactionHasBeenAdded: :{{.Emoji}}: {{.Permalink}}\n")
+		require.NoError(t, err)
+		require.Len(t, templates, 2)
+
+		var sb strings.Builder
+		require.NoError(t, templates[hookMessageHasBeenPosted].Execute(&sb, hookTemplateData{
+			User: &templateUser{Username: "alice"},
+			Post: &templatePost{Excerpt: excerpt("hello\n  world", postExcerptLength)},
+		}))
+		assert.Equal(t, "alice posted hello world", sb.String())
+
+		_, err = parseHookTemplates("MessageHasBeenPosted: {{with .Team}}{{.Name}}{{end}}\nUserHasJoinedTeam: {{.User.Username}} joined {{.Team.Name}}")
+		assert.NoError(t, err, "fields the hook may not set can be checked")
+	})
+
+	t.Run("invalid templates", func(t *testing.T) {
+		for _, setting := range []string{
+			"MessageHasBeenPosted {{.User.Username}}",
+			"NotAHook: {{.User.Username}}",
+			"MessageWillBePosted: {{.User.Username}}",
+			"MessageHasBeenPosted: {{.User.Username}",
+			"MessageHasBeenPosted: {{.User.Email}}",
+			"MessageHasBeenPosted: a\nMessageHasBeenPosted: b",
+			"UserHasJoinedTeam: {{.Post.Excerpt}}",
+			"ChannelHasBeenCreated: {{.User.Username}}",
+			"MessageHasBeenPosted: {{.Team.Name}}",
+		} {
+			_, err := parseHookTemplates(setting)
+			assert.Error(t, err, setting)
+		}
+	})
+}
+
+func TestConfigurationWillBeSaved(t *testing.T) {
+	plugin := &Plugin{}
+	api := &plugintest.API{}
+	plugin.SetAPI(api)
+	api.On("GetTeams").Return([]*model.Team{}, nil)
+
+	save := func(settings map[string]any) error {
+		_, err := plugin.ConfigurationWillBeSaved(&model.Config{PluginSettings: model.PluginSettings{
+			Plugins: map[string]map[string]any{manifest.Id: settings},
+		}})
+		return err
+	}
+
+	assert.NoError(t, save(map[string]any{"EventWebhooks": "https://example.com/hook secret"}))
+
+	err := save(map[string]any{"EventWebhooks": "https://example.com/hook"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid Event Webhooks value used: line 1")
+
+	t.Run("while the hooks are disabled", func(t *testing.T) {
+		config := plugin.getConfiguration().Clone()
+		config.disabled = true
+		plugin.setConfiguration(config)
+
+		assert.NoError(t, save(map[string]any{"DisabledHooks": "MessageHasBeenPosted"}))
+		assert.Error(t, save(map[string]any{"DisabledHooks": "NotAHook"}))
+		assert.Error(t, save(map[string]any{"EventWebhooks": "https://example.com/hook"}))
+		api.AssertNumberOfCalls(t, "GetTeams", 2)
+	})
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	// deletedPostKeyPrefix prefixes the KV keys of the archived deleted posts, by post id.
+	deletedPostKeyPrefix = "deleted_post_"
+
+	// deletedPostsIndexKey is the KV key of the index of the archived posts, so that listing them
+	// doesn't scan the KV store. deletedPostsChannelIndexKeyPrefix prefixes the per channel ones.
+	deletedPostsIndexKey              = "deleted_posts_index"
+	deletedPostsChannelIndexKeyPrefix = "deleted_posts_index_"
+
+	// maxDeletedPostsIndexSize is the maximum number of posts indexed, the first deleted ones
+	// being dropped first. They can still be restored by id until they expire.
+	maxDeletedPostsIndexSize = 1000
+
+	deletedPostsPageSize = 20
+)
+
+// deletedPost is the snapshot of a deleted post kept in the archive.
+type deletedPost struct {
+	ID        string                `json:"id"`
+	ChannelID string                `json:"channel_id"`
+	UserID    string                `json:"user_id"`
+	RootID    string                `json:"root_id,omitempty"`
+	Message   string                `json:"message"`
+	Props     model.StringInterface `json:"props,omitempty"`
+	FileIDs   []string              `json:"file_ids,omitempty"`
+	CreateAt  int64                 `json:"create_at"`
+	DeleteAt  int64                 `json:"delete_at"`
+
+	// DeletedBy is the user who deleted the post, if not its author.
+	DeletedBy string `json:"deleted_by,omitempty"`
+}
+
+// deletedPostIndexEntry is an archived post in the indexes.
+type deletedPostIndexEntry struct {
+	PostID   string `json:"post_id"`
+	DeleteAt int64  `json:"delete_at"`
+
+	// ExpireAt is when the archived post expires, after which the entry is dropped.
+	ExpireAt int64 `json:"expire_at"`
+}
+
+func deletedPostKey(postID string) string {
+	return deletedPostKeyPrefix + postID
+}
+
+// updateDeletedPostIndexes atomically applies the given change to the index of the archived posts
+// and to the one of the channel.
+func (p *Plugin) updateDeletedPostIndexes(channelID string, ttl time.Duration, change func(entries []deletedPostIndexEntry) []deletedPostIndexEntry) error {
+	for _, key := range []string{deletedPostsIndexKey, deletedPostsChannelIndexKeyPrefix + channelID} {
+		if err := p.updateKV(key, ttl, func(oldValue []byte) (any, error) {
+			var entries []deletedPostIndexEntry
+			if len(oldValue) > 0 {
+				if err := json.Unmarshal(oldValue, &entries); err != nil {
+					return nil, errors.Wrap(err, "failed to unmarshal deleted posts index")
+				}
+			}
+
+			if entries = change(entries); len(entries) == 0 {
+				return nil, nil
+			}
+			return entries, nil
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newDeletedPost(post *model.Post) *deletedPost {
+	snapshot := &deletedPost{
+		ID:        post.Id,
+		ChannelID: post.ChannelId,
+		UserID:    post.UserId,
+		RootID:    post.RootId,
+		Message:   post.Message,
+		Props:     post.GetProps(),
+		FileIDs:   post.FileIds,
+		CreateAt:  post.CreateAt,
+		DeleteAt:  post.DeleteAt,
+	}
+	if snapshot.DeleteAt == 0 {
+		snapshot.DeleteAt = model.GetMillis()
+	}
+	if deletedBy, ok := snapshot.Props[model.PostPropsDeleteBy].(string); ok {
+		snapshot.DeletedBy = deletedBy
+		delete(snapshot.Props, model.PostPropsDeleteBy)
+	}
+
+	return snapshot
+}
+
+// archiveDeletedPost snapshots the deleted post in the archive, expiring it after the configured
+// retention, and indexes it. Posts aren't archived when the retention is zero.
+func (p *Plugin) archiveDeletedPost(post *model.Post) error {
+	retentionDays := p.getConfiguration().DeletedPostRetentionDays
+	if retentionDays <= 0 {
+		return nil
+	}
+
+	retention := time.Duration(retentionDays) * 24 * time.Hour
+	snapshot := newDeletedPost(post)
+	if _, err := p.client.KV.Set(deletedPostKey(post.Id), snapshot, pluginapi.SetExpiry(retention)); err != nil {
+		return errors.Wrap(err, "failed to archive deleted post")
+	}
+
+	now := model.GetMillis()
+	if err := p.updateDeletedPostIndexes(post.ChannelId, retention, func(entries []deletedPostIndexEntry) []deletedPostIndexEntry {
+		entries = slices.DeleteFunc(entries, func(entry deletedPostIndexEntry) bool {
+			return entry.PostID == post.Id || entry.ExpireAt < now
+		})
+		entries = append(entries, deletedPostIndexEntry{PostID: post.Id, DeleteAt: snapshot.DeleteAt, ExpireAt: now + retention.Milliseconds()})
+		if len(entries) > maxDeletedPostsIndexSize {
+			entries = entries[len(entries)-maxDeletedPostsIndexSize:]
+		}
+		return entries
+	}); err != nil {
+		return errors.Wrap(err, "failed to index deleted post")
+	}
+
+	return nil
+}
+
+// listDeletedPosts returns the archived posts, most recently deleted first, optionally only those
+// of the given channel.
+func (p *Plugin) listDeletedPosts(channelID string, limit int) ([]*deletedPost, error) {
+	key := deletedPostsIndexKey
+	if channelID != "" {
+		key = deletedPostsChannelIndexKeyPrefix + channelID
+	}
+
+	var entries []deletedPostIndexEntry
+	if err := p.client.KV.Get(key, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to get deleted posts index")
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].DeleteAt > entries[j].DeleteAt })
+
+	posts := []*deletedPost{}
+	for _, entry := range entries {
+		if len(posts) == limit {
+			break
+		}
+
+		var post deletedPost
+		if err := p.client.KV.Get(deletedPostKey(entry.PostID), &post); err != nil {
+			return nil, errors.Wrapf(err, "failed to get deleted post %s", entry.PostID)
+		}
+
+		// The post may have expired or been restored since it was indexed.
+		if post.ID != "" {
+			posts = append(posts, &post)
+		}
+	}
+
+	return posts, nil
+}
+
+// restoreDeletedPost re-creates an archived post as the bot, with attribution to its author, and
+// removes it from the archive. The post is threaded under its root if the root still exists.
+func (p *Plugin) restoreDeletedPost(postID string) (*model.Post, error) {
+	key := deletedPostKey(postID)
+
+	// Take the post out of the archive first, so that it is restored only once.
+	data, err := p.takeKV(key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var snapshot deletedPost
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal deleted post")
+	}
+
+	post := p.restoredPost(&snapshot)
+	created, appErr := p.API.CreatePost(post)
+	if appErr != nil && post.RootId != "" {
+		// The thread may have been deleted as well, restore the post on its own then.
+		post.RootId = ""
+		created, appErr = p.API.CreatePost(post)
+	}
+	if appErr != nil {
+		var options []pluginapi.KVSetOption
+		if retentionDays := p.getConfiguration().DeletedPostRetentionDays; retentionDays > 0 {
+			options = append(options, pluginapi.SetExpiry(time.Duration(retentionDays)*24*time.Hour))
+		}
+		if _, err := p.client.KV.Set(key, data, options...); err != nil {
+			p.API.LogError("Failed to put deleted post back into the archive", "post_id", postID, "err", err.Error())
+		}
+		return nil, errors.Wrap(appErr, "failed to restore post")
+	}
+
+	if err := p.updateDeletedPostIndexes(snapshot.ChannelID, 0, func(entries []deletedPostIndexEntry) []deletedPostIndexEntry {
+		return slices.DeleteFunc(entries, func(entry deletedPostIndexEntry) bool { return entry.PostID == postID })
+	}); err != nil {
+		p.API.LogWarn("Failed to remove restored post from the index", "post_id", postID, "err", err.Error())
+	}
+
+	return created, nil
+}
+
+// restoredPost builds the post re-creating the snapshot as the bot.
+func (p *Plugin) restoredPost(snapshot *deletedPost) *model.Post {
+	author := snapshot.UserID
+	if user, appErr := p.API.GetUser(snapshot.UserID); appErr == nil {
+		author = "@" + user.Username
+	}
+
+	props := model.StringInterface{}
+	for key, value := range snapshot.Props {
+		props[key] = value
+	}
+	props["restored_from_post_id"] = snapshot.ID
+	props["restored_from_user_id"] = snapshot.UserID
+
+	message := snapshot.Message
+	attribution := fmt.Sprintf("_Restored post by %s, originally posted %s:_",
+		author, time.UnixMilli(snapshot.CreateAt).UTC().Format(time.RFC1123))
+
+	// Encrypted posts are restored as is so that they can still be decrypted, the attribution
+	// being only set as props.
+	if !encryptedMessageRegexp.MatchString(message) {
+		message = attribution + "\n\n" + message
+	}
+
+	// Files of deleted posts are deleted as well, so only their names are restored.
+	var fileNames []string
+	for _, fileID := range snapshot.FileIDs {
+		name := fileID
+		if info, appErr := p.API.GetFileInfo(fileID); appErr == nil {
+			name = info.Name
+		}
+		fileNames = append(fileNames, name)
+	}
+	if len(fileNames) > 0 {
+		message += fmt.Sprintf("\n\n_Attached files: %s_", strings.Join(fileNames, ", "))
+	}
+
+	return &model.Post{
+		UserId:    p.botID,
+		ChannelId: snapshot.ChannelID,
+		RootId:    snapshot.RootID,
+		Message:   message,
+		Props:     props,
+	}
+}
+
+func (p *Plugin) executeCommandDeleted(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if !p.isSystemAdmin(args.UserId) {
+		return respond("Only system admins can manage the deleted posts.")
+	}
+
+	switch {
+	case len(params) == 0 || (params[0] == "list" && len(params) <= 2):
+		channelID := ""
+		if len(params) == 2 {
+			channel, err := p.findChannel(args.TeamId, params[1])
+			if err != nil {
+				return respond(fmt.Sprintf("Unknown channel %s.", params[1]))
+			}
+			channelID = channel.Id
+		}
+
+		posts, err := p.listDeletedPosts(channelID, deletedPostsPageSize)
+		if err != nil {
+			p.API.LogError("Failed to list deleted posts", "err", err.Error())
+			return respond("Failed to list the deleted posts.")
+		}
+
+		return respond(p.deletedPostsSummary(posts))
+	case params[0] == "restore" && len(params) == 2:
+		post, err := p.restoreDeletedPost(params[1])
+		if err != nil {
+			p.API.LogError("Failed to restore deleted post", "post_id", params[1], "err", err.Error())
+			return respond("Failed to restore the deleted post.")
+		}
+		if post == nil {
+			return respond(fmt.Sprintf("Post `%s` isn't in the archive of deleted posts.", params[1]))
+		}
+
+		return respond(fmt.Sprintf("Restored post `%s`: %s", params[1], p.permalink(post.Id)))
+	default:
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+}
+
+// findChannel looks up a channel by id, or by name in the given team, with or without the ~
+// prefix.
+func (p *Plugin) findChannel(teamID, nameOrID string) (*model.Channel, error) {
+	nameOrID = strings.TrimPrefix(nameOrID, "~")
+	if model.IsValidId(nameOrID) {
+		if channel, appErr := p.API.GetChannel(nameOrID); appErr == nil {
+			return channel, nil
+		}
+	}
+
+	channel, appErr := p.API.GetChannelByName(teamID, nameOrID, false)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	return channel, nil
+}
+
+// deletedPostsSummary lists the archived posts in Markdown.
+func (p *Plugin) deletedPostsSummary(posts []*deletedPost) string {
+	if len(posts) == 0 {
+		return "No deleted posts in the archive."
+	}
+
+	usernames := make(map[string]string)
+	username := func(userID string) string {
+		if _, ok := usernames[userID]; !ok {
+			usernames[userID] = userID
+			if user, appErr := p.API.GetUser(userID); appErr == nil {
+				usernames[userID] = "@" + user.Username
+			}
+		}
+		return usernames[userID]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Post | Channel | Author | Deleted | Message |\n")
+	sb.WriteString("|------|---------|--------|---------|---------|\n")
+	for _, post := range posts {
+		message := excerpt(post.Message, postExcerptLength)
+		if encryptedMessageRegexp.MatchString(post.Message) {
+			message = "_encrypted_"
+		}
+
+		deleted := time.UnixMilli(post.DeleteAt).UTC().Format(time.RFC3339)
+		if post.DeletedBy != "" {
+			deleted += " by " + username(post.DeletedBy)
+		}
+
+		fmt.Fprintf(&sb, "| `%s` | `%s` | %s | %s | %s |\n",
+			post.ID, post.ChannelID, username(post.UserID), deleted, strings.ReplaceAll(message, "|", `\|`))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestDeletedPostArchive(t *testing.T) {
+	cluster := newFakeCluster()
+	node := cluster.addNode(t)
+	node.botID = "bot"
+	configuration := node.getConfiguration().Clone()
+	configuration.DeletedPostRetentionDays = 7
+	node.setConfiguration(configuration)
+
+	api := node.API.(*fakeNodeAPI).API
+	api.On("GetUser", "author").Return(&model.User{Id: "author", Username: "jane"}, nil)
+	api.On("GetFileInfo", "file1").Return(&model.FileInfo{Id: "file1", Name: "notes.txt"}, nil)
+
+	deleted := func(id, channelID, message string, deleteAt int64) *model.Post {
+		post := &model.Post{Id: id, ChannelId: channelID, UserId: "author", RootId: "root", Message: message, CreateAt: 1, DeleteAt: deleteAt}
+		post.AddProp(model.PostPropsDeleteBy, "admin")
+		require.NoError(t, node.archiveDeletedPost(post))
+		return post
+	}
+
+	post1 := deleted("post1", "channel1", "first", 10)
+	post1.FileIds = model.StringArray{"file1"}
+	require.NoError(t, node.archiveDeletedPost(post1))
+	deleted("post2", "channel2", "second", 20)
+	deleted("post3", "channel1", "third", 30)
+
+	t.Run("list", func(t *testing.T) {
+		kvLists := cluster.kvLists
+		defer func() { assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned") }()
+
+		posts, err := node.listDeletedPosts("", 10)
+		require.NoError(t, err)
+		require.Len(t, posts, 3)
+		assert.Equal(t, []string{"post3", "post2", "post1"}, []string{posts[0].ID, posts[1].ID, posts[2].ID})
+		assert.Equal(t, "admin", posts[0].DeletedBy)
+		assert.NotContains(t, posts[0].Props, model.PostPropsDeleteBy)
+
+		posts, err = node.listDeletedPosts("channel1", 1)
+		require.NoError(t, err)
+		require.Len(t, posts, 1)
+		assert.Equal(t, "post3", posts[0].ID)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		// The thread was deleted as well, so the post is restored on its own.
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool { return post.RootId != "" })).
+			Return(nil, &model.AppError{Message: "invalid root"}).Once()
+		var restored *model.Post
+		api.On("CreatePost", mock.MatchedBy(func(post *model.Post) bool { return post.RootId == "" })).
+			Run(func(args mock.Arguments) { restored = args.Get(0).(*model.Post) }).
+			Return(&model.Post{Id: "restored"}, nil).Once()
+
+		post, err := node.restoreDeletedPost("post1")
+		require.NoError(t, err)
+		assert.Equal(t, "restored", post.Id)
+
+		require.NotNil(t, restored)
+		assert.Equal(t, "bot", restored.UserId)
+		assert.Equal(t, "channel1", restored.ChannelId)
+		assert.True(t, strings.HasPrefix(restored.Message, "_Restored post by @jane"))
+		assert.Contains(t, restored.Message, "\n\nfirst\n\n_Attached files: notes.txt_")
+		assert.Equal(t, "post1", restored.GetProp("restored_from_post_id"))
+
+		post, err = node.restoreDeletedPost("post1")
+		require.NoError(t, err)
+		assert.Nil(t, post, "posts are restored only once")
+
+		var entries []deletedPostIndexEntry
+		require.NoError(t, node.client.KV.Get(deletedPostsChannelIndexKeyPrefix+"channel1", &entries))
+		require.Len(t, entries, 1, "restored posts are removed from the index")
+		assert.Equal(t, "post3", entries[0].PostID)
+	})
+
+	t.Run("failed restores keep the post archived", func(t *testing.T) {
+		api.On("CreatePost", mock.Anything).Return(nil, &model.AppError{Message: "failed"}).Twice()
+
+		_, err := node.restoreDeletedPost("post2")
+		require.Error(t, err)
+
+		posts, err := node.listDeletedPosts("channel2", 10)
+		require.NoError(t, err)
+		assert.Len(t, posts, 1)
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+const (
+	// digestKeyPrefix prefixes the KV keys of the per team digest buffers.
+	digestKeyPrefix = "digest_"
+
+	defaultDigestInterval = 15 * time.Minute
+
+	// digestTopActors is the number of most active users listed for each hook in a digest.
+	digestTopActors = 3
+)
+
+// digestBuffer accumulates the hook notifications of a team until the next digest is posted.
+type digestBuffer struct {
+	// Since is the time, in milliseconds, of the first buffered notification.
+	Since int64 `json:"since"`
+
+	// Counts maps each hook to the number of notifications per actor. Notifications without an
+	// actor are counted under the empty string.
+	Counts map[string]map[string]int `json:"counts"`
+}
+
+// postHookMessage posts the notification of a hook to the demo channel of the team, rendered
+// with the configured template if any, or buffers it for the next digest when digest mode is
+// enabled.
+func (p *Plugin) postHookMessage(hook, teamID string, data hookTemplateData, msg string) *model.AppError {
+	if !p.getConfiguration().EnableDigest {
+		return p.postPluginMessage(teamID, p.renderHookMessage(hook, teamID, data, msg))
+	}
+
+	actor := ""
+	if data.User != nil {
+		actor = data.User.Username
+	}
+
+	err := p.updateKV(digestKeyPrefix+teamID, 0, func(oldValue []byte) (any, error) {
+		buffer := digestBuffer{
+			Since:  model.GetMillis(),
+			Counts: make(map[string]map[string]int),
+		}
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &buffer); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal digest buffer")
+			}
+		}
+
+		if buffer.Counts[hook] == nil {
+			buffer.Counts[hook] = make(map[string]int)
+		}
+		buffer.Counts[hook][actor]++
+
+		return buffer, nil
+	})
+	if err != nil {
+		return model.NewAppError("postHookMessage", "demo_plugin.digest.buffer", nil, err.Error(), http.StatusInternalServerError)
+	}
+
+	return nil
+}
+
+// postHookMessageWithReply posts the notification of a hook like postHookMessage, threading the
+// given reply under it. The reply is dropped in digest mode.
+func (p *Plugin) postHookMessageWithReply(hook, teamID string, data hookTemplateData, msg string, reply *model.Post) *model.AppError {
+	if p.getConfiguration().EnableDigest {
+		return p.postHookMessage(hook, teamID, data, msg)
+	}
+
+	posts, appErr := p.createPluginMessages(teamID, p.renderHookMessage(hook, teamID, data, msg))
+	if appErr != nil {
+		return appErr
+	}
+
+	for _, post := range posts {
+		threaded := reply.Clone()
+		threaded.UserId = p.botID
+		threaded.ChannelId = post.ChannelId
+		threaded.RootId = post.Id
+		if _, appErr := p.API.CreatePost(threaded); appErr != nil {
+			return appErr
+		}
+	}
+
+	return nil
+}
+
+// digestWaitInterval schedules the digest job using the currently configured interval, so that
+// changes to the configuration apply without rescheduling the job.
+func (p *Plugin) digestWaitInterval(now time.Time, metadata cluster.JobMetadata) time.Duration {
+	interval := time.Duration(p.getConfiguration().DigestIntervalMinutes) * time.Minute
+	if interval <= 0 {
+		interval = defaultDigestInterval
+	}
+
+	return cluster.MakeWaitForInterval(interval)(now, metadata)
+}
+
+// DigestJob posts a summary of the buffered hook notifications to the demo channel of each
+// team. The notifications without a team, e.g. of direct messages, are buffered under the empty
+// team id and posted to the demo channel of every team, as they are when digest mode is disabled.
+// It is scheduled on a cluster.Job, so only one plugin instance posts digests at a time. Buffers
+// are flushed even if digest mode was disabled in the meantime.
+func (p *Plugin) DigestJob() {
+	configuration := p.getConfiguration()
+
+	teamIDs := []string{""}
+	for teamID := range configuration.demoChannelIDs {
+		teamIDs = append(teamIDs, teamID)
+	}
+
+	for _, teamID := range teamIDs {
+		buffer, err := p.takeDigestBuffer(teamID)
+		if err != nil {
+			p.API.LogError("Failed to take digest buffer", "team_id", teamID, "err", err.Error())
+			continue
+		}
+
+		if buffer == nil || len(buffer.Counts) == 0 {
+			continue
+		}
+
+		if appErr := p.postPluginMessage(teamID, formatDigest(buffer)); appErr != nil {
+			p.API.LogError("Failed to post digest", "team_id", teamID, "err", appErr.Error())
+		}
+	}
+}
+
+// takeDigestBuffer atomically removes and returns the digest buffer of the team, so that
+// notifications buffered concurrently end up in the next digest instead of being lost.
+func (p *Plugin) takeDigestBuffer(teamID string) (*digestBuffer, error) {
+	data, err := p.takeKV(digestKeyPrefix + teamID)
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var buffer digestBuffer
+	if err := json.Unmarshal(data, &buffer); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal digest buffer")
+	}
+
+	return &buffer, nil
+}
+
+// formatDigest summarizes the buffer in Markdown, grouped by hook with the total number of
+// notifications and the most active users.
+func formatDigest(buffer *digestBuffer) string {
+	hooks := make([]string, 0, len(buffer.Counts))
+	totals := make(map[string]int, len(buffer.Counts))
+	for hook, actors := range buffer.Counts {
+		hooks = append(hooks, hook)
+		for _, count := range actors {
+			totals[hook] += count
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		if totals[hooks[i]] != totals[hooks[j]] {
+			return totals[hooks[i]] > totals[hooks[j]]
+		}
+		return hooks[i] < hooks[j]
+	})
+
+	var sb strings.Builder
+	since := time.UnixMilli(buffer.Since).UTC().Format(time.RFC1123)
+	fmt.Fprintf(&sb, "Digest of hook notifications since %s\n\n", since)
+	sb.WriteString("| Hook | Count | Top users |\n")
+	sb.WriteString("|------|-------|-----------|\n")
+	for _, hook := range hooks {
+		fmt.Fprintf(&sb, "| %s | %d | %s |\n", hook, totals[hook], formatTopActors(buffer.Counts[hook]))
+	}
+
+	return sb.String()
+}
+
+func formatTopActors(counts map[string]int) string {
+	actors := make([]string, 0, len(counts))
+	for actor := range counts {
+		if actor != "" {
+			actors = append(actors, actor)
+		}
+	}
+	sort.Slice(actors, func(i, j int) bool {
+		if counts[actors[i]] != counts[actors[j]] {
+			return counts[actors[i]] > counts[actors[j]]
+		}
+		return actors[i] < actors[j]
+	})
+
+	if len(actors) > digestTopActors {
+		actors = actors[:digestTopActors]
+	}
+
+	top := make([]string, 0, len(actors))
+	for _, actor := range actors {
+		top = append(top, fmt.Sprintf("@%s (%d)", actor, counts[actor]))
+	}
+
+	return strings.Join(top, ", ")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestFormatDigest(t *testing.T) {
+	since := time.Date(2024, 3, 1, 9, 30, 0, 0, time.UTC)
+	digest := formatDigest(&digestBuffer{
+		Since: since.UnixMilli(),
+		Counts: map[string]map[string]int{
+			hookUserHasJoinedTeam:     {"bob": 1},
+			hookMessageHasBeenPosted:  {"alice": 3, "bob": 3, "carol": 1, "dave": 2, "": 4},
+			hookChannelHasBeenCreated: {"erin": 1},
+		},
+	})
+
+	assert.Equal(t, "Digest of hook notifications since Fri, 01 Mar 2024 09:30:00 UTC\n\n"+
+		"| Hook | Count | Top users |\n"+
+		"|------|-------|-----------|\n"+
+		"| MessageHasBeenPosted | 13 | @alice (3), @bob (3), @dave (2) |\n"+
+		"| ChannelHasBeenCreated | 1 | @erin (1) |\n"+
+		"| UserHasJoinedTeam | 1 | @bob (1) |\n", digest)
+}
+
+func TestDigest(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	var lock sync.Mutex
+	var posts []*model.Post
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		config := node.getConfiguration().Clone()
+		config.EnableDigest = true
+		config.demoChannelIDs = map[string]string{"team1": "demo1", "team2": "demo2"}
+		node.setConfiguration(config)
+
+		node.API.(*fakeNodeAPI).API.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			lock.Lock()
+			defer lock.Unlock()
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+	}
+
+	notify := func(node *Plugin, hook, teamID, username string) {
+		data := hookTemplateData{Hook: hook}
+		if username != "" {
+			data.User = newTemplateUser(&model.User{Username: username})
+		}
+		require.Nil(t, node.postHookMessage(hook, teamID, data, "message"))
+	}
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func(node *Plugin) {
+			defer wg.Done()
+			notify(node, hookMessageHasBeenPosted, "team1", "alice")
+		}(cluster.nodes[i%2])
+	}
+	wg.Wait()
+	notify(node1, hookMessageHasBeenPosted, "team1", "bob")
+	notify(node2, hookMessageHasBeenPosted, "team1", "")
+	notify(node2, hookUserHasJoinedTeam, "team1", "carol")
+	notify(node1, hookUserHasLeftTeam, "team2", "dave")
+	assert.Empty(t, posts, "notifications are buffered")
+
+	t.Run("flushed once across nodes", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for _, node := range cluster.nodes {
+			wg.Add(1)
+			go func(node *Plugin) {
+				defer wg.Done()
+				node.DigestJob()
+			}(node)
+		}
+		wg.Wait()
+
+		require.Len(t, posts, 2)
+		byChannel := map[string]string{}
+		for _, post := range posts {
+			byChannel[post.ChannelId] = post.Message
+		}
+
+		team1 := byChannel["demo1"]
+		assert.Contains(t, team1, "| MessageHasBeenPosted | 12 | @alice (10), @bob (1) |\n")
+		assert.Contains(t, team1, "| UserHasJoinedTeam | 1 | @carol (1) |\n")
+		assert.Less(t, strings.Index(team1, hookMessageHasBeenPosted), strings.Index(team1, hookUserHasJoinedTeam), "busiest hooks first")
+		assert.NotContains(t, team1, hookUserHasLeftTeam)
+
+		assert.Contains(t, byChannel["demo2"], "| UserHasLeftTeam | 1 | @dave (1) |\n")
+	})
+
+	t.Run("nothing to flush", func(t *testing.T) {
+		posts = nil
+		node1.DigestJob()
+		node2.DigestJob()
+		assert.Empty(t, posts)
+	})
+
+	t.Run("next digest", func(t *testing.T) {
+		notify(node2, hookChannelHasBeenCreated, "team2", "erin")
+		node1.DigestJob()
+		require.Len(t, posts, 1)
+		assert.Equal(t, "demo2", posts[0].ChannelId)
+		assert.Contains(t, posts[0].Message, "| ChannelHasBeenCreated | 1 | @erin (1) |\n")
+		assert.NotContains(t, posts[0].Message, hookUserHasLeftTeam)
+	})
+
+	t.Run("notifications without a team", func(t *testing.T) {
+		posts = nil
+		notify(node1, hookMessageHasBeenPosted, "", "grace")
+		notify(node2, hookMessageHasBeenPosted, "", "grace")
+		node2.DigestJob()
+
+		require.Len(t, posts, 2)
+		assert.ElementsMatch(t, []string{"demo1", "demo2"}, []string{posts[0].ChannelId, posts[1].ChannelId})
+		for _, post := range posts {
+			assert.Contains(t, post.Message, "| MessageHasBeenPosted | 2 | @grace (2) |\n")
+		}
+	})
+
+	t.Run("posted directly when disabled", func(t *testing.T) {
+		posts = nil
+		config := node1.getConfiguration().Clone()
+		config.EnableDigest = false
+		node1.setConfiguration(config)
+
+		notify(node1, hookUserHasJoinedTeam, "team1", "frank")
+		require.Len(t, posts, 1)
+		assert.Equal(t, "message", posts[0].Message)
+	})
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errDueQueueFull is returned when adding an item to a dueQueue for a user who already has the
+// maximum number of items.
+var errDueQueueFull = errors.New("too many items")
+
+// dueItem is an item waiting in a dueQueue for its time to come.
+type dueItem interface {
+	// dueKey returns the id of the user the item belongs to and the id of the item.
+	dueKey() (ownerID, id string)
+
+	// dueAt returns when the item is due, in milliseconds.
+	dueAt() int64
+}
+
+// dueQueue keeps the items to be delivered once their time has come, such as scheduled posts
+// and reminders, in the KV store. The items of a user are kept together under a single key, and
+// an index key lists when each item is due, so that neither listing the items of a user nor
+// finding the due items scans the KV store. Items are taken from the queue before being
+// delivered, so that each item is delivered only once, even if cancelled concurrently.
+type dueQueue[T dueItem] struct {
+	p *Plugin
+
+	// keyPrefix prefixes the KV keys of the queue: the index of the due items, and the items of
+	// each user.
+	keyPrefix string
+
+	// name is the name of the items, used in the logs.
+	name string
+
+	maxPerOwner int
+
+	// maxAttempts is how many times the delivery of an item is attempted before giving up,
+	// retryDelay apart.
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+// dueEntry is the entry of an item in the index of a dueQueue.
+type dueEntry struct {
+	OwnerID  string `json:"owner_id"`
+	ID       string `json:"id"`
+	DueAt    int64  `json:"due_at"`
+	Attempts int    `json:"attempts,omitempty"`
+}
+
+func (q *dueQueue[T]) indexKey() string {
+	return q.keyPrefix + "due"
+}
+
+func (q *dueQueue[T]) ownerKey(ownerID string) string {
+	return q.keyPrefix + "user_" + ownerID
+}
+
+func sortDueItems[T dueItem](items []T) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].dueAt() < items[j].dueAt()
+	})
+}
+
+// list returns the items of the user, soonest first.
+func (q *dueQueue[T]) list(ownerID string) ([]T, error) {
+	var items []T
+	if err := q.p.client.KV.Get(q.ownerKey(ownerID), &items); err != nil {
+		return nil, errors.Wrapf(err, "failed to get %ss of user %s", q.name, ownerID)
+	}
+	sortDueItems(items)
+
+	return items, nil
+}
+
+// listAll returns the items of all the users, soonest first.
+func (q *dueQueue[T]) listAll() ([]T, error) {
+	entries, err := q.getIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var items []T
+	listed := make(map[string]bool)
+	for _, entry := range entries {
+		if listed[entry.OwnerID] {
+			continue
+		}
+		listed[entry.OwnerID] = true
+
+		ownerItems, err := q.list(entry.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, ownerItems...)
+	}
+	sortDueItems(items)
+
+	return items, nil
+}
+
+func (q *dueQueue[T]) getIndex() ([]dueEntry, error) {
+	var entries []dueEntry
+	if err := q.p.client.KV.Get(q.indexKey(), &entries); err != nil {
+		return nil, errors.Wrapf(err, "failed to get %s index", q.name)
+	}
+
+	return entries, nil
+}
+
+// setIndexEntry atomically adds the entry to the index, replacing the one of the same item.
+func (q *dueQueue[T]) setIndexEntry(entry dueEntry) error {
+	return q.p.updateKV(q.indexKey(), 0, func(oldValue []byte) (any, error) {
+		var entries []dueEntry
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &entries); err != nil {
+				return nil, errors.Wrapf(err, "failed to unmarshal %s index", q.name)
+			}
+		}
+
+		entries = slices.DeleteFunc(entries, func(e dueEntry) bool {
+			return e.OwnerID == entry.OwnerID && e.ID == entry.ID
+		})
+		i, _ := slices.BinarySearchFunc(entries, entry.DueAt, func(e dueEntry, dueAt int64) int {
+			if e.DueAt <= dueAt {
+				return -1
+			}
+			return 1
+		})
+
+		return slices.Insert(entries, i, entry), nil
+	})
+}
+
+// removeIndexEntry atomically removes the entry of the item from the index.
+func (q *dueQueue[T]) removeIndexEntry(ownerID, id string) error {
+	return q.p.updateKV(q.indexKey(), 0, func(oldValue []byte) (any, error) {
+		if len(oldValue) == 0 {
+			return nil, nil
+		}
+
+		var entries []dueEntry
+		if err := json.Unmarshal(oldValue, &entries); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal %s index", q.name)
+		}
+
+		entries = slices.DeleteFunc(entries, func(e dueEntry) bool {
+			return e.OwnerID == ownerID && e.ID == id
+		})
+		if len(entries) == 0 {
+			return nil, nil
+		}
+
+		return entries, nil
+	})
+}
+
+// updateOwner atomically replaces the items of the user with the ones returned by update. The
+// key is deleted when no item is left.
+func (q *dueQueue[T]) updateOwner(ownerID string, update func(items []T) ([]T, error)) error {
+	return q.p.updateKV(q.ownerKey(ownerID), 0, func(oldValue []byte) (any, error) {
+		var items []T
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &items); err != nil {
+				return nil, errors.Wrapf(err, "failed to unmarshal %ss of user %s", q.name, ownerID)
+			}
+		}
+
+		items, err := update(items)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) == 0 {
+			return nil, nil
+		}
+
+		return items, nil
+	})
+}
+
+// add stores the new item, returning errDueQueueFull if its user already has maxPerOwner items.
+func (q *dueQueue[T]) add(item T) error {
+	ownerID, id := item.dueKey()
+	return q.store(item, dueEntry{OwnerID: ownerID, ID: id, DueAt: item.dueAt()}, true)
+}
+
+// put stores the item, replacing the one with the same id if any, regardless of how many items
+// its user has.
+func (q *dueQueue[T]) put(item T) error {
+	ownerID, id := item.dueKey()
+	return q.store(item, dueEntry{OwnerID: ownerID, ID: id, DueAt: item.dueAt()}, false)
+}
+
+// store indexes the item, then saves it with the other items of the user. It is indexed first
+// so that an item is never left out of the index, an entry without item being dropped when due.
+func (q *dueQueue[T]) store(item T, entry dueEntry, limit bool) error {
+	if err := q.setIndexEntry(entry); err != nil {
+		return err
+	}
+
+	err := q.updateOwner(entry.OwnerID, func(items []T) ([]T, error) {
+		i := slices.IndexFunc(items, func(other T) bool {
+			_, id := other.dueKey()
+			return id == entry.ID
+		})
+		if i >= 0 {
+			items[i] = item
+			return items, nil
+		}
+		if limit && len(items) >= q.maxPerOwner {
+			return nil, errDueQueueFull
+		}
+
+		return append(items, item), nil
+	})
+	if errors.Is(err, errDueQueueFull) {
+		if removeErr := q.removeIndexEntry(entry.OwnerID, entry.ID); removeErr != nil {
+			q.p.API.LogWarn("Failed to remove index entry", "name", q.name, "id", entry.ID, "err", removeErr.Error())
+		}
+	}
+
+	return err
+}
+
+// take atomically removes the item from the queue and returns it. It returns false if the item
+// doesn't exist, e.g. if it was taken concurrently.
+func (q *dueQueue[T]) take(ownerID, id string) (T, bool, error) {
+	var taken T
+	var found bool
+	err := q.updateOwner(ownerID, func(items []T) ([]T, error) {
+		found = false
+		return slices.DeleteFunc(items, func(item T) bool {
+			if _, itemID := item.dueKey(); itemID != id {
+				return false
+			}
+			taken = item
+			found = true
+			return true
+		}), nil
+	})
+	if err != nil {
+		return taken, false, err
+	}
+
+	// A stale entry is dropped when due, so the item is delivered regardless.
+	if err := q.removeIndexEntry(ownerID, id); err != nil {
+		q.p.API.LogWarn("Failed to remove index entry", "name", q.name, "id", id, "err", err.Error())
+	}
+
+	return taken, found, nil
+}
+
+// deliverDue takes the items due at the given time and delivers them. Failed deliveries are put
+// back to be retried, up to maxAttempts, after which giveUp is called if not nil.
+func (q *dueQueue[T]) deliverDue(now time.Time, deliver func(item T) error, giveUp func(item T, err error)) {
+	entries, err := q.getIndex()
+	if err != nil {
+		q.p.API.LogError("Failed to list due items", "name", q.name, "err", err.Error())
+		return
+	}
+
+	for _, entry := range entries {
+		if entry.DueAt > now.UnixMilli() {
+			break
+		}
+
+		item, found, err := q.take(entry.OwnerID, entry.ID)
+		if err != nil {
+			q.p.API.LogError("Failed to claim due item", "name", q.name, "id", entry.ID, "err", err.Error())
+			continue
+		}
+		if !found {
+			// Cancelled or delivered in the meantime.
+			continue
+		}
+
+		err = deliver(item)
+		if err == nil {
+			continue
+		}
+
+		attempts := entry.Attempts + 1
+		q.p.API.LogWarn("Failed to deliver due item", "name", q.name, "id", entry.ID, "attempts", attempts, "err", err.Error())
+		if attempts >= q.maxAttempts {
+			if giveUp != nil {
+				giveUp(item, err)
+			}
+			continue
+		}
+
+		retry := dueEntry{OwnerID: entry.OwnerID, ID: entry.ID, DueAt: time.Now().Add(q.retryDelay).UnixMilli(), Attempts: attempts}
+		if err := q.store(item, retry, false); err != nil {
+			q.p.API.LogError("Failed to save due item for retry", "name", q.name, "id", entry.ID, "err", err.Error())
+		}
+	}
+}
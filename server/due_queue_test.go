@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDueQueue(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	for _, node := range cluster.nodes {
+		node.API.(*fakeNodeAPI).API.On("LogWarn", "Failed to deliver due item", "name", "scheduled post", "id", "c", "attempts", mock.Anything, "err", "failed")
+	}
+
+	queue := func(node *Plugin) *dueQueue[*scheduledPost] {
+		q := node.scheduledPostsQueue()
+		q.maxPerOwner = 3
+		return q
+	}
+
+	now := time.Now()
+	item := func(userID, id string, postAt time.Time) *scheduledPost {
+		return &scheduledPost{ID: id, UserID: userID, ChannelID: "channel1", Message: "message " + id, PostAt: postAt.UnixMilli()}
+	}
+	ids := func(items []*scheduledPost) []string {
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item.UserID+"/"+item.ID)
+		}
+		return ids
+	}
+
+	require.NoError(t, queue(node1).add(item("user1", "b", now.Add(time.Hour))))
+	require.NoError(t, queue(node2).add(item("user1", "a", now.Add(-time.Minute))))
+	require.NoError(t, queue(node1).add(item("user2", "c", now.Add(-time.Second))))
+	require.NoError(t, queue(node2).add(item("user1", "d", now.Add(2*time.Hour))))
+
+	t.Run("list", func(t *testing.T) {
+		items, err := queue(node2).list("user1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user1/a", "user1/b", "user1/d"}, ids(items))
+
+		items, err = queue(node1).listAll()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user1/a", "user2/c", "user1/b", "user1/d"}, ids(items))
+	})
+
+	t.Run("limit per user", func(t *testing.T) {
+		assert.ErrorIs(t, queue(node1).add(item("user1", "e", now)), errDueQueueFull)
+		require.NoError(t, queue(node1).put(item("user1", "b", now.Add(3*time.Hour))), "existing items can be replaced")
+
+		entries, err := queue(node2).getIndex()
+		require.NoError(t, err)
+		require.Len(t, entries, 4, "the rejected item isn't indexed")
+		assert.Equal(t, "b", entries[3].ID, "the index is sorted by due time")
+	})
+
+	t.Run("take", func(t *testing.T) {
+		taken, found, err := queue(node2).take("user1", "d")
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Equal(t, "message d", taken.Message)
+
+		_, found, err = queue(node1).take("user1", "d")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("deliver due items with retries", func(t *testing.T) {
+		var delivered, failed []string
+		var gaveUp []string
+		deliver := func(item *scheduledPost) error {
+			if item.ID == "c" {
+				failed = append(failed, item.ID)
+				return errors.New("failed")
+			}
+			delivered = append(delivered, item.ID)
+			return nil
+		}
+		giveUp := func(item *scheduledPost, err error) {
+			gaveUp = append(gaveUp, item.ID)
+		}
+
+		kvLists := cluster.kvLists
+		queue(node1).deliverDue(now, deliver, giveUp)
+		assert.Equal(t, []string{"a"}, delivered)
+		assert.Equal(t, []string{"c"}, failed)
+
+		queue(node2).deliverDue(now, deliver, giveUp)
+		assert.Len(t, failed, 1, "retried after the retry delay")
+
+		for i := range scheduledPostMaxAttempts {
+			queue(node2).deliverDue(time.Now().Add(time.Duration(i+1)*scheduledPostRetryDelay), deliver, giveUp)
+		}
+		assert.Len(t, failed, scheduledPostMaxAttempts)
+		assert.Equal(t, []string{"c"}, gaveUp)
+		assert.Equal(t, []string{"a"}, delivered)
+		assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned")
+
+		items, err := queue(node1).listAll()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user1/b"}, ids(items))
+	})
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// editHistoryKeyPrefix prefixes the KV keys of the per post edit histories.
+	editHistoryKeyPrefix = "post_history_"
+
+	// editHistoryRetention is how long the edit history of a post is kept after its last edit.
+	editHistoryRetention = 90 * 24 * time.Hour
+
+	// maxEditHistoryRevisions bounds the number of revisions kept per post, dropping the oldest.
+	maxEditHistoryRevisions = 50
+
+	// maxDiffCells bounds the size of the table used to diff the changed words, above which the
+	// changed span is reported as replaced as a whole.
+	maxDiffCells = 250000
+
+	// diffContextWords is the number of unchanged words kept around changes in rendered diffs.
+	diffContextWords = 5
+)
+
+const (
+	diffEqual  = "equal"
+	diffInsert = "insert"
+	diffDelete = "delete"
+)
+
+// diffOp is a span of text that is unchanged, inserted or deleted.
+type diffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// tokenizeWords splits text into words and runs of whitespace, so that joining the tokens gives
+// the text back.
+func tokenizeWords(text string) []string {
+	var tokens []string
+	start, inSpace := 0, false
+	for i, r := range text {
+		if i > start && unicode.IsSpace(r) != inSpace {
+			tokens = append(tokens, text[start:i])
+			start = i
+		}
+		inSpace = unicode.IsSpace(r)
+	}
+	if start < len(text) {
+		tokens = append(tokens, text[start:])
+	}
+
+	return tokens
+}
+
+// diffWords computes a word-level diff from the old to the new text.
+func diffWords(oldText, newText string) []diffOp {
+	a, b := tokenizeWords(oldText), tokenizeWords(newText)
+
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	add := func(op string, tokens ...string) {
+		text := strings.Join(tokens, "")
+		if text == "" {
+			return
+		}
+		if n := len(ops); n > 0 && ops[n-1].Op == op {
+			ops[n-1].Text += text
+			return
+		}
+		ops = append(ops, diffOp{Op: op, Text: text})
+	}
+
+	add(diffEqual, a[:prefix]...)
+
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(midA)+1)*(len(midB)+1) > maxDiffCells {
+		add(diffDelete, midA...)
+		add(diffInsert, midB...)
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:].
+		lcs := make([][]int32, len(midA)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(midB)+1)
+		}
+		for i := len(midA) - 1; i >= 0; i-- {
+			for j := len(midB) - 1; j >= 0; j-- {
+				if midA[i] == midB[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else {
+					lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(midA) && j < len(midB) {
+			switch {
+			case midA[i] == midB[j]:
+				add(diffEqual, midA[i])
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				add(diffDelete, midA[i])
+				i++
+			default:
+				add(diffInsert, midB[j])
+				j++
+			}
+		}
+		add(diffDelete, midA[i:]...)
+		add(diffInsert, midB[j:]...)
+	}
+
+	add(diffEqual, a[len(a)-suffix:]...)
+
+	return ops
+}
+
+// renderDiff renders the diff in Markdown, striking deleted words through and emphasizing
+// inserted ones. Long unchanged spans are shortened to the words around the changes.
+func renderDiff(ops []diffOp) string {
+	var sb strings.Builder
+	for i, op := range ops {
+		switch op.Op {
+		case diffEqual:
+			sb.WriteString(shortenUnchanged(op.Text, i > 0, i < len(ops)-1))
+		case diffDelete:
+			writeEmphasized(&sb, op.Text, "~~")
+		case diffInsert:
+			writeEmphasized(&sb, op.Text, "**")
+		}
+	}
+
+	return sb.String()
+}
+
+// writeEmphasized wraps the text in the given Markdown delimiters, keeping the surrounding
+// whitespace outside of them so that they render.
+func writeEmphasized(sb *strings.Builder, text, delimiter string) {
+	trimmed := strings.TrimSpace(text)
+	if trimmed == "" {
+		sb.WriteString(text)
+		return
+	}
+
+	start := strings.Index(text, trimmed)
+	sb.WriteString(text[:start])
+	sb.WriteString(delimiter + trimmed + delimiter)
+	sb.WriteString(text[start+len(trimmed):])
+}
+
+// shortenUnchanged keeps the words of an unchanged span that are next to a change.
+func shortenUnchanged(text string, afterChange, beforeChange bool) string {
+	words := tokenizeWords(text)
+	keep := diffContextWords * 2 // words and the whitespace between them
+	if len(words) <= keep*2 {
+		return text
+	}
+
+	var head, tail string
+	if afterChange {
+		head = strings.Join(words[:keep], "")
+	}
+	if beforeChange {
+		tail = strings.Join(words[len(words)-keep:], "")
+	}
+
+	return head + "…" + tail
+}
+
+// fileChanges returns the files attached and detached by an edit.
+func fileChanges(oldFileIDs, newFileIDs []string) (added, removed []string) {
+	for _, id := range newFileIDs {
+		if !slices.Contains(oldFileIDs, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range oldFileIDs {
+		if !slices.Contains(newFileIDs, id) {
+			removed = append(removed, id)
+		}
+	}
+
+	return added, removed
+}
+
+// propChange is the old and new value of a post prop changed by an edit. Either is nil if the
+// prop was added or removed.
+type propChange struct {
+	Old any `json:"old,omitempty"`
+	New any `json:"new,omitempty"`
+}
+
+// propChanges returns the props changed by an edit.
+func propChanges(oldProps, newProps model.StringInterface) map[string]propChange {
+	changes := make(map[string]propChange)
+	for key, value := range newProps {
+		if old, ok := oldProps[key]; !ok || !reflect.DeepEqual(old, value) {
+			changes[key] = propChange{Old: old, New: value}
+		}
+	}
+	for key, value := range oldProps {
+		if _, ok := newProps[key]; !ok {
+			changes[key] = propChange{Old: value}
+		}
+	}
+
+	return changes
+}
+
+// postEditDiff describes the changes made by an edit.
+type postEditDiff struct {
+	Message []diffOp `json:"message,omitempty"`
+
+	// EncryptedMessageChanged is set instead of Message for [SECURE] posts, whose content must
+	// not be disclosed.
+	EncryptedMessageChanged bool `json:"encrypted_message_changed,omitempty"`
+
+	FilesAdded   []string              `json:"files_added,omitempty"`
+	FilesRemoved []string              `json:"files_removed,omitempty"`
+	Props        map[string]propChange `json:"props,omitempty"`
+}
+
+func newPostEditDiff(oldPost, newPost *model.Post) *postEditDiff {
+	diff := &postEditDiff{}
+	if oldPost.Message != newPost.Message {
+		if encryptedMessageRegexp.MatchString(oldPost.Message) || encryptedMessageRegexp.MatchString(newPost.Message) {
+			diff.EncryptedMessageChanged = true
+		} else {
+			diff.Message = diffWords(oldPost.Message, newPost.Message)
+		}
+	}
+	diff.FilesAdded, diff.FilesRemoved = fileChanges(oldPost.FileIds, newPost.FileIds)
+	if changes := propChanges(oldPost.GetProps(), newPost.GetProps()); len(changes) > 0 {
+		diff.Props = changes
+	}
+
+	return diff
+}
+
+// render describes the diff in Markdown, naming files with the given function.
+func (d *postEditDiff) render(fileName func(fileID string) string) string {
+	var sb strings.Builder
+	if len(d.Message) > 0 {
+		sb.WriteString(renderDiff(d.Message))
+		sb.WriteString("\n")
+	}
+	if d.EncryptedMessageChanged {
+		sb.WriteString("_The encrypted message changed._\n")
+	}
+
+	for _, id := range d.FilesAdded {
+		fmt.Fprintf(&sb, "\n- Attached **%s**", fileName(id))
+	}
+	for _, id := range d.FilesRemoved {
+		fmt.Fprintf(&sb, "\n- Detached ~~%s~~", fileName(id))
+	}
+
+	keys := make([]string, 0, len(d.Props))
+	for key := range d.Props {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		change := d.Props[key]
+		switch {
+		case change.Old == nil:
+			fmt.Fprintf(&sb, "\n- Added prop `%s`", key)
+		case change.New == nil:
+			fmt.Fprintf(&sb, "\n- Removed prop `%s`", key)
+		default:
+			fmt.Fprintf(&sb, "\n- Changed prop `%s`", key)
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "No visible changes."
+	}
+
+	return strings.TrimSpace(sb.String())
+}
+
+// postEditDiffReply builds the reply describing an edit, threaded under the notification of the
+// MessageHasBeenUpdated hook. The structured diff is set as props, rendered by the web app.
+func (p *Plugin) postEditDiffReply(oldPost, newPost *model.Post) *model.Post {
+	diff := newPostEditDiff(oldPost, newPost)
+
+	fileName := func(fileID string) string {
+		if info, appErr := p.API.GetFileInfo(fileID); appErr == nil {
+			return info.Name
+		}
+		return fileID
+	}
+
+	props := model.StringInterface{}
+	if data, err := json.Marshal(diff); err == nil {
+		_ = json.Unmarshal(data, &props)
+	}
+
+	return &model.Post{
+		Message: diff.render(fileName),
+		Type:    "custom_demo_plugin",
+		Props:   props,
+	}
+}
+
+// postRevision is a version of a post kept in its edit history.
+type postRevision struct {
+	EditAt  int64                 `json:"edit_at"`
+	UserID  string                `json:"user_id"`
+	Message string                `json:"message"`
+	FileIDs []string              `json:"file_ids,omitempty"`
+	Props   model.StringInterface `json:"props,omitempty"`
+}
+
+func newPostRevision(post *model.Post) postRevision {
+	editAt := post.EditAt
+	if editAt == 0 {
+		editAt = post.CreateAt
+	}
+
+	return postRevision{
+		EditAt:  editAt,
+		UserID:  post.UserId,
+		Message: post.Message,
+		FileIDs: post.FileIds,
+		Props:   post.GetProps(),
+	}
+}
+
+// recordPostRevision atomically appends the new version of an edited post to its history,
+// starting with the original version on the first edit. The history expires once the post hasn't
+// been edited for the retention period.
+func (p *Plugin) recordPostRevision(oldPost, newPost *model.Post) error {
+	return p.updateKV(editHistoryKeyPrefix+newPost.Id, editHistoryRetention, func(oldValue []byte) (any, error) {
+		var revisions []postRevision
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &revisions); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal edit history")
+			}
+		}
+		if len(revisions) == 0 {
+			revisions = append(revisions, newPostRevision(oldPost))
+		}
+
+		revisions = append(revisions, newPostRevision(newPost))
+		if len(revisions) > maxEditHistoryRevisions {
+			revisions = revisions[len(revisions)-maxEditHistoryRevisions:]
+		}
+
+		return revisions, nil
+	})
+}
+
+// expirePostHistory expires the edit history of a deleted post along with the archived post, or
+// deletes it if deleted posts aren't archived.
+func (p *Plugin) expirePostHistory(postID string) error {
+	retentionDays := p.getConfiguration().DeletedPostRetentionDays
+	return p.updateKV(editHistoryKeyPrefix+postID, time.Duration(retentionDays)*24*time.Hour, func(oldValue []byte) (any, error) {
+		if retentionDays <= 0 || len(oldValue) == 0 {
+			return nil, nil
+		}
+		return oldValue, nil
+	})
+}
+
+// handlePostHistory returns the edit history of a post, oldest revision first, to the users
+// allowed to read its channel.
+func (p *Plugin) handlePostHistory(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	postID := mux.Vars(r)["id"]
+	if !model.IsValidId(postID) {
+		http.Error(w, "Invalid post id", http.StatusBadRequest)
+		return
+	}
+
+	post, appErr := p.API.GetPost(postID)
+	if appErr != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+	if !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PermissionReadChannel) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	var revisions []postRevision
+	if err := p.client.KV.Get(editHistoryKeyPrefix+postID, &revisions); err != nil {
+		p.API.LogError("Failed to get edit history", "post_id", postID, "err", err.Error())
+		http.Error(w, "Failed to get edit history", http.StatusInternalServerError)
+		return
+	}
+
+	// [SECURE] posts are stored encrypted, and decrypted only for the users who can read them.
+	for i := range revisions {
+		revision := &model.Post{ChannelId: post.ChannelId, Message: revisions[i].Message}
+		p.decryptPost(revision)
+		revisions[i].Message = revision.Message
+	}
+
+	if revisions == nil {
+		revisions = []postRevision{}
+	}
+
+	p.writeJSON(w, struct {
+		PostID    string         `json:"post_id"`
+		Revisions []postRevision `json:"revisions"`
+	}{
+		PostID:    postID,
+		Revisions: revisions,
+	})
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestDiffWords(t *testing.T) {
+	assert.Equal(t, []string{"Hello", " ", "big", "  ", "world!"}, tokenizeWords("Hello big  world!"))
+
+	ops := diffWords("The quick brown fox jumps", "The slow brown fox leaps high")
+	assert.Equal(t, []diffOp{
+		{Op: diffEqual, Text: "The "},
+		{Op: diffDelete, Text: "quick"},
+		{Op: diffInsert, Text: "slow"},
+		{Op: diffEqual, Text: " brown fox "},
+		{Op: diffDelete, Text: "jumps"},
+		{Op: diffInsert, Text: "leaps high"},
+	}, ops)
+	assert.Equal(t, "The ~~quick~~**slow** brown fox ~~jumps~~**leaps high**", renderDiff(ops))
+
+	assert.Equal(t, []diffOp{{Op: diffInsert, Text: "new"}}, diffWords("", "new"))
+	assert.Equal(t, []diffOp{{Op: diffEqual, Text: "same"}}, diffWords("same", "same"))
+
+	t.Run("long unchanged spans are shortened", func(t *testing.T) {
+		words := strings.Fields(strings.Repeat("word ", 30))
+		oldText := strings.Join(words, " ") + " old"
+		newText := strings.Join(words, " ") + " new"
+
+		rendered := renderDiff(diffWords(oldText, newText))
+		assert.Equal(t, "…word word word word word ~~old~~**new**", rendered)
+	})
+}
+
+func TestPostEditDiff(t *testing.T) {
+	oldPost := &model.Post{Message: "hello", FileIds: model.StringArray{"file1", "file2"}}
+	oldPost.AddProp("color", "red")
+	oldPost.AddProp("size", 1)
+	newPost := &model.Post{Message: "hello world", FileIds: model.StringArray{"file2", "file3"}}
+	newPost.AddProp("color", "blue")
+	newPost.AddProp("shape", "round")
+
+	diff := newPostEditDiff(oldPost, newPost)
+	assert.Equal(t, []string{"file3"}, diff.FilesAdded)
+	assert.Equal(t, []string{"file1"}, diff.FilesRemoved)
+	assert.Equal(t, map[string]propChange{
+		"color": {Old: "red", New: "blue"},
+		"size":  {Old: 1},
+		"shape": {New: "round"},
+	}, diff.Props)
+
+	rendered := diff.render(func(fileID string) string { return fileID + ".txt" })
+	assert.Equal(t, "hello **world**\n\n- Attached **file3.txt**\n- Detached ~~file1.txt~~\n- Changed prop `color`\n- Added prop `shape`\n- Removed prop `size`", rendered)
+
+	t.Run("encrypted messages aren't disclosed", func(t *testing.T) {
+		diff := newPostEditDiff(&model.Post{Message: "[ENCRYPTED:abc]AAAA"}, &model.Post{Message: "[ENCRYPTED:abc]BBBB"})
+		assert.True(t, diff.EncryptedMessageChanged)
+		assert.Empty(t, diff.Message)
+	})
+}
+
+func TestRecordPostRevision(t *testing.T) {
+	cluster := newFakeCluster()
+	node := cluster.addNode(t)
+
+	post := &model.Post{Id: model.NewId(), UserId: "user1", CreateAt: 1, Message: "v0"}
+	for i := 1; i <= maxEditHistoryRevisions+5; i++ {
+		edited := post.Clone()
+		edited.EditAt = int64(i)
+		edited.Message = "v" + strings.Repeat("i", i)
+		require.NoError(t, node.recordPostRevision(post, edited))
+		post = edited
+	}
+
+	var revisions []postRevision
+	require.NoError(t, node.client.KV.Get(editHistoryKeyPrefix+post.Id, &revisions))
+	require.Len(t, revisions, maxEditHistoryRevisions)
+	assert.Equal(t, post.Message, revisions[len(revisions)-1].Message)
+	assert.Equal(t, int64(6), revisions[0].EditAt, "the oldest revisions are dropped")
+	assert.Equal(t, int64(editHistoryRetention.Seconds()), cluster.ttls[editHistoryKeyPrefix+post.Id])
+
+	t.Run("deleted posts", func(t *testing.T) {
+		config := node.getConfiguration().Clone()
+		config.DeletedPostRetentionDays = 7
+		node.setConfiguration(config)
+
+		require.NoError(t, node.expirePostHistory(post.Id))
+		assert.Equal(t, int64(7*24*time.Hour/time.Second), cluster.ttls[editHistoryKeyPrefix+post.Id], "expires along with the archived post")
+		require.NoError(t, node.client.KV.Get(editHistoryKeyPrefix+post.Id, &revisions))
+		assert.Len(t, revisions, maxEditHistoryRevisions)
+
+		config = node.getConfiguration().Clone()
+		config.DeletedPostRetentionDays = 0
+		node.setConfiguration(config)
+
+		require.NoError(t, node.expirePostHistory(post.Id))
+		assert.NotContains(t, cluster.kv, editHistoryKeyPrefix+post.Id)
+	})
+}
+
+func TestEditDiffReply(t *testing.T) {
+	cluster := newFakeCluster()
+	node := cluster.addNode(t)
+	node.botID = "bot"
+	config := node.getConfiguration().Clone()
+	config.demoChannelIDs = map[string]string{"team1": "demo1", "team2": "demo2"}
+	node.setConfiguration(config)
+
+	var posts []*model.Post
+	api := node.API.(*fakeNodeAPI).API
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer("http://localhost")}})
+	api.On("GetUser", "user1").Return(&model.User{Id: "user1", Username: "jane"}, nil)
+	api.On("GetChannel", "open").Return(&model.Channel{Id: "open", Name: "town-square", TeamId: "team1", Type: model.ChannelTypeOpen}, nil)
+	api.On("GetChannel", "private").Return(&model.Channel{Id: "private", Name: "apollo", TeamId: "team1", Type: model.ChannelTypePrivate}, nil)
+	api.On("GetChannel", "dm").Return(&model.Channel{Id: "dm", Name: "user1__user2", Type: model.ChannelTypeDirect}, nil)
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) (*model.Post, *model.AppError) {
+		post = post.Clone()
+		post.Id = model.NewId()
+		posts = append(posts, post)
+		return post, nil
+	})
+
+	edit := func(channelID string) {
+		posts = nil
+		oldPost := &model.Post{Id: "post1", ChannelId: channelID, UserId: "user1", Message: "launch on monday"}
+		newPost := oldPost.Clone()
+		newPost.Message = "launch on friday"
+		node.MessageHasBeenUpdated(nil, newPost, oldPost)
+	}
+
+	t.Run("public channel", func(t *testing.T) {
+		edit("open")
+		require.Len(t, posts, 2)
+		assert.Equal(t, "demo1", posts[0].ChannelId)
+		assert.Equal(t, "MessageHasBeenUpdated: @jane, ~town-square", posts[0].Message)
+		assert.Equal(t, posts[0].Id, posts[1].RootId)
+		assert.Contains(t, posts[1].Message, "friday")
+	})
+
+	t.Run("private channel", func(t *testing.T) {
+		edit("private")
+		require.Len(t, posts, 1)
+		assert.Equal(t, "demo1", posts[0].ChannelId)
+		assert.Equal(t, "MessageHasBeenUpdated: @jane, ~apollo", posts[0].Message)
+	})
+
+	t.Run("direct message", func(t *testing.T) {
+		edit("dm")
+		require.Len(t, posts, 2, "logged to the demo channel of each team")
+		for _, post := range posts {
+			assert.Empty(t, post.RootId)
+			assert.NotContains(t, post.Message, "monday")
+			assert.NotContains(t, post.Message, "friday")
+		}
+	})
+}
@@ -13,34 +13,50 @@ import (
 // This demo implementation logs a message to the demo channel in the team
 // when a new file is uploaded.
 func (p *Plugin) FileWillBeUploaded(c *plugin.Context, fileInfo *model.FileInfo, reader bytes.Reader, buf *bytes.Buffer) (*model.FileInfo, string) {
+	event := newHookEvent(hookFileWillBeUploaded)
+	event.ChannelID = fileInfo.ChannelId
+	event.UserID = fileInfo.CreatorId
+	event.FileID = fileInfo.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
 	if configuration.disabled {
+		event.reject("Configuration is disabled")
 		return nil, "Configuration is disabled"
 	}
 
+	if !configuration.isHookEnabled(hookFileWillBeUploaded) {
+		event.Outcome = eventOutcomeSkipped
+		return nil, ""
+	}
+
 	teams, err := p.API.GetTeams()
 	if err != nil {
 		p.API.LogError(
 			"Failed to query teams FileWillBeUploaded",
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return nil, "Failed to query teams"
 	}
 
 	if reader.Size() == 0 {
 		p.API.LogError("Uploaded file has zero size")
+		event.reject("Upload Failed as file has zero size")
 		return nil, "Upload Failed as file has zero size"
 	}
 
 	for _, team := range teams {
+		data := hookTemplateData{Team: newTemplateTeam(team), FileName: fileInfo.Name}
 		msg := fmt.Sprintf("FileName @%s has been created in", fileInfo.Name)
-		if err := p.postPluginMessage(team.Id, msg); err != nil {
+		if err := p.postHookMessage(hookFileWillBeUploaded, team.Id, data, msg); err != nil {
 			p.API.LogError(
 				"Failed to post FileWillBeUploaded message",
 				"channel_id", configuration.demoChannelIDs[team.Id],
 				"error", err.Error(),
 			)
+			event.fail(err)
 		}
 	}
 	return nil, ""
@@ -56,9 +72,17 @@ func (p *Plugin) FileWillBeUploaded(c *plugin.Context, fileInfo *model.FileInfo,
 //   - model.FileDownloadTypePreview: Preview image request
 //   - model.FileDownloadTypePublic: Public link access (userId will be empty in this case)
 func (p *Plugin) FileWillBeDownloaded(c *plugin.Context, fileInfo *model.FileInfo, userId string, downloadType model.FileDownloadType) string {
+	event := newHookEvent(hookFileWillBeDownloaded)
+	event.ChannelID = fileInfo.ChannelId
+	event.UserID = userId
+	event.PostID = fileInfo.PostId
+	event.FileID = fileInfo.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookFileWillBeDownloaded) {
+		event.Outcome = eventOutcomeSkipped
 		return ""
 	}
 
@@ -74,21 +98,29 @@ func (p *Plugin) FileWillBeDownloaded(c *plugin.Context, fileInfo *model.FileInf
 	case model.FileDownloadTypeFile:
 		if configuration.RejectFileDownloads {
 			p.API.LogInfo("Rejecting file download", "file_id", fileInfo.Id, "type", "file")
+			p.metrics.observeFileDownloadRejection(downloadType)
+			event.reject("Full file downloads are currently disabled by the demo plugin")
 			return "Full file downloads are currently disabled by the demo plugin"
 		}
 	case model.FileDownloadTypeThumbnail:
 		if configuration.RejectThumbDownloads {
 			p.API.LogInfo("Rejecting file download", "file_id", fileInfo.Id, "type", "thumbnail")
+			p.metrics.observeFileDownloadRejection(downloadType)
+			event.reject("Thumbnail downloads are currently disabled by the demo plugin")
 			return "Thumbnail downloads are currently disabled by the demo plugin"
 		}
 	case model.FileDownloadTypePreview:
 		if configuration.RejectPreviewDownloads {
 			p.API.LogInfo("Rejecting file download", "file_id", fileInfo.Id, "type", "preview")
+			p.metrics.observeFileDownloadRejection(downloadType)
+			event.reject("Preview downloads are currently disabled by the demo plugin")
 			return "Preview downloads are currently disabled by the demo plugin"
 		}
 	case model.FileDownloadTypePublic:
 		if configuration.RejectPublicLinkDownloads {
 			p.API.LogInfo("Rejecting file download", "file_id", fileInfo.Id, "type", "public")
+			p.metrics.observeFileDownloadRejection(downloadType)
+			event.reject("Public link downloads are currently disabled by the demo plugin")
 			return "Public link downloads are currently disabled by the demo plugin"
 		}
 	}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// Names of the hooks recorded in the event log.
+const (
+	hookMessageWillBePosted    = "MessageWillBePosted"
+	hookMessageWillBeUpdated   = "MessageWillBeUpdated"
+	hookMessageHasBeenPosted   = "MessageHasBeenPosted"
+	hookMessageHasBeenUpdated  = "MessageHasBeenUpdated"
+	hookMessageHasBeenDeleted  = "MessageHasBeenDeleted"
+	hookReactionHasBeenAdded   = "ReactionHasBeenAdded"
+	hookReactionHasBeenRemoved = "ReactionHasBeenRemoved"
+	hookChannelHasBeenCreated  = "ChannelHasBeenCreated"
+	hookUserHasJoinedChannel   = "UserHasJoinedChannel"
+	hookUserHasLeftChannel     = "UserHasLeftChannel"
+	hookUserHasJoinedTeam      = "UserHasJoinedTeam"
+	hookUserHasLeftTeam        = "UserHasLeftTeam"
+	hookUserHasBeenCreated     = "UserHasBeenCreated"
+	hookUserHasBeenDeactivated = "UserHasBeenDeactivated"
+	hookUserWillLogIn          = "UserWillLogIn"
+	hookUserHasLoggedIn        = "UserHasLoggedIn"
+	hookFileWillBeUploaded     = "FileWillBeUploaded"
+	hookFileWillBeDownloaded   = "FileWillBeDownloaded"
+)
+
+// Outcomes of a recorded hook invocation.
+const (
+	// eventOutcomeOK means the hook ran to completion.
+	eventOutcomeOK = "ok"
+	// eventOutcomeSkipped means the hook returned early, e.g. because the hooks are disabled.
+	eventOutcomeSkipped = "skipped"
+	// eventOutcomeRejected means the hook rejected the operation it was invoked for.
+	eventOutcomeRejected = "rejected"
+	// eventOutcomeError means the hook failed while handling the invocation.
+	eventOutcomeError = "error"
+)
+
+const (
+	// eventKeyPrefix prefixes the keys ordering the recorded events, which embed a zero padded
+	// timestamp so that sorting them lexically sorts the events chronologically. They are used as
+	// the cursors of the event log.
+	eventKeyPrefix = "event_"
+
+	// eventRecordKeyPrefix prefixes the KV key of each recorded event, followed by the number of
+	// minutes since the epoch, the id of the shard that wrote it and its number in the shard.
+	eventRecordKeyPrefix = "event_record_"
+
+	// eventShardsKeyPrefix prefixes the KV keys listing the shards that wrote events of a minute,
+	// followed by the number of minutes since the epoch. eventIndexKeyPrefix prefixes the KV keys
+	// listing the minutes of a day that have events, followed by the number of days since the
+	// epoch, and eventDaysKey lists the days that have an index. Queries read these to fetch only
+	// the events within their time range, rather than listing the whole KV store.
+	eventShardsKeyPrefix = "event_shards_"
+	eventIndexKeyPrefix  = "event_index_"
+	eventDaysKey         = "event_days"
+
+	// eventShardCount is the number of shards writing events concurrently on each plugin
+	// instance.
+	eventShardCount = 8
+
+	// maxEventShardMinutes is the number of minutes each shard keeps writing events of. An
+	// event of an older minute is written under a new shard id.
+	maxEventShardMinutes = 5
+
+	defaultEventsPerPage = 100
+	maxEventsPerPage     = 1000
+)
+
+// hookEvent is a structured record of a single hook invocation.
+type hookEvent struct {
+	ID        string `json:"id"`
+	Hook      string `json:"hook"`
+	TeamID    string `json:"team_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	PostID    string `json:"post_id,omitempty"`
+	FileID    string `json:"file_id,omitempty"`
+	Timestamp int64  `json:"timestamp"`
+	Outcome   string `json:"outcome"`
+
+	// Detail optionally explains the outcome, e.g. the reason a post was rejected.
+	Detail string `json:"detail,omitempty"`
+
+	// start is when the hook was invoked, to measure its latency.
+	start time.Time
+}
+
+// newHookEvent starts recording an invocation of the given hook. The outcome defaults to
+// eventOutcomeOK and is expected to be adjusted by the hook before the event is recorded.
+func newHookEvent(hook string) *hookEvent {
+	return &hookEvent{
+		ID:        model.NewId(),
+		Hook:      hook,
+		Timestamp: model.GetMillis(),
+		Outcome:   eventOutcomeOK,
+		start:     time.Now(),
+	}
+}
+
+// fail marks the event as failed with the given error.
+func (e *hookEvent) fail(err error) {
+	e.Outcome = eventOutcomeError
+	e.Detail = err.Error()
+}
+
+// reject marks the event as rejected for the given reason.
+func (e *hookEvent) reject(reason string) {
+	e.Outcome = eventOutcomeRejected
+	e.Detail = reason
+}
+
+func (e *hookEvent) key() string {
+	return fmt.Sprintf("%s%013d_%s", eventKeyPrefix, e.Timestamp, e.ID)
+}
+
+func eventMinute(timestamp int64) int64 {
+	return timestamp / time.Minute.Milliseconds()
+}
+
+func eventDay(timestamp int64) int64 {
+	return timestamp / (24 * time.Hour).Milliseconds()
+}
+
+func eventRecordKey(minute int64, shardID string, number int) string {
+	return fmt.Sprintf("%s%d_%s_%d", eventRecordKeyPrefix, minute, shardID, number)
+}
+
+func eventShardsKey(minute int64) string {
+	return fmt.Sprintf("%s%d", eventShardsKeyPrefix, minute)
+}
+
+func eventIndexKey(day int64) string {
+	return fmt.Sprintf("%s%d", eventIndexKeyPrefix, day)
+}
+
+// recordEvent forwards the event to the webhook endpoints and persists it in the KV store,
+// expiring it after the configured retention. Hooks typically defer this call right after
+// creating the event.
+func (p *Plugin) recordEvent(event *hookEvent) {
+	p.metrics.observeHook(event.Hook, event.Outcome, time.Since(event.start))
+	p.forwardEvent(event)
+
+	retentionDays := p.getConfiguration().EventLogRetentionDays
+	if retentionDays <= 0 {
+		return
+	}
+
+	retention := time.Duration(retentionDays) * 24 * time.Hour
+	if err := p.storeEvent(event, retention); err != nil {
+		p.API.LogWarn("Failed to record hook event", "hook", event.Hook, "err", err.Error())
+	}
+}
+
+// eventShard writes events of a plugin instance. Every event has its own KV key, numbered within
+// its minute by the shard writing it, so that recording an event doesn't contend on any key and
+// queries can still fetch the events of a minute without listing the KV store.
+type eventShard struct {
+	lock sync.Mutex
+
+	// minutes are the minutes the shard recently wrote events of.
+	minutes map[int64]*eventShardMinute
+}
+
+// eventShardMinute is the id a shard registered for a minute, and the number of events of the
+// minute it wrote.
+type eventShardMinute struct {
+	id    string
+	count int
+}
+
+// storeEvent writes the event through one of the shards of the plugin instance. The first event
+// a shard writes for a minute registers the shard in the list of the minute, the first shard of
+// a minute adds the minute to the index of its day, and the first minute of a day adds the day to
+// the list of days, so that each event usually costs a single write.
+func (p *Plugin) storeEvent(event *hookEvent, retention time.Duration) error {
+	shard := &p.eventShards[p.nextEventShard.Add(1)%eventShardCount]
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+
+	minute := eventMinute(event.Timestamp)
+	written := shard.minutes[minute]
+	if written == nil {
+		written = &eventShardMinute{id: model.NewId()}
+		if err := p.registerEventShard(minute, written.id, retention); err != nil {
+			return err
+		}
+
+		if shard.minutes == nil {
+			shard.minutes = make(map[int64]*eventShardMinute)
+		}
+		shard.minutes[minute] = written
+		if len(shard.minutes) > maxEventShardMinutes {
+			delete(shard.minutes, slices.Min(slices.Collect(maps.Keys(shard.minutes))))
+		}
+	}
+
+	// The events are numbered without gaps, since queries stop at the first missing number.
+	if _, err := p.client.KV.Set(eventRecordKey(minute, written.id, written.count), event, pluginapi.SetExpiry(retention)); err != nil {
+		return errors.Wrap(err, "failed to save event")
+	}
+	written.count++
+
+	return nil
+}
+
+// registerEventShard adds the shard to the list of the shards of the minute, and indexes the
+// minute if it is the first shard.
+func (p *Plugin) registerEventShard(minute int64, shardID string, retention time.Duration) error {
+	newMinute := false
+	if err := p.updateKV(eventShardsKey(minute), retention, func(oldValue []byte) (any, error) {
+		var shardIDs []string
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &shardIDs); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal event shards")
+			}
+		}
+		newMinute = len(shardIDs) == 0
+
+		return append(shardIDs, shardID), nil
+	}); err != nil {
+		return err
+	}
+	if !newMinute {
+		return nil
+	}
+
+	// The indexes outlive the events they list by a day, since they are written when the first
+	// event they list is.
+	day := eventDay(minute * time.Minute.Milliseconds())
+	newIndex := false
+	if err := p.updateKV(eventIndexKey(day), retention+24*time.Hour, func(oldValue []byte) (any, error) {
+		minutes, err := addEventIndexEntry(oldValue, minute, 0)
+		newIndex = len(minutes) == 1
+		return minutes, err
+	}); err != nil {
+		return err
+	}
+	if !newIndex {
+		return nil
+	}
+
+	return p.updateKV(eventDaysKey, 0, func(oldValue []byte) (any, error) {
+		return addEventIndexEntry(oldValue, day, eventDay(model.GetMillis()-retention.Milliseconds())-1)
+	})
+}
+
+// getMinuteEvents returns the events of the minute, in chronological order.
+func (p *Plugin) getMinuteEvents(minute int64) ([]*hookEvent, error) {
+	var shardIDs []string
+	if err := p.client.KV.Get(eventShardsKey(minute), &shardIDs); err != nil {
+		return nil, errors.Wrapf(err, "failed to get event shards of minute %d", minute)
+	}
+
+	var events []*hookEvent
+	for _, shardID := range shardIDs {
+		// The events may have expired since reading the index.
+		for number := 0; ; number++ {
+			var event *hookEvent
+			if err := p.client.KV.Get(eventRecordKey(minute, shardID, number), &event); err != nil {
+				return nil, errors.Wrapf(err, "failed to get event of minute %d", minute)
+			}
+			if event == nil {
+				break
+			}
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].key() < events[j].key()
+	})
+
+	return events, nil
+}
+
+// addEventIndexEntry adds the entry to the sorted index, dropping the entries older than oldest.
+func addEventIndexEntry(data []byte, entry, oldest int64) ([]int64, error) {
+	var entries []int64
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal event index")
+		}
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		if e >= oldest {
+			kept = append(kept, e)
+		}
+	}
+	if !slices.Contains(kept, entry) {
+		kept = append(kept, entry)
+		slices.Sort(kept)
+	}
+
+	return kept, nil
+}
+
+// eventQuery filters the events returned by queryEvents. Empty fields match everything.
+type eventQuery struct {
+	Hook   string
+	TeamID string
+	UserID string
+
+	// Since and Until bound the event timestamps, in milliseconds, inclusively.
+	Since int64
+	Until int64
+
+	// Cursor is the opaque value returned by a previous query to fetch the next page.
+	Cursor string
+	Limit  int
+}
+
+func (q eventQuery) matches(event *hookEvent) bool {
+	if q.Hook != "" && q.Hook != event.Hook {
+		return false
+	}
+	if q.TeamID != "" && q.TeamID != event.TeamID {
+		return false
+	}
+	if q.UserID != "" && q.UserID != event.UserID {
+		return false
+	}
+
+	return true
+}
+
+// start returns the earliest timestamp of the events to return, from the time range and the
+// timestamp embedded in the cursor.
+func (q eventQuery) start() int64 {
+	start := q.Since
+	rest := strings.TrimPrefix(q.Cursor, eventKeyPrefix)
+	if timestamp, err := strconv.ParseInt(strings.SplitN(rest, "_", 2)[0], 10, 64); err == nil && timestamp > start {
+		start = timestamp
+	}
+
+	return start
+}
+
+// queryEvents returns the recorded events matching the query in chronological order, along
+// with the cursor for the next page. The cursor is empty once there are no more events. Only the
+// events of the minutes within the time range are read, up to the one completing the page.
+func (p *Plugin) queryEvents(q eventQuery) ([]*hookEvent, string, error) {
+	start := q.start()
+	end := q.Until
+	if end <= 0 {
+		end = math.MaxInt64
+	}
+
+	var days []int64
+	if err := p.client.KV.Get(eventDaysKey, &days); err != nil {
+		return nil, "", errors.Wrap(err, "failed to get event days")
+	}
+
+	events := []*hookEvent{}
+	for _, day := range days {
+		if day < eventDay(start) || day > eventDay(end) {
+			continue
+		}
+
+		var minutes []int64
+		if err := p.client.KV.Get(eventIndexKey(day), &minutes); err != nil {
+			return nil, "", errors.Wrapf(err, "failed to get event index of day %d", day)
+		}
+
+		for _, minute := range minutes {
+			if minute < eventMinute(start) || minute > eventMinute(end) {
+				continue
+			}
+
+			minuteEvents, err := p.getMinuteEvents(minute)
+			if err != nil {
+				return nil, "", err
+			}
+
+			for _, event := range minuteEvents {
+				if event.key() <= q.Cursor || event.Timestamp < q.Since || event.Timestamp > end || !q.matches(event) {
+					continue
+				}
+				if len(events) == q.Limit {
+					return events, events[len(events)-1].key(), nil
+				}
+
+				events = append(events, event)
+			}
+		}
+	}
+
+	return events, "", nil
+}
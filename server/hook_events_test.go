@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestEventLog(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	for _, node := range cluster.nodes {
+		config := node.getConfiguration().Clone()
+		config.EventLogRetentionDays = 7
+		node.setConfiguration(config)
+
+		api := node.API.(*fakeNodeAPI).API
+		api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+		api.On("HasPermissionTo", "user1", model.PermissionManageSystem).Return(false)
+	}
+
+	// 30 events spread over three days, a few seconds apart within the same minutes and a few
+	// minutes apart, alternating between two hooks, teams and users.
+	start := time.Now().Add(-72 * time.Hour).Truncate(time.Minute).UnixMilli()
+	var recorded []*hookEvent
+	for i := range 30 {
+		event := newHookEvent(hookMessageHasBeenPosted)
+		if i%3 == 0 {
+			event.Hook = hookReactionHasBeenAdded
+		}
+		event.TeamID = fmt.Sprintf("team%d", i%2)
+		event.UserID = fmt.Sprintf("user%d", i%5)
+		event.Timestamp = start + int64(i/10)*(24*time.Hour).Milliseconds() + int64(i%10/3)*time.Minute.Milliseconds() + int64(i%10)*time.Second.Milliseconds()
+		cluster.nodes[i%2].recordEvent(event)
+		recorded = append(recorded, event)
+	}
+
+	ids := func(events []*hookEvent) []string {
+		var ids []string
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		return ids
+	}
+	expected := func(match func(i int, event *hookEvent) bool) []string {
+		var ids []string
+		for i, event := range recorded {
+			if match(i, event) {
+				ids = append(ids, event.ID)
+			}
+		}
+		return ids
+	}
+	// query fetches every page of the query, checking that none is larger than the limit.
+	query := func(node *Plugin, q eventQuery) ([]string, int) {
+		var all []*hookEvent
+		pages := 0
+		for {
+			events, cursor, err := node.queryEvents(q)
+			require.NoError(t, err)
+			require.LessOrEqual(t, len(events), q.Limit)
+			all = append(all, events...)
+			pages++
+			if cursor == "" {
+				return ids(all), pages
+			}
+			q.Cursor = cursor
+		}
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		all, pages := query(node2, eventQuery{Limit: 7})
+		assert.Equal(t, ids(recorded), all)
+		assert.Equal(t, 5, pages)
+
+		all, pages = query(node1, eventQuery{Limit: 10})
+		assert.Equal(t, ids(recorded), all)
+		assert.Equal(t, 3, pages, "no empty page when the last page is full")
+	})
+
+	t.Run("hook filter", func(t *testing.T) {
+		events, _ := query(node1, eventQuery{Hook: hookReactionHasBeenAdded, Limit: 4})
+		assert.Equal(t, expected(func(i int, event *hookEvent) bool { return event.Hook == hookReactionHasBeenAdded }), events)
+		assert.Len(t, events, 10)
+	})
+
+	t.Run("team and user filters", func(t *testing.T) {
+		events, _ := query(node2, eventQuery{TeamID: "team1", UserID: "user3", Limit: 2})
+		assert.Equal(t, expected(func(i int, event *hookEvent) bool { return i%2 == 1 && i%5 == 3 }), events)
+		assert.Len(t, events, 3)
+	})
+
+	t.Run("time filters", func(t *testing.T) {
+		since := recorded[12].Timestamp
+		until := recorded[24].Timestamp
+		events, _ := query(node1, eventQuery{Since: since, Until: until, Limit: 5})
+		assert.Equal(t, expected(func(i int, event *hookEvent) bool {
+			return event.Timestamp >= since && event.Timestamp <= until
+		}), events)
+		assert.Equal(t, recorded[12].ID, events[0])
+		assert.Equal(t, recorded[24].ID, events[len(events)-1])
+
+		events, _ = query(node1, eventQuery{Since: time.Now().UnixMilli(), Limit: 5})
+		assert.Empty(t, events)
+	})
+
+	t.Run("http", func(t *testing.T) {
+		node1.initializeAPI()
+		get := func(userID, url string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, url, nil)
+			r.Header.Set("Mattermost-User-ID", userID)
+			node1.ServeHTTP(nil, w, r)
+			return w
+		}
+
+		assert.Equal(t, http.StatusForbidden, get("user1", "/events").Code)
+		assert.Equal(t, http.StatusBadRequest, get("admin", "/events?cursor=nope").Code)
+		assert.Equal(t, http.StatusBadRequest, get("admin", "/events?per_page=1001").Code)
+
+		w := get("admin", fmt.Sprintf("/events?hook=%s&team_id=team0&per_page=3", hookMessageHasBeenPosted))
+		require.Equal(t, http.StatusOK, w.Code)
+		var page struct {
+			Events     []*hookEvent `json:"events"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		want := expected(func(i int, event *hookEvent) bool { return event.Hook == hookMessageHasBeenPosted && i%2 == 0 })
+		assert.Equal(t, want[:3], ids(page.Events))
+		require.NotEmpty(t, page.NextCursor)
+
+		w = get("admin", fmt.Sprintf("/events?hook=%s&team_id=team0&per_page=3&cursor=%s", hookMessageHasBeenPosted, page.NextCursor))
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&page))
+		assert.Equal(t, want[3:6], ids(page.Events))
+	})
+}
+
+func TestEventLogConcurrentEvents(t *testing.T) {
+	cluster := newFakeCluster()
+	cluster.addNode(t)
+	cluster.addNode(t)
+	for _, node := range cluster.nodes {
+		config := node.getConfiguration().Clone()
+		config.EventLogRetentionDays = 7
+		node.setConfiguration(config)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 200 {
+		wg.Add(1)
+		go func(node *Plugin) {
+			defer wg.Done()
+			node.recordEvent(newHookEvent(hookMessageHasBeenPosted))
+		}(cluster.nodes[i%2])
+	}
+	wg.Wait()
+
+	events, cursor, err := cluster.nodes[0].queryEvents(eventQuery{Limit: maxEventsPerPage})
+	require.NoError(t, err)
+	assert.Len(t, events, 200)
+	assert.Empty(t, cursor)
+
+	records := 0
+	for key := range cluster.kv {
+		if strings.HasPrefix(key, eventRecordKeyPrefix) {
+			records++
+			assert.Equal(t, int64(7*24*60*60), cluster.ttls[key])
+		}
+	}
+	assert.Equal(t, 200, records)
+}
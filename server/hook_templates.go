@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// postExcerptLength is the maximum number of characters of a post message exposed to the
+// hook templates.
+const postExcerptLength = 100
+
+// templatedHooks lists the hooks posting a notification to the demo channel, which can be
+// customized with a template.
+var templatedHooks = map[string]bool{
+	hookMessageHasBeenPosted:   true,
+	hookMessageHasBeenUpdated:  true,
+	hookMessageHasBeenDeleted:  true,
+	hookReactionHasBeenAdded:   true,
+	hookReactionHasBeenRemoved: true,
+	hookChannelHasBeenCreated:  true,
+	hookUserHasJoinedChannel:   true,
+	hookUserHasLeftChannel:     true,
+	hookUserHasJoinedTeam:      true,
+	hookUserHasLeftTeam:        true,
+	hookUserHasBeenCreated:     true,
+	hookUserHasBeenDeactivated: true,
+	hookUserHasLoggedIn:        true,
+	hookFileWillBeUploaded:     true,
+}
+
+// hookTemplateData is the data model the hook templates are executed with. Fields that don't
+// apply to a hook are nil or empty, e.g. Post for UserHasJoinedTeam.
+type hookTemplateData struct {
+	// Hook is the name of the hook, e.g. MessageHasBeenPosted.
+	Hook string
+
+	// User is the user that triggered the hook.
+	User *templateUser
+
+	// Channel is the channel the hook relates to.
+	Channel *templateChannel
+
+	// Team is the team whose demo channel the notification is posted to.
+	Team *templateTeam
+
+	// Post is the post the hook relates to.
+	Post *templatePost
+
+	// Emoji is the name of the emoji of a reaction, without colons.
+	Emoji string
+
+	// FileName is the name of an uploaded file.
+	FileName string
+
+	// Permalink is the link to the post the hook relates to.
+	Permalink string
+}
+
+type templateUser struct {
+	ID        string
+	Username  string
+	FirstName string
+	LastName  string
+}
+
+type templateChannel struct {
+	ID          string
+	Name        string
+	DisplayName string
+}
+
+type templateTeam struct {
+	ID          string
+	Name        string
+	DisplayName string
+}
+
+type templatePost struct {
+	ID string
+
+	// Excerpt is the beginning of the message of the post, on a single line.
+	Excerpt string
+}
+
+func newTemplateUser(user *model.User) *templateUser {
+	return &templateUser{
+		ID:        user.Id,
+		Username:  user.Username,
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+	}
+}
+
+func newTemplateChannel(channel *model.Channel) *templateChannel {
+	return &templateChannel{
+		ID:          channel.Id,
+		Name:        channel.Name,
+		DisplayName: channel.DisplayName,
+	}
+}
+
+func newTemplateTeam(team *model.Team) *templateTeam {
+	return &templateTeam{
+		ID:          team.Id,
+		Name:        team.Name,
+		DisplayName: team.DisplayName,
+	}
+}
+
+func newTemplatePost(post *model.Post) *templatePost {
+	return &templatePost{
+		ID:      post.Id,
+		Excerpt: excerpt(post.Message, postExcerptLength),
+	}
+}
+
+// excerpt returns the message on a single line, truncated to the given number of characters.
+func excerpt(message string, length int) string {
+	message = strings.Join(strings.Fields(message), " ")
+
+	runes := []rune(message)
+	if len(runes) <= length {
+		return message
+	}
+
+	return string(runes[:length]) + "…"
+}
+
+var (
+	sampleTemplateUser    = &templateUser{ID: "user-id", Username: "username", FirstName: "First", LastName: "Last"}
+	sampleTemplateChannel = &templateChannel{ID: "channel-id", Name: "town-square", DisplayName: "Town Square"}
+	sampleTemplateTeam    = &templateTeam{ID: "team-id", Name: "team", DisplayName: "Team"}
+	sampleTemplatePost    = &templatePost{ID: "post-id", Excerpt: "Hello world"}
+	samplePermalink       = "http://localhost:8065/_redirect/pl/post-id"
+)
+
+// hookTemplateSamples holds the data each templated hook is executed with, so that references to
+// unknown fields, or to fields the hook doesn't set, are reported when saving the configuration
+// rather than when the hook is invoked. Team is nil for the hooks that may relate to a direct or
+// group message channel.
+var hookTemplateSamples = map[string]hookTemplateData{
+	hookMessageHasBeenPosted:   {User: sampleTemplateUser, Channel: sampleTemplateChannel, Post: sampleTemplatePost, Permalink: samplePermalink},
+	hookMessageHasBeenUpdated:  {User: sampleTemplateUser, Channel: sampleTemplateChannel, Post: sampleTemplatePost, Permalink: samplePermalink},
+	hookMessageHasBeenDeleted:  {User: sampleTemplateUser, Channel: sampleTemplateChannel, Post: sampleTemplatePost, Permalink: samplePermalink},
+	hookReactionHasBeenAdded:   {User: sampleTemplateUser, Channel: sampleTemplateChannel, Post: sampleTemplatePost, Emoji: "smile", Permalink: samplePermalink},
+	hookReactionHasBeenRemoved: {User: sampleTemplateUser, Channel: sampleTemplateChannel, Post: sampleTemplatePost, Emoji: "smile", Permalink: samplePermalink},
+	hookChannelHasBeenCreated:  {Channel: sampleTemplateChannel},
+	hookUserHasJoinedChannel:   {User: sampleTemplateUser, Channel: sampleTemplateChannel},
+	hookUserHasLeftChannel:     {User: sampleTemplateUser, Channel: sampleTemplateChannel},
+	hookUserHasJoinedTeam:      {User: sampleTemplateUser, Team: sampleTemplateTeam},
+	hookUserHasLeftTeam:        {User: sampleTemplateUser, Team: sampleTemplateTeam},
+	hookUserHasBeenCreated:     {User: sampleTemplateUser, Team: sampleTemplateTeam},
+	hookUserHasBeenDeactivated: {User: sampleTemplateUser, Team: sampleTemplateTeam},
+	hookUserHasLoggedIn:        {User: sampleTemplateUser, Team: sampleTemplateTeam},
+	hookFileWillBeUploaded:     {Team: sampleTemplateTeam, FileName: "file.txt"},
+}
+
+// validateHookTemplate executes the template with the sample data of the hook.
+func validateHookTemplate(hook string, tmpl *template.Template) error {
+	data := hookTemplateSamples[hook]
+	data.Hook = hook
+	return tmpl.Execute(io.Discard, data)
+}
+
+// parseHookTemplates parses the HookTemplates setting, one template per line as the name of
+// the hook, a colon and the template, e.g.
+//
+//	MessageHasBeenPosted: {{.User.Username}} posted in {{.Channel.Name}}
+func parseHookTemplates(setting string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for i, line := range strings.Split(setting, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		name, text, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, errors.Errorf("line %d: expected <hook>: <template>", i+1)
+		}
+
+		hook, ok := findHook(strings.TrimSpace(name))
+		if !ok || !templatedHooks[hook] {
+			return nil, errors.Errorf("line %d: hook %q doesn't post notifications", i+1, strings.TrimSpace(name))
+		}
+		if _, ok := templates[hook]; ok {
+			return nil, errors.Errorf("line %d: duplicate template for %s", i+1, hook)
+		}
+
+		tmpl, err := template.New(hook).Parse(strings.TrimSpace(text))
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", i+1)
+		}
+
+		if err := validateHookTemplate(hook, tmpl); err != nil {
+			return nil, errors.Wrapf(err, "line %d", i+1)
+		}
+
+		templates[hook] = tmpl
+	}
+
+	return templates, nil
+}
+
+// renderHookMessage renders the configured template of the hook, if any, and otherwise returns
+// the default message. The team is looked up when the data doesn't include it.
+func (p *Plugin) renderHookMessage(hook, teamID string, data hookTemplateData, defaultMsg string) string {
+	tmpl := p.getConfiguration().hookTemplates[hook]
+	if tmpl == nil {
+		return defaultMsg
+	}
+
+	data.Hook = hook
+	if data.Team == nil && teamID != "" {
+		team, appErr := p.API.GetTeam(teamID)
+		if appErr != nil {
+			p.API.LogWarn("Failed to get team for hook template", "team_id", teamID, "err", appErr.Error())
+		} else {
+			data.Team = newTemplateTeam(team)
+		}
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		p.API.LogWarn("Failed to execute hook template, using the default message", "hook", hook, "err", err.Error())
+		return defaultMsg
+	}
+
+	return sb.String()
+}
+
+// permalink returns the link redirecting to the given post.
+func (p *Plugin) permalink(postID string) string {
+	return fmt.Sprintf("%s/_redirect/pl/%s", *p.API.GetConfig().ServiceSettings.SiteURL, postID)
+}
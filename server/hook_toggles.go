@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// hookMessagesWillBeConsumed isn't recorded in the event log, given how often it is invoked,
+// but can be toggled like the other hooks.
+const hookMessagesWillBeConsumed = "MessagesWillBeConsumed"
+
+// toggleableHooks lists, in display order, the hooks that can be enabled or disabled
+// individually.
+var toggleableHooks = []string{
+	hookMessageWillBePosted,
+	hookMessageWillBeUpdated,
+	hookMessageHasBeenPosted,
+	hookMessageHasBeenUpdated,
+	hookMessageHasBeenDeleted,
+	hookMessagesWillBeConsumed,
+	hookReactionHasBeenAdded,
+	hookReactionHasBeenRemoved,
+	hookChannelHasBeenCreated,
+	hookUserHasJoinedChannel,
+	hookUserHasLeftChannel,
+	hookUserHasJoinedTeam,
+	hookUserHasLeftTeam,
+	hookUserHasBeenCreated,
+	hookUserHasBeenDeactivated,
+	hookUserWillLogIn,
+	hookUserHasLoggedIn,
+	hookFileWillBeUploaded,
+	hookFileWillBeDownloaded,
+}
+
+// findHook returns the canonical name of the toggleable hook matching the given name, ignoring
+// case.
+func findHook(name string) (string, bool) {
+	for _, hook := range toggleableHooks {
+		if strings.EqualFold(hook, name) {
+			return hook, true
+		}
+	}
+
+	return "", false
+}
+
+// parseDisabledHooks parses the comma separated DisabledHooks setting into a set of hook names.
+func parseDisabledHooks(setting string) (map[string]bool, error) {
+	disabledHooks := make(map[string]bool)
+	for _, name := range strings.Split(setting, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		hook, ok := findHook(name)
+		if !ok {
+			return nil, errors.Errorf("unknown hook %q", name)
+		}
+		disabledHooks[hook] = true
+	}
+
+	return disabledHooks, nil
+}
+
+// isHookEnabled reports whether the given hook should run. Hooks are all disabled while the
+// plugin hooks are disabled as a whole. Otherwise a hook toggled at runtime takes precedence
+// over the DisabledHooks setting.
+func (c *configuration) isHookEnabled(hook string) bool {
+	if c.disabled {
+		return false
+	}
+
+	if enabled, ok := c.hookOverrides[hook]; ok {
+		return enabled
+	}
+
+	return !c.disabledHooks[hook]
+}
+
+// hookMatrix returns whether each toggleable hook is currently enabled.
+func (c *configuration) hookMatrix() map[string]bool {
+	matrix := make(map[string]bool, len(toggleableHooks))
+	for _, hook := range toggleableHooks {
+		matrix[hook] = c.isHookEnabled(hook)
+	}
+
+	return matrix
+}
+
+// setHookEnabled updates the runtime state to enable or disable a single hook on every plugin
+// instance.
+func (p *Plugin) setHookEnabled(hook string, enabled bool) error {
+	return p.updateRuntimeState(func(state *runtimeState) {
+		state.HookOverrides[hook] = enabled
+	})
+}
+
+func (p *Plugin) executeCommandHookToggles(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if len(params) == 0 || params[0] == "list" {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         p.hookMatrixSummary(),
+		}
+	}
+
+	action := params[0]
+	if (action != "enable" && action != "disable") || len(params) != 2 {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("Unknown command action: %s", args.Command),
+		}
+	}
+
+	hook, ok := findHook(params[1])
+	if !ok {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("Unknown hook: %s. Use `/demo_plugin hooks list` for available hooks.", params[1]),
+		}
+	}
+
+	enabled := action == "enable"
+	if err := p.setHookEnabled(hook, enabled); err != nil {
+		p.API.LogError("Failed to toggle hook", "hook", hook, "err", err.Error())
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         fmt.Sprintf("Failed to toggle the %s hook.", hook),
+		}
+	}
+	p.emitStatusChange()
+
+	text := fmt.Sprintf("Enabled the %s hook.", hook)
+	if !enabled {
+		text = fmt.Sprintf("Disabled the %s hook.", hook)
+	}
+	if p.getConfiguration().disabled {
+		text += " The demo plugin hooks are currently disabled as a whole, use `/demo_plugin true` to enable them."
+	}
+
+	return &model.CommandResponse{
+		ResponseType: model.CommandResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+// hookMatrixSummary describes in Markdown whether each hook is enabled, and why.
+func (p *Plugin) hookMatrixSummary() string {
+	configuration := p.getConfiguration()
+
+	var sb strings.Builder
+	if configuration.disabled {
+		sb.WriteString("_The demo plugin hooks are currently disabled as a whole._\n\n")
+	}
+
+	sb.WriteString("| Hook | Enabled | Source |\n")
+	sb.WriteString("|------|---------|--------|\n")
+	for _, hook := range toggleableHooks {
+		source := "default"
+		if _, ok := configuration.hookOverrides[hook]; ok {
+			source = "command"
+		} else if configuration.disabledHooks[hook] {
+			source = "settings"
+		}
+
+		fmt.Fprintf(&sb, "| %s | %t | %s |\n", hook, configuration.isHookEnabled(hook), source)
+	}
+
+	return sb.String()
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"html"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -28,6 +30,7 @@ func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Req
 
 func (p *Plugin) initializeAPI() {
 	router := mux.NewRouter()
+	router.Use(p.withMetrics)
 
 	router.HandleFunc("/status", p.handleStatus)
 	router.HandleFunc("/hello", p.handleHello)
@@ -41,16 +44,25 @@ func (p *Plugin) initializeAPI() {
 	interativeRouter := router.PathPrefix("/interactive").Subrouter()
 	interativeRouter.Use(p.withDelay)
 	interativeRouter.HandleFunc("/button/1", p.handleInteractiveAction)
+	interativeRouter.HandleFunc("/reminder/{action:complete|snooze}", p.handleReminderAction).Methods(http.MethodPost)
+	interativeRouter.HandleFunc("/poll/{action:close|reopen}", p.handlePollAction).Methods(http.MethodPost)
+	interativeRouter.HandleFunc("/onboarding/start", p.handleOnboardingStart).Methods(http.MethodPost)
+	interativeRouter.HandleFunc("/offboarding/{action:reassign|cancel}", p.handleOffboardingAction).Methods(http.MethodPost)
 
 	dialogRouter := router.PathPrefix("/dialog").Subrouter()
 	dialogRouter.Use(p.withDelay)
-	dialogRouter.HandleFunc("/1", p.handleDialog1)
-	dialogRouter.HandleFunc("/2", p.handleDialog2)
-	dialogRouter.HandleFunc("/3", p.handleDialog3)
-	dialogRouter.HandleFunc("/date", p.handleDateDialog)
-	dialogRouter.HandleFunc("/error", p.handleDialogWithError)
-	dialogRouter.HandleFunc("/field-refresh", p.handleDialogFieldRefresh)
-	dialogRouter.HandleFunc("/multistep", p.handleDialogMultistep)
+
+	// Dialog submissions are counted by outcome, unlike the dynamic select lookups below.
+	dialogSubmissionRouter := dialogRouter.NewRoute().Subrouter()
+	dialogSubmissionRouter.Use(p.withDialogMetrics)
+	dialogSubmissionRouter.HandleFunc("/1", p.handleDialog1)
+	dialogSubmissionRouter.HandleFunc("/2", p.handleDialog2)
+	dialogSubmissionRouter.HandleFunc("/3", p.handleDialog3)
+	dialogSubmissionRouter.HandleFunc("/date", p.handleDateDialog)
+	dialogSubmissionRouter.HandleFunc("/error", p.handleDialogWithError)
+	dialogSubmissionRouter.HandleFunc("/field-refresh", p.handleDialogFieldRefresh)
+	dialogSubmissionRouter.HandleFunc("/multistep", p.handleDialogMultistep)
+	dialogSubmissionRouter.HandleFunc("/onboarding", p.handleDialogOnboarding)
 
 	dialogRouter.HandleFunc("/products", p.handleDynamicProducts).Methods(http.MethodPost)
 	dialogRouter.HandleFunc("/companies", p.handleDynamicCompanies).Methods(http.MethodPost)
@@ -61,6 +73,12 @@ func (p *Plugin) initializeAPI() {
 	ephemeralRouter.HandleFunc("/update", p.handleEphemeralUpdate)
 	ephemeralRouter.HandleFunc("/delete", p.handleEphemeralDelete)
 
+	router.HandleFunc("/posts/{id}/history", p.handlePostHistory).Methods(http.MethodGet)
+
+	eventsRouter := router.PathPrefix("/events").Subrouter()
+	eventsRouter.Use(p.requireSystemAdmin)
+	eventsRouter.HandleFunc("", p.handleEvents).Methods(http.MethodGet)
+
 	p.router = router
 }
 
@@ -75,13 +93,33 @@ func (p *Plugin) withDelay(next http.Handler) http.Handler {
 	})
 }
 
+// requireSystemAdmin rejects requests that aren't made by an authenticated system admin.
+func (p *Plugin) requireSystemAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := r.Header.Get("Mattermost-User-ID")
+		if userID == "" {
+			http.Error(w, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+
+		if !p.isSystemAdmin(userID) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
 	configuration := p.getConfiguration()
 
 	var response = struct {
-		Enabled bool `json:"enabled"`
+		Enabled bool            `json:"enabled"`
+		Hooks   map[string]bool `json:"hooks"`
 	}{
 		Enabled: !configuration.disabled,
+		Hooks:   configuration.hookMatrix(),
 	}
 
 	responseJSON, _ := json.Marshal(response)
@@ -92,6 +130,61 @@ func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleEvents returns the hook events recorded in the event log. The results can be filtered
+// with the hook, team_id, user_id, since and until (in milliseconds) query parameters, and are
+// paginated using per_page and the cursor returned with each page.
+func (p *Plugin) handleEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := eventQuery{
+		Hook:   query.Get("hook"),
+		TeamID: query.Get("team_id"),
+		UserID: query.Get("user_id"),
+		Cursor: query.Get("cursor"),
+		Limit:  defaultEventsPerPage,
+	}
+
+	if q.Cursor != "" && !strings.HasPrefix(q.Cursor, eventKeyPrefix) {
+		http.Error(w, "Invalid cursor parameter", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if q.Since, err = strconv.ParseInt(since, 10, 64); err != nil {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if q.Until, err = strconv.ParseInt(until, 10, 64); err != nil {
+			http.Error(w, "Invalid until parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	if perPage := query.Get("per_page"); perPage != "" {
+		if q.Limit, err = strconv.Atoi(perPage); err != nil || q.Limit <= 0 || q.Limit > maxEventsPerPage {
+			http.Error(w, fmt.Sprintf("per_page must be between 1 and %d", maxEventsPerPage), http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, nextCursor, err := p.queryEvents(q)
+	if err != nil {
+		p.API.LogError("Failed to query events", "err", err.Error())
+		http.Error(w, "Failed to query events", http.StatusInternalServerError)
+		return
+	}
+
+	p.writeJSON(w, struct {
+		Events     []*hookEvent `json:"events"`
+		NextCursor string       `json:"next_cursor,omitempty"`
+	}{
+		Events:     events,
+		NextCursor: nextCursor,
+	})
+}
+
 func (p *Plugin) handleHello(w http.ResponseWriter, r *http.Request) {
 	if _, err := w.Write([]byte("Hello World!")); err != nil {
 		p.API.LogError("Failed to write hello world", "err", err.Error())
@@ -164,6 +257,12 @@ func (p *Plugin) handleDialog1(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 		}
+
+		// Don't post personal data, such as the email address, publicly
+		if errs := p.redactDialogSubmission(&request); len(errs) > 0 {
+			p.writeJSON(w, &model.SubmitDialogResponse{Errors: errs})
+			return
+		}
 	}
 
 	user, appErr := p.API.GetUser(request.UserId)
@@ -189,10 +288,6 @@ func (p *Plugin) handleDialog1(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !request.Cancelled {
-		// Don't post the email address publicly
-		if request.Submission[dialogElementNameEmail] != nil {
-			request.Submission[dialogElementNameEmail] = "xxxxxxxxxxx"
-		}
 		if _, appErr = p.API.CreatePost(&model.Post{
 			UserId:    p.botID,
 			ChannelId: request.ChannelId,
@@ -257,6 +352,10 @@ func (p *Plugin) handleDialog3(w http.ResponseWriter, r *http.Request) {
 	if request.Cancelled {
 		message = "Dialog cancelled"
 	} else {
+		if errs := p.redactDialogSubmission(&request); len(errs) > 0 {
+			p.writeJSON(w, &model.SubmitDialogResponse{Errors: errs})
+			return
+		}
 		submission := request.Submission
 
 		// Generic approach - format submission data as structured lines
@@ -310,6 +409,10 @@ func (p *Plugin) handleDateDialog(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		for field, message := range p.redactDialogSubmission(&request) {
+			validationErrors[field] = message
+		}
+
 		// Return validation errors to user
 		if len(validationErrors) > 0 {
 			response := &model.SubmitDialogResponse{
@@ -650,6 +753,56 @@ func (p *Plugin) handleDialogFieldRefresh(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+// dialogSteps are the steps of a multi-step dialog by state. The submission of each step returns
+// the form of the next step, the client accumulating the values of the previous steps in the
+// submission, until the last step returns nil to close the dialog.
+type dialogSteps map[string]func(request *model.SubmitDialogRequest) *model.SubmitDialogResponse
+
+// runDialogStep runs the step of the dialog and writes its response.
+func (p *Plugin) runDialogStep(w http.ResponseWriter, request *model.SubmitDialogRequest, state string, steps dialogSteps) {
+	step, ok := steps[state]
+	if !ok {
+		p.writeJSON(w, &model.SubmitDialogResponse{Error: "Unknown dialog state"})
+		return
+	}
+
+	if response := step(request); response != nil {
+		p.writeJSON(w, response)
+		return
+	}
+
+	// Dialog closes automatically when no response type is specified
+	w.WriteHeader(http.StatusOK)
+}
+
+// nextDialogStep returns the form of the next step of a multi-step dialog.
+func nextDialogStep(dialog model.Dialog) *model.SubmitDialogResponse {
+	return &model.SubmitDialogResponse{
+		Type: "form",
+		Form: &dialog,
+	}
+}
+
+// redactDialogSteps applies the redaction policy to the values of all the steps of a multi-step
+// dialog. Fields of the previous steps can't be pointed at anymore, so a single error is
+// reported if the policy rejects personal data.
+func (p *Plugin) redactDialogSteps(request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+	errs := p.redactDialogSubmission(request)
+	if len(errs) == 0 {
+		return nil
+	}
+
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return &model.SubmitDialogResponse{
+		Error: fmt.Sprintf("Personal data isn't allowed in: %s", strings.Join(fields, ", ")),
+	}
+}
+
 // handleDialogMultistep handles the multi-step dialog functionality
 // This demonstrates how submit can return a new form instead of closing the dialog
 func (p *Plugin) handleDialogMultistep(w http.ResponseWriter, r *http.Request) {
@@ -663,96 +816,79 @@ func (p *Plugin) handleDialogMultistep(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
 	// Handle different steps based on the dialog state
-	switch request.State {
-	case "step1":
-		// Move to step 2 - client automatically accumulates values
-		userType := interfaceToString(request.Submission["user_type"])
-		useCase := interfaceToString(request.Submission["use_case"])
-
-		dialog := getDialogStep2(userType, useCase)
-		response := &model.SubmitDialogResponse{
-			Type: "form",
-			Form: &dialog,
-		}
-		p.writeJSON(w, response)
-		return
+	p.runDialogStep(w, &request, request.State, dialogSteps{
+		"step1": func(request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+			// Move to step 2 - client automatically accumulates values
+			userType := interfaceToString(request.Submission["user_type"])
+			useCase := interfaceToString(request.Submission["use_case"])
 
-	case "step2":
-		// Move to step 3 (final confirmation) - client has all values
-		dialog := getDialogStep3Summary(request.Submission)
-		response := &model.SubmitDialogResponse{
-			Type: "form",
-			Form: &dialog,
-		}
-		p.writeJSON(w, response)
-		return
+			return nextDialogStep(getDialogStep2(userType, useCase))
+		},
+		"step2": func(request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+			// Move to step 3 (final confirmation) - client has all values
+			return nextDialogStep(getDialogStep3Summary(request.Submission))
+		},
+		"final": p.completeDialogMultistep,
+	})
+}
 
-	case "final":
-		// Process final submission - all values are in request.Submission
-		user, appErr := p.API.GetUser(request.UserId)
-		if appErr != nil {
-			p.API.LogError("Failed to get user for multistep dialog", "err", appErr.Error())
-			w.WriteHeader(http.StatusOK)
-			return
-		}
+// completeDialogMultistep processes the final submission of the multi-step dialog, with all the
+// values in request.Submission.
+func (p *Plugin) completeDialogMultistep(request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+	user, appErr := p.API.GetUser(request.UserId)
+	if appErr != nil {
+		p.API.LogError("Failed to get user for multistep dialog", "err", appErr.Error())
+		return nil
+	}
 
-		// Check if terms were accepted
-		acceptTerms := interfaceToString(request.Submission["accept_terms"])
-		acceptPrivacy := interfaceToString(request.Submission["accept_privacy"])
+	// Check if terms were accepted
+	acceptTerms := interfaceToString(request.Submission["accept_terms"])
+	acceptPrivacy := interfaceToString(request.Submission["accept_privacy"])
 
-		if acceptTerms != "true" || acceptPrivacy != "true" {
-			response := &model.SubmitDialogResponse{
-				Errors: map[string]string{
-					"accept_terms":   "You must accept the Terms & Conditions",
-					"accept_privacy": "You must accept the Privacy Policy",
-				},
-			}
-			p.writeJSON(w, response)
-			return
+	if acceptTerms != "true" || acceptPrivacy != "true" {
+		return &model.SubmitDialogResponse{
+			Errors: map[string]string{
+				"accept_terms":   "You must accept the Terms & Conditions",
+				"accept_privacy": "You must accept the Privacy Policy",
+			},
 		}
+	}
 
-		// Registration complete - create summary post with all collected data
-		msg := fmt.Sprintf("🎉 @%v successfully completed the multi-step registration process!", user.Username)
+	if response := p.redactDialogSteps(request); response != nil {
+		return response
+	}
 
-		rootPost, appErr := p.API.CreatePost(&model.Post{
-			UserId:    p.botID,
-			ChannelId: request.ChannelId,
-			Message:   msg,
-		})
-		if appErr != nil {
-			p.API.LogError("Failed to post multistep completion message", "err", appErr.Error())
-			return
-		}
+	// Registration complete - create summary post with all collected data
+	msg := fmt.Sprintf("🎉 @%v successfully completed the multi-step registration process!", user.Username)
 
-		// Post summary of all collected data as a thread reply
-		summaryText := "**Complete Registration Data:**\n"
-		for key, value := range request.Submission {
-			if str := interfaceToString(value); str != "" {
-				summaryText += fmt.Sprintf("- **%s:** %s\n", key, str)
-			}
-		}
+	rootPost, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: request.ChannelId,
+		Message:   msg,
+	})
+	if appErr != nil {
+		p.API.LogError("Failed to post multistep completion message", "err", appErr.Error())
+		return nil
+	}
 
-		if _, appErr = p.API.CreatePost(&model.Post{
-			UserId:    p.botID,
-			ChannelId: request.ChannelId,
-			RootId:    rootPost.Id,
-			Message:   summaryText,
-		}); appErr != nil {
-			p.API.LogError("Failed to post registration summary", "err", appErr.Error())
+	// Post summary of all collected data as a thread reply
+	summaryText := "**Complete Registration Data:**\n"
+	for key, value := range request.Submission {
+		if str := interfaceToString(value); str != "" {
+			summaryText += fmt.Sprintf("- **%s:** %s\n", key, str)
 		}
+	}
 
-		// Dialog closes automatically when no response type is specified
-		w.WriteHeader(http.StatusOK)
-		return
-
-	default:
-		// Unknown state - return error
-		response := &model.SubmitDialogResponse{
-			Error: "Unknown dialog state",
-		}
-		p.writeJSON(w, response)
-		return
+	if _, appErr = p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: request.ChannelId,
+		RootId:    rootPost.Id,
+		Message:   summaryText,
+	}); appErr != nil {
+		p.API.LogError("Failed to post registration summary", "err", appErr.Error())
 	}
+
+	return nil
 }
 
 func (p *Plugin) handleDynamicProducts(w http.ResponseWriter, r *http.Request) {
@@ -8,6 +8,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
 )
 
 func TestServeHTTP(t *testing.T) {
@@ -21,7 +23,7 @@ func TestServeHTTP(t *testing.T) {
 			RequestURL:         "/status",
 			ExpectedStatusCode: http.StatusOK,
 			ExpectedHeader:     http.Header{"Content-Type": []string{"application/json"}},
-			ExpectedbodyString: `{"enabled":true}`,
+			ExpectedbodyString: `{"enabled":true,"hooks":{"ChannelHasBeenCreated":true,"FileWillBeDownloaded":true,"FileWillBeUploaded":true,"MessageHasBeenDeleted":true,"MessageHasBeenPosted":true,"MessageHasBeenUpdated":true,"MessageWillBePosted":true,"MessageWillBeUpdated":true,"MessagesWillBeConsumed":true,"ReactionHasBeenAdded":true,"ReactionHasBeenRemoved":true,"UserHasBeenCreated":true,"UserHasBeenDeactivated":true,"UserHasJoinedChannel":true,"UserHasJoinedTeam":true,"UserHasLeftChannel":true,"UserHasLeftTeam":true,"UserHasLoggedIn":true,"UserWillLogIn":true}}`,
 		},
 		"Hello world": {
 			RequestURL:         "/hello",
@@ -29,6 +31,18 @@ func TestServeHTTP(t *testing.T) {
 			ExpectedHeader:     http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
 			ExpectedbodyString: "Hello World!",
 		},
+		"Events require authentication": {
+			RequestURL:         "/events",
+			ExpectedStatusCode: http.StatusUnauthorized,
+			ExpectedHeader:     http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}, "X-Content-Type-Options": []string{"nosniff"}},
+			ExpectedbodyString: "Not authorized\n",
+		},
+		"Post history requires authentication": {
+			RequestURL:         "/posts/" + model.NewId() + "/history",
+			ExpectedStatusCode: http.StatusUnauthorized,
+			ExpectedHeader:     http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}, "X-Content-Type-Options": []string{"nosniff"}},
+			ExpectedbodyString: "Not authorized\n",
+		},
 		"InvalidRequestURL": {
 			RequestURL:         "/not_found",
 			ExpectedStatusCode: http.StatusNotFound,
@@ -25,4 +25,6 @@ func (p *Plugin) BackgroundJob() {
 			)
 		}
 	}
+
+	p.rotateExpiredSecrets()
 }
@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	commandTriggerKarma = "karma"
+
+	// karmaUserKeyPrefix prefixes the KV keys of the karma of the users, followed by the id of the
+	// team and the id of the user.
+	karmaUserKeyPrefix = "karma_user_"
+
+	// karmaTeamKeyPrefix prefixes the KV keys of the ids of the users having karma in a team,
+	// followed by the id of the team, so that the leaderboard doesn't scan the KV store.
+	karmaTeamKeyPrefix = "karma_team_"
+
+	// karmaReactionKeyPrefix prefixes the KV keys of the reactions counted as karma, followed by
+	// the id of the post, the id of the user who reacted and the emoji.
+	karmaReactionKeyPrefix = "karma_reaction_"
+
+	// karmaHistoryDays is the number of days the karma of each day is kept for, to report the
+	// karma since a given time. Totals are kept forever.
+	karmaHistoryDays = 366
+
+	// karmaReactionRetention is how long the reactions counted are kept for, so that removing
+	// them reverses their karma. Removing older reactions leaves the karma as is.
+	karmaReactionRetention = karmaHistoryDays * 24 * time.Hour
+
+	karmaLeaderboardSize = 10
+	maxKarmaWeight       = 100
+
+	// karmaDefaultWeightKey is the key of the weight of the emoji not listed in the weights.
+	karmaDefaultWeightKey = "*"
+)
+
+// karmaCounts are the reactions given and received by a user, and the score, which is the sum
+// of the weights of the emoji received.
+type karmaCounts struct {
+	Given    int `json:"given,omitempty"`
+	Received int `json:"received,omitempty"`
+	Score    int `json:"score,omitempty"`
+}
+
+func (c *karmaCounts) add(other karmaCounts, sign int) {
+	c.Given += sign * other.Given
+	c.Received += sign * other.Received
+	c.Score += sign * other.Score
+}
+
+// karmaRecord is the karma of a user in a team, in total and for each UTC day.
+type karmaRecord struct {
+	Total karmaCounts             `json:"total"`
+	Days  map[string]*karmaCounts `json:"days,omitempty"`
+}
+
+// since returns the karma since the day of the given time, or the total if it is zero.
+func (r *karmaRecord) since(from time.Time) karmaCounts {
+	if from.IsZero() {
+		return r.Total
+	}
+
+	fromDay := from.UTC().Format(time.DateOnly)
+	var counts karmaCounts
+	for day, dayCounts := range r.Days {
+		if day >= fromDay {
+			counts.add(*dayCounts, 1)
+		}
+	}
+
+	return counts
+}
+
+// errKarmaUnchanged aborts the update of a karma reaction when it was already counted or
+// removed.
+var errKarmaUnchanged = errors.New("karma unchanged")
+
+// karmaReaction is the karma counted for a reaction, reversed as is when the reaction is
+// removed, even if the weights changed in the meantime. Once removed, only RemovedAt is kept, so
+// that the reaction isn't counted if the hook of its addition runs after the one of its removal.
+type karmaReaction struct {
+	TeamID     string `json:"team_id,omitempty"`
+	GiverID    string `json:"giver_id,omitempty"`
+	ReceiverID string `json:"receiver_id,omitempty"`
+	Day        string `json:"day,omitempty"`
+	Weight     int    `json:"weight,omitempty"`
+	RemovedAt  int64  `json:"removed_at,omitempty"`
+}
+
+func karmaUserKey(teamID, userID string) string {
+	return karmaUserKeyPrefix + teamID + "_" + userID
+}
+
+func karmaReactionKey(reaction *model.Reaction) string {
+	return karmaReactionKeyPrefix + reaction.PostId + "_" + reaction.UserId + "_" + reaction.EmojiName
+}
+
+// parseKarmaWeights parses the KarmaEmojiWeights setting, a comma or newline separated list of
+// emoji and weights such as "+1=1, heart=2, -1=-1", "*" being the weight of the other emoji.
+func parseKarmaWeights(setting string) (map[string]int, error) {
+	weights := make(map[string]int)
+	for _, entry := range strings.FieldsFunc(setting, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		emoji, value, ok := strings.Cut(entry, "=")
+		emoji = strings.ToLower(strings.Trim(strings.TrimSpace(emoji), ":"))
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || (emoji != karmaDefaultWeightKey && !emojiNameRegexp.MatchString(emoji)) {
+			return nil, errors.Errorf("invalid karma weight %q, expected emoji=weight", entry)
+		}
+		if weight < -maxKarmaWeight || weight > maxKarmaWeight {
+			return nil, errors.Errorf("invalid karma weight %q, the weight must be between %d and %d", entry, -maxKarmaWeight, maxKarmaWeight)
+		}
+		weights[emoji] = weight
+	}
+
+	return weights, nil
+}
+
+// karmaWeight returns the weight of the emoji, zero if reactions with it aren't counted.
+func (c *configuration) karmaWeight(emoji string) int {
+	if weight, ok := c.karmaWeights[emoji]; ok {
+		return weight
+	}
+	return c.karmaWeights[karmaDefaultWeightKey]
+}
+
+// addKarma atomically adds, or subtracts if sign is negative, the counts to the karma of the
+// user on the given day, dropping the days older than karmaHistoryDays. Users are added to the
+// index of the team along with their first karma.
+func (p *Plugin) addKarma(teamID, userID, day string, counts karmaCounts, sign int) error {
+	oldest := time.Now().UTC().AddDate(0, 0, -karmaHistoryDays).Format(time.DateOnly)
+
+	var created bool
+	err := p.updateKV(karmaUserKey(teamID, userID), 0, func(oldValue []byte) (any, error) {
+		var record karmaRecord
+		created = len(oldValue) == 0
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &record); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal karma")
+			}
+		}
+		if record.Days == nil {
+			record.Days = make(map[string]*karmaCounts)
+		}
+
+		record.Total.add(counts, sign)
+		if record.Days[day] == nil {
+			record.Days[day] = &karmaCounts{}
+		}
+		record.Days[day].add(counts, sign)
+
+		for d, dayCounts := range record.Days {
+			if d < oldest || *dayCounts == (karmaCounts{}) {
+				delete(record.Days, d)
+			}
+		}
+
+		return &record, nil
+	})
+	if err != nil || !created {
+		return err
+	}
+
+	return p.updateKV(karmaTeamKeyPrefix+teamID, 0, func(oldValue []byte) (any, error) {
+		var userIDs []string
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &userIDs); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal karma users")
+			}
+		}
+		if slices.Contains(userIDs, userID) {
+			return nil, errKarmaUnchanged
+		}
+
+		return append(userIDs, userID), nil
+	})
+}
+
+// recordKarma counts the reaction as karma given by the user reacting and received by the
+// author of the post, unless the emoji has no weight or users react to their own posts. The karma
+// is applied first, then the reaction is recorded atomically, so that it is counted once even if
+// the hook runs on several plugin instances, and isn't counted if it was removed meanwhile. The
+// karma is reversed if the reaction was already counted or can't be recorded.
+func (p *Plugin) recordKarma(reaction *model.Reaction, post *model.Post, channel *model.Channel) error {
+	configuration := p.getConfiguration()
+	if channel.TeamId == "" || reaction.UserId == post.UserId || reaction.UserId == p.botID ||
+		post.UserId == p.botID || post.UserId == configuration.demoUserID {
+		return nil
+	}
+
+	weight := configuration.karmaWeight(reaction.EmojiName)
+	if weight == 0 {
+		return nil
+	}
+
+	createAt := reaction.CreateAt
+	if createAt == 0 {
+		createAt = model.GetMillis()
+	}
+	counted := &karmaReaction{
+		TeamID:     channel.TeamId,
+		GiverID:    reaction.UserId,
+		ReceiverID: post.UserId,
+		Day:        time.UnixMilli(createAt).UTC().Format(time.DateOnly),
+		Weight:     weight,
+	}
+
+	if err := p.applyKarmaReaction(counted, 1); err != nil {
+		return err
+	}
+
+	err := p.updateKV(karmaReactionKey(reaction), karmaReactionRetention, func(oldValue []byte) (any, error) {
+		if len(oldValue) > 0 {
+			var recorded karmaReaction
+			if err := json.Unmarshal(oldValue, &recorded); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal karma reaction")
+			}
+			// Reactions added again after being removed are counted again.
+			if recorded.RemovedAt == 0 || createAt <= recorded.RemovedAt {
+				return nil, errKarmaUnchanged
+			}
+		}
+
+		return counted, nil
+	})
+	if err == nil {
+		return nil
+	}
+
+	if reverseErr := p.applyKarmaReaction(counted, -1); reverseErr != nil {
+		p.API.LogError("Failed to reverse karma", "post_id", reaction.PostId, "user_id", reaction.UserId, "err", reverseErr.Error())
+	}
+	if err == errKarmaUnchanged {
+		return nil
+	}
+
+	return errors.Wrap(err, "failed to record karma reaction")
+}
+
+// reverseKarma reverses the karma counted for a removed reaction, if any, and records that the
+// reaction was removed. The record is restored if the karma can't be reversed.
+func (p *Plugin) reverseKarma(reaction *model.Reaction) error {
+	removedAt := model.GetMillis()
+	var counted *karmaReaction
+	var previous []byte
+	err := p.updateKV(karmaReactionKey(reaction), karmaReactionRetention, func(oldValue []byte) (any, error) {
+		counted, previous = nil, oldValue
+		if len(oldValue) > 0 {
+			var recorded karmaReaction
+			if err := json.Unmarshal(oldValue, &recorded); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal karma reaction")
+			}
+			if recorded.RemovedAt != 0 {
+				return nil, errKarmaUnchanged
+			}
+			counted = &recorded
+		}
+
+		return &karmaReaction{RemovedAt: removedAt}, nil
+	})
+	if err == errKarmaUnchanged || (err == nil && counted == nil) {
+		return nil
+	} else if err != nil {
+		return errors.Wrap(err, "failed to record removed karma reaction")
+	}
+
+	if err := p.applyKarmaReaction(counted, -1); err != nil {
+		restoreErr := p.updateKV(karmaReactionKey(reaction), karmaReactionRetention, func(oldValue []byte) (any, error) {
+			var recorded karmaReaction
+			if err := json.Unmarshal(oldValue, &recorded); err != nil || recorded.RemovedAt != removedAt {
+				return nil, errKarmaUnchanged
+			}
+			if len(previous) == 0 {
+				return nil, nil
+			}
+			return previous, nil
+		})
+		if restoreErr != nil && restoreErr != errKarmaUnchanged {
+			p.API.LogError("Failed to restore karma reaction", "post_id", reaction.PostId, "user_id", reaction.UserId, "err", restoreErr.Error())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// applyKarmaReaction adds, or subtracts if sign is negative, the karma of the reaction to the
+// receiver and the giver, as a whole: the receiver's is rolled back if the giver's fails.
+func (p *Plugin) applyKarmaReaction(counted *karmaReaction, sign int) error {
+	received := karmaCounts{Received: 1, Score: counted.Weight}
+	if err := p.addKarma(counted.TeamID, counted.ReceiverID, counted.Day, received, sign); err != nil {
+		return err
+	}
+
+	if err := p.addKarma(counted.TeamID, counted.GiverID, counted.Day, karmaCounts{Given: 1}, sign); err != nil {
+		if rollbackErr := p.addKarma(counted.TeamID, counted.ReceiverID, counted.Day, received, -sign); rollbackErr != nil {
+			p.API.LogError("Failed to roll back karma", "user_id", counted.ReceiverID, "err", rollbackErr.Error())
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (p *Plugin) getKarma(teamID, userID string) (*karmaRecord, error) {
+	var record karmaRecord
+	if err := p.client.KV.Get(karmaUserKey(teamID, userID), &record); err != nil {
+		return nil, errors.Wrap(err, "failed to get karma")
+	}
+
+	return &record, nil
+}
+
+// karmaLeaderboard returns the users of the team with the highest scores since the given time,
+// and their karma.
+func (p *Plugin) karmaLeaderboard(teamID string, from time.Time) ([]string, map[string]karmaCounts, error) {
+	var teamUserIDs []string
+	if err := p.client.KV.Get(karmaTeamKeyPrefix+teamID, &teamUserIDs); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get karma users")
+	}
+
+	var userIDs []string
+	counts := make(map[string]karmaCounts)
+	for _, userID := range teamUserIDs {
+		record, err := p.getKarma(teamID, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		userCounts := record.since(from)
+		if userCounts == (karmaCounts{}) {
+			continue
+		}
+		userIDs = append(userIDs, userID)
+		counts[userID] = userCounts
+	}
+
+	sort.Slice(userIDs, func(i, j int) bool {
+		a, b := counts[userIDs[i]], counts[userIDs[j]]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Received != b.Received {
+			return a.Received > b.Received
+		}
+		return userIDs[i] < userIDs[j]
+	})
+	if len(userIDs) > karmaLeaderboardSize {
+		userIDs = userIDs[:karmaLeaderboardSize]
+	}
+
+	return userIDs, counts, nil
+}
+
+func getCommandKarmaAutocompleteData() *model.AutocompleteData {
+	command := model.NewAutocompleteData(commandTriggerKarma, "[@user] [--since 7d]", "Show the karma earned with reactions in this team.")
+
+	leaderboard := model.NewAutocompleteData("leaderboard", "[--since 7d]", "Show the users with the most karma in this team.")
+	leaderboard.AddTextArgument("Only count the reactions since, e.g. 7d or 24h", "[--since 7d]", "")
+	command.AddCommand(leaderboard)
+
+	command.AddTextArgument("User, yourself by default, and only count the reactions since, e.g. 7d or 24h", "[@user] [--since 7d]", "")
+
+	return command
+}
+
+func (p *Plugin) executeCommandKarma(args *model.CommandArgs) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	fields := strings.Fields(args.Command)[1:]
+
+	var from time.Time
+	period := "overall"
+	for i, field := range fields {
+		if field != "--since" {
+			continue
+		}
+		if i+1 >= len(fields) {
+			return respond("Usage: `/karma [@user] [--since 7d]` or `/karma leaderboard [--since 7d]`.")
+		}
+		duration, ok := parseRelativeDuration(fields[i+1])
+		if !ok {
+			return respond(fmt.Sprintf("Invalid duration %s, expected e.g. 7d or 24h.", fields[i+1]))
+		}
+		from = time.Now().Add(-duration)
+		period = "since " + from.UTC().Format("Mon Jan 2 2006")
+		fields = append(fields[:i], fields[i+2:]...)
+		break
+	}
+	if len(fields) > 1 {
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+
+	if len(fields) == 1 && fields[0] == "leaderboard" {
+		userIDs, counts, err := p.karmaLeaderboard(args.TeamId, from)
+		if err != nil {
+			p.API.LogError("Failed to get karma leaderboard", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to get the karma leaderboard.")
+		}
+		return respond(p.karmaLeaderboardSummary(userIDs, counts, period))
+	}
+
+	user, appErr := p.API.GetUser(args.UserId)
+	if len(fields) == 1 {
+		if !strings.HasPrefix(fields[0], "@") {
+			return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+		}
+		user, appErr = p.API.GetUserByUsername(strings.TrimPrefix(fields[0], "@"))
+		if appErr != nil {
+			return respond(fmt.Sprintf("Unknown user %s.", fields[0]))
+		}
+	}
+	if appErr != nil {
+		p.API.LogError("Failed to get user", "user_id", args.UserId, "err", appErr.Error())
+		return respond("Failed to get your karma.")
+	}
+
+	record, err := p.getKarma(args.TeamId, user.Id)
+	if err != nil {
+		p.API.LogError("Failed to get karma", "user_id", user.Id, "err", err.Error())
+		return respond("Failed to get the karma.")
+	}
+	counts := record.since(from)
+
+	return respond(fmt.Sprintf("@%s has **%d** karma in this team %s, from %d reactions received. They gave %d reactions.", user.Username, counts.Score, period, counts.Received, counts.Given))
+}
+
+// karmaLeaderboardSummary describes the leaderboard in Markdown.
+func (p *Plugin) karmaLeaderboardSummary(userIDs []string, counts map[string]karmaCounts, period string) string {
+	if len(userIDs) == 0 {
+		return fmt.Sprintf("Nobody earned karma in this team %s.", period)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Karma leaderboard %s:\n\n| Rank | User | Karma | Received | Given |\n|:-----|:-----|:------|:---------|:------|\n", period)
+	for i, userID := range userIDs {
+		username := userID
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			username = "@" + user.Username
+		}
+		c := counts[userID]
+		fmt.Fprintf(&sb, "| %d | %s | %d | %d | %d |\n", i+1, username, c.Score, c.Received, c.Given)
+	}
+
+	return sb.String()
+}
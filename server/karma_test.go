@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseKarmaWeights(t *testing.T) {
+	weights, err := parseKarmaWeights("+1=1, :heart: = 2\n-1=-1,*=0,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"+1": 1, "heart": 2, "-1": -1, "*": 0}, weights)
+
+	for _, setting := range []string{"heart", "heart=lots", "=1", "heart=101", "bad emoji=1"} {
+		_, err := parseKarmaWeights(setting)
+		assert.Error(t, err, setting)
+	}
+
+	config := &configuration{karmaWeights: map[string]int{"heart": 2, "*": 1}}
+	assert.Equal(t, 2, config.karmaWeight("heart"))
+	assert.Equal(t, 1, config.karmaWeight("smile"))
+	assert.Equal(t, 0, (&configuration{}).karmaWeight("smile"))
+}
+
+func TestKarma(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	for _, node := range cluster.nodes {
+		configuration := node.getConfiguration().Clone()
+		configuration.karmaWeights = map[string]int{"heart": 2, "-1": -1, "eyes": 0, "*": 1}
+		node.setConfiguration(configuration)
+	}
+
+	channel := &model.Channel{Id: "channel1", TeamId: "team1"}
+	post := &model.Post{Id: "post1", ChannelId: "channel1", UserId: "author"}
+	react := func(node *Plugin, userID, emoji string, add bool) {
+		reaction := &model.Reaction{UserId: userID, PostId: post.Id, EmojiName: emoji}
+		if add {
+			require.NoError(t, node.recordKarma(reaction, post, channel))
+		} else {
+			require.NoError(t, node.reverseKarma(reaction))
+		}
+	}
+	karma := func(userID string, from time.Time) karmaCounts {
+		record, err := node2.getKarma("team1", userID)
+		require.NoError(t, err)
+		return record.since(from)
+	}
+
+	react(node1, "user1", "heart", true)
+	react(node2, "user1", "heart", true)
+	react(node2, "user1", "-1", true)
+	react(node1, "user2", "smile", true)
+	react(node1, "user2", "eyes", true)
+	react(node1, "author", "heart", true)
+
+	assert.Equal(t, karmaCounts{Received: 3, Score: 2}, karma("author", time.Time{}))
+	assert.Equal(t, karmaCounts{Received: 3, Score: 2}, karma("author", time.Now().Add(-time.Hour)))
+	assert.Equal(t, karmaCounts{}, karma("author", time.Now().Add(48*time.Hour)))
+	assert.Equal(t, karmaCounts{Given: 2}, karma("user1", time.Time{}))
+
+	react(node2, "user1", "-1", false)
+	react(node1, "user1", "-1", false)
+	react(node1, "user2", "eyes", false)
+	assert.Equal(t, karmaCounts{Received: 2, Score: 3}, karma("author", time.Time{}))
+
+	t.Run("reactions are kept for the karma history", func(t *testing.T) {
+		key := karmaReactionKey(&model.Reaction{UserId: "user1", PostId: post.Id, EmojiName: "heart"})
+		cluster.mu.Lock()
+		defer cluster.mu.Unlock()
+		assert.Equal(t, int64(karmaReactionRetention/time.Second), cluster.ttls[key])
+	})
+
+	t.Run("removal handled before the addition", func(t *testing.T) {
+		reaction := &model.Reaction{UserId: "user3", PostId: post.Id, EmojiName: "heart", CreateAt: model.GetMillis() - 1}
+		require.NoError(t, node2.reverseKarma(reaction))
+		require.NoError(t, node1.recordKarma(reaction, post, channel))
+		assert.Equal(t, karmaCounts{}, karma("user3", time.Time{}))
+
+		readded := &model.Reaction{UserId: "user3", PostId: post.Id, EmojiName: "heart", CreateAt: model.GetMillis() + 1}
+		require.NoError(t, node1.recordKarma(readded, post, channel))
+		require.NoError(t, node2.recordKarma(readded, post, channel))
+		assert.Equal(t, karmaCounts{Given: 1}, karma("user3", time.Time{}), "reactions added again are counted")
+
+		require.NoError(t, node2.reverseKarma(readded))
+		assert.Equal(t, karmaCounts{}, karma("user3", time.Time{}))
+		assert.Equal(t, karmaCounts{Received: 2, Score: 3}, karma("author", time.Time{}))
+	})
+
+	t.Run("concurrent reactions", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := range 20 {
+			wg.Add(1)
+			go func(node *Plugin, userID string) {
+				defer wg.Done()
+				react(node, userID, "heart", true)
+				react(node, userID, "tada", true)
+				react(node, userID, "tada", false)
+			}(cluster.nodes[i%2], fmt.Sprintf("fan%d", i))
+		}
+		wg.Wait()
+
+		assert.Equal(t, karmaCounts{Received: 22, Score: 43}, karma("author", time.Time{}))
+	})
+
+	t.Run("failures", func(t *testing.T) {
+		reaction := &model.Reaction{UserId: "user4", PostId: post.Id, EmojiName: "heart", CreateAt: model.GetMillis()}
+		cluster.failedKeyPrefix = karmaReactionKeyPrefix
+		require.Error(t, node1.recordKarma(reaction, post, channel))
+		assert.Equal(t, karmaCounts{}, karma("user4", time.Time{}), "the karma is reversed if the reaction can't be recorded")
+
+		cluster.failedKeyPrefix = karmaUserKey("team1", "user4")
+		require.Error(t, node1.recordKarma(reaction, post, channel))
+		assert.Equal(t, karmaCounts{Received: 22, Score: 43}, karma("author", time.Time{}), "the karma of the receiver is rolled back")
+
+		cluster.failedKeyPrefix = ""
+		require.NoError(t, node1.recordKarma(reaction, post, channel))
+		assert.Equal(t, karmaCounts{Received: 23, Score: 45}, karma("author", time.Time{}))
+
+		cluster.failedKeyPrefix = karmaUserKey("team1", "user4")
+		require.Error(t, node1.reverseKarma(reaction))
+		cluster.failedKeyPrefix = ""
+		require.NoError(t, node2.reverseKarma(reaction), "the reaction is recorded again if its karma can't be reversed")
+		assert.Equal(t, karmaCounts{}, karma("user4", time.Time{}))
+		assert.Equal(t, karmaCounts{Received: 22, Score: 43}, karma("author", time.Time{}))
+	})
+
+	t.Run("leaderboard", func(t *testing.T) {
+		kvLists := cluster.kvLists
+		defer func() { assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned") }()
+
+		userIDs, counts, err := node1.karmaLeaderboard("team1", time.Time{})
+		require.NoError(t, err)
+		require.Len(t, userIDs, karmaLeaderboardSize)
+		assert.Equal(t, "author", userIDs[0])
+		assert.Equal(t, 43, counts["author"].Score)
+		assert.Equal(t, "fan0", userIDs[1], "ties are broken by user id")
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	// kvListPageSize is the number of keys requested per page when listing the KV store.
+	kvListPageSize = 1000
+
+	// kvAtomicRetries is the number of times an atomic KV operation is retried when the value
+	// changes concurrently.
+	kvAtomicRetries = 5
+)
+
+// listKeysWithPrefix pages through the plugin's KV store and returns every key starting with
+// the given prefix.
+func (p *Plugin) listKeysWithPrefix(prefix string) ([]string, error) {
+	var keys []string
+	for page := 0; ; page++ {
+		pageKeys, appErr := p.API.KVList(page, kvListPageSize)
+		if appErr != nil {
+			return nil, errors.Wrap(appErr, "failed to list keys")
+		}
+
+		for _, key := range pageKeys {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+
+		if len(pageKeys) < kvListPageSize {
+			return keys, nil
+		}
+	}
+}
+
+// takeKV atomically deletes the given key and returns the value it had, or nil if it didn't
+// exist. When several plugin instances take the same key concurrently, only one of them gets
+// the value.
+func (p *Plugin) takeKV(key string) ([]byte, error) {
+	for range kvAtomicRetries {
+		var data []byte
+		if err := p.client.KV.Get(key, &data); err != nil {
+			return nil, errors.Wrapf(err, "failed to get key %s", key)
+		}
+		if len(data) == 0 {
+			return nil, nil
+		}
+
+		deleted, appErr := p.API.KVCompareAndDelete(key, data)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to delete key %s", key)
+		}
+		if deleted {
+			return data, nil
+		}
+	}
+
+	return nil, errors.Errorf("failed to take key %s after %d retries", key, kvAtomicRetries)
+}
+
+// updateKV atomically replaces the value of the given key with the one returned by update,
+// which is passed the current value or nil. The key expires after the given ttl, if positive,
+// and is deleted when update returns nil.
+func (p *Plugin) updateKV(key string, ttl time.Duration, update func(oldValue []byte) (any, error)) error {
+	for range kvAtomicRetries {
+		var oldValue []byte
+		if err := p.client.KV.Get(key, &oldValue); err != nil {
+			return errors.Wrapf(err, "failed to get key %s", key)
+		}
+
+		newValue, err := update(oldValue)
+		if err != nil {
+			return err
+		}
+		if newValue == nil && len(oldValue) == 0 {
+			return nil
+		}
+
+		options := []pluginapi.KVSetOption{pluginapi.SetAtomic(oldValue)}
+		if ttl > 0 && newValue != nil {
+			options = append(options, pluginapi.SetExpiry(ttl))
+		}
+
+		saved, err := p.client.KV.Set(key, newValue, options...)
+		if err != nil {
+			return errors.Wrapf(err, "failed to set key %s", key)
+		}
+		if saved {
+			return nil
+		}
+	}
+
+	return errors.Errorf("failed to update key %s after %d retries", key, kvAtomicRetries)
+}
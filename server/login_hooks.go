@@ -12,9 +12,19 @@ import (
 //
 // This demo implementation rejects login attempts by the demo user.
 func (p *Plugin) UserWillLogIn(c *plugin.Context, user *model.User) string {
+	event := newHookEvent(hookUserWillLogIn)
+	event.UserID = user.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
+	if !configuration.isHookEnabled(hookUserWillLogIn) {
+		event.Outcome = eventOutcomeSkipped
+		return ""
+	}
+
 	if user.Username == configuration.Username {
+		event.reject("the demo user is not allowed to login")
 		return "the demo user is not allowed to login"
 	}
 
@@ -25,14 +35,24 @@ func (p *Plugin) UserWillLogIn(c *plugin.Context, user *model.User) string {
 //
 // This demo implementation logs a message to the demo channel whenever a user logs in.
 func (p *Plugin) UserHasLoggedIn(c *plugin.Context, user *model.User) {
+	event := newHookEvent(hookUserHasLoggedIn)
+	event.UserID = user.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
+	if !configuration.isHookEnabled(hookUserHasLoggedIn) {
+		event.Outcome = eventOutcomeSkipped
+		return
+	}
+
 	teams, err := p.API.GetTeams()
 	if err != nil {
 		p.API.LogError(
 			"Failed to query teams UserHasLoggedIn",
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -44,8 +64,9 @@ func (p *Plugin) UserHasLoggedIn(c *plugin.Context, user *model.User) {
 			continue
 		}
 
+		data := hookTemplateData{User: newTemplateUser(user), Team: newTemplateTeam(team)}
 		msg := fmt.Sprintf("@%s has logged in. ID: `%s`", user.Username, user.Id)
-		if err := p.postPluginMessage(team.Id, msg); err != nil {
+		if err := p.postHookMessage(hookUserHasLoggedIn, team.Id, data, msg); err != nil {
 			p.API.LogError(
 				"Failed to post UserHasLoggedIn message",
 				"teamName", team.Name,
@@ -53,6 +74,7 @@ func (p *Plugin) UserHasLoggedIn(c *plugin.Context, user *model.User) {
 				"channel_id", channelId,
 				"error", err.Error(),
 			)
+			event.fail(err)
 		}
 	}
 }
@@ -2,8 +2,8 @@ package main
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
@@ -15,14 +15,23 @@ import (
 //
 // If you don't need to modify or reject posts, use MessageHasBeenPosted instead.
 //
-// Note that this method will be called for posts created by plugins, including the plugin that created the post.
+// Note that this method will be called for posts created by plugins, including the plugin that
+// created the post.
 //
 // This demo implementation rejects posts in the demo channel, as well as posts that @-mention
-// the demo plugin user.
+// the demo plugin user unless the auto-responder is enabled. Other posts are subject to the
+// moderation rules and the redaction policy of the team and to the slow mode of the channel, then
+// encrypted when prefixed with "[SECURE]".
 func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (*model.Post, string) {
+	event := newHookEvent(hookMessageWillBePosted)
+	event.ChannelID = post.ChannelId
+	event.UserID = post.UserId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookMessageWillBePosted) {
+		event.Outcome = eventOutcomeSkipped
 		return post, ""
 	}
 
@@ -40,23 +49,42 @@ func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (*mode
 				Message:   "Posting is not allowed in this channel.",
 			})
 
+			event.reject("disallowing post in demo channel")
 			return nil, "disallowing post in demo channel"
 		}
 	}
 
-	// Reject posts mentioning the demo plugin user.
-	if strings.Contains(post.Message, fmt.Sprintf("@%s", configuration.Username)) {
+	// Reject posts mentioning the demo plugin user, unless the demo plugin user replies to them.
+	if !configuration.EnableAutoResponder && strings.Contains(post.Message, fmt.Sprintf("@%s", configuration.Username)) {
 		p.API.SendEphemeralPost(post.UserId, &model.Post{
 			UserId:    configuration.demoUserID,
 			ChannelId: post.ChannelId,
 			Message:   "Shh! You must not talk about the demo plugin user.",
 		})
 
+		event.reject("mention of demo plugin user")
 		return nil, plugin.DismissPostError
 	}
 
-	// Otherwise, allow the post through.
-	return post, ""
+	// Otherwise, let the moderation rules decide, then apply the redaction policy and the slow
+	// mode, and encrypt [SECURE] posts.
+	post, reason := p.moderatePost(post, event)
+	if post == nil {
+		return nil, reason
+	}
+
+	post, reason = p.redactPost(post, redactionSourcePost, event)
+	if post == nil {
+		return nil, reason
+	}
+
+	// Posts rejected above don't count towards the slow mode of the channel.
+	post, reason = p.enforceSlowMode(post, event)
+	if post == nil {
+		return nil, reason
+	}
+
+	return p.encryptSecurePost(post, event)
 }
 
 // MessageWillBeUpdated is invoked when a message is updated by a user before it is committed to
@@ -69,44 +97,83 @@ func (p *Plugin) MessageWillBePosted(c *plugin.Context, post *model.Post) (*mode
 // Note that this method will be called for posts updated by plugins, including the plugin that
 // updated the post.
 //
-// This demo implementation rejects posts that @-mention the demo plugin user.
+// This demo implementation rejects posts that @-mention the demo plugin user, unless the
+// auto-responder is enabled. Other posts are subject to the moderation rules and the redaction
+// policy of the team, and [SECURE] posts are encrypted again.
 func (p *Plugin) MessageWillBeUpdated(c *plugin.Context, newPost, oldPost *model.Post) (*model.Post, string) {
+	event := newHookEvent(hookMessageWillBeUpdated)
+	event.ChannelID = newPost.ChannelId
+	event.UserID = newPost.UserId
+	event.PostID = newPost.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookMessageWillBeUpdated) {
+		event.Outcome = eventOutcomeSkipped
 		return newPost, ""
 	}
 
-	// Reject posts mentioning the demo plugin user.
-	if strings.Contains(newPost.Message, fmt.Sprintf("@%s", configuration.Username)) {
+	// Reject posts mentioning the demo plugin user, unless the demo plugin user replies to them.
+	if !configuration.EnableAutoResponder && strings.Contains(newPost.Message, fmt.Sprintf("@%s", configuration.Username)) {
 		p.API.SendEphemeralPost(newPost.UserId, &model.Post{
 			UserId:    configuration.demoUserID,
 			ChannelId: newPost.ChannelId,
 			Message:   "You must not talk about the demo plugin user.",
 		})
 
+		event.reject("disallowing mention of demo plugin user")
 		return nil, "disallowing mention of demo plugin user"
 	}
 
-	// Otherwise, allow the post through.
-	return newPost, ""
+	// Posts updated by the demo plugin user and demo plugin bot aren't moderated nor redacted.
+	if newPost.UserId != p.botID && newPost.UserId != configuration.demoUserID {
+		var reason string
+		newPost, reason = p.moderatePost(newPost, event)
+		if newPost == nil {
+			return nil, reason
+		}
+
+		newPost, reason = p.redactPost(newPost, redactionSourcePostUpdate, event)
+		if newPost == nil {
+			return nil, reason
+		}
+	}
+
+	// Edited [SECURE] posts are encrypted again.
+	return p.encryptSecurePost(newPost, event)
 }
 
 // MessageHasBeenPosted is invoked after the message has been committed to the database. If you
 // need to modify or reject the post, see MessageWillBePosted Note that this method will be called
 // for posts created by plugins, including the plugin that created the post.
 //
-// This demo implementation logs a message to the demo channel whenever a message is posted,
-// unless by the demo plugin user itself.
+// This demo implementation reports the posts flagged by the moderation rules, and logs a message to
+// the demo channel whenever a message is posted, unless by the demo plugin user itself. When the
+// auto-responder is enabled, the demo plugin user replies to the posts mentioning it and to its
+// direct messages. It also alerts the users watching keywords the post contains, and credits the
+// first user to post one of the team's secrets.
 func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
+	event := newHookEvent(hookMessageHasBeenPosted)
+	event.ChannelID = post.ChannelId
+	event.UserID = post.UserId
+	event.PostID = post.Id
+	defer p.recordEvent(event)
+
+	// Posts flagged by the moderation rules are reported with their saved content, even when the
+	// notifications of the hook are disabled.
+	p.reportFlaggedPost(post)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookMessageHasBeenPosted) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
 	// Ignore posts by the demo plugin user and demo plugin bot.
 	if post.UserId == p.botID || post.UserId == configuration.demoUserID {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -117,6 +184,7 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 			"user_id", post.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -127,63 +195,89 @@ func (p *Plugin) MessageHasBeenPosted(c *plugin.Context, post *model.Post) {
 			"channel_id", post.ChannelId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
+	event.TeamID = channel.TeamId
 
+	data := hookTemplateData{
+		User:      newTemplateUser(user),
+		Channel:   newTemplateChannel(channel),
+		Post:      newTemplatePost(post),
+		Permalink: p.permalink(post.Id),
+	}
 	msg := fmt.Sprintf("MessageHasBeenPosted: @%s, ~%s", user.Username, channel.Name)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookMessageHasBeenPosted, channel.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post MessageHasBeenPosted message",
 			"channel_id", channel.Id,
 			"user_id", user.Id,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 
-	// Check if the Random Secret was posted
-	if strings.Contains(post.Message, configuration.RandomSecret) {
-		msg = fmt.Sprintf("The random secret %q has been entered by @%s!\n%s",
-			configuration.RandomSecret, user.Username, configuration.SecretMessage,
+	if err := p.notifyWatchers(post, user, channel); err != nil {
+		p.API.LogError(
+			"Failed to alert keyword watchers",
+			"channel_id", channel.Id,
+			"user_id", user.Id,
+			"error", err.Error(),
 		)
-		if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
-			p.API.LogError(
-				"Failed to post random secret message",
-				"channel_id", channel.Id,
-				"user_id", user.Id,
-				"error", err.Error(),
-			)
-		}
+		event.fail(err)
 	}
 
-	if strings.Contains(post.Message, strconv.Itoa(configuration.SecretNumber)) {
-		msg = fmt.Sprintf("The random number %d has been entered by @%s!",
-			configuration.SecretNumber, user.Username)
-		if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if configuration.EnableAutoResponder && p.isDemoUserAddressed(post, channel) {
+		if err := p.autoRespond(post, channel.TeamId, data); err != nil {
 			p.API.LogError(
-				"Failed to post random secret message",
+				"Failed to post auto-responder reply",
 				"channel_id", channel.Id,
 				"user_id", user.Id,
 				"error", err.Error(),
 			)
+			event.fail(err)
 		}
 	}
+
+	// Check if one of the team's secrets was posted
+	if err := p.checkSecrets(post, user, channel.TeamId); err != nil {
+		p.API.LogError(
+			"Failed to check secrets",
+			"channel_id", channel.Id,
+			"user_id", user.Id,
+			"error", err.Error(),
+		)
+		event.fail(err)
+	}
 }
 
 // MessageHasBeenUpdated is invoked after a message is updated and has been updated in the
 // database. If you need to modify or reject the post, see MessageWillBeUpdated Note that this
 // method will be called for posts created by plugins, including the plugin that created the post.
 //
-// This demo implementation logs a message to the demo channel whenever a message is updated,
-// unless by the demo plugin user itself.
+// This demo implementation reports the posts flagged by the moderation rules, and logs a message to
+// the demo channel whenever a message is updated, unless by the demo plugin user itself, with a
+// threaded reply describing the changes in public channels. It also records the edit history of
+// the post when enabled.
 func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *model.Post) {
+	event := newHookEvent(hookMessageHasBeenUpdated)
+	event.ChannelID = newPost.ChannelId
+	event.UserID = newPost.UserId
+	event.PostID = newPost.Id
+	defer p.recordEvent(event)
+
+	p.reportFlaggedPost(newPost)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookMessageHasBeenUpdated) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
-	// Ignore updates by the demo plugin user.
-	if newPost.UserId == configuration.demoUserID {
+	// Ignore updates by the demo plugin user, and the tallies of polls.
+	if newPost.UserId == configuration.demoUserID || (newPost.UserId == p.botID && newPost.GetProp(pollPropKey) != nil) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -194,6 +288,7 @@ func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *mode
 			"user_id", newPost.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -204,32 +299,50 @@ func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *mode
 			"channel_id", newPost.ChannelId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
+	event.TeamID = channel.TeamId
 
+	data := hookTemplateData{
+		User:      newTemplateUser(user),
+		Channel:   newTemplateChannel(channel),
+		Post:      newTemplatePost(newPost),
+		Permalink: p.permalink(newPost.Id),
+	}
 	msg := fmt.Sprintf("MessageHasBeenUpdated: @%s, ~%s", user.Username, channel.Name)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if configuration.EnableEditHistory {
+		if err := p.recordPostRevision(oldPost, newPost); err != nil {
+			p.API.LogError("Failed to record edit history", "post_id", newPost.Id, "err", err.Error())
+		}
+	}
+
+	// The diff discloses the content of the post, while direct and group messages are logged to
+	// the demo channels of every team and private channels to the demo channel of their team.
+	if channel.Type == model.ChannelTypeOpen {
+		err = p.postHookMessageWithReply(hookMessageHasBeenUpdated, channel.TeamId, data, msg, p.postEditDiffReply(oldPost, newPost))
+	} else {
+		err = p.postHookMessage(hookMessageHasBeenUpdated, channel.TeamId, data, msg)
+	}
+	if err != nil {
 		p.API.LogError(
 			"Failed to post MessageHasBeenUpdated message",
 			"channel_id", channel.Id,
 			"user_id", user.Id,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 
-	// Check if the Random Secret was posted
-	if strings.Contains(newPost.Message, configuration.RandomSecret) {
-		msg = fmt.Sprintf("The random secret %q has been entered by @%s!\n%s",
-			configuration.RandomSecret, user.Username, configuration.SecretMessage,
+	// Check if one of the team's secrets was posted
+	if err := p.checkSecrets(newPost, user, channel.TeamId); err != nil {
+		p.API.LogError(
+			"Failed to check secrets",
+			"channel_id", channel.Id,
+			"user_id", user.Id,
+			"error", err.Error(),
 		)
-		if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
-			p.API.LogError(
-				"Failed to post random secret message",
-				"channel_id", channel.Id,
-				"user_id", user.Id,
-				"error", err.Error(),
-			)
-		}
+		event.fail(err)
 	}
 }
 
@@ -238,19 +351,41 @@ func (p *Plugin) MessageHasBeenUpdated(c *plugin.Context, newPost, oldPost *mode
 // Note that this method will be called for posts deleted by plugins, including
 // the plugin that deleted the post.
 //
-// This demo implementation logs a message to the demo channel whenever a message is deleted.
+// This demo implementation logs a message to the demo channel whenever a message is deleted, and
+// archives the deleted post so that it can be restored with /demo_plugin deleted restore. Its edit
+// history expires along with the archived post, and the poll of a deleted poll post is deleted.
 func (p *Plugin) MessageHasBeenDeleted(c *plugin.Context, post *model.Post) {
+	event := newHookEvent(hookMessageHasBeenDeleted)
+	event.ChannelID = post.ChannelId
+	event.UserID = post.UserId
+	event.PostID = post.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookMessageHasBeenDeleted) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
 	// Ignore updates by the demo plugin user.
 	if post.UserId == configuration.demoUserID {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
+	if err := p.archiveDeletedPost(post); err != nil {
+		p.API.LogError("Failed to archive deleted post", "post_id", post.Id, "err", err.Error())
+	}
+	if err := p.expirePostHistory(post.Id); err != nil {
+		p.API.LogError("Failed to expire edit history", "post_id", post.Id, "err", err.Error())
+	}
+	if post.GetProp(pollPropKey) != nil {
+		if err := p.deletePoll(post.Id); err != nil {
+			p.API.LogError("Failed to delete poll", "post_id", post.Id, "err", err.Error())
+		}
+	}
+
 	user, err := p.API.GetUser(post.UserId)
 	if err != nil {
 		p.API.LogError(
@@ -258,6 +393,7 @@ func (p *Plugin) MessageHasBeenDeleted(c *plugin.Context, post *model.Post) {
 			"user_id", post.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -268,17 +404,26 @@ func (p *Plugin) MessageHasBeenDeleted(c *plugin.Context, post *model.Post) {
 			"channel_id", post.ChannelId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
+	event.TeamID = channel.TeamId
 
+	data := hookTemplateData{
+		User:      newTemplateUser(user),
+		Channel:   newTemplateChannel(channel),
+		Post:      newTemplatePost(post),
+		Permalink: p.permalink(post.Id),
+	}
 	msg := fmt.Sprintf("MessageHasBeenDeleted: @%s, ~%s", user.Username, channel.Name)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookMessageHasBeenDeleted, channel.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post MessageHasBeenDeleted message",
 			"channel_id", channel.Id,
 			"user_id", user.Id,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
 
@@ -286,18 +431,30 @@ func (p *Plugin) MessageHasBeenDeleted(c *plugin.Context, post *model.Post) {
 // modify the message before it is sent to the client. Note that this method will be called for
 // posts created by plugins, including the plugin that created the post.
 //
-// This demo implementation replaces "SECURE" prefix in the messages with "ENCRYPTED" prefix.
+// This demo implementation decrypts the [SECURE] posts encrypted at rest by MessageWillBePosted.
+// Decryption doesn't depend on the hook being enabled, as clients couldn't read these posts
+// otherwise. When encryption is disabled, the "SECURE" prefix in the messages is replaced with
+// "ENCRYPTED" prefix instead.
 func (p *Plugin) MessagesWillBeConsumed(posts []*model.Post) []*model.Post {
+	start := time.Now()
+	defer func() {
+		p.metrics.observeConsumedPosts(len(posts), time.Since(start))
+	}()
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	for _, post := range posts {
+		p.decryptPost(post)
+	}
+
+	if !configuration.isHookEnabled(hookMessagesWillBeConsumed) || configuration.EnableSecureEncryption {
 		return posts
 	}
 
 	for _, post := range posts {
 		// Replaces posts that include "SECURE" prefix with "ENCRYPTED" prefix.
-		if strings.HasPrefix(post.Message, "[SECURE]") {
-			post.Message = strings.Replace(post.Message, "[SECURE]", "[ENCRYPTED]", 1)
+		if strings.HasPrefix(post.Message, securePrefix) {
+			post.Message = strings.Replace(post.Message, securePrefix, "[ENCRYPTED]", 1)
 		}
 	}
 	return posts
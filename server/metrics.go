@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const metricsNamespace = "demo_plugin"
+
+const (
+	dialogOutcomeSubmitted = "submitted"
+	dialogOutcomeCancelled = "cancelled"
+	dialogOutcomeInvalid   = "invalid"
+	dialogOutcomeError     = "error"
+)
+
+// metrics holds the Prometheus collectors of the plugin. The methods are no-ops on a nil
+// *metrics, so that code paths running before activation, or in tests, don't need to check.
+type metrics struct {
+	registry *prometheus.Registry
+
+	hookInvocations *prometheus.CounterVec
+	hookDuration    *prometheus.HistogramVec
+	consumedPosts   prometheus.Histogram
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	commandInvocations *prometheus.CounterVec
+	commandDuration    *prometheus.HistogramVec
+
+	dialogSubmissions *prometheus.CounterVec
+
+	fileDownloadRejections *prometheus.CounterVec
+
+	jobRuns     *prometheus.CounterVec
+	jobDuration *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+
+		hookInvocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "hook_invocations_total",
+			Help:      "The number of hook invocations, by hook and outcome.",
+		}, []string{"hook", "outcome"}),
+		hookDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "hook_duration_seconds",
+			Help:      "The time spent handling hook invocations, by hook.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"hook"}),
+		consumedPosts: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "consumed_posts",
+			Help:      "The number of posts per MessagesWillBeConsumed invocation.",
+			Buckets:   prometheus.ExponentialBuckets(1, 4, 7),
+		}),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "The number of HTTP requests, by route, method and status code.",
+		}, []string{"route", "method", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "The time spent handling HTTP requests, by route and method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method"}),
+
+		commandInvocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_invocations_total",
+			Help:      "The number of slash command invocations, by trigger.",
+		}, []string{"trigger"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_duration_seconds",
+			Help:      "The time spent executing slash commands, by trigger.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"trigger"}),
+
+		dialogSubmissions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dialog_submissions_total",
+			Help:      "The number of interactive dialog submissions, by dialog route and outcome.",
+		}, []string{"dialog", "outcome"}),
+
+		fileDownloadRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "file_download_rejections_total",
+			Help:      "The number of rejected file downloads, by download type.",
+		}, []string{"download_type"}),
+
+		jobRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "job_runs_total",
+			Help:      "The number of background job runs, by job.",
+		}, []string{"job"}),
+		jobDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "job_duration_seconds",
+			Help:      "The time spent running background jobs, by job.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60},
+		}, []string{"job"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.hookInvocations,
+		m.hookDuration,
+		m.consumedPosts,
+		m.httpRequests,
+		m.httpDuration,
+		m.commandInvocations,
+		m.commandDuration,
+		m.dialogSubmissions,
+		m.fileDownloadRejections,
+		m.jobRuns,
+		m.jobDuration,
+	)
+
+	return m
+}
+
+func (m *metrics) observeHook(hook, outcome string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.hookInvocations.WithLabelValues(hook, outcome).Inc()
+	m.hookDuration.WithLabelValues(hook).Observe(elapsed.Seconds())
+}
+
+func (m *metrics) observeCommand(trigger string, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.commandInvocations.WithLabelValues(trigger).Inc()
+	m.commandDuration.WithLabelValues(trigger).Observe(elapsed.Seconds())
+}
+
+// observeConsumedPosts records a MessagesWillBeConsumed invocation, which isn't logged as a hook
+// event since it runs for every post delivered to clients.
+func (m *metrics) observeConsumedPosts(count int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.observeHook(hookMessagesWillBeConsumed, eventOutcomeOK, elapsed)
+	m.consumedPosts.Observe(float64(count))
+}
+
+func (m *metrics) observeFileDownloadRejection(downloadType model.FileDownloadType) {
+	if m == nil {
+		return
+	}
+
+	m.fileDownloadRejections.WithLabelValues(string(downloadType)).Inc()
+}
+
+// instrumentJob wraps the callback of a cluster job to count its runs and measure their
+// duration.
+func (m *metrics) instrumentJob(job string, callback func()) func() {
+	if m == nil {
+		return callback
+	}
+
+	return func() {
+		start := time.Now()
+		callback()
+
+		m.jobRuns.WithLabelValues(job).Inc()
+		m.jobDuration.WithLabelValues(job).Observe(time.Since(start).Seconds())
+	}
+}
+
+// statusRecorder captures the status code, and optionally the body, written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	body   *bytes.Buffer
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.body != nil {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// routeTemplate returns the path template of the route matched by the router, which keeps the
+// cardinality of the route label bounded.
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+
+	return "unknown"
+}
+
+// withMetrics is a middleware counting the requests handled by the router and measuring their
+// duration.
+func (p *Plugin) withMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.metrics == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := routeTemplate(r)
+		p.metrics.httpRequests.WithLabelValues(route, r.Method, strconv.Itoa(recorder.status)).Inc()
+		p.metrics.httpDuration.WithLabelValues(route, r.Method).Observe(time.Since(start).Seconds())
+	})
+}
+
+// withDialogMetrics is a middleware counting interactive dialog submissions by outcome: whether
+// the dialog was cancelled, submitted, rejected with validation errors or failed.
+func (p *Plugin) withDialogMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.metrics == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK, body: &bytes.Buffer{}}
+		next.ServeHTTP(recorder, r)
+
+		var request model.SubmitDialogRequest
+		var response model.SubmitDialogResponse
+		outcome := dialogOutcomeSubmitted
+		switch {
+		case recorder.status >= http.StatusBadRequest || json.Unmarshal(body, &request) != nil:
+			outcome = dialogOutcomeError
+		case request.Cancelled:
+			outcome = dialogOutcomeCancelled
+		case json.Unmarshal(recorder.body.Bytes(), &response) == nil && (response.Error != "" || len(response.Errors) > 0):
+			outcome = dialogOutcomeInvalid
+		}
+
+		p.metrics.dialogSubmissions.WithLabelValues(routeTemplate(r), outcome).Inc()
+	})
+}
+
+// ServeMetrics allows plugins to expose their own metrics endpoint through the server's metrics
+// HTTP listener. Requests destined to the /plugins/{id}/metrics path will be routed to the plugin.
+//
+// This demo implementation exposes Prometheus metrics about hook invocations, HTTP requests,
+// slash commands, dialog submissions, file download rejections and background jobs.
+func (p *Plugin) ServeMetrics(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	if p.metrics == nil {
+		http.Error(w, "Metrics are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	promhttp.HandlerFor(p.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
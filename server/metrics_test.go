@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestMetrics(t *testing.T) {
+	plugin := &Plugin{metrics: newMetrics()}
+	plugin.initializeAPI()
+
+	serve := func(method, url, body string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, strings.NewReader(body))
+		plugin.ServeHTTP(nil, w, r)
+	}
+
+	serve(http.MethodGet, "/hello", "")
+	serve(http.MethodGet, "/hello", "")
+	serve(http.MethodGet, "/not_found", "")
+	serve(http.MethodPost, "/dialog/error", `{"callback_id":"callback"}`)
+	serve(http.MethodPost, "/dialog/error", `{"cancelled":true}`)
+
+	plugin.metrics.observeHook(hookMessageHasBeenPosted, eventOutcomeOK, time.Millisecond)
+	plugin.metrics.observeFileDownloadRejection(model.FileDownloadTypeThumbnail)
+	plugin.metrics.instrumentJob("TestJob", func() {})()
+
+	m := plugin.metrics
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.httpRequests.WithLabelValues("/hello", http.MethodGet, "200")))
+	assert.Equal(t, 2, testutil.CollectAndCount(m.httpRequests), "unmatched routes aren't counted")
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.dialogSubmissions.WithLabelValues("/dialog/error", dialogOutcomeInvalid)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.dialogSubmissions.WithLabelValues("/dialog/error", dialogOutcomeCancelled)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.hookInvocations.WithLabelValues(hookMessageHasBeenPosted, eventOutcomeOK)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.fileDownloadRejections.WithLabelValues(string(model.FileDownloadTypeThumbnail))))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.jobRuns.WithLabelValues("TestJob")))
+
+	w := httptest.NewRecorder()
+	plugin.ServeMetrics(nil, w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body, err := io.ReadAll(w.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `demo_plugin_http_requests_total{method="GET",route="/hello",status="200"} 2`)
+	assert.Contains(t, string(body), `demo_plugin_hook_duration_seconds_count{hook="MessageHasBeenPosted"} 1`)
+
+	t.Run("no-op before activation", func(t *testing.T) {
+		var m *metrics
+		assert.NotPanics(t, func() {
+			m.observeHook(hookMessageHasBeenPosted, eventOutcomeOK, time.Millisecond)
+			m.observeCommand("demo_plugin", time.Millisecond)
+			m.instrumentJob("TestJob", func() {})()
+		})
+	})
+}
@@ -0,0 +1,685 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// moderationRulesKey is the KV key of the ordered list of moderation rules.
+	moderationRulesKey = "moderation_rules"
+
+	// moderationRulesClusterEventID identifies the cluster events notifying the other plugin
+	// instances that the moderation rules changed.
+	moderationRulesClusterEventID = "moderation_rules_changed"
+
+	defaultRejectMessage = "Your message was rejected by a moderation rule."
+
+	// moderationFlagsPropKey is the post property holding the comma separated ids of the rules
+	// flagging the post, reported once the post is saved.
+	moderationFlagsPropKey = "moderation_flagged_by"
+)
+
+const (
+	moderationActionReject  = "reject"
+	moderationActionRewrite = "rewrite"
+	moderationActionRedact  = "redact"
+	moderationActionFlag    = "flag"
+	moderationActionAllow   = "allow"
+)
+
+var moderationActions = []string{
+	moderationActionReject,
+	moderationActionRewrite,
+	moderationActionRedact,
+	moderationActionFlag,
+	moderationActionAllow,
+}
+
+// moderationRule is evaluated against the posts created or updated by users. All the set
+// conditions must match for the action to apply.
+//
+// Rules are evaluated in order. The reject and allow actions stop the evaluation, while the
+// rewrite, redact and flag actions apply and carry on with the next rule.
+type moderationRule struct {
+	ID string `json:"id"`
+
+	// Pattern is a regular expression matched against the message.
+	Pattern string `json:"pattern,omitempty"`
+
+	ChannelID string `json:"channel_id,omitempty"`
+	TeamID    string `json:"team_id,omitempty"`
+
+	// Role matches users having the given role, e.g. system_user or system_guest.
+	Role string `json:"role,omitempty"`
+
+	// HasAttachments, if set, matches posts with or without files attached.
+	HasAttachments *bool `json:"has_attachments,omitempty"`
+
+	Action string `json:"action"`
+
+	// Message is the explanation sent to the user when rejecting a post.
+	Message string `json:"message,omitempty"`
+
+	// Replacement replaces the matches of the pattern when rewriting a post. It can refer to
+	// capture groups, e.g. ${1}.
+	Replacement string `json:"replacement,omitempty"`
+
+	// ReviewChannelID is the channel flagged posts are reported to.
+	ReviewChannelID string `json:"review_channel_id,omitempty"`
+
+	// DryRun only logs what the rule would do.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// validate checks the rule is complete and compiles its pattern.
+func (r *moderationRule) validate() error {
+	if !slices.Contains(moderationActions, r.Action) {
+		return errors.Errorf("unknown action %q, expected one of %s", r.Action, strings.Join(moderationActions, ", "))
+	}
+
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return errors.Wrap(err, "invalid pattern")
+		}
+		r.re = re
+	}
+
+	switch r.Action {
+	case moderationActionRewrite, moderationActionRedact:
+		if r.re == nil {
+			return errors.Errorf("the %s action requires a pattern", r.Action)
+		}
+	case moderationActionFlag:
+		if r.ReviewChannelID == "" {
+			return errors.New("the flag action requires a review channel")
+		}
+	}
+
+	return nil
+}
+
+// moderationTarget is the post being moderated. The team and roles are only looked up when a
+// rule needs them.
+type moderationTarget struct {
+	post *model.Post
+
+	getTeamID func() (string, error)
+	getRoles  func() (string, error)
+}
+
+// matches reports whether every condition of the rule matches the target.
+func (r *moderationRule) matches(target *moderationTarget) (bool, error) {
+	post := target.post
+
+	if r.ChannelID != "" && r.ChannelID != post.ChannelId {
+		return false, nil
+	}
+
+	if r.HasAttachments != nil && *r.HasAttachments != (len(post.FileIds) > 0) {
+		return false, nil
+	}
+
+	if r.re != nil && !r.re.MatchString(post.Message) {
+		return false, nil
+	}
+
+	if r.TeamID != "" {
+		teamID, err := target.getTeamID()
+		if err != nil {
+			return false, err
+		}
+		if teamID != r.TeamID {
+			return false, nil
+		}
+	}
+
+	if r.Role != "" {
+		roles, err := target.getRoles()
+		if err != nil {
+			return false, err
+		}
+		if !slices.Contains(strings.Fields(roles), r.Role) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// moderationOutcome is the result of evaluating the moderation rules against a post.
+type moderationOutcome struct {
+	// post is the post to save, possibly rewritten or redacted.
+	post *model.Post
+
+	// rejectedBy is the rule rejecting the post, if any.
+	rejectedBy *moderationRule
+
+	// flaggedBy are the rules flagging the post to a review channel.
+	flaggedBy []*moderationRule
+
+	// applied are the ids of the rules that changed the outcome, in order.
+	applied []string
+
+	// dryRuns describe what the matching dry-run rules would have done.
+	dryRuns []string
+}
+
+// evaluateModerationRules evaluates the rules in order against the target. The post of the
+// target is cloned before being modified.
+func evaluateModerationRules(rules []*moderationRule, target *moderationTarget) (*moderationOutcome, error) {
+	outcome := &moderationOutcome{post: target.post}
+
+	for _, rule := range rules {
+		matched, err := rule.matches(&moderationTarget{
+			post:      outcome.post,
+			getTeamID: target.getTeamID,
+			getRoles:  target.getRoles,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to evaluate rule %s", rule.ID)
+		}
+		if !matched {
+			continue
+		}
+
+		if rule.DryRun {
+			outcome.dryRuns = append(outcome.dryRuns, fmt.Sprintf("rule %s would %s the post", rule.ID, rule.Action))
+			continue
+		}
+
+		outcome.applied = append(outcome.applied, rule.ID)
+
+		switch rule.Action {
+		case moderationActionReject:
+			outcome.rejectedBy = rule
+			return outcome, nil
+		case moderationActionAllow:
+			return outcome, nil
+		case moderationActionRewrite:
+			outcome.post = outcome.post.Clone()
+			outcome.post.Message = rule.re.ReplaceAllString(outcome.post.Message, rule.Replacement)
+		case moderationActionRedact:
+			outcome.post = outcome.post.Clone()
+			outcome.post.Message = rule.re.ReplaceAllStringFunc(outcome.post.Message, func(match string) string {
+				return strings.Repeat("*", utf8.RuneCountInString(match))
+			})
+		case moderationActionFlag:
+			outcome.flaggedBy = append(outcome.flaggedBy, rule)
+		}
+	}
+
+	return outcome, nil
+}
+
+// getModerationRules returns the moderation rules, loading them from the KV store the first
+// time.
+func (p *Plugin) getModerationRules() ([]*moderationRule, error) {
+	p.moderationRulesLock.RLock()
+	rules, loaded := p.moderationRules, p.moderationRulesLoaded
+	p.moderationRulesLock.RUnlock()
+
+	if loaded {
+		return rules, nil
+	}
+
+	return p.loadModerationRules()
+}
+
+// loadModerationRules reloads the moderation rules from the KV store.
+func (p *Plugin) loadModerationRules() ([]*moderationRule, error) {
+	var data []byte
+	if err := p.client.KV.Get(moderationRulesKey, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get moderation rules")
+	}
+
+	rules, err := unmarshalModerationRules(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p.moderationRulesLock.Lock()
+	p.moderationRules = rules
+	p.moderationRulesLoaded = true
+	p.moderationRulesLock.Unlock()
+
+	return rules, nil
+}
+
+func unmarshalModerationRules(data []byte) ([]*moderationRule, error) {
+	var rules []*moderationRule
+	if len(data) == 0 {
+		return rules, nil
+	}
+
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal moderation rules")
+	}
+	for _, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid moderation rule %s", rule.ID)
+		}
+	}
+
+	return rules, nil
+}
+
+// updateModerationRules atomically applies the given change to the stored moderation rules, then
+// reloads them locally and notifies the other plugin instances.
+func (p *Plugin) updateModerationRules(change func(rules []*moderationRule) ([]*moderationRule, error)) error {
+	err := p.updateKV(moderationRulesKey, 0, func(oldValue []byte) (any, error) {
+		rules, err := unmarshalModerationRules(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		return change(rules)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save moderation rules")
+	}
+
+	if _, err := p.loadModerationRules(); err != nil {
+		return err
+	}
+
+	if err := p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id: moderationRulesClusterEventID,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	}); err != nil {
+		p.API.LogWarn("Failed to broadcast moderation rules change", "err", err.Error())
+	}
+
+	return nil
+}
+
+// moderatePost evaluates the moderation rules against the post, marking flagged posts and logging
+// dry runs. It returns the post to save, or nil and the reason if the post is rejected.
+//
+// Flagged posts are only reported by reportFlaggedPost once saved, as the post may still be
+// redacted, encrypted or rejected afterwards.
+func (p *Plugin) moderatePost(post *model.Post, event *hookEvent) (*model.Post, string) {
+	rules, err := p.getModerationRules()
+	if err != nil {
+		p.API.LogError("Failed to get moderation rules", "err", err.Error())
+		return post, ""
+	}
+	if len(rules) == 0 {
+		return post, ""
+	}
+
+	outcome, err := evaluateModerationRules(rules, &moderationTarget{
+		post: post,
+		getTeamID: func() (string, error) {
+			channel, appErr := p.API.GetChannel(post.ChannelId)
+			if appErr != nil {
+				return "", appErr
+			}
+			return channel.TeamId, nil
+		},
+		getRoles: func() (string, error) {
+			user, appErr := p.API.GetUser(post.UserId)
+			if appErr != nil {
+				return "", appErr
+			}
+			return user.Roles, nil
+		},
+	})
+	if err != nil {
+		p.API.LogError("Failed to evaluate moderation rules", "err", err.Error())
+		return post, ""
+	}
+
+	for _, dryRun := range outcome.dryRuns {
+		p.API.LogInfo("Moderation dry run", "detail", dryRun, "channel_id", post.ChannelId, "user_id", post.UserId)
+	}
+
+	if len(outcome.applied) > 0 {
+		event.Detail = fmt.Sprintf("moderation rules applied: %s", strings.Join(outcome.applied, ", "))
+	}
+
+	if rule := outcome.rejectedBy; rule != nil {
+		message := rule.Message
+		if message == "" {
+			message = defaultRejectMessage
+		}
+
+		p.API.SendEphemeralPost(post.UserId, &model.Post{
+			UserId:    p.getConfiguration().demoUserID,
+			ChannelId: post.ChannelId,
+			Message:   message,
+		})
+
+		event.reject(fmt.Sprintf("rejected by moderation rule %s", rule.ID))
+		return nil, message
+	}
+
+	// The property is always reset, so that an edit doesn't report the flags of the previous
+	// version of the post.
+	if len(outcome.flaggedBy) > 0 || outcome.post.GetProp(moderationFlagsPropKey) != nil {
+		outcome.post = outcome.post.Clone()
+		outcome.post.DelProp(moderationFlagsPropKey)
+
+		var ids []string
+		for _, rule := range outcome.flaggedBy {
+			ids = append(ids, rule.ID)
+		}
+		if len(ids) > 0 {
+			outcome.post.AddProp(moderationFlagsPropKey, strings.Join(ids, ","))
+		}
+	}
+
+	return outcome.post, ""
+}
+
+// reportFlaggedPost reports the saved post to the review channels of the rules that flagged it,
+// if any. Rules removed in the meantime are ignored.
+func (p *Plugin) reportFlaggedPost(post *model.Post) {
+	flags, _ := post.GetProp(moderationFlagsPropKey).(string)
+	if flags == "" {
+		return
+	}
+
+	rules, err := p.getModerationRules()
+	if err != nil {
+		p.API.LogError("Failed to get moderation rules", "err", err.Error())
+		return
+	}
+
+	for _, id := range strings.Split(flags, ",") {
+		i := slices.IndexFunc(rules, func(rule *moderationRule) bool { return rule.ID == id })
+		if i >= 0 {
+			p.flagPost(rules[i], post)
+		}
+	}
+}
+
+// flagPost reports the post to the review channel of the rule. The content of encrypted posts
+// isn't disclosed.
+func (p *Plugin) flagPost(rule *moderationRule, post *model.Post) {
+	author := post.UserId
+	if user, appErr := p.API.GetUser(post.UserId); appErr == nil {
+		author = "@" + user.Username
+	}
+
+	channelName := post.ChannelId
+	if channel, appErr := p.API.GetChannel(post.ChannelId); appErr == nil {
+		channelName = "~" + channel.Name
+	}
+
+	content := excerpt(post.Message, postExcerptLength)
+	if encryptedMessageRegexp.MatchString(post.Message) {
+		content = "(encrypted message)"
+	}
+
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: rule.ReviewChannelID,
+		Message: fmt.Sprintf("Moderation rule `%s` flagged a post by %s in %s:\n> %s",
+			rule.ID, author, channelName, content),
+	}); appErr != nil {
+		p.API.LogError("Failed to flag post", "rule_id", rule.ID, "channel_id", rule.ReviewChannelID, "err", appErr.Error())
+	}
+}
+
+// parseKeyValueArgs parses space separated key=value arguments, where values containing spaces
+// are double quoted, e.g. pattern="bad word" dry_run=true. Within quotes, \" stands for a double
+// quote and other backslashes are kept as is, so that regular expressions don't need escaping.
+func parseKeyValueArgs(s string) (map[string]string, error) {
+	args := make(map[string]string)
+
+	s = strings.TrimSpace(s)
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, errors.Errorf("expected key=value, got %q", strings.Fields(s)[0])
+		}
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' && end+1 < len(rest) && rest[end+1] == '"' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return nil, errors.Errorf("unterminated quoted value for %s", key)
+			}
+			value = strings.ReplaceAll(rest[1:end], `\"`, `"`)
+			rest = rest[end+1:]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+		}
+
+		args[key] = value
+		s = strings.TrimSpace(rest)
+	}
+
+	return args, nil
+}
+
+// newModerationRule builds a rule from the arguments of /demo_plugin rules add.
+func newModerationRule(action string, args map[string]string) (*moderationRule, error) {
+	rule := &moderationRule{
+		ID:     model.NewId()[:8],
+		Action: action,
+	}
+
+	for key, value := range args {
+		switch key {
+		case "pattern":
+			rule.Pattern = value
+		case "channel":
+			rule.ChannelID = value
+		case "team":
+			rule.TeamID = value
+		case "role":
+			rule.Role = value
+		case "attachments":
+			hasAttachments, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.Errorf("invalid attachments value %q", value)
+			}
+			rule.HasAttachments = &hasAttachments
+		case "message":
+			rule.Message = value
+		case "replacement":
+			rule.Replacement = value
+		case "review_channel":
+			rule.ReviewChannelID = value
+		case "dry_run":
+			dryRun, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, errors.Errorf("invalid dry_run value %q", value)
+			}
+			rule.DryRun = dryRun
+		default:
+			return nil, errors.Errorf("unknown argument %q", key)
+		}
+	}
+
+	if err := rule.validate(); err != nil {
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// describe summarizes the conditions and action of the rule in Markdown.
+func (r *moderationRule) describe() string {
+	var conditions []string
+	if r.Pattern != "" {
+		conditions = append(conditions, fmt.Sprintf("pattern `%s`", r.Pattern))
+	}
+	if r.ChannelID != "" {
+		conditions = append(conditions, fmt.Sprintf("channel `%s`", r.ChannelID))
+	}
+	if r.TeamID != "" {
+		conditions = append(conditions, fmt.Sprintf("team `%s`", r.TeamID))
+	}
+	if r.Role != "" {
+		conditions = append(conditions, fmt.Sprintf("role `%s`", r.Role))
+	}
+	if r.HasAttachments != nil {
+		conditions = append(conditions, fmt.Sprintf("attachments `%t`", *r.HasAttachments))
+	}
+	if len(conditions) == 0 {
+		conditions = append(conditions, "every post")
+	}
+
+	action := r.Action
+	switch r.Action {
+	case moderationActionReject:
+		if r.Message != "" {
+			action = fmt.Sprintf("reject with %q", r.Message)
+		}
+	case moderationActionRewrite:
+		action = fmt.Sprintf("rewrite to %q", r.Replacement)
+	case moderationActionFlag:
+		action = fmt.Sprintf("flag to `%s`", r.ReviewChannelID)
+	}
+
+	return fmt.Sprintf("%s → %s", strings.Join(conditions, ", "), action)
+}
+
+func (p *Plugin) executeCommandRules(args *model.CommandArgs, params []string) *model.CommandResponse {
+	if !p.isSystemAdmin(args.UserId) {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         "Only system admins can manage the moderation rules.",
+		}
+	}
+
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if len(params) == 0 || params[0] == "list" {
+		rules, err := p.getModerationRules()
+		if err != nil {
+			p.API.LogError("Failed to get moderation rules", "err", err.Error())
+			return respond("Failed to get the moderation rules.")
+		}
+		return respond(moderationRulesSummary(rules))
+	}
+
+	switch action := params[0]; {
+	case action == "add" && len(params) >= 2:
+		// Values may contain spaces, so parse the raw command rather than the fields.
+		_, rawArgs, _ := strings.Cut(args.Command, " add ")
+		_, rawArgs, _ = strings.Cut(rawArgs, params[1])
+		return respond(p.addModerationRule(params[1], rawArgs))
+	case action == "remove" && len(params) == 2:
+		return respond(p.removeModerationRule(params[1]))
+	case action == "move" && len(params) == 3:
+		return respond(p.moveModerationRule(params[1], params[2]))
+	case action == "dryrun" && len(params) == 3 && (params[2] == "on" || params[2] == "off"):
+		return respond(p.setModerationRuleDryRun(params[1], params[2] == "on"))
+	default:
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+}
+
+func (p *Plugin) addModerationRule(action, rawArgs string) string {
+	args, err := parseKeyValueArgs(rawArgs)
+	if err != nil {
+		return fmt.Sprintf("Invalid rule: %s", err.Error())
+	}
+
+	rule, err := newModerationRule(action, args)
+	if err != nil {
+		return fmt.Sprintf("Invalid rule: %s", err.Error())
+	}
+
+	if err := p.updateModerationRules(func(rules []*moderationRule) ([]*moderationRule, error) {
+		return append(rules, rule), nil
+	}); err != nil {
+		p.API.LogError("Failed to add moderation rule", "err", err.Error())
+		return "Failed to add the moderation rule."
+	}
+
+	return fmt.Sprintf("Added moderation rule `%s`: %s", rule.ID, rule.describe())
+}
+
+func (p *Plugin) removeModerationRule(id string) string {
+	return p.editModerationRule(id, "Removed", func(rules []*moderationRule, i int) []*moderationRule {
+		return slices.Delete(rules, i, i+1)
+	})
+}
+
+func (p *Plugin) moveModerationRule(id, position string) string {
+	to, err := strconv.Atoi(position)
+	if err != nil || to < 1 {
+		return fmt.Sprintf("Invalid position %q, expected a number starting at 1.", position)
+	}
+
+	return p.editModerationRule(id, "Moved", func(rules []*moderationRule, i int) []*moderationRule {
+		rule := rules[i]
+		rules = slices.Delete(rules, i, i+1)
+		return slices.Insert(rules, min(to-1, len(rules)), rule)
+	})
+}
+
+func (p *Plugin) setModerationRuleDryRun(id string, dryRun bool) string {
+	return p.editModerationRule(id, "Updated", func(rules []*moderationRule, i int) []*moderationRule {
+		rules[i].DryRun = dryRun
+		return rules
+	})
+}
+
+// editModerationRule applies the given edit to the rule with the given id, describing the
+// result for the user.
+func (p *Plugin) editModerationRule(id, verb string, edit func(rules []*moderationRule, i int) []*moderationRule) string {
+	errNotFound := errors.Errorf("unknown moderation rule %s", id)
+
+	err := p.updateModerationRules(func(rules []*moderationRule) ([]*moderationRule, error) {
+		i := slices.IndexFunc(rules, func(rule *moderationRule) bool { return rule.ID == id })
+		if i < 0 {
+			return nil, errNotFound
+		}
+
+		return edit(rules, i), nil
+	})
+	if errors.Is(err, errNotFound) {
+		return fmt.Sprintf("Unknown moderation rule `%s`.", id)
+	} else if err != nil {
+		p.API.LogError("Failed to update moderation rule", "rule_id", id, "err", err.Error())
+		return "Failed to update the moderation rule."
+	}
+
+	return fmt.Sprintf("%s moderation rule `%s`.", verb, id)
+}
+
+// moderationRulesSummary lists the rules in evaluation order in Markdown.
+func moderationRulesSummary(rules []*moderationRule) string {
+	if len(rules) == 0 {
+		return "No moderation rules. Add one with `/demo_plugin rules add <action> [key=value ...]`."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| # | ID | Rule | Dry run |\n")
+	sb.WriteString("|---|----|------|---------|\n")
+	for i, rule := range rules {
+		fmt.Fprintf(&sb, "| %d | `%s` | %s | %t |\n", i+1, rule.ID, rule.describe(), rule.DryRun)
+	}
+
+	return sb.String()
+}
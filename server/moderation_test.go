@@ -0,0 +1,200 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseKeyValueArgs(t *testing.T) {
+	args, err := parseKeyValueArgs(` pattern="bad \"word\"" dry_run=true  message="No bad words, please." `)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"pattern": `bad "word"`,
+		"dry_run": "true",
+		"message": "No bad words, please.",
+	}, args)
+
+	args, err = parseKeyValueArgs(`pattern="\b\d{4}\b" replacement=x`)
+	require.NoError(t, err)
+	assert.Equal(t, `\b\d{4}\b`, args["pattern"])
+	assert.Equal(t, "x", args["replacement"])
+
+	args, err = parseKeyValueArgs("")
+	require.NoError(t, err)
+	assert.Empty(t, args)
+
+	_, err = parseKeyValueArgs(`pattern="unterminated`)
+	assert.Error(t, err)
+
+	_, err = parseKeyValueArgs(`pattern`)
+	assert.Error(t, err)
+}
+
+func TestNewModerationRule(t *testing.T) {
+	rule, err := newModerationRule(moderationActionRewrite, map[string]string{
+		"pattern":     `(?i)colou?r`,
+		"replacement": "color",
+		"attachments": "false",
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, rule.ID)
+	require.NotNil(t, rule.HasAttachments)
+	assert.False(t, *rule.HasAttachments)
+
+	for name, test := range map[string]struct {
+		action string
+		args   map[string]string
+	}{
+		"unknown action":           {action: "delete"},
+		"unknown argument":         {action: moderationActionReject, args: map[string]string{"color": "red"}},
+		"invalid pattern":          {action: moderationActionReject, args: map[string]string{"pattern": "("}},
+		"rewrite without pattern":  {action: moderationActionRewrite},
+		"flag without channel":     {action: moderationActionFlag, args: map[string]string{"pattern": "x"}},
+		"invalid attachments flag": {action: moderationActionAllow, args: map[string]string{"attachments": "maybe"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newModerationRule(test.action, test.args)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEvaluateModerationRules(t *testing.T) {
+	newRule := func(action string, args map[string]string) *moderationRule {
+		rule, err := newModerationRule(action, args)
+		require.NoError(t, err)
+		return rule
+	}
+
+	lookups := 0
+	newTarget := func(message string) *moderationTarget {
+		return &moderationTarget{
+			post: &model.Post{ChannelId: "channel1", UserId: "user1", Message: message},
+			getTeamID: func() (string, error) {
+				lookups++
+				return "team1", nil
+			},
+			getRoles: func() (string, error) {
+				lookups++
+				return "system_user system_guest", nil
+			},
+		}
+	}
+
+	redact := newRule(moderationActionRedact, map[string]string{"pattern": `\d{4}-\d{4}`})
+	flag := newRule(moderationActionFlag, map[string]string{"pattern": "(?i)urgent", "review_channel": "review"})
+	allowAdmins := newRule(moderationActionAllow, map[string]string{"role": "system_admin"})
+	rejectGuests := newRule(moderationActionReject, map[string]string{"pattern": "(?i)spam", "role": "system_guest", "team": "team1"})
+	rewrite := newRule(moderationActionRewrite, map[string]string{"pattern": "spam", "replacement": "ham"})
+	rules := []*moderationRule{redact, flag, allowAdmins, rejectGuests, rewrite}
+
+	t.Run("no match", func(t *testing.T) {
+		lookups = 0
+		target := newTarget("hello")
+		outcome, err := evaluateModerationRules(rules, target)
+		require.NoError(t, err)
+		assert.Same(t, target.post, outcome.post)
+		assert.Nil(t, outcome.rejectedBy)
+		assert.Empty(t, outcome.applied)
+		assert.Equal(t, 1, lookups, "only the role of the user is looked up")
+	})
+
+	t.Run("redact and flag, then reject", func(t *testing.T) {
+		target := newTarget("URGENT spam, call 1234-5678")
+		outcome, err := evaluateModerationRules(rules, target)
+		require.NoError(t, err)
+		assert.Equal(t, rejectGuests, outcome.rejectedBy)
+		assert.Equal(t, []*moderationRule{flag}, outcome.flaggedBy)
+		assert.Equal(t, []string{redact.ID, flag.ID, rejectGuests.ID}, outcome.applied)
+		assert.Equal(t, "URGENT spam, call *********", outcome.post.Message)
+		assert.Equal(t, "URGENT spam, call 1234-5678", target.post.Message, "the original post isn't modified")
+	})
+
+	t.Run("dry runs are only reported", func(t *testing.T) {
+		dryRunReject := *rejectGuests
+		dryRunReject.DryRun = true
+
+		outcome, err := evaluateModerationRules([]*moderationRule{&dryRunReject, rewrite}, newTarget("spam"))
+		require.NoError(t, err)
+		assert.Nil(t, outcome.rejectedBy)
+		assert.Equal(t, []string{rewrite.ID}, outcome.applied)
+		assert.Len(t, outcome.dryRuns, 1)
+		assert.Equal(t, "ham", outcome.post.Message)
+	})
+
+	t.Run("attachments", func(t *testing.T) {
+		rejectFiles := newRule(moderationActionReject, map[string]string{"attachments": "true"})
+
+		target := newTarget("hello")
+		outcome, err := evaluateModerationRules([]*moderationRule{rejectFiles}, target)
+		require.NoError(t, err)
+		assert.Nil(t, outcome.rejectedBy)
+
+		target.post.FileIds = model.StringArray{"file1"}
+		outcome, err = evaluateModerationRules([]*moderationRule{rejectFiles}, target)
+		require.NoError(t, err)
+		assert.Equal(t, rejectFiles, outcome.rejectedBy)
+	})
+}
+
+func TestModerationFlags(t *testing.T) {
+	cluster := newFakeCluster()
+	node := cluster.addNode(t)
+	node.botID = "bot"
+
+	config := node.getConfiguration().Clone()
+	config.disabledHooks = map[string]bool{hookMessageHasBeenPosted: true, hookMessageHasBeenUpdated: true}
+	node.setConfiguration(config)
+
+	var posts []*model.Post
+	api := node.API.(*fakeNodeAPI).API
+	api.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Name: "general", TeamId: "team1", Type: model.ChannelTypeOpen}, nil)
+	api.On("GetUser", "user1").Return(&model.User{Id: "user1", Username: "jane"}, nil)
+	api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+		posts = append(posts, args.Get(0).(*model.Post))
+	}).Return(&model.Post{}, nil)
+	api.On("SendEphemeralPost", "user1", mock.Anything).Return(&model.Post{})
+
+	flag, err := newModerationRule(moderationActionFlag, map[string]string{"pattern": "(?i)urgent", "review_channel": "review"})
+	require.NoError(t, err)
+	reject, err := newModerationRule(moderationActionReject, map[string]string{"pattern": "spam"})
+	require.NoError(t, err)
+	require.NoError(t, node.updateModerationRules(func([]*moderationRule) ([]*moderationRule, error) {
+		return []*moderationRule{flag, reject}, nil
+	}))
+	_, err = node.updateRedactionPolicy("team1", map[string]string{"action": redactionActionMask})
+	require.NoError(t, err)
+
+	newPost := func(message string) *model.Post {
+		return &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: "user1", Message: message}
+	}
+
+	t.Run("reported once saved, with the redacted message", func(t *testing.T) {
+		post, _ := node.MessageWillBePosted(nil, newPost("URGENT: call +1 (555) 123-4567"))
+		require.NotNil(t, post)
+		assert.Empty(t, posts, "nothing is reported before the post is saved")
+
+		node.MessageHasBeenPosted(nil, post)
+		require.Len(t, posts, 1)
+		assert.Equal(t, "review", posts[0].ChannelId)
+		assert.Equal(t, "Moderation rule `"+flag.ID+"` flagged a post by @jane in ~general:\n> URGENT: call [REDACTED:phone]", posts[0].Message)
+
+		posts = nil
+		edited, _ := node.MessageWillBeUpdated(nil, &model.Post{Id: post.Id, ChannelId: "channel1", UserId: "user1", Message: "Never mind", Props: post.GetProps()}, post)
+		require.NotNil(t, edited)
+		node.MessageHasBeenUpdated(nil, edited, post)
+		assert.Empty(t, posts, "the flag of the previous version isn't reported again")
+	})
+
+	t.Run("rejected posts aren't reported", func(t *testing.T) {
+		posts = nil
+		post, _ := node.MessageWillBePosted(nil, newPost("URGENT spam"))
+		assert.Nil(t, post)
+		assert.Empty(t, posts)
+	})
+}
@@ -0,0 +1,714 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// offboardingRecentFiles is how far back the files uploaded by a deactivated user are
+	// reported.
+	offboardingRecentFiles = 30 * 24 * time.Hour
+
+	// maxOffboardingItems is the number of items listed in each section of an offboarding
+	// report, the others being counted.
+	maxOffboardingItems = 10
+
+	offboardingChannelMembersPerPage = 200
+
+	// The sections of an offboarding report whose items can be reassigned or cancelled. Reminders
+	// and scheduled posts are written by their creator, so they are only cancelled: neither is
+	// delivered on behalf of someone else.
+	offboardingSectionChannels       = "channels"
+	offboardingSectionReminders      = "reminders"
+	offboardingSectionScheduledPosts = "scheduled_posts"
+	offboardingSectionResources      = "resources"
+)
+
+// offboardingReport lists what a deactivated user leaves behind.
+type offboardingReport struct {
+	User *model.User
+
+	// SoleAdminChannels are the channels the user was the only active admin of.
+	SoleAdminChannels []*model.Channel
+
+	// Reminders and ScheduledPosts are the ones created by the user and still waiting to be
+	// delivered.
+	Reminders      []*reminder
+	ScheduledPosts []*scheduledPost
+
+	// Files are the files uploaded by the user recently, most recent first.
+	Files []*model.FileInfo
+
+	// Resources describe the resources of the plugin that reference the user, such as the
+	// reaction actions notifying them or the polls they created.
+	Resources []string
+}
+
+// parseOffboardingReportChannel parses the Offboarding Report Channel setting, the name of a team
+// and the name of a channel separated by a slash. Both are empty when the setting is.
+func parseOffboardingReportChannel(setting string) (teamName, channelName string, err error) {
+	setting = strings.TrimSpace(setting)
+	if setting == "" {
+		return "", "", nil
+	}
+
+	teamName, channelName, found := strings.Cut(setting, "/")
+	if !found || !model.IsValidTeamName(teamName) || !model.IsValidChannelIdentifier(channelName) {
+		return "", "", errors.Errorf("invalid channel %q, expected the name of a team and the name of a channel separated by a slash such as myteam/admins", setting)
+	}
+
+	return teamName, channelName, nil
+}
+
+// postOffboardingReport posts the offboarding report of the deactivated user to the
+// Offboarding Report Channel.
+func (p *Plugin) postOffboardingReport(user *model.User) error {
+	teamName, channelName, err := parseOffboardingReportChannel(p.getConfiguration().OffboardingReportChannel)
+	if err != nil {
+		return err
+	}
+
+	channel, appErr := p.API.GetChannelByNameForTeamName(teamName, channelName, false)
+	if appErr != nil {
+		return errors.Wrapf(appErr, "failed to get offboarding report channel %s/%s", teamName, channelName)
+	}
+
+	report, err := p.getOffboardingReport(user)
+	if err != nil {
+		return err
+	}
+
+	post := p.offboardingReportPost(report)
+	post.ChannelId = channel.Id
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to post offboarding report")
+	}
+
+	return nil
+}
+
+// getOffboardingReport gathers what the deactivated user leaves behind.
+func (p *Plugin) getOffboardingReport(user *model.User) (*offboardingReport, error) {
+	report := &offboardingReport{User: user}
+
+	var err error
+	if report.SoleAdminChannels, err = p.soleAdminChannels(user.Id); err != nil {
+		return nil, err
+	}
+	if report.Reminders, err = p.listReminders(user.Id); err != nil {
+		return nil, err
+	}
+	if report.ScheduledPosts, err = p.listScheduledPosts(user.Id); err != nil {
+		return nil, err
+	}
+
+	files, appErr := p.API.GetFileInfos(0, maxOffboardingItems+1, &model.GetFileInfosOptions{
+		UserIds:        []string{user.Id},
+		Since:          time.Now().Add(-offboardingRecentFiles).UnixMilli(),
+		SortBy:         model.FileinfoSortByCreated,
+		SortDescending: true,
+	})
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get files")
+	}
+	report.Files = files
+
+	if report.Resources, err = p.describeReferencingResources(user.Id); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// soleAdminChannels returns the channels of the teams of the user that the user is the only
+// active admin of. Direct and group messages have no admins, and are skipped.
+func (p *Plugin) soleAdminChannels(userID string) ([]*model.Channel, error) {
+	teams, appErr := p.API.GetTeamsForUser(userID)
+	if appErr != nil {
+		return nil, errors.Wrap(appErr, "failed to get teams")
+	}
+
+	var channels []*model.Channel
+	for _, team := range teams {
+		teamChannels, appErr := p.API.GetChannelsForTeamForUser(team.Id, userID, false)
+		if appErr != nil {
+			return nil, errors.Wrapf(appErr, "failed to get channels of team %s", team.Id)
+		}
+
+		for _, channel := range teamChannels {
+			if channel.IsGroupOrDirect() {
+				continue
+			}
+
+			soleAdmin, err := p.isSoleChannelAdmin(channel.Id, userID)
+			if err != nil {
+				return nil, err
+			}
+			if soleAdmin {
+				channels = append(channels, channel)
+			}
+		}
+	}
+
+	return channels, nil
+}
+
+// isSoleChannelAdmin reports whether the user is an admin of the channel, and the other admins
+// of the channel, if any, are deactivated.
+func (p *Plugin) isSoleChannelAdmin(channelID, userID string) (bool, error) {
+	admin := false
+	for page := 0; ; page++ {
+		members, appErr := p.API.GetChannelMembers(channelID, page, offboardingChannelMembersPerPage)
+		if appErr != nil {
+			return false, errors.Wrapf(appErr, "failed to get members of channel %s", channelID)
+		}
+
+		for _, member := range members {
+			if !member.SchemeAdmin {
+				continue
+			}
+			if member.UserId == userID {
+				admin = true
+				continue
+			}
+
+			user, appErr := p.API.GetUser(member.UserId)
+			if appErr != nil {
+				return false, errors.Wrapf(appErr, "failed to get user %s", member.UserId)
+			}
+			if user.DeleteAt == 0 {
+				return false, nil
+			}
+		}
+
+		if len(members) < offboardingChannelMembersPerPage {
+			return admin, nil
+		}
+	}
+}
+
+// offboardingChannel mentions the channel in an offboarding report, or returns its id if it
+// can't be found. Direct and group messages aren't named after their members.
+func (p *Plugin) offboardingChannel(channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		return channelID
+	}
+
+	switch channel.Type {
+	case model.ChannelTypeDirect:
+		return "a direct message"
+	case model.ChannelTypeGroup:
+		return "a group message"
+	default:
+		return "~" + channel.Name
+	}
+}
+
+// describeReferencingResources describes the resources of the plugin that reference the user,
+// other than the reminders and scheduled posts the user created: the reaction actions created by
+// or notifying the user, the channel policies adding the user to new channels, the reminders of
+// other users reminding the user, and the open polls created by the user. The messages of the
+// reminders and the questions of the polls aren't included, since they may be private.
+func (p *Plugin) describeReferencingResources(userID string) ([]string, error) {
+	var resources []string
+
+	actions, err := p.getReactionActions()
+	if err != nil {
+		return nil, err
+	}
+	for _, action := range actions {
+		if action.CreatedBy == userID || action.TargetUserID == userID {
+			resources = append(resources, fmt.Sprintf("Reaction action :%s: %s", action.Emoji, p.describeReactionAction(action)))
+		}
+	}
+
+	policies, err := p.listChannelPolicies()
+	if err != nil {
+		return nil, err
+	}
+	for teamID, policy := range policies {
+		if !slices.Contains(policy.MemberIDs, userID) {
+			continue
+		}
+		name := teamID
+		if team, appErr := p.API.GetTeam(teamID); appErr == nil {
+			name = team.DisplayName
+		}
+		resources = append(resources, fmt.Sprintf("Channel policy of **%s**, adding them to the public channels created", name))
+	}
+
+	reminders, err := p.listReminders("")
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reminders {
+		if r.UserID != userID || r.CreatorID == userID {
+			continue
+		}
+		creator := r.CreatorID
+		if user, appErr := p.API.GetUser(r.CreatorID); appErr == nil {
+			creator = "@" + user.Username
+		}
+		resources = append(resources, fmt.Sprintf("Reminder from %s on %s", creator, formatOffboardingTime(r.RemindAt)))
+	}
+
+	polls, err := p.listUserOpenPolls(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, pl := range polls {
+		resources = append(resources, fmt.Sprintf("[Poll](%s) in %s", p.permalink(pl.ID), p.offboardingChannel(pl.ChannelID)))
+	}
+
+	return resources, nil
+}
+
+func formatOffboardingTime(millis int64) string {
+	return time.UnixMilli(millis).UTC().Format("Mon Jan 2 15:04 MST")
+}
+
+// formatFileSize formats the size of a file in bytes for humans.
+func formatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value, exp := float64(size)/unit, 0
+	for value >= unit && exp < 3 {
+		value /= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", value, "KMGT"[exp])
+}
+
+// offboardingList renders the items as a Markdown list, counting those beyond
+// maxOffboardingItems.
+func offboardingList(items []string) string {
+	var sb strings.Builder
+	for i, item := range items {
+		if i == maxOffboardingItems {
+			fmt.Fprintf(&sb, "- and %d more\n", len(items)-maxOffboardingItems)
+			break
+		}
+		fmt.Fprintf(&sb, "- %s\n", item)
+	}
+
+	return sb.String()
+}
+
+// offboardingAction returns the action reassigning the items of the section to the user
+// selected, or cancelling them.
+func offboardingAction(userID, section, action string) *model.PostAction {
+	postAction := &model.PostAction{
+		Type: model.PostActionTypeButton,
+		Name: "Cancel all",
+		Integration: &model.PostActionIntegration{
+			URL:     fmt.Sprintf("/plugins/%s/interactive/offboarding/%s", manifest.Id, action),
+			Context: map[string]any{"user_id": userID, "section": section},
+		},
+	}
+	if action == "reassign" {
+		postAction.Type = model.PostActionTypeSelect
+		postAction.Name = "Reassign all to…"
+		postAction.DataSource = model.PostActionDataSourceUsers
+	}
+
+	return postAction
+}
+
+// offboardingReportPost renders the report in a post of the bot, each section being an
+// attachment with the actions reassigning or cancelling its items. The reminders, scheduled posts
+// and files are described by their times and channels, without their content, which the admins
+// reading the report may not be allowed to see.
+func (p *Plugin) offboardingReportPost(report *offboardingReport) *model.Post {
+	userID := report.User.Id
+	var attachments []*model.SlackAttachment
+
+	if len(report.SoleAdminChannels) > 0 {
+		items := make([]string, 0, len(report.SoleAdminChannels))
+		for _, channel := range report.SoleAdminChannels {
+			items = append(items, fmt.Sprintf("~%s", channel.Name))
+		}
+		attachments = append(attachments, &model.SlackAttachment{
+			Title:   fmt.Sprintf("Channels they were the only admin of (%d)", len(items)),
+			Text:    offboardingList(items) + "\nThe user picked is made an admin of each channel.",
+			Actions: []*model.PostAction{offboardingAction(userID, offboardingSectionChannels, "reassign")},
+		})
+	}
+
+	if len(report.Reminders) > 0 {
+		items := make([]string, 0, len(report.Reminders))
+		for _, r := range report.Reminders {
+			recipient := "to themselves"
+			switch {
+			case r.ChannelID != "":
+				recipient = "in " + p.offboardingChannel(r.ChannelID)
+			case r.UserID != userID:
+				recipient = "to " + r.UserID
+				if user, appErr := p.API.GetUser(r.UserID); appErr == nil {
+					recipient = "to @" + user.Username
+				}
+			}
+			items = append(items, fmt.Sprintf("%s, %s", formatOffboardingTime(r.RemindAt), recipient))
+		}
+		attachments = append(attachments, &model.SlackAttachment{
+			Title:   fmt.Sprintf("Open reminders (%d)", len(items)),
+			Text:    offboardingList(items),
+			Actions: []*model.PostAction{offboardingAction(userID, offboardingSectionReminders, "cancel")},
+		})
+	}
+
+	if len(report.ScheduledPosts) > 0 {
+		items := make([]string, 0, len(report.ScheduledPosts))
+		for _, scheduled := range report.ScheduledPosts {
+			target := "in " + p.offboardingChannel(scheduled.ChannelID)
+			if scheduled.RootID != "" {
+				target = "in a thread of " + p.offboardingChannel(scheduled.ChannelID)
+			}
+			items = append(items, fmt.Sprintf("%s, %s", formatOffboardingTime(scheduled.PostAt), target))
+		}
+		attachments = append(attachments, &model.SlackAttachment{
+			Title:   fmt.Sprintf("Scheduled posts (%d)", len(items)),
+			Text:    offboardingList(items),
+			Actions: []*model.PostAction{offboardingAction(userID, offboardingSectionScheduledPosts, "cancel")},
+		})
+	}
+
+	if len(report.Files) > 0 {
+		files := report.Files
+		title := fmt.Sprintf("Files uploaded in the last %d days (%d)", int(offboardingRecentFiles.Hours()/24), len(files))
+		if len(files) > maxOffboardingItems {
+			title = fmt.Sprintf("Files uploaded in the last %d days (more than %d)", int(offboardingRecentFiles.Hours()/24), maxOffboardingItems)
+			files = files[:maxOffboardingItems]
+		}
+
+		// The files are counted per channel, the channel of the most recent file first.
+		var channelIDs []string
+		counts := make(map[string]int)
+		sizes := make(map[string]int64)
+		for _, file := range files {
+			if counts[file.ChannelId] == 0 {
+				channelIDs = append(channelIDs, file.ChannelId)
+			}
+			counts[file.ChannelId]++
+			sizes[file.ChannelId] += file.Size
+		}
+		items := make([]string, 0, len(channelIDs))
+		for _, channelID := range channelIDs {
+			noun := "files"
+			if counts[channelID] == 1 {
+				noun = "file"
+			}
+			items = append(items, fmt.Sprintf("%d %s in %s, %s", counts[channelID], noun, p.offboardingChannel(channelID), formatFileSize(sizes[channelID])))
+		}
+		attachments = append(attachments, &model.SlackAttachment{
+			Title: title,
+			Text:  offboardingList(items),
+		})
+	}
+
+	if len(report.Resources) > 0 {
+		attachments = append(attachments, &model.SlackAttachment{
+			Title:   fmt.Sprintf("Plugin resources referencing them (%d)", len(report.Resources)),
+			Text:    offboardingList(report.Resources),
+			Actions: []*model.PostAction{offboardingAction(userID, offboardingSectionResources, "reassign")},
+		})
+	}
+
+	message := fmt.Sprintf("#### Offboarding of @%s\n@%s has been deactivated. ID: `%s`", report.User.Username, report.User.Username, userID)
+	if len(attachments) == 0 {
+		message += "\n\nThey leave nothing behind that needs a new owner."
+	}
+
+	post := &model.Post{
+		UserId:  p.botID,
+		Message: message,
+	}
+	model.ParseSlackAttachment(post, attachments)
+
+	return post
+}
+
+// reassignSoleAdminChannels makes the user an admin of the channels the deactivated user was the
+// only active admin of, adding them to the channels if needed.
+func (p *Plugin) reassignSoleAdminChannels(fromID, toID string) (int, error) {
+	channels, err := p.soleAdminChannels(fromID)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, channel := range channels {
+		if _, appErr := p.API.AddChannelMember(channel.Id, toID); appErr != nil {
+			return i, errors.Wrapf(appErr, "failed to add user to ~%s", channel.Name)
+		}
+		if _, appErr := p.API.UpdateChannelMemberRoles(channel.Id, toID, model.ChannelUserRoleId+" "+model.ChannelAdminRoleId); appErr != nil {
+			return i, errors.Wrapf(appErr, "failed to make user an admin of ~%s", channel.Name)
+		}
+	}
+
+	return len(channels), nil
+}
+
+// reassignResources replaces the deactivated user with the user in the resources of the plugin
+// listed by describeReferencingResources.
+func (p *Plugin) reassignResources(fromID, toID, adminID string) (int, error) {
+	count := 0
+	err := p.updateReactionActions(func(actions []*reactionAction) ([]*reactionAction, error) {
+		count = 0
+		for _, action := range actions {
+			if action.CreatedBy != fromID && action.TargetUserID != fromID {
+				continue
+			}
+			if action.CreatedBy == fromID {
+				action.CreatedBy = toID
+			}
+			if action.TargetUserID == fromID {
+				action.TargetUserID = toID
+			}
+			count++
+		}
+		return actions, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	policies, err := p.listChannelPolicies()
+	if err != nil {
+		return count, err
+	}
+	for teamID, policy := range policies {
+		if !slices.Contains(policy.MemberIDs, fromID) {
+			continue
+		}
+		if err := p.updateChannelPolicy(teamID, adminID, func(policy *channelPolicy) error {
+			var memberIDs []string
+			for _, memberID := range policy.MemberIDs {
+				if memberID == fromID {
+					memberID = toID
+				}
+				if !slices.Contains(memberIDs, memberID) {
+					memberIDs = append(memberIDs, memberID)
+				}
+			}
+			policy.MemberIDs = memberIDs
+			return nil
+		}); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	reminders, err := p.listReminders("")
+	if err != nil {
+		return count, err
+	}
+	for _, r := range reminders {
+		if r.UserID != fromID || r.CreatorID == fromID {
+			continue
+		}
+		// The reminder is taken before being saved again, so that it isn't delivered meanwhile.
+		found, err := p.deleteReminder(r.CreatorID, r.ID)
+		if err != nil {
+			return count, err
+		}
+		if !found {
+			continue
+		}
+		r.UserID = toID
+		if err := p.saveReminder(r); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	polls, err := p.listUserOpenPolls(fromID)
+	if err != nil {
+		return count, err
+	}
+	for _, pl := range polls {
+		err := p.updatePoll(pl.ID, func(pl *poll) error {
+			if pl.CreatorID != fromID {
+				return errPollUnchanged
+			}
+			pl.CreatorID = toID
+			return nil
+		})
+		if err == errPollUnchanged || err == errPollNotFound {
+			continue
+		}
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// cancelOffboardingItems deletes the reminders or scheduled posts of the deactivated user.
+func (p *Plugin) cancelOffboardingItems(section, userID string) (int, error) {
+	var ids []string
+	var cancel func(userID, id string) (bool, error)
+	switch section {
+	case offboardingSectionReminders:
+		reminders, err := p.listReminders(userID)
+		if err != nil {
+			return 0, err
+		}
+		for _, r := range reminders {
+			ids = append(ids, r.ID)
+		}
+		cancel = p.deleteReminder
+	case offboardingSectionScheduledPosts:
+		scheduledPosts, err := p.listScheduledPosts(userID)
+		if err != nil {
+			return 0, err
+		}
+		for _, scheduled := range scheduledPosts {
+			ids = append(ids, scheduled.ID)
+		}
+		cancel = p.cancelScheduledPost
+	default:
+		return 0, errors.Errorf("%s can't be cancelled", section)
+	}
+
+	count := 0
+	for _, id := range ids {
+		cancelled, err := cancel(userID, id)
+		if err != nil {
+			return count, err
+		}
+		if cancelled {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// offboardingItems describes the number of items of the section.
+func offboardingItems(section string, count int) string {
+	nouns := map[string][2]string{
+		offboardingSectionChannels:       {"channel", "channels"},
+		offboardingSectionReminders:      {"reminder", "reminders"},
+		offboardingSectionScheduledPosts: {"scheduled post", "scheduled posts"},
+		offboardingSectionResources:      {"plugin resource", "plugin resources"},
+	}[section]
+	if count == 1 {
+		return "1 " + nouns[0]
+	}
+
+	return fmt.Sprintf("%d %s", count, nouns[1])
+}
+
+// handleOffboardingAction reassigns the items of a section of an offboarding report to the user
+// selected, or cancels them. Only system admins can do so. The outcome is replied in the thread
+// of the report, so that the other admins know the items were taken care of.
+func (p *Plugin) handleOffboardingAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode PostActionIntegrationRequest", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !p.isSystemAdmin(userID) {
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "Only system admins can act on offboarding reports."})
+		return
+	}
+
+	deactivatedID, _ := request.Context["user_id"].(string)
+	section, _ := request.Context["section"].(string)
+	deactivated, appErr := p.API.GetUser(deactivatedID)
+	if appErr != nil {
+		p.API.LogError("Failed to get user of offboarding report", "user_id", deactivatedID, "err", appErr.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	admin, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("Failed to get user for offboarding action", "err", appErr.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var count int
+	var err error
+	var outcome string
+	switch mux.Vars(r)["action"] {
+	case "reassign":
+		selectedID, _ := request.Context["selected_option"].(string)
+		selected, appErr := p.API.GetUser(selectedID)
+		if appErr != nil || selected.DeleteAt != 0 || selected.Id == deactivated.Id {
+			p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "Pick an active user to reassign to."})
+			return
+		}
+
+		switch section {
+		case offboardingSectionChannels:
+			count, err = p.reassignSoleAdminChannels(deactivated.Id, selected.Id)
+		case offboardingSectionResources:
+			count, err = p.reassignResources(deactivated.Id, selected.Id, userID)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		outcome = fmt.Sprintf("reassigned %s of @%s to @%s", offboardingItems(section, count), deactivated.Username, selected.Username)
+
+	case "cancel":
+		if section != offboardingSectionReminders && section != offboardingSectionScheduledPosts {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if deactivated.DeleteAt == 0 {
+			p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: fmt.Sprintf("@%s has been reactivated, so nothing was cancelled.", deactivated.Username)})
+			return
+		}
+		count, err = p.cancelOffboardingItems(section, deactivated.Id)
+		outcome = fmt.Sprintf("cancelled %s of @%s", offboardingItems(section, count), deactivated.Username)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		p.API.LogError("Failed to act on offboarding report", "user_id", deactivated.Id, "section", section, "err", err.Error())
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: fmt.Sprintf("Stopped after you %s: %s", outcome, err.Error())})
+		return
+	}
+
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: request.ChannelId,
+		RootId:    request.PostId,
+		Message:   fmt.Sprintf("@%s %s.", admin.Username, outcome),
+	}); appErr != nil {
+		p.API.LogError("Failed to reply to offboarding report", "post_id", request.PostId, "err", appErr.Error())
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: fmt.Sprintf("You %s.", outcome)})
+		return
+	}
+
+	p.writeJSON(w, &model.PostActionIntegrationResponse{})
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseOffboardingReportChannel(t *testing.T) {
+	teamName, channelName, err := parseOffboardingReportChannel(" eng/admins ")
+	require.NoError(t, err)
+	assert.Equal(t, "eng", teamName)
+	assert.Equal(t, "admins", channelName)
+
+	teamName, channelName, err = parseOffboardingReportChannel("")
+	require.NoError(t, err)
+	assert.Empty(t, teamName)
+	assert.Empty(t, channelName)
+
+	for _, setting := range []string{"admins", "eng/", "/admins", "eng/Admins Channel", "e/admins"} {
+		_, _, err := parseOffboardingReportChannel(setting)
+		assert.Error(t, err, setting)
+	}
+
+	assert.Equal(t, "512 B", formatFileSize(512))
+	assert.Equal(t, "1.5 KB", formatFileSize(1536))
+	assert.Equal(t, "2.0 MB", formatFileSize(2*1024*1024))
+}
+
+func TestOffboarding(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	user := &model.User{Id: "user1", Username: "jane", DeleteAt: 1}
+
+	var posts []*model.Post
+	var added, promoted []string
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		node.initializeAPI()
+		config := node.getConfiguration().Clone()
+		config.OffboardingReportChannel = "eng/admins"
+		node.setConfiguration(config)
+
+		api := node.API.(*fakeNodeAPI).API
+		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer("http://localhost")}})
+		api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+		api.On("HasPermissionTo", mock.Anything, model.PermissionManageSystem).Return(false)
+		api.On("GetUser", "user1").Return(user, nil)
+		api.On("GetUser", "user2").Return(&model.User{Id: "user2", Username: "john"}, nil)
+		api.On("GetUser", "user3").Return(&model.User{Id: "user3", Username: "gone", DeleteAt: 1}, nil)
+		api.On("GetUser", "admin").Return(&model.User{Id: "admin", Username: "admin"}, nil)
+		api.On("GetChannelByNameForTeamName", "eng", "admins", false).Return(&model.Channel{Id: "admins", Name: "admins"}, nil)
+		api.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Name: "apollo", Type: model.ChannelTypePrivate}, nil)
+		api.On("GetChannel", "channel2").Return(&model.Channel{Id: "channel2", Name: "town-square", Type: model.ChannelTypeOpen}, nil)
+		api.On("GetChannel", "dm").Return(&model.Channel{Id: "dm", Name: "user1__user2", Type: model.ChannelTypeDirect}, nil)
+		api.On("GetTeamsForUser", "user1").Return([]*model.Team{{Id: "team1"}}, nil)
+		api.On("GetTeam", "team1").Return(&model.Team{Id: "team1", DisplayName: "Engineering"}, nil)
+		api.On("GetChannelsForTeamForUser", "team1", "user1", false).Return([]*model.Channel{
+			{Id: "channel1", Name: "apollo", Type: model.ChannelTypePrivate},
+			{Id: "channel2", Name: "town-square", Type: model.ChannelTypeOpen},
+			{Id: "dm", Name: "user1__user2", Type: model.ChannelTypeDirect},
+		}, nil)
+		api.On("GetChannelMembers", "channel1", 0, offboardingChannelMembersPerPage).Return(model.ChannelMembers{
+			{UserId: "user1", SchemeAdmin: true},
+			{UserId: "user2"},
+			{UserId: "user3", SchemeAdmin: true},
+		}, nil)
+		api.On("GetChannelMembers", "channel2", 0, offboardingChannelMembersPerPage).Return(model.ChannelMembers{
+			{UserId: "user1", SchemeAdmin: true},
+			{UserId: "user2", SchemeAdmin: true},
+		}, nil)
+		api.On("GetFileInfos", 0, maxOffboardingItems+1, mock.MatchedBy(func(options *model.GetFileInfosOptions) bool {
+			return len(options.UserIds) == 1 && options.UserIds[0] == "user1" && options.Since > 0
+		})).Return([]*model.FileInfo{
+			{Id: "file1", Name: "roadmap.pdf", ChannelId: "channel1", PostId: "post1", Size: 2048, CreateAt: model.GetMillis()},
+			{Id: "file2", Name: "salaries.xlsx", ChannelId: "dm", PostId: "post2", Size: 1024, CreateAt: model.GetMillis() - 1},
+			{Id: "file3", Name: "budget.xlsx", ChannelId: "channel1", PostId: "post3", Size: 1024, CreateAt: model.GetMillis() - 2},
+		}, nil)
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+		api.On("AddChannelMember", mock.Anything, "user2").Run(func(args mock.Arguments) {
+			added = append(added, args.String(0))
+		}).Return(&model.ChannelMember{}, nil)
+		api.On("UpdateChannelMemberRoles", mock.Anything, "user2", "channel_user channel_admin").Run(func(args mock.Arguments) {
+			promoted = append(promoted, args.String(0))
+		}).Return(&model.ChannelMember{}, nil)
+	}
+
+	remindAt := time.Now().Add(time.Hour).UnixMilli()
+	require.NoError(t, node1.saveReminder(&reminder{ID: "r1", CreatorID: "user1", UserID: "user1", Message: "Renew the certificates", RemindAt: remindAt}))
+	require.NoError(t, node1.saveReminder(&reminder{ID: "r2", CreatorID: "user2", UserID: "user1", Message: "Review the launch plan", RemindAt: remindAt}))
+	require.NoError(t, node2.schedulePost(&scheduledPost{ID: "s1", UserID: "user1", ChannelID: "channel1", Message: "Standup notes", PostAt: remindAt}))
+	require.NoError(t, node2.updateReactionActions(func(actions []*reactionAction) ([]*reactionAction, error) {
+		return append(actions, &reactionAction{ID: "a1", Emoji: "bell", Action: reactionActionNotify, CreatedBy: "admin", TargetUserID: "user1"}), nil
+	}))
+	require.NoError(t, node1.updateChannelPolicy("team1", "admin", func(policy *channelPolicy) error {
+		policy.MemberIDs = []string{"user1", "user2"}
+		return nil
+	}))
+	require.NoError(t, node1.savePoll(&poll{ID: "poll1", ChannelID: "channel2", CreatorID: "user1", Question: "Lunch?"}))
+
+	kvLists := cluster.kvLists
+	node1.UserHasBeenDeactivated(nil, user)
+	assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned")
+	require.Len(t, posts, 1)
+	report := posts[0]
+	assert.Equal(t, "admins", report.ChannelId)
+	assert.Equal(t, "bot", report.UserId)
+	assert.Contains(t, report.Message, "@jane has been deactivated.")
+
+	attachments := report.Attachments()
+	require.Len(t, attachments, 5)
+	assert.Equal(t, "Channels they were the only admin of (1)", attachments[0].Title)
+	assert.Equal(t, "- ~apollo\n\nThe user picked is made an admin of each channel.", attachments[0].Text)
+	assert.Equal(t, "Open reminders (1)", attachments[1].Title)
+	assert.Equal(t, "- "+formatOffboardingTime(remindAt)+", to themselves\n", attachments[1].Text, "the messages aren't shown")
+	require.Len(t, attachments[1].Actions, 1)
+	assert.Equal(t, "Cancel all", attachments[1].Actions[0].Name)
+	assert.Equal(t, "Scheduled posts (1)", attachments[2].Title)
+	assert.Equal(t, "- "+formatOffboardingTime(remindAt)+", in ~apollo\n", attachments[2].Text)
+	require.Len(t, attachments[2].Actions, 1)
+	assert.Equal(t, "Cancel all", attachments[2].Actions[0].Name)
+	assert.Equal(t, "Files uploaded in the last 30 days (3)", attachments[3].Title)
+	assert.Equal(t, "- 2 files in ~apollo, 3.0 KB\n- 1 file in a direct message, 1.0 KB\n", attachments[3].Text, "the names aren't shown")
+	assert.Empty(t, attachments[3].Actions)
+	assert.Equal(t, "Plugin resources referencing them (4)", attachments[4].Title)
+	assert.Contains(t, attachments[4].Text, "Reaction action :bell: notify @jane")
+	assert.Contains(t, attachments[4].Text, "Channel policy of **Engineering**")
+	assert.Contains(t, attachments[4].Text, "Reminder from @john on "+formatOffboardingTime(remindAt)+"\n")
+	assert.Contains(t, attachments[4].Text, "[Poll](http://localhost/_redirect/pl/poll1) in ~town-square")
+	assert.NotContains(t, attachments[4].Text, "Review the launch plan")
+	assert.NotContains(t, attachments[4].Text, "Lunch?")
+
+	act := func(node *Plugin, userID string, action *model.PostAction, selected string) *httptest.ResponseRecorder {
+		context := map[string]any{}
+		for key, value := range action.Integration.Context {
+			context[key] = value
+		}
+		if selected != "" {
+			context["selected_option"] = selected
+		}
+		data, err := json.Marshal(&model.PostActionIntegrationRequest{UserId: userID, PostId: "report", ChannelId: "admins", Context: context})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, action.Integration.URL[len("/plugins/"+manifest.Id):], bytes.NewReader(data))
+		r.Header.Set("Mattermost-User-ID", userID)
+		node.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	t.Run("only system admins can act", func(t *testing.T) {
+		w := act(node2, "user2", attachments[0].Actions[0], "user2")
+		assert.Contains(t, w.Body.String(), "Only system admins")
+		assert.Empty(t, added)
+	})
+
+	t.Run("reassign to an active user", func(t *testing.T) {
+		w := act(node2, "admin", attachments[0].Actions[0], "user3")
+		assert.Contains(t, w.Body.String(), "Pick an active user")
+		assert.Empty(t, added)
+	})
+
+	t.Run("reassign channels", func(t *testing.T) {
+		posts = nil
+		act(node2, "admin", attachments[0].Actions[0], "user2")
+		assert.Equal(t, []string{"channel1"}, added)
+		assert.Equal(t, []string{"channel1"}, promoted)
+		require.Len(t, posts, 1)
+		assert.Equal(t, "report", posts[0].RootId)
+		assert.Equal(t, "@admin reassigned 1 channel of @jane to @john.", posts[0].Message)
+	})
+
+	t.Run("reactivated users keep their items", func(t *testing.T) {
+		posts = nil
+		user.DeleteAt = 0
+		defer func() { user.DeleteAt = 1 }()
+
+		w := act(node1, "admin", attachments[1].Actions[0], "")
+		assert.Contains(t, w.Body.String(), "@jane has been reactivated")
+		assert.Empty(t, posts)
+
+		reminders, err := node2.listReminders("user1")
+		require.NoError(t, err)
+		assert.Len(t, reminders, 1)
+	})
+
+	t.Run("cancel reminders", func(t *testing.T) {
+		posts = nil
+		act(node1, "admin", attachments[1].Actions[0], "")
+		require.Len(t, posts, 1)
+		assert.Equal(t, "@admin cancelled 1 reminder of @jane.", posts[0].Message)
+
+		reminders, err := node2.listReminders("user1")
+		require.NoError(t, err)
+		assert.Empty(t, reminders)
+
+		// The reminders of other users reminding them are plugin resources.
+		reminders, err = node2.listReminders("user2")
+		require.NoError(t, err)
+		require.Len(t, reminders, 1)
+		assert.Equal(t, "r2", reminders[0].ID)
+	})
+
+	t.Run("cancel scheduled posts", func(t *testing.T) {
+		posts = nil
+		act(node2, "admin", attachments[2].Actions[0], "")
+		require.Len(t, posts, 1)
+		assert.Equal(t, "@admin cancelled 1 scheduled post of @jane.", posts[0].Message)
+
+		scheduledPosts, err := node1.listScheduledPosts("")
+		require.NoError(t, err)
+		assert.Empty(t, scheduledPosts)
+	})
+
+	t.Run("reassign resources", func(t *testing.T) {
+		posts = nil
+		act(node1, "admin", attachments[4].Actions[0], "user2")
+		require.Len(t, posts, 1)
+		assert.Equal(t, "@admin reassigned 4 plugin resources of @jane to @john.", posts[0].Message)
+
+		actions, err := node2.getReactionActions()
+		require.NoError(t, err)
+		assert.Equal(t, "user2", actions[0].TargetUserID)
+		assert.Equal(t, "admin", actions[0].CreatedBy)
+
+		policy, err := node2.getChannelPolicy("team1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user2"}, policy.MemberIDs)
+
+		pl, err := node2.getPoll("poll1")
+		require.NoError(t, err)
+		assert.Equal(t, "user2", pl.CreatorID)
+
+		resources, err := node2.describeReferencingResources("user1")
+		require.NoError(t, err)
+		assert.Empty(t, resources)
+	})
+}
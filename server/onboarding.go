@@ -0,0 +1,631 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// onboardingKeyPrefix prefixes the KV keys of the onboarding of the users, followed by the id
+	// of the team and the id of the user.
+	onboardingKeyPrefix = "onboarding_"
+
+	// onboardingTeamKeyPrefix prefixes the KV keys of the ids of the users onboarded in a team,
+	// followed by the id of the team, so that the onboardings are listed without scanning the KV
+	// store.
+	onboardingTeamKeyPrefix = "onboardings_"
+
+	onboardingStepRole      = "role"
+	onboardingStepInterests = "interests"
+	onboardingStepTimezone  = "timezone"
+
+	onboardingOptionRole     = "role"
+	onboardingOptionInterest = "interest"
+
+	// maxOnboardingStatusRows is the number of users listed by /demo_plugin onboarding status.
+	maxOnboardingStatusRows = 50
+)
+
+// onboarding tracks the onboarding of a user who joined a team.
+type onboarding struct {
+	TeamID      string   `json:"team_id"`
+	UserID      string   `json:"user_id"`
+	StartedAt   int64    `json:"started_at"`
+	CompletedAt int64    `json:"completed_at,omitempty"`
+	Role        string   `json:"role,omitempty"`
+	Interests   []string `json:"interests,omitempty"`
+	Timezone    string   `json:"timezone,omitempty"`
+
+	// ChannelIDs are the channels the user was added to.
+	ChannelIDs []string `json:"channel_ids,omitempty"`
+}
+
+// onboardingOption is a role or an interest offered during onboarding, and the names of the
+// channels users choosing it join.
+type onboardingOption struct {
+	Name     string
+	Channels []string
+}
+
+// onboardingOptions are the roles and interests parsed from the OnboardingChannels setting.
+type onboardingOptions struct {
+	Roles     []onboardingOption
+	Interests []onboardingOption
+}
+
+// parseOnboardingChannels parses the OnboardingChannels setting, one role or interest per line
+// as "role" or "interest", its name, a colon and the channels to join separated by commas, e.g.
+//
+//	role Engineering: engineering, code-review
+//	interest Releases: releases
+func parseOnboardingChannels(setting string) (*onboardingOptions, error) {
+	options := &onboardingOptions{}
+	seen := make(map[string]bool)
+	for i, line := range strings.Split(setting, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		head, channels, ok := strings.Cut(line, ":")
+		kind, name, _ := strings.Cut(strings.TrimSpace(head), " ")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || (kind != onboardingOptionRole && kind != onboardingOptionInterest) {
+			return nil, errors.Errorf("line %d: expected role <name>: <channels> or interest <name>: <channels>", i+1)
+		}
+		if seen[kind+" "+name] {
+			return nil, errors.Errorf("line %d: duplicate %s %q", i+1, kind, name)
+		}
+		seen[kind+" "+name] = true
+
+		option := onboardingOption{Name: name}
+		for _, channel := range strings.Split(channels, ",") {
+			channel = strings.TrimPrefix(strings.TrimSpace(channel), "~")
+			if channel == "" {
+				continue
+			}
+			if !model.IsValidChannelIdentifier(channel) {
+				return nil, errors.Errorf("line %d: invalid channel name %q", i+1, channel)
+			}
+			option.Channels = append(option.Channels, channel)
+		}
+
+		if kind == onboardingOptionRole {
+			options.Roles = append(options.Roles, option)
+		} else {
+			options.Interests = append(options.Interests, option)
+		}
+	}
+
+	return options, nil
+}
+
+// channels returns the names of the channels to join for the given role and interests.
+func (o *onboardingOptions) channels(role string, interests []string) []string {
+	var channels []string
+	add := func(option onboardingOption) {
+		for _, channel := range option.Channels {
+			if !slices.Contains(channels, channel) {
+				channels = append(channels, channel)
+			}
+		}
+	}
+
+	for _, option := range o.Roles {
+		if option.Name == role {
+			add(option)
+		}
+	}
+	for _, option := range o.Interests {
+		if slices.Contains(interests, option.Name) {
+			add(option)
+		}
+	}
+
+	return channels
+}
+
+func onboardingKey(teamID, userID string) string {
+	return onboardingKeyPrefix + teamID + "_" + userID
+}
+
+func (p *Plugin) getOnboarding(teamID, userID string) (*onboarding, error) {
+	var record *onboarding
+	if err := p.client.KV.Get(onboardingKey(teamID, userID), &record); err != nil {
+		return nil, errors.Wrap(err, "failed to get onboarding")
+	}
+
+	return record, nil
+}
+
+// errOnboardingCompleted is returned when starting the onboarding of a user who completed it.
+var errOnboardingCompleted = errors.New("onboarding already completed")
+
+// startOnboarding records that the user joined the team and sends the welcome message, with a
+// button opening the onboarding dialog. Users who completed the onboarding of the team, e.g.
+// before leaving it and joining it again, aren't welcomed again. Users are added to the index of
+// the team along with their first onboarding.
+func (p *Plugin) startOnboarding(user *model.User, team *model.Team) error {
+	var created bool
+	err := p.updateKV(onboardingKey(team.Id, user.Id), 0, func(oldValue []byte) (any, error) {
+		created = len(oldValue) == 0
+		if len(oldValue) > 0 {
+			var record onboarding
+			if err := json.Unmarshal(oldValue, &record); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal onboarding")
+			}
+			if record.CompletedAt > 0 {
+				return nil, errOnboardingCompleted
+			}
+		}
+
+		return &onboarding{TeamID: team.Id, UserID: user.Id, StartedAt: model.GetMillis()}, nil
+	})
+	if errors.Is(err, errOnboardingCompleted) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if created {
+		if err := p.indexOnboarding(team.Id, user.Id); err != nil {
+			return err
+		}
+	}
+
+	dm, appErr := p.API.GetDirectChannel(user.Id, p.botID)
+	if appErr != nil {
+		return errors.Wrapf(appErr, "failed to get direct channel of user %s", user.Id)
+	}
+
+	post := &model.Post{
+		UserId:    p.botID,
+		ChannelId: dm.Id,
+		Message:   fmt.Sprintf("Welcome to **%s**, @%s! :wave:", team.DisplayName, user.Username),
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Text: "Tell me about your role and interests, and I'll add you to the channels that matter to you.",
+		Actions: []*model.PostAction{{
+			Type: model.PostActionTypeButton,
+			Name: "Get started",
+			Integration: &model.PostActionIntegration{
+				URL:     fmt.Sprintf("/plugins/%s/interactive/onboarding/start", manifest.Id),
+				Context: map[string]any{"team_id": team.Id},
+			},
+		}},
+	}})
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to send welcome message")
+	}
+
+	return nil
+}
+
+// handleOnboardingStart opens the first step of the onboarding dialog for the user who clicked
+// the button of the welcome message.
+func (p *Plugin) handleOnboardingStart(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode PostActionIntegrationRequest", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	teamID, _ := request.Context["team_id"].(string)
+	record, err := p.getOnboarding(teamID, userID)
+	if err != nil {
+		p.API.LogError("Failed to get onboarding", "team_id", teamID, "user_id", userID, "err", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if record == nil {
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "This welcome message isn't yours."})
+		return
+	}
+	if record.CompletedAt > 0 {
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "You already completed your onboarding. :tada:"})
+		return
+	}
+
+	options, err := parseOnboardingChannels(p.getConfiguration().OnboardingChannels)
+	if err != nil {
+		p.API.LogError("Failed to parse onboarding channels", "err", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if appErr := p.API.OpenInteractiveDialog(model.OpenDialogRequest{
+		TriggerId: request.TriggerId,
+		URL:       fmt.Sprintf("/plugins/%s/dialog/onboarding", manifest.Id),
+		Dialog:    getOnboardingDialogRole(teamID, options),
+	}); appErr != nil {
+		p.API.LogError("Failed to open onboarding dialog", "err", appErr.Error())
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "Failed to open the onboarding dialog."})
+		return
+	}
+
+	p.writeJSON(w, &model.PostActionIntegrationResponse{})
+}
+
+// The onboarding dialog has a step per question, run by the dialogSteps of the multi-step dialog
+// sample. The state of the dialog is the step and the id of the team.
+
+func onboardingDialogState(step, teamID string) string {
+	return step + ":" + teamID
+}
+
+func onboardingOptionItems(options []onboardingOption) []*model.PostActionOptions {
+	items := make([]*model.PostActionOptions, 0, len(options))
+	for _, option := range options {
+		items = append(items, &model.PostActionOptions{Text: option.Name, Value: option.Name})
+	}
+
+	return items
+}
+
+func getOnboardingDialogRole(teamID string, options *onboardingOptions) model.Dialog {
+	role := model.DialogElement{
+		DisplayName: "Role",
+		Name:        "role",
+		Type:        "radio",
+		HelpText:    "What do you do in this team?",
+		Options:     onboardingOptionItems(options.Roles),
+	}
+	if len(options.Roles) == 0 {
+		role.Type = "text"
+		role.Placeholder = "e.g. Engineering"
+		role.MaxLength = 64
+	}
+
+	return model.Dialog{
+		CallbackId:  "onboarding_role",
+		Title:       "Welcome - Step 1",
+		SubmitLabel: "Next Step",
+		State:       onboardingDialogState(onboardingStepRole, teamID),
+		Elements:    []model.DialogElement{role},
+	}
+}
+
+func getOnboardingDialogInterests(teamID string, options *onboardingOptions) model.Dialog {
+	dialog := model.Dialog{
+		CallbackId:       "onboarding_interests",
+		Title:            "Welcome - Step 2",
+		IntroductionText: "Nothing else to pick here, go to the next step.",
+		SubmitLabel:      "Next Step",
+		State:            onboardingDialogState(onboardingStepInterests, teamID),
+		Elements:         []model.DialogElement{},
+	}
+	if len(options.Interests) > 0 {
+		dialog.IntroductionText = ""
+		dialog.Elements = append(dialog.Elements, model.DialogElement{
+			DisplayName: "Interests",
+			Name:        "interests",
+			Type:        "select",
+			MultiSelect: true,
+			Placeholder: "Select your interests...",
+			HelpText:    "You'll join the channels of the topics you pick.",
+			Optional:    true,
+			Options:     onboardingOptionItems(options.Interests),
+		})
+	}
+
+	return dialog
+}
+
+func getOnboardingDialogTimezone(teamID, timezone string) model.Dialog {
+	return model.Dialog{
+		CallbackId:  "onboarding_timezone",
+		Title:       "Welcome - Step 3",
+		SubmitLabel: "Finish",
+		State:       onboardingDialogState(onboardingStepTimezone, teamID),
+		Elements: []model.DialogElement{{
+			DisplayName: "Time Zone",
+			Name:        "timezone",
+			Type:        "text",
+			Placeholder: "e.g. Europe/Paris",
+			HelpText:    "Your time zone, used for reminders and scheduled posts.",
+			Default:     timezone,
+			MaxLength:   64,
+		}},
+	}
+}
+
+// submittedString returns the value of an element, or an empty string if it wasn't submitted.
+func submittedString(value any) string {
+	if value == nil {
+		return ""
+	}
+	return interfaceToString(value)
+}
+
+// submittedList returns the values of a multi-select element, submitted as a list or as a
+// comma separated string.
+func submittedList(value any) []string {
+	var values []string
+	switch v := value.(type) {
+	case nil:
+	case []any:
+		for _, item := range v {
+			values = append(values, interfaceToString(item))
+		}
+	default:
+		values = strings.Split(interfaceToString(v), ",")
+	}
+
+	return slices.DeleteFunc(values, func(s string) bool { return strings.TrimSpace(s) == "" })
+}
+
+// handleDialogOnboarding handles the submissions of the steps of the onboarding dialog,
+// returning the form of the next step until the last one completes the onboarding.
+func (p *Plugin) handleDialogOnboarding(w http.ResponseWriter, r *http.Request) {
+	var request model.SubmitDialogRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode SubmitDialogRequest for onboarding", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if userID := r.Header.Get("Mattermost-User-ID"); userID == "" || userID != request.UserId {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	if request.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	options, err := parseOnboardingChannels(p.getConfiguration().OnboardingChannels)
+	if err != nil {
+		p.API.LogError("Failed to parse onboarding channels", "err", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	step, teamID, _ := strings.Cut(request.State, ":")
+
+	// The role is shown to the team admins in the onboarding status, so personal data is handled
+	// as in the other dialog submissions.
+	request.TeamId = teamID
+	if response := p.redactDialogSteps(&request); response != nil {
+		p.writeJSON(w, response)
+		return
+	}
+
+	p.runDialogStep(w, &request, step, dialogSteps{
+		onboardingStepRole: func(request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+			role := strings.TrimSpace(submittedString(request.Submission["role"]))
+			if role == "" {
+				return &model.SubmitDialogResponse{Errors: map[string]string{"role": "Please pick a role."}}
+			}
+
+			return nextDialogStep(getOnboardingDialogInterests(teamID, options))
+		},
+		onboardingStepInterests: func(request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+			return nextDialogStep(getOnboardingDialogTimezone(teamID, p.userLocation(request.UserId).String()))
+		},
+		onboardingStepTimezone: func(request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+			timezone := strings.TrimSpace(submittedString(request.Submission["timezone"]))
+			if _, err := time.LoadLocation(timezone); err != nil || timezone == "" {
+				return &model.SubmitDialogResponse{Errors: map[string]string{"timezone": "Unknown time zone, expected e.g. Europe/Paris or UTC."}}
+			}
+
+			role := strings.TrimSpace(submittedString(request.Submission["role"]))
+			interests := submittedList(request.Submission["interests"])
+			if err := p.completeOnboarding(teamID, request.UserId, role, interests, timezone, options); err != nil {
+				p.API.LogError("Failed to complete onboarding", "team_id", teamID, "user_id", request.UserId, "err", err.Error())
+				return &model.SubmitDialogResponse{Error: "Failed to complete your onboarding, please try again."}
+			}
+
+			return nil
+		},
+	})
+}
+
+// completeOnboarding applies the answers of the user: the user joins the channels mapped from
+// the role and the interests, and their time zone is set. The onboarding is then recorded as
+// completed, and the bot sends a summary.
+func (p *Plugin) completeOnboarding(teamID, userID, role string, interests []string, timezone string, options *onboardingOptions) error {
+	record, err := p.getOnboarding(teamID, userID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return errors.New("onboarding not started")
+	}
+
+	var joined, missing []string
+	var channelIDs []string
+	for _, name := range options.channels(role, interests) {
+		channel, appErr := p.API.GetChannelByName(teamID, name, false)
+		if appErr != nil {
+			missing = append(missing, name)
+			continue
+		}
+		if _, appErr := p.API.AddChannelMember(channel.Id, userID); appErr != nil {
+			p.API.LogWarn("Failed to add user to onboarding channel", "channel_id", channel.Id, "user_id", userID, "err", appErr.Error())
+			missing = append(missing, name)
+			continue
+		}
+		joined = append(joined, "~"+channel.Name)
+		channelIDs = append(channelIDs, channel.Id)
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return errors.Wrap(appErr, "failed to get user")
+	}
+	if model.GetPreferredTimezone(user.Timezone) != timezone {
+		if user.Timezone == nil {
+			user.Timezone = make(model.StringMap)
+		}
+		user.Timezone["useAutomaticTimezone"] = "false"
+		user.Timezone["manualTimezone"] = timezone
+		if _, appErr := p.API.UpdateUser(user); appErr != nil {
+			return errors.Wrap(appErr, "failed to set time zone")
+		}
+	}
+
+	err = p.updateKV(onboardingKey(teamID, userID), 0, func(oldValue []byte) (any, error) {
+		completed := *record
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &completed); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal onboarding")
+			}
+		}
+		completed.CompletedAt = model.GetMillis()
+		completed.Role = role
+		completed.Interests = interests
+		completed.Timezone = timezone
+		completed.ChannelIDs = channelIDs
+
+		return &completed, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	summary := "You're all set! :tada:"
+	if len(joined) > 0 {
+		summary += " I added you to " + strings.Join(joined, ", ") + "."
+	}
+	if len(missing) > 0 {
+		summary += fmt.Sprintf(" I couldn't add you to %s, please ask a team admin.", strings.Join(missing, ", "))
+	}
+	dm, appErr := p.API.GetDirectChannel(userID, p.botID)
+	if appErr != nil {
+		return errors.Wrapf(appErr, "failed to get direct channel of user %s", userID)
+	}
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: dm.Id,
+		Message:   summary,
+	}); appErr != nil {
+		return errors.Wrap(appErr, "failed to send onboarding summary")
+	}
+
+	return nil
+}
+
+// indexOnboarding atomically adds the user to the index of the users onboarded in the team.
+func (p *Plugin) indexOnboarding(teamID, userID string) error {
+	return p.updateKV(onboardingTeamKeyPrefix+teamID, 0, func(oldValue []byte) (any, error) {
+		var userIDs []string
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &userIDs); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal onboarded users")
+			}
+		}
+		if slices.Contains(userIDs, userID) {
+			return oldValue, nil
+		}
+
+		return append(userIDs, userID), nil
+	})
+}
+
+// listOnboardings returns the onboardings of the users who joined the team, the most recent
+// first.
+func (p *Plugin) listOnboardings(teamID string) ([]*onboarding, error) {
+	var userIDs []string
+	if err := p.client.KV.Get(onboardingTeamKeyPrefix+teamID, &userIDs); err != nil {
+		return nil, errors.Wrap(err, "failed to get onboarded users")
+	}
+
+	records := make([]*onboarding, 0, len(userIDs))
+	for _, userID := range userIDs {
+		record, err := p.getOnboarding(teamID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if record != nil {
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartedAt > records[j].StartedAt
+	})
+
+	return records, nil
+}
+
+// executeCommandOnboarding reports the onboarding of the users who joined the team to the team
+// admins.
+func (p *Plugin) executeCommandOnboarding(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if len(params) != 1 || params[0] != "status" {
+		return respond("Usage: `/demo_plugin onboarding status`.")
+	}
+	if !p.API.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionManageTeam) {
+		return respond("Only team admins can see the onboarding status of the team.")
+	}
+
+	records, err := p.listOnboardings(args.TeamId)
+	if err != nil {
+		p.API.LogError("Failed to list onboardings", "team_id", args.TeamId, "err", err.Error())
+		return respond("Failed to get the onboarding status of the team.")
+	}
+
+	return respond(p.onboardingSummary(records, p.userLocation(args.UserId)))
+}
+
+// onboardingSummary describes the onboardings in Markdown.
+func (p *Plugin) onboardingSummary(records []*onboarding, location *time.Location) string {
+	if len(records) == 0 {
+		return "Nobody joined this team since onboarding was enabled."
+	}
+
+	completed := 0
+	for _, record := range records {
+		if record.CompletedAt > 0 {
+			completed++
+		}
+	}
+
+	const layout = "Jan 2 2006 15:04"
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d of %d users who joined this team completed their onboarding.\n\n", completed, len(records))
+	sb.WriteString("| User | Joined | Completed | Role | Interests |\n|:-----|:-------|:----------|:-----|:----------|\n")
+	for i, record := range records {
+		if i == maxOnboardingStatusRows {
+			fmt.Fprintf(&sb, "\n%d more users not listed.", len(records)-maxOnboardingStatusRows)
+			break
+		}
+
+		username := record.UserID
+		if user, appErr := p.API.GetUser(record.UserID); appErr == nil {
+			username = "@" + user.Username
+		}
+		status := "pending"
+		if record.CompletedAt > 0 {
+			status = time.UnixMilli(record.CompletedAt).In(location).Format(layout)
+		}
+		fmt.Fprintf(&sb, "| %s | %s | %s | %s | %s |\n", username, time.UnixMilli(record.StartedAt).In(location).Format(layout), status, record.Role, strings.Join(record.Interests, ", "))
+	}
+
+	return sb.String()
+}
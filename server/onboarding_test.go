@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseOnboardingChannels(t *testing.T) {
+	options, err := parseOnboardingChannels("role Engineering: engineering, ~code-review\n\nrole Product Management:\ninterest Releases: releases, engineering\n")
+	require.NoError(t, err)
+	assert.Equal(t, []onboardingOption{
+		{Name: "Engineering", Channels: []string{"engineering", "code-review"}},
+		{Name: "Product Management"},
+	}, options.Roles)
+	assert.Equal(t, []onboardingOption{{Name: "Releases", Channels: []string{"releases", "engineering"}}}, options.Interests)
+
+	assert.Equal(t, []string{"engineering", "code-review", "releases"}, options.channels("Engineering", []string{"Releases", "Unknown"}))
+	assert.Empty(t, options.channels("Product Management", nil))
+
+	for _, setting := range []string{"Engineering: engineering", "team Engineering: engineering", "role: engineering", "role Engineering", "role Design: Design Team", "role A: a\nrole A: b"} {
+		_, err := parseOnboardingChannels(setting)
+		assert.Error(t, err, setting)
+	}
+
+	assert.Equal(t, []string{"a", "b"}, submittedList([]any{"a", "b"}))
+	assert.Equal(t, []string{"a", "b"}, submittedList("a,b"))
+	assert.Empty(t, submittedList(nil))
+	assert.Empty(t, submittedList(""))
+}
+
+func TestOnboarding(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	user := &model.User{Id: "user1", Username: "jane", Timezone: model.StringMap{"useAutomaticTimezone": "true", "automaticTimezone": "UTC"}}
+	team := &model.Team{Id: "team1", DisplayName: "Engineering"}
+
+	var posts []*model.Post
+	var dialogs []model.OpenDialogRequest
+	var added []string
+	var updatedUser *model.User
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		node.initializeAPI()
+		configuration := node.getConfiguration().Clone()
+		configuration.EnableOnboarding = true
+		configuration.OnboardingChannels = "role Engineering: engineering\nrole Design: design\ninterest Releases: releases, missing"
+		node.setConfiguration(configuration)
+
+		api := node.API.(*fakeNodeAPI).API
+		api.On("GetUser", "user1").Return(user, nil)
+		api.On("GetUser", "user2").Return(&model.User{Id: "user2", Username: "john"}, nil)
+		api.On("GetUser", "admin").Return(&model.User{Id: "admin", Username: "admin"}, nil)
+		api.On("GetTeam", "team1").Return(team, nil)
+		api.On("GetDirectChannel", mock.Anything, "bot").Return(&model.Channel{Id: "dm"}, nil)
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+		api.On("OpenInteractiveDialog", mock.Anything).Run(func(args mock.Arguments) {
+			dialogs = append(dialogs, args.Get(0).(model.OpenDialogRequest))
+		}).Return(nil)
+		api.On("GetChannelByName", "team1", "engineering", false).Return(&model.Channel{Id: "channel1", Name: "engineering"}, nil)
+		api.On("GetChannelByName", "team1", "releases", false).Return(&model.Channel{Id: "channel2", Name: "releases"}, nil)
+		api.On("GetChannelByName", "team1", "missing", false).Return(nil, model.NewAppError("GetChannelByName", "not_found", nil, "", http.StatusNotFound))
+		api.On("AddChannelMember", mock.Anything, "user1").Run(func(args mock.Arguments) {
+			added = append(added, args.String(0))
+		}).Return(&model.ChannelMember{}, nil)
+		api.On("UpdateUser", mock.Anything).Run(func(args mock.Arguments) {
+			updatedUser = args.Get(0).(*model.User)
+		}).Return(&model.User{}, nil)
+		api.On("HasPermissionToTeam", "admin", "team1", model.PermissionManageTeam).Return(true)
+		api.On("HasPermissionToTeam", mock.Anything, "team1", model.PermissionManageTeam).Return(false)
+	}
+
+	serve := func(node *Plugin, userID, path string, body any) *httptest.ResponseRecorder {
+		data, err := json.Marshal(body)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		r.Header.Set("Mattermost-User-ID", userID)
+		node.ServeHTTP(nil, w, r)
+		return w
+	}
+	submit := func(node *Plugin, state string, submission map[string]any) *model.SubmitDialogResponse {
+		w := serve(node, "user1", "/dialog/onboarding", &model.SubmitDialogRequest{UserId: "user1", State: state, Submission: submission})
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response model.SubmitDialogResponse
+		if w.Body.Len() > 0 {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return &response
+	}
+
+	require.NoError(t, node1.startOnboarding(user, team))
+	require.Len(t, posts, 1)
+	assert.Equal(t, "dm", posts[0].ChannelId)
+	assert.Contains(t, posts[0].Message, "Welcome to **Engineering**, @jane!")
+	action := posts[0].Attachments()[0].Actions[0]
+
+	t.Run("only the user welcomed can start", func(t *testing.T) {
+		w := serve(node2, "user2", "/interactive/onboarding/start", &model.PostActionIntegrationRequest{UserId: "user2", Context: action.Integration.Context})
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "isn't yours")
+		assert.Empty(t, dialogs)
+	})
+
+	t.Run("dialog steps", func(t *testing.T) {
+		w := serve(node2, "user1", action.Integration.URL[len("/plugins/"+manifest.Id):], &model.PostActionIntegrationRequest{UserId: "user1", TriggerId: "trigger", Context: action.Integration.Context})
+		require.Equal(t, http.StatusOK, w.Code)
+		require.Len(t, dialogs, 1)
+		assert.Equal(t, "trigger", dialogs[0].TriggerId)
+		state := dialogs[0].Dialog.State
+		require.Len(t, dialogs[0].Dialog.Elements, 1)
+		assert.Len(t, dialogs[0].Dialog.Elements[0].Options, 2)
+
+		response := submit(node1, state, map[string]any{})
+		assert.Contains(t, response.Errors, "role")
+
+		response = submit(node1, state, map[string]any{"role": "Engineering"})
+		require.NotNil(t, response.Form)
+		assert.True(t, response.Form.Elements[0].MultiSelect)
+
+		response = submit(node2, response.Form.State, map[string]any{"role": "Engineering", "interests": []any{"Releases"}})
+		require.NotNil(t, response.Form)
+		assert.Equal(t, "UTC", response.Form.Elements[0].Default)
+		state = response.Form.State
+
+		response = submit(node1, state, map[string]any{"role": "Engineering", "interests": []any{"Releases"}, "timezone": "Mars/Olympus"})
+		assert.Contains(t, response.Errors, "timezone")
+
+		posts = nil
+		response = submit(node1, state, map[string]any{"role": "Engineering", "interests": []any{"Releases"}, "timezone": "Europe/Paris"})
+		assert.Empty(t, response.Errors)
+		assert.Equal(t, []string{"channel1", "channel2"}, added)
+		require.NotNil(t, updatedUser)
+		assert.Equal(t, "Europe/Paris", model.GetPreferredTimezone(updatedUser.Timezone))
+		require.Len(t, posts, 1)
+		assert.Equal(t, "You're all set! :tada: I added you to ~engineering, ~releases. I couldn't add you to missing, please ask a team admin.", posts[0].Message)
+
+		record, err := node2.getOnboarding("team1", "user1")
+		require.NoError(t, err)
+		assert.NotZero(t, record.CompletedAt)
+		assert.Equal(t, "Engineering", record.Role)
+		assert.Equal(t, []string{"Releases"}, record.Interests)
+	})
+
+	t.Run("submissions of other users are rejected", func(t *testing.T) {
+		w := serve(node1, "user2", "/dialog/onboarding", &model.SubmitDialogRequest{UserId: "user1", State: "timezone:team1"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("users aren't welcomed twice", func(t *testing.T) {
+		posts = nil
+		require.NoError(t, node2.startOnboarding(user, team))
+		assert.Empty(t, posts)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		require.NoError(t, node2.startOnboarding(&model.User{Id: "user2", Username: "john"}, team))
+
+		command := func(userID string) string {
+			args := &model.CommandArgs{UserId: userID, TeamId: "team1", Command: "/demo_plugin onboarding status"}
+			return node1.executeCommandHooks(args).Text
+		}
+		assert.Contains(t, command("user1"), "Only team admins")
+
+		kvLists := cluster.kvLists
+		status := command("admin")
+		assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned")
+		assert.Contains(t, status, "1 of 2 users who joined this team completed their onboarding.")
+		assert.Contains(t, status, "| @john |")
+		assert.Contains(t, status, "| pending |")
+		assert.Contains(t, status, "| Engineering | Releases |")
+	})
+	t.Run("personal data is rejected", func(t *testing.T) {
+		_, err := node1.updateRedactionPolicy("team1", map[string]string{"action": redactionActionReject})
+		require.NoError(t, err)
+
+		response := submit(node2, onboardingDialogState(onboardingStepRole, "team1"), map[string]any{"role": "Call +1 (555) 123-4567"})
+		assert.Equal(t, "Personal data isn't allowed in: role", response.Error)
+		assert.Nil(t, response.Form)
+	})
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -28,15 +30,70 @@ type Plugin struct {
 	// setConfiguration for usage.
 	configuration *configuration
 
+	// runtimeStateLock serializes applying the runtime state to the configuration.
+	runtimeStateLock sync.Mutex
+
+	// moderationRules caches the moderation rules stored in the KV store, reloaded whenever
+	// they change on any plugin instance.
+	moderationRules       []*moderationRule
+	moderationRulesLoaded bool
+	moderationRulesLock   sync.RWMutex
+
+	// channelKeys caches the encryption keys of the channels with [SECURE] posts, needed for
+	// every post delivered to clients.
+	channelKeys     map[string]*channelKeys
+	channelKeysLock sync.RWMutex
+
+	// redactionPolicies caches the redaction policies of the teams, needed for every post.
+	redactionPolicies     map[string]*redactionPolicy
+	redactionPoliciesLock sync.RWMutex
+
+	// watchIndex caches the index of the keyword subscriptions, needed for every post, rebuilt
+	// whenever they change on any plugin instance.
+	watchIndex     *watchIndex
+	watchIndexLock sync.RWMutex
+
+	// reactionActions caches the emoji mapped to actions, needed for every reaction, reloaded
+	// whenever they change on any plugin instance.
+	reactionActions       []*reactionAction
+	reactionActionsLoaded bool
+	reactionActionsLock   sync.RWMutex
+
+	// slowModePolicies caches the slow mode policies of the channels in slow mode, needed for
+	// every post.
+	slowModePolicies       map[string]*slowModePolicy
+	slowModePoliciesLoaded bool
+	slowModePoliciesLock   sync.RWMutex
+
 	router *mux.Router
 
+	// metrics holds the Prometheus collectors exposed by ServeMetrics.
+	metrics *metrics
+
 	// BotId of the created bot account.
 	botID string
 
 	// backgroundJob is a job that executes periodically on only one plugin instance at a time
 	backgroundJob *cluster.Job
 
-	// Session tracking
-	sessionToConn   map[string]string
-	sessionToConnMu sync.RWMutex
+	// webhookRetryJob retries failed webhook deliveries on only one plugin instance at a time
+	webhookRetryJob *cluster.Job
+
+	// digestJob posts the digests of hook notifications on only one plugin instance at a time
+	digestJob *cluster.Job
+
+	// scheduledPostsJob delivers the scheduled posts on only one plugin instance at a time
+	scheduledPostsJob *cluster.Job
+
+	// remindersJob delivers the reminders on only one plugin instance at a time
+	remindersJob *cluster.Job
+
+	// eventShards write the hook events recorded by this plugin instance, taken in turns.
+	eventShards    [eventShardCount]eventShard
+	nextEventShard atomic.Uint32
+
+	// trackedConns caches when the websocket connections attached to this plugin instance were
+	// last tracked in the KV store.
+	trackedConns   map[string]time.Time
+	trackedConnsMu sync.Mutex
 }
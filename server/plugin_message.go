@@ -10,10 +10,17 @@ import (
 // for the team specified. If the teamID specified is empty, the method
 // will post the message to the "demo" channel for each team.
 func (p *Plugin) postPluginMessage(teamID, msg string) *model.AppError {
+	_, err := p.createPluginMessages(teamID, msg)
+	return err
+}
+
+// createPluginMessages posts a message like postPluginMessage, returning the created posts so
+// that replies can be threaded under them.
+func (p *Plugin) createPluginMessages(teamID, msg string) ([]*model.Post, *model.AppError) {
 	configuration := p.getConfiguration()
 
 	if configuration.disabled {
-		return nil
+		return nil, nil
 	}
 
 	if configuration.EnableMentionUser {
@@ -21,27 +28,28 @@ func (p *Plugin) postPluginMessage(teamID, msg string) *model.AppError {
 	}
 	msg = fmt.Sprintf("%s%s%s", configuration.TextStyle, msg, configuration.TextStyle)
 
-	if teamID != "" {
-		_, err := p.API.CreatePost(&model.Post{
-			UserId:    p.botID,
-			ChannelId: configuration.demoChannelIDs[teamID],
-			Message:   msg,
-		})
-		return err
+	channelIDs := []string{configuration.demoChannelIDs[teamID]}
+	if teamID == "" {
+		channelIDs = channelIDs[:0]
+		for _, channelID := range configuration.demoChannelIDs {
+			channelIDs = append(channelIDs, channelID)
+		}
 	}
 
-	for _, channelID := range configuration.demoChannelIDs {
-		_, err := p.API.CreatePost(&model.Post{
+	posts := make([]*model.Post, 0, len(channelIDs))
+	for _, channelID := range channelIDs {
+		post, err := p.API.CreatePost(&model.Post{
 			UserId:    p.botID,
 			ChannelId: channelID,
 			Message:   msg,
 		})
 		if err != nil {
-			return err
+			return posts, err
 		}
+		posts = append(posts, post)
 	}
 
-	return nil
+	return posts, nil
 }
 
 // sendEphemeralMessage sends an ephemeral message to a specific user in a channel
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	commandTriggerPoll = "poll"
+
+	// pollKeyPrefix prefixes the KV keys of the polls, followed by the id of the poll post.
+	pollKeyPrefix = "poll_"
+
+	// openPollsKeyPrefix prefixes the KV keys of the ids of the open polls created by a user,
+	// followed by the id of the user.
+	openPollsKeyPrefix = "polls_open_"
+
+	// pollPropKey marks the poll posts, so that only reactions to them are looked up as votes.
+	// The id of a poll is the id of its post.
+	pollPropKey = "demo_poll"
+
+	pollBarWidth = 10
+)
+
+// pollEmoji are the emoji bound to the options of a poll, in order.
+var pollEmoji = []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "keycap_ten"}
+
+var (
+	// errPollNotFound aborts the update of a post that isn't a poll.
+	errPollNotFound = errors.New("poll not found")
+
+	// errPollUnchanged aborts the update of a poll when a vote changes nothing.
+	errPollUnchanged = errors.New("poll unchanged")
+)
+
+// poll is a question posted by the bot, whose options are voted for by reacting with their
+// emoji. Votes map the users to the indexes of the options they voted for.
+type poll struct {
+	ID        string           `json:"id"`
+	ChannelID string           `json:"channel_id"`
+	CreatorID string           `json:"creator_id"`
+	Question  string           `json:"question"`
+	Options   []string         `json:"options"`
+	Single    bool             `json:"single,omitempty"`
+	Anonymous bool             `json:"anonymous,omitempty"`
+	Closed    bool             `json:"closed,omitempty"`
+	Votes     map[string][]int `json:"votes,omitempty"`
+}
+
+// optionIndex returns the index of the option bound to the emoji, or -1.
+func (p *poll) optionIndex(emoji string) int {
+	i := slices.Index(pollEmoji, emoji)
+	if i >= len(p.Options) {
+		return -1
+	}
+	return i
+}
+
+// message renders the question and the tallies of the options as bars.
+func (p *poll) message() string {
+	tallies := make([]int, len(p.Options))
+	voters := 0
+	for _, options := range p.Votes {
+		if len(options) > 0 {
+			voters++
+		}
+		for _, option := range options {
+			tallies[option]++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#### :bar_chart: %s\n", p.Question)
+	for i, option := range p.Options {
+		filled, percent := 0, 0
+		if voters > 0 {
+			filled = (tallies[i]*pollBarWidth + voters/2) / voters
+			percent = (tallies[i]*100 + voters/2) / voters
+		}
+		fmt.Fprintf(&sb, ":%s: **%s**\n`%s%s` %d (%d%%)\n",
+			pollEmoji[i], option,
+			strings.Repeat("█", filled), strings.Repeat("░", pollBarWidth-filled),
+			tallies[i], percent,
+		)
+	}
+
+	details := []string{fmt.Sprintf("%d voters", voters)}
+	if voters == 1 {
+		details[0] = "1 voter"
+	}
+	if p.Single {
+		details = append(details, "one vote per user")
+	}
+	if p.Anonymous {
+		details = append(details, "anonymous")
+	}
+	if p.Closed {
+		details = append(details, "**closed**")
+	} else {
+		details = append(details, "react to vote")
+	}
+	fmt.Fprintf(&sb, "\n_%s_", strings.Join(details, " · "))
+
+	return sb.String()
+}
+
+// apply renders the poll in the post, with the button to close or reopen it.
+func (p *poll) apply(post *model.Post) {
+	post.Message = p.message()
+	post.AddProp(pollPropKey, true)
+
+	action := &model.PostAction{
+		Type: model.PostActionTypeButton,
+		Name: "Close poll",
+		Integration: &model.PostActionIntegration{
+			URL: fmt.Sprintf("/plugins/%s/interactive/poll/close", manifest.Id),
+		},
+	}
+	if p.Closed {
+		action.Name = "Reopen poll"
+		action.Integration.URL = fmt.Sprintf("/plugins/%s/interactive/poll/reopen", manifest.Id)
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{Actions: []*model.PostAction{action}}})
+}
+
+// splitQuoted splits the text into words, keeping the words between straight or curly double
+// quotes together.
+func splitQuoted(text string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '“' || r == '”':
+			if quoted {
+				words = append(words, word.String())
+				word.Reset()
+				inWord, quoted = false, false
+			} else if !inWord {
+				inWord, quoted = true, true
+			} else {
+				word.WriteRune(r)
+			}
+		case unicode.IsSpace(r) && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+func (p *Plugin) getPoll(id string) (*poll, error) {
+	var pl poll
+	if err := p.client.KV.Get(pollKeyPrefix+id, &pl); err != nil {
+		return nil, errors.Wrap(err, "failed to get poll")
+	}
+	if pl.ID == "" {
+		return nil, errPollNotFound
+	}
+
+	return &pl, nil
+}
+
+// savePoll saves a new poll, indexing it as open.
+func (p *Plugin) savePoll(pl *poll) error {
+	if _, err := p.client.KV.Set(pollKeyPrefix+pl.ID, pl); err != nil {
+		return errors.Wrap(err, "failed to save poll")
+	}
+
+	return p.syncOpenPollIndex(&poll{ID: pl.ID, Closed: true}, pl)
+}
+
+// updatePoll atomically applies the change to the poll, which returns errPollUnchanged if there
+// is nothing to save.
+func (p *Plugin) updatePoll(id string, change func(pl *poll) error) error {
+	var before, after poll
+	err := p.updateKV(pollKeyPrefix+id, 0, func(oldValue []byte) (any, error) {
+		if len(oldValue) == 0 {
+			return nil, errPollNotFound
+		}
+
+		var pl poll
+		if err := json.Unmarshal(oldValue, &pl); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal poll")
+		}
+		before = pl
+		if err := change(&pl); err != nil {
+			return nil, err
+		}
+		after = pl
+
+		return &pl, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return p.syncOpenPollIndex(&before, &after)
+}
+
+// deletePoll deletes the poll of a deleted post.
+func (p *Plugin) deletePoll(id string) error {
+	data, err := p.takeKV(pollKeyPrefix + id)
+	if err != nil || data == nil {
+		return err
+	}
+
+	var pl poll
+	if err := json.Unmarshal(data, &pl); err != nil {
+		return errors.Wrap(err, "failed to unmarshal poll")
+	}
+
+	return p.syncOpenPollIndex(&pl, &poll{ID: pl.ID, Closed: true})
+}
+
+// syncOpenPollIndex updates the indexes of the open polls of their creators after a poll changed
+// from before to after.
+func (p *Plugin) syncOpenPollIndex(before, after *poll) error {
+	if !before.Closed && (after.Closed || after.CreatorID != before.CreatorID) {
+		if err := p.updateOpenPollIndex(before.CreatorID, before.ID, false); err != nil {
+			return err
+		}
+	}
+	if !after.Closed && (before.Closed || after.CreatorID != before.CreatorID) {
+		if err := p.updateOpenPollIndex(after.CreatorID, after.ID, true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateOpenPollIndex atomically adds or removes the poll from the open polls of the user.
+func (p *Plugin) updateOpenPollIndex(userID, id string, open bool) error {
+	return p.updateKV(openPollsKeyPrefix+userID, 0, func(oldValue []byte) (any, error) {
+		var ids []string
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &ids); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal open polls")
+			}
+		}
+
+		ids = slices.DeleteFunc(ids, func(pollID string) bool { return pollID == id })
+		if open {
+			ids = append(ids, id)
+		}
+		if len(ids) == 0 {
+			return nil, nil
+		}
+
+		return ids, nil
+	})
+}
+
+// listUserOpenPolls returns the open polls created by the user.
+func (p *Plugin) listUserOpenPolls(userID string) ([]*poll, error) {
+	var ids []string
+	if err := p.client.KV.Get(openPollsKeyPrefix+userID, &ids); err != nil {
+		return nil, errors.Wrap(err, "failed to get open polls")
+	}
+
+	var polls []*poll
+	for _, id := range ids {
+		pl, err := p.getPoll(id)
+		if errors.Is(err, errPollNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !pl.Closed && pl.CreatorID == userID {
+			polls = append(polls, pl)
+		}
+	}
+
+	return polls, nil
+}
+
+// refreshPollPost renders the current state of the poll in its post. The poll is read again
+// rather than passed in, so that concurrent votes don't leave a stale tally.
+func (p *Plugin) refreshPollPost(post *model.Post) error {
+	pl, err := p.getPoll(post.Id)
+	if err != nil {
+		return err
+	}
+
+	pl.apply(post)
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to update poll post")
+	}
+
+	return nil
+}
+
+// recordPollVote counts the reaction as a vote if the post is a poll and the emoji is bound to
+// one of its options. Reactions to closed polls are removed. In anonymous polls, the reaction
+// is removed once the vote is recorded, and reacting again with the same option withdraws the
+// vote. When users have a single vote, their reactions to the other options are removed.
+func (p *Plugin) recordPollVote(reaction *model.Reaction, post *model.Post) error {
+	if reaction.UserId == p.botID || post.GetProp(pollPropKey) == nil {
+		return nil
+	}
+
+	var closed, anonymous, withdrawn bool
+	var option int
+	var optionName string
+	var replaced []int
+	err := p.updatePoll(post.Id, func(pl *poll) error {
+		closed, anonymous, withdrawn, replaced = pl.Closed, pl.Anonymous, false, nil
+		option = pl.optionIndex(reaction.EmojiName)
+		if option < 0 || pl.Closed {
+			return errPollUnchanged
+		}
+		optionName = pl.Options[option]
+
+		if pl.Votes == nil {
+			pl.Votes = make(map[string][]int)
+		}
+		votes := pl.Votes[reaction.UserId]
+		switch {
+		case slices.Contains(votes, option) && pl.Anonymous:
+			pl.Votes[reaction.UserId] = slices.DeleteFunc(votes, func(o int) bool { return o == option })
+			withdrawn = true
+		case slices.Contains(votes, option):
+			return errPollUnchanged
+		case pl.Single:
+			replaced = votes
+			pl.Votes[reaction.UserId] = []int{option}
+		default:
+			pl.Votes[reaction.UserId] = append(votes, option)
+		}
+		return nil
+	})
+	if errors.Is(err, errPollNotFound) {
+		return nil
+	} else if err != nil && !errors.Is(err, errPollUnchanged) {
+		return err
+	}
+	if option < 0 {
+		return nil
+	}
+
+	ephemeral := func(message string) {
+		p.API.SendEphemeralPost(reaction.UserId, &model.Post{
+			UserId:    p.botID,
+			ChannelId: post.ChannelId,
+			RootId:    post.RootId,
+			Message:   message,
+		})
+	}
+	removeReaction := func(emoji string) {
+		if appErr := p.API.RemoveReaction(&model.Reaction{UserId: reaction.UserId, PostId: post.Id, EmojiName: emoji}); appErr != nil {
+			p.API.LogWarn("Failed to remove poll reaction", "post_id", post.Id, "err", appErr.Error())
+		}
+	}
+
+	switch {
+	case closed:
+		removeReaction(reaction.EmojiName)
+		ephemeral("This poll is closed.")
+		return nil
+	case anonymous:
+		removeReaction(reaction.EmojiName)
+		if withdrawn {
+			ephemeral(fmt.Sprintf("Withdrew your anonymous vote for **%s**.", optionName))
+		} else {
+			ephemeral(fmt.Sprintf("Recorded your anonymous vote for **%s**. React with :%s: again to withdraw it.", optionName, reaction.EmojiName))
+		}
+	default:
+		for _, previous := range replaced {
+			removeReaction(pollEmoji[previous])
+		}
+	}
+
+	if errors.Is(err, errPollUnchanged) {
+		return nil
+	}
+
+	return p.refreshPollPost(post)
+}
+
+// withdrawPollVote withdraws the vote of a removed reaction. Votes of anonymous polls, whose
+// reactions are removed as soon as they are recorded, and of closed polls are kept.
+func (p *Plugin) withdrawPollVote(reaction *model.Reaction, post *model.Post) error {
+	if reaction.UserId == p.botID || post.GetProp(pollPropKey) == nil {
+		return nil
+	}
+
+	err := p.updatePoll(post.Id, func(pl *poll) error {
+		option := pl.optionIndex(reaction.EmojiName)
+		votes := pl.Votes[reaction.UserId]
+		if option < 0 || pl.Closed || pl.Anonymous || !slices.Contains(votes, option) {
+			return errPollUnchanged
+		}
+
+		pl.Votes[reaction.UserId] = slices.DeleteFunc(votes, func(o int) bool { return o == option })
+		return nil
+	})
+	if errors.Is(err, errPollNotFound) || errors.Is(err, errPollUnchanged) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return p.refreshPollPost(post)
+}
+
+// handlePollAction closes or reopens a poll. Only the creator of the poll and the channel admins
+// can do so.
+func (p *Plugin) handlePollAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode PostActionIntegrationRequest", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	pollID := request.PostId
+	pl, err := p.getPoll(pollID)
+	if err != nil {
+		p.API.LogError("Failed to get poll of action", "poll_id", pollID, "err", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if pl.CreatorID != userID && !p.API.HasPermissionToChannel(userID, pl.ChannelID, model.PermissionManageChannelRoles) {
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "Only the creator of the poll and the channel admins can close or reopen it."})
+		return
+	}
+
+	closed := mux.Vars(r)["action"] == "close"
+	err = p.updatePoll(pollID, func(pl *poll) error {
+		if pl.Closed == closed {
+			return errPollUnchanged
+		}
+		pl.Closed = closed
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPollUnchanged) {
+		p.API.LogError("Failed to update poll", "poll_id", pollID, "err", err.Error())
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "Failed to update the poll."})
+		return
+	}
+
+	if pl, err = p.getPoll(pollID); err != nil {
+		p.API.LogError("Failed to get poll", "poll_id", pollID, "err", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	update := &model.Post{}
+	pl.apply(update)
+	p.writeJSON(w, &model.PostActionIntegrationResponse{Update: update})
+}
+
+func getCommandPollAutocompleteData() *model.AutocompleteData {
+	command := model.NewAutocompleteData(commandTriggerPoll, `[--single] [--anonymous] "Question" "Option" "Option" ...`, "Post a poll voted on with reactions.")
+	command.AddTextArgument(`--single for one vote per user, --anonymous for private votes, then the quoted question and options`, `[--single] [--anonymous] "Question" "Option" "Option" ...`, "")
+
+	return command
+}
+
+func (p *Plugin) executeCommandPoll(args *model.CommandArgs) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+	usage := `Usage: ` + "`" + `/poll [--single] [--anonymous] "Question" "Option A" "Option B" ...` + "`" + fmt.Sprintf(", with 2 to %d options. Users vote by reacting with the emoji of the options.", len(pollEmoji))
+
+	words, err := splitQuoted(strings.TrimPrefix(strings.TrimSpace(args.Command), "/"+commandTriggerPoll))
+	if err != nil {
+		return respond(fmt.Sprintf("Invalid poll: %s. %s", err.Error(), usage))
+	}
+
+	pl := &poll{
+		ChannelID: args.ChannelId,
+		CreatorID: args.UserId,
+	}
+	for len(words) > 0 && strings.HasPrefix(words[0], "--") {
+		switch words[0] {
+		case "--single":
+			pl.Single = true
+		case "--anonymous":
+			pl.Anonymous = true
+		default:
+			return respond(fmt.Sprintf("Unknown option %s. %s", words[0], usage))
+		}
+		words = words[1:]
+	}
+	if len(words) < 3 || len(words) > len(pollEmoji)+1 {
+		return respond(usage)
+	}
+	pl.Question, pl.Options = words[0], words[1:]
+
+	post := &model.Post{
+		UserId:    p.botID,
+		ChannelId: args.ChannelId,
+		RootId:    args.RootId,
+	}
+	pl.apply(post)
+	post, appErr := p.API.CreatePost(post)
+	if appErr != nil {
+		p.API.LogError("Failed to create poll post", "err", appErr.Error())
+		return respond("Failed to post the poll.")
+	}
+
+	// The id of the poll is the id of its post, so the post is deleted if the poll can't be saved.
+	pl.ID = post.Id
+	if err := p.savePoll(pl); err != nil {
+		p.API.LogError("Failed to save poll", "err", err.Error())
+		if appErr := p.API.DeletePost(post.Id); appErr != nil {
+			p.API.LogWarn("Failed to delete the post of the unsaved poll", "post_id", post.Id, "err", appErr.Error())
+		}
+		return respond("Failed to save the poll.")
+	}
+
+	for i := range pl.Options {
+		if _, appErr := p.API.AddReaction(&model.Reaction{UserId: p.botID, PostId: post.Id, EmojiName: pollEmoji[i]}); appErr != nil {
+			p.API.LogWarn("Failed to add poll reaction", "post_id", post.Id, "err", appErr.Error())
+		}
+	}
+
+	return &model.CommandResponse{}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+func TestSplitQuoted(t *testing.T) {
+	words, err := splitQuoted(` --single "Where to?"  “The beach” mountains "" `)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--single", "Where to?", "The beach", "mountains", ""}, words)
+
+	_, err = splitQuoted(`"Where to?" "The beach`)
+	assert.Error(t, err)
+}
+
+func TestPollMessage(t *testing.T) {
+	pl := &poll{
+		Question: "Lunch?",
+		Options:  []string{"Pizza", "Sushi", "Salad"},
+		Single:   true,
+		Votes:    map[string][]int{"user1": {0}, "user2": {0}, "user3": {1}, "user4": {}},
+	}
+
+	assert.Equal(t, "#### :bar_chart: Lunch?\n"+
+		":one: **Pizza**\n`███████░░░` 2 (67%)\n"+
+		":two: **Sushi**\n`███░░░░░░░` 1 (33%)\n"+
+		":three: **Salad**\n`░░░░░░░░░░` 0 (0%)\n"+
+		"\n_3 voters · one vote per user · react to vote_", pl.message())
+
+	assert.Equal(t, 2, pl.optionIndex("three"))
+	assert.Equal(t, -1, pl.optionIndex("four"))
+	assert.Equal(t, -1, pl.optionIndex("smile"))
+}
+
+func TestPolls(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	var removed []string
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		node.initializeAPI()
+		api := node.API.(*fakeNodeAPI).API
+		api.On("UpdatePost", mock.Anything).Return(&model.Post{}, nil)
+		api.On("SendEphemeralPost", mock.Anything, mock.Anything).Return(&model.Post{})
+		api.On("RemoveReaction", mock.Anything).Run(func(args mock.Arguments) {
+			reaction := args.Get(0).(*model.Reaction)
+			removed = append(removed, reaction.UserId+":"+reaction.EmojiName)
+		}).Return(nil)
+		api.On("HasPermissionToChannel", mock.Anything, "channel1", model.PermissionManageChannelRoles).Return(false)
+	}
+
+	newPoll := func(id string, single, anonymous bool) *model.Post {
+		pl := &poll{ID: id, ChannelID: "channel1", CreatorID: "creator", Question: "Lunch?", Options: []string{"Pizza", "Sushi"}, Single: single, Anonymous: anonymous}
+		require.NoError(t, node1.savePoll(pl))
+
+		post := &model.Post{Id: id, ChannelId: "channel1", UserId: "bot"}
+		pl.apply(post)
+		return post
+	}
+	react := func(node *Plugin, post *model.Post, userID, emoji string, add bool) {
+		reaction := &model.Reaction{UserId: userID, PostId: post.Id, EmojiName: emoji}
+		if add {
+			require.NoError(t, node.recordPollVote(reaction, post))
+		} else {
+			require.NoError(t, node.withdrawPollVote(reaction, post))
+		}
+	}
+	openPolls := func(userID string) []string {
+		t.Helper()
+		polls, err := node2.listUserOpenPolls(userID)
+		require.NoError(t, err)
+		var ids []string
+		for _, pl := range polls {
+			ids = append(ids, pl.ID)
+		}
+		return ids
+	}
+	votes := func(id string) map[string][]int {
+		pl, err := node2.getPoll(id)
+		require.NoError(t, err)
+		return pl.Votes
+	}
+
+	t.Run("multiple votes", func(t *testing.T) {
+		post := newPoll("poll1", false, false)
+		react(node1, post, "user1", "one", true)
+		react(node2, post, "user1", "two", true)
+		react(node2, post, "user2", "two", true)
+		react(node1, post, "user2", "smile", true)
+		react(node1, post, "bot", "one", true)
+		react(node1, post, "user2", "two", false)
+		assert.Equal(t, map[string][]int{"user1": {0, 1}, "user2": {}}, votes("poll1"))
+		assert.Empty(t, removed)
+	})
+
+	t.Run("single vote", func(t *testing.T) {
+		post := newPoll("poll2", true, false)
+		react(node1, post, "user1", "one", true)
+		react(node2, post, "user1", "two", true)
+		assert.Equal(t, []string{"user1:one"}, removed)
+		// The removal of the previous reaction doesn't withdraw the new vote.
+		react(node2, post, "user1", "one", false)
+		assert.Equal(t, map[string][]int{"user1": {1}}, votes("poll2"))
+		removed = nil
+	})
+
+	t.Run("anonymous votes", func(t *testing.T) {
+		post := newPoll("poll3", false, true)
+		react(node1, post, "user1", "two", true)
+		react(node1, post, "user1", "two", false)
+		assert.Equal(t, map[string][]int{"user1": {1}}, votes("poll3"))
+		react(node2, post, "user1", "two", true)
+		assert.Equal(t, map[string][]int{"user1": {}}, votes("poll3"), "voting again withdraws the vote")
+		assert.Equal(t, []string{"user1:two", "user1:two"}, removed)
+		removed = nil
+	})
+
+	t.Run("close and reopen", func(t *testing.T) {
+		post := newPoll("poll4", false, false)
+		act := func(userID, action string) *model.PostActionIntegrationResponse {
+			body, err := json.Marshal(&model.PostActionIntegrationRequest{UserId: userID, PostId: post.Id})
+			require.NoError(t, err)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/interactive/poll/"+action, bytes.NewReader(body))
+			r.Header.Set("Mattermost-User-ID", userID)
+			node2.ServeHTTP(nil, w, r)
+			require.Equal(t, http.StatusOK, w.Code)
+
+			var response model.PostActionIntegrationResponse
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			return &response
+		}
+
+		assert.Contains(t, act("user1", "close").EphemeralText, "Only the creator")
+
+		assert.Contains(t, openPolls("creator"), "poll4")
+		response := act("creator", "close")
+		require.NotNil(t, response.Update)
+		assert.NotContains(t, openPolls("creator"), "poll4", "closed polls aren't listed as open")
+		assert.Contains(t, response.Update.Message, "**closed**")
+		assert.Equal(t, "Reopen poll", response.Update.Attachments()[0].Actions[0].Name)
+
+		react(node1, post, "user1", "one", true)
+		assert.Empty(t, votes("poll4"))
+		assert.Equal(t, []string{"user1:one"}, removed)
+
+		response = act("creator", "reopen")
+		require.NotNil(t, response.Update)
+		assert.NotContains(t, response.Update.Message, "**closed**")
+		react(node1, post, "user1", "one", true)
+		assert.Equal(t, map[string][]int{"user1": {0}}, votes("poll4"))
+		assert.Contains(t, openPolls("creator"), "poll4")
+	})
+
+	t.Run("deleted with their post", func(t *testing.T) {
+		assert.Equal(t, []string{"poll1", "poll2", "poll3", "poll4"}, openPolls("creator"))
+
+		require.NoError(t, node1.deletePoll("poll1"))
+		require.NoError(t, node1.deletePoll("poll1"))
+		assert.NotContains(t, cluster.kv, pollKeyPrefix+"poll1")
+		assert.Equal(t, []string{"poll2", "poll3", "poll4"}, openPolls("creator"))
+	})
+}
+
+func TestPollPostDeletedIfUnsaved(t *testing.T) {
+	node := &Plugin{botID: "bot"}
+	api := &plugintest.API{}
+	node.SetAPI(api)
+	node.client = pluginapi.NewClient(api, nil)
+
+	api.On("CreatePost", mock.Anything).Return(&model.Post{Id: "post1"}, nil)
+	api.On("KVSetWithOptions", pollKeyPrefix+"post1", mock.Anything, mock.Anything).Return(false, &model.AppError{Message: "unavailable"})
+	api.On("LogError", "Failed to save poll", "err", mock.Anything)
+	api.On("DeletePost", "post1").Return(nil).Once()
+
+	response := node.executeCommandPoll(&model.CommandArgs{UserId: "user1", ChannelId: "channel1", Command: `/poll "Lunch?" "Pizza" "Sushi"`})
+	assert.Equal(t, "Failed to save the poll.", response.Text)
+	api.AssertExpectations(t)
+}
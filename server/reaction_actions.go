@@ -0,0 +1,577 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	// reactionActionsKey is the KV key of the list of emoji mapped to actions.
+	reactionActionsKey = "reaction_actions"
+
+	// reactionActionsClusterEventID identifies the cluster events notifying the other plugin
+	// instances that the reaction actions changed, so that they reload them.
+	reactionActionsClusterEventID = "reaction_actions_changed"
+
+	// reactionActionDoneKeyPrefix prefixes the KV keys recording that an action ran for a post,
+	// followed by the id of the mapping and the id of the post, so that the actions meant to run
+	// once per post don't run again when other users add the same reaction.
+	reactionActionDoneKeyPrefix = "reaction_action_done_"
+	reactionActionDoneRetention = 30 * 24 * time.Hour
+
+	reactionActionPin       = "pin"
+	reactionActionCopy      = "copy"
+	reactionActionRemind    = "remind"
+	reactionActionChecklist = "checklist"
+	reactionActionNotify    = "notify"
+
+	defaultReactionReminderDelay = 24 * time.Hour
+
+	maxReactionActions = 50
+)
+
+var (
+	reactionActions = []string{reactionActionPin, reactionActionCopy, reactionActionRemind, reactionActionChecklist, reactionActionNotify}
+
+	emojiNameRegexp = regexp.MustCompile(`^[a-z0-9_+-]+$`)
+)
+
+// reactionAction maps an emoji to an action run when the emoji is added to a post, in every
+// channel or only in the channel it is scoped to.
+type reactionAction struct {
+	ID        string `json:"id"`
+	Emoji     string `json:"emoji"`
+	Action    string `json:"action"`
+	ChannelID string `json:"channel_id,omitempty"`
+	CreatedBy string `json:"created_by,omitempty"`
+
+	// TargetChannelID is the channel posts are copied to.
+	TargetChannelID string `json:"target_channel_id,omitempty"`
+
+	// TargetUserID is the user notified.
+	TargetUserID string `json:"target_user_id,omitempty"`
+
+	// Delay is the delay of the follow-up reminder, e.g. 1d.
+	Delay string `json:"delay,omitempty"`
+
+	// Items are the items of the checklist.
+	Items []string `json:"items,omitempty"`
+}
+
+// validate checks that the action has the arguments it needs.
+func (a *reactionAction) validate() error {
+	if !emojiNameRegexp.MatchString(a.Emoji) {
+		return errors.Errorf("invalid emoji %q", a.Emoji)
+	}
+
+	switch a.Action {
+	case reactionActionPin:
+	case reactionActionCopy:
+		if a.TargetChannelID == "" {
+			return errors.New("copy needs the channel to copy posts to")
+		}
+	case reactionActionRemind:
+		if a.Delay != "" {
+			if _, ok := parseRelativeDuration(a.Delay); !ok {
+				return errors.Errorf("invalid delay %q, expected e.g. 2h or 1d", a.Delay)
+			}
+		}
+	case reactionActionChecklist:
+		if len(a.Items) == 0 {
+			return errors.New("checklist needs items separated by ;")
+		}
+	case reactionActionNotify:
+		if a.TargetUserID == "" {
+			return errors.New("notify needs the user to notify")
+		}
+	default:
+		return errors.Errorf("unknown action %q, expected one of %s", a.Action, strings.Join(reactionActions, ", "))
+	}
+
+	return nil
+}
+
+// reminderDelay returns the delay of the follow-up reminder, a day by default.
+func (a *reactionAction) reminderDelay() time.Duration {
+	if delay, ok := parseRelativeDuration(a.Delay); ok {
+		return delay
+	}
+	return defaultReactionReminderDelay
+}
+
+// findReactionAction returns the action the emoji is mapped to in the channel, preferring the
+// mappings scoped to the channel over the global ones, or nil.
+func findReactionAction(actions []*reactionAction, channelID, emoji string) *reactionAction {
+	var global *reactionAction
+	for _, action := range actions {
+		if action.Emoji != emoji {
+			continue
+		}
+		if action.ChannelID == channelID {
+			return action
+		}
+		if action.ChannelID == "" {
+			global = action
+		}
+	}
+
+	return global
+}
+
+// getReactionActions returns the reaction actions, loading them from the KV store the first
+// time.
+func (p *Plugin) getReactionActions() ([]*reactionAction, error) {
+	p.reactionActionsLock.RLock()
+	actions, loaded := p.reactionActions, p.reactionActionsLoaded
+	p.reactionActionsLock.RUnlock()
+
+	if loaded {
+		return actions, nil
+	}
+
+	return p.loadReactionActions()
+}
+
+// loadReactionActions reloads the reaction actions from the KV store.
+func (p *Plugin) loadReactionActions() ([]*reactionAction, error) {
+	var data []byte
+	if err := p.client.KV.Get(reactionActionsKey, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get reaction actions")
+	}
+
+	actions, err := unmarshalReactionActions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p.reactionActionsLock.Lock()
+	p.reactionActions = actions
+	p.reactionActionsLoaded = true
+	p.reactionActionsLock.Unlock()
+
+	return actions, nil
+}
+
+func unmarshalReactionActions(data []byte) ([]*reactionAction, error) {
+	var actions []*reactionAction
+	if len(data) == 0 {
+		return actions, nil
+	}
+
+	if err := json.Unmarshal(data, &actions); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal reaction actions")
+	}
+
+	return actions, nil
+}
+
+// updateReactionActions atomically applies the given change to the stored reaction actions,
+// then reloads them locally and notifies the other plugin instances.
+func (p *Plugin) updateReactionActions(change func(actions []*reactionAction) ([]*reactionAction, error)) error {
+	err := p.updateKV(reactionActionsKey, 0, func(oldValue []byte) (any, error) {
+		actions, err := unmarshalReactionActions(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		return change(actions)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save reaction actions")
+	}
+
+	if _, err := p.loadReactionActions(); err != nil {
+		return err
+	}
+
+	if err := p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id: reactionActionsClusterEventID,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	}); err != nil {
+		p.API.LogWarn("Failed to broadcast reaction actions change", "err", err.Error())
+	}
+
+	return nil
+}
+
+// claimReactionAction records that the action ran for the post, returning false if it already
+// did, on this or another plugin instance.
+func (p *Plugin) claimReactionAction(action *reactionAction, postID string) (bool, error) {
+	claimed, err := p.client.KV.Set(reactionActionDoneKeyPrefix+action.ID+"_"+postID, true, pluginapi.SetAtomic(nil), pluginapi.SetExpiry(reactionActionDoneRetention))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to record reaction action")
+	}
+
+	return claimed, nil
+}
+
+// releaseReactionAction forgets that the action ran for the post, so that it runs again on the
+// next reaction.
+func (p *Plugin) releaseReactionAction(action *reactionAction, postID string) error {
+	if err := p.client.KV.Delete(reactionActionDoneKeyPrefix + action.ID + "_" + postID); err != nil {
+		return errors.Wrap(err, "failed to release reaction action")
+	}
+
+	return nil
+}
+
+// runReactionAction runs the action the emoji of the reaction is mapped to, if any. Copies,
+// checklists and notifications are made once per post, pins are idempotent and reminders are
+// set for each user reacting.
+func (p *Plugin) runReactionAction(reaction *model.Reaction, user *model.User, post *model.Post, channel *model.Channel) error {
+	if reaction.UserId == p.botID {
+		return nil
+	}
+
+	actions, err := p.getReactionActions()
+	if err != nil {
+		return err
+	}
+	action := findReactionAction(actions, post.ChannelId, reaction.EmojiName)
+	if action == nil {
+		return nil
+	}
+
+	claimed := false
+	if action.Action != reactionActionPin && action.Action != reactionActionRemind {
+		claimed, err = p.claimReactionAction(action, post.Id)
+		if err != nil || !claimed {
+			return err
+		}
+	}
+
+	err = p.applyReactionAction(action, reaction, user, post, channel)
+	if err != nil && claimed {
+		// Let the next reaction retry the action.
+		if releaseErr := p.releaseReactionAction(action, post.Id); releaseErr != nil {
+			p.API.LogWarn("Failed to release reaction action", "action_id", action.ID, "post_id", post.Id, "err", releaseErr.Error())
+		}
+	}
+
+	return err
+}
+
+// applyReactionAction runs the action for the reaction.
+func (p *Plugin) applyReactionAction(action *reactionAction, reaction *model.Reaction, user *model.User, post *model.Post, channel *model.Channel) error {
+	permalink := p.permalink(post.Id)
+	location := "~" + channel.Name
+	if channel.IsGroupOrDirect() {
+		location = "a direct message"
+	}
+
+	switch action.Action {
+	case reactionActionPin:
+		if post.IsPinned {
+			return nil
+		}
+		post.IsPinned = true
+		if _, appErr := p.API.UpdatePost(post); appErr != nil {
+			return errors.Wrap(appErr, "failed to pin post")
+		}
+
+	case reactionActionCopy:
+		// Global mappings don't copy posts out of private channels, whose members may not
+		// expect them to be shared.
+		if action.ChannelID == "" && channel.Type != model.ChannelTypeOpen {
+			p.API.SendEphemeralPost(user.Id, &model.Post{
+				UserId:    p.botID,
+				ChannelId: post.ChannelId,
+				RootId:    post.RootId,
+				Message:   fmt.Sprintf(":%s: doesn't copy posts out of private channels.", reaction.EmojiName),
+			})
+			return nil
+		}
+
+		copied := &model.Post{
+			UserId:    p.botID,
+			ChannelId: action.TargetChannelID,
+			Message:   fmt.Sprintf("Copied by @%s from %s: %s\n\n%s", user.Username, location, permalink, quoteMarkdown(post.Message)),
+		}
+		if len(post.FileIds) > 0 {
+			fileIDs, appErr := p.API.CopyFileInfos(p.botID, post.FileIds)
+			if appErr != nil {
+				return errors.Wrap(appErr, "failed to copy files")
+			}
+			copied.FileIds = fileIDs
+		}
+		if _, appErr := p.API.CreatePost(copied); appErr != nil {
+			return errors.Wrap(appErr, "failed to copy post")
+		}
+
+	case reactionActionRemind:
+		remindAt := time.Now().Add(action.reminderDelay())
+		if err := p.saveReminder(&reminder{
+			ID:        model.NewId(),
+			CreatorID: user.Id,
+			UserID:    user.Id,
+			Message:   fmt.Sprintf("Follow up on %s: %s", permalink, excerpt(post.Message, postExcerptLength)),
+			RemindAt:  remindAt.UnixMilli(),
+			CreatedAt: model.GetMillis(),
+		}); err != nil {
+			return err
+		}
+		p.API.SendEphemeralPost(user.Id, &model.Post{
+			UserId:    p.botID,
+			ChannelId: post.ChannelId,
+			RootId:    post.RootId,
+			Message:   fmt.Sprintf("I will remind you to follow up on this post on %s.", remindAt.In(p.userLocation(user.Id)).Format("Mon Jan 2 2006 15:04 MST")),
+		})
+
+	case reactionActionChecklist:
+		rootID := post.RootId
+		if rootID == "" {
+			rootID = post.Id
+		}
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "Checklist started by @%s:\n", user.Username)
+		for _, item := range action.Items {
+			fmt.Fprintf(&sb, "- [ ] %s\n", item)
+		}
+		if _, appErr := p.API.CreatePost(&model.Post{
+			UserId:    p.botID,
+			ChannelId: post.ChannelId,
+			RootId:    rootID,
+			Message:   sb.String(),
+		}); appErr != nil {
+			return errors.Wrap(appErr, "failed to post checklist")
+		}
+
+	case reactionActionNotify:
+		// Likewise, global mappings don't notify about posts in private channels, which the user
+		// notified may not be a member of.
+		if action.ChannelID == "" && channel.Type != model.ChannelTypeOpen {
+			p.API.SendEphemeralPost(user.Id, &model.Post{
+				UserId:    p.botID,
+				ChannelId: post.ChannelId,
+				RootId:    post.RootId,
+				Message:   fmt.Sprintf(":%s: doesn't notify about posts in private channels.", reaction.EmojiName),
+			})
+			return nil
+		}
+
+		dm, appErr := p.API.GetDirectChannel(action.TargetUserID, p.botID)
+		if appErr != nil {
+			return errors.Wrapf(appErr, "failed to get direct channel of user %s", action.TargetUserID)
+		}
+		if _, appErr := p.API.CreatePost(&model.Post{
+			UserId:    p.botID,
+			ChannelId: dm.Id,
+			Message:   fmt.Sprintf("@%s reacted with :%s: to a post in %s: %s", user.Username, reaction.EmojiName, location, permalink),
+		}); appErr != nil {
+			return errors.Wrap(appErr, "failed to notify user")
+		}
+	}
+
+	return nil
+}
+
+// executeCommandReactions lists, maps and unmaps the reaction actions. Global mappings are
+// managed by system admins, and mappings scoped to a channel with --channel [~channel] by the
+// admins of the channel.
+func (p *Plugin) executeCommandReactions(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if len(params) == 0 || (params[0] == "list" && len(params) == 1) {
+		actions, err := p.getReactionActions()
+		if err != nil {
+			p.API.LogError("Failed to get reaction actions", "err", err.Error())
+			return respond("Failed to get the reaction actions.")
+		}
+		return respond(p.reactionActionsSummary(actions))
+	}
+
+	command := params[0]
+	if (command != "map" && command != "unmap") || len(params) < 2 {
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+	params = params[1:]
+
+	// The mapping is global unless scoped with --channel, to the current channel or the one named.
+	scopeID := ""
+	if i := slices.Index(params, "--channel"); i >= 0 {
+		scopeID = args.ChannelId
+		end := i + 1
+		if end < len(params) && strings.HasPrefix(params[end], "~") {
+			channel, err := p.findChannel(args.TeamId, params[end])
+			if err != nil {
+				return respond(fmt.Sprintf("Unknown channel %s.", params[end]))
+			}
+			scopeID = channel.Id
+			end++
+		}
+		params = slices.Delete(params, i, end)
+	}
+
+	if scopeID == "" && !p.isSystemAdmin(args.UserId) {
+		return respond("Only system admins can map reactions in every channel. Channel admins can map them in their channels with `--channel`.")
+	}
+	if scopeID != "" && !p.API.HasPermissionToChannel(args.UserId, scopeID, model.PermissionManageChannelRoles) {
+		return respond("Only channel admins can map reactions in the channel.")
+	}
+
+	if len(params) == 0 {
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+	emoji := strings.ToLower(strings.Trim(params[0], ":"))
+
+	same := func(other *reactionAction) bool {
+		return other.Emoji == emoji && other.ChannelID == scopeID
+	}
+
+	if command == "unmap" {
+		if len(params) != 1 {
+			return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+		}
+		errNotFound := errors.New("not mapped")
+		err := p.updateReactionActions(func(actions []*reactionAction) ([]*reactionAction, error) {
+			i := slices.IndexFunc(actions, same)
+			if i < 0 {
+				return nil, errNotFound
+			}
+			return slices.Delete(actions, i, i+1), nil
+		})
+		if errors.Is(err, errNotFound) {
+			return respond(fmt.Sprintf(":%s: isn't mapped to an action here.", emoji))
+		} else if err != nil {
+			p.API.LogError("Failed to unmap reaction", "err", err.Error())
+			return respond("Failed to unmap the reaction.")
+		}
+		return respond(fmt.Sprintf("Unmapped :%s:.", emoji))
+	}
+
+	if len(params) < 2 {
+		return respond(fmt.Sprintf("Usage: `/demo_plugin reactions map :emoji: <%s> [args] [--channel [~channel]]`.", strings.Join(reactionActions, "|")))
+	}
+	action := &reactionAction{
+		ID:        model.NewId(),
+		Emoji:     emoji,
+		Action:    params[1],
+		ChannelID: scopeID,
+		CreatedBy: args.UserId,
+	}
+	actionArgs := params[2:]
+
+	switch action.Action {
+	case reactionActionCopy:
+		if len(actionArgs) != 1 {
+			return respond("Usage: `/demo_plugin reactions map :emoji: copy ~channel`.")
+		}
+		target, err := p.findChannel(args.TeamId, actionArgs[0])
+		if err != nil {
+			return respond(fmt.Sprintf("Unknown channel %s.", actionArgs[0]))
+		}
+		if !p.API.HasPermissionToChannel(args.UserId, target.Id, model.PermissionCreatePost) {
+			return respond(fmt.Sprintf("You can't post in ~%s.", target.Name))
+		}
+		action.TargetChannelID = target.Id
+	case reactionActionNotify:
+		if len(actionArgs) != 1 {
+			return respond("Usage: `/demo_plugin reactions map :emoji: notify @user`.")
+		}
+		target, appErr := p.API.GetUserByUsername(strings.TrimPrefix(actionArgs[0], "@"))
+		if appErr != nil {
+			return respond(fmt.Sprintf("Unknown user %s.", actionArgs[0]))
+		}
+		action.TargetUserID = target.Id
+	case reactionActionRemind:
+		if len(actionArgs) > 1 {
+			return respond("Usage: `/demo_plugin reactions map :emoji: remind [delay]`.")
+		}
+		if len(actionArgs) == 1 {
+			action.Delay = actionArgs[0]
+		}
+	case reactionActionChecklist:
+		for _, item := range strings.Split(strings.Join(actionArgs, " "), ";") {
+			if item = strings.TrimSpace(item); item != "" {
+				action.Items = append(action.Items, item)
+			}
+		}
+	default:
+		if len(actionArgs) > 0 {
+			return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+		}
+	}
+
+	if err := action.validate(); err != nil {
+		return respond(fmt.Sprintf("Invalid mapping: %s.", err.Error()))
+	}
+
+	var invalid error
+	err := p.updateReactionActions(func(actions []*reactionAction) ([]*reactionAction, error) {
+		actions = slices.DeleteFunc(actions, same)
+		if len(actions) >= maxReactionActions {
+			invalid = errors.Errorf("there can't be more than %d mappings", maxReactionActions)
+			return nil, invalid
+		}
+		return append(actions, action), nil
+	})
+	if invalid != nil {
+		return respond(fmt.Sprintf("Failed to map :%s:: %s.", emoji, invalid.Error()))
+	} else if err != nil {
+		p.API.LogError("Failed to map reaction", "err", err.Error())
+		return respond("Failed to map the reaction.")
+	}
+
+	return respond(fmt.Sprintf("Mapped :%s: to %s.", emoji, p.describeReactionAction(action)))
+}
+
+// describeReactionAction describes the action and its arguments in Markdown.
+func (p *Plugin) describeReactionAction(action *reactionAction) string {
+	switch action.Action {
+	case reactionActionCopy:
+		target := action.TargetChannelID
+		if channel, appErr := p.API.GetChannel(action.TargetChannelID); appErr == nil {
+			target = "~" + channel.Name
+		}
+		return "copy to " + target
+	case reactionActionNotify:
+		target := action.TargetUserID
+		if user, appErr := p.API.GetUser(action.TargetUserID); appErr == nil {
+			target = "@" + user.Username
+		}
+		return "notify " + target
+	case reactionActionRemind:
+		return fmt.Sprintf("remind after %s", action.reminderDelay())
+	case reactionActionChecklist:
+		return fmt.Sprintf("checklist `%s`", strings.Join(action.Items, "; "))
+	default:
+		return action.Action
+	}
+}
+
+// reactionActionsSummary lists the reaction actions in Markdown.
+func (p *Plugin) reactionActionsSummary(actions []*reactionAction) string {
+	if len(actions) == 0 {
+		return "No reaction is mapped to an action. Use `/demo_plugin reactions map :emoji: <action> [args] [--channel [~channel]]` to map one."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Emoji | Action | Channel |\n|:------|:-------|:--------|\n")
+	for _, action := range actions {
+		scope := "every channel"
+		if action.ChannelID != "" {
+			scope = fmt.Sprintf("`%s`", action.ChannelID)
+			if channel, appErr := p.API.GetChannel(action.ChannelID); appErr == nil {
+				scope = "~" + channel.Name
+			}
+		}
+		fmt.Fprintf(&sb, "| :%s: | %s | %s |\n", action.Emoji, p.describeReactionAction(action), scope)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestFindReactionAction(t *testing.T) {
+	global := &reactionAction{Emoji: "pushpin", Action: reactionActionPin}
+	scoped := &reactionAction{Emoji: "pushpin", Action: reactionActionChecklist, ChannelID: "channel1"}
+	actions := []*reactionAction{scoped, global}
+
+	assert.Equal(t, scoped, findReactionAction(actions, "channel1", "pushpin"))
+	assert.Equal(t, global, findReactionAction(actions, "channel2", "pushpin"))
+	assert.Nil(t, findReactionAction(actions, "channel1", "smile"))
+
+	for _, action := range []*reactionAction{
+		{Emoji: ":x:", Action: reactionActionPin},
+		{Emoji: "x", Action: "explode"},
+		{Emoji: "x", Action: reactionActionCopy},
+		{Emoji: "x", Action: reactionActionNotify},
+		{Emoji: "x", Action: reactionActionChecklist},
+		{Emoji: "x", Action: reactionActionRemind, Delay: "soon"},
+	} {
+		assert.Error(t, action.validate(), action)
+	}
+}
+
+func TestReactionActions(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	var posts []*model.Post
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		api := node.API.(*fakeNodeAPI).API
+		api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer("http://localhost")}})
+		api.On("HasPermissionTo", "admin", model.PermissionManageSystem).Return(true)
+		api.On("HasPermissionTo", mock.Anything, model.PermissionManageSystem).Return(false)
+		api.On("HasPermissionToChannel", "admin", mock.Anything, mock.Anything).Return(true)
+		api.On("HasPermissionToChannel", "lead", "channel1", model.PermissionManageChannelRoles).Return(true)
+		api.On("HasPermissionToChannel", mock.Anything, mock.Anything, model.PermissionManageChannelRoles).Return(false)
+		api.On("GetChannel", "archive").Return(&model.Channel{Id: "archive", Name: "archive"}, nil)
+		api.On("GetChannelByName", "team1", "archive", false).Return(&model.Channel{Id: "archive", Name: "archive"}, nil)
+		api.On("SendEphemeralPost", mock.Anything, mock.Anything).Return(&model.Post{})
+		api.On("GetUserByUsername", "john").Return(&model.User{Id: "user3", Username: "john"}, nil)
+		api.On("GetUser", "user3").Return(&model.User{Id: "user3", Username: "john"}, nil)
+		if node == node1 {
+			api.On("GetDirectChannel", "user3", "bot").Return(nil, model.NewAppError("GetDirectChannel", "unavailable", nil, "", http.StatusInternalServerError)).Once()
+		}
+		api.On("GetDirectChannel", "user3", "bot").Return(&model.Channel{Id: "dm3"}, nil)
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+	}
+
+	command := func(node *Plugin, userID, channelID, command string) string {
+		args := &model.CommandArgs{UserId: userID, ChannelId: channelID, TeamId: "team1", Command: command}
+		return node.executeCommandHooks(args).Text
+	}
+
+	assert.Contains(t, command(node1, "lead", "channel1", "/demo_plugin reactions map :inbox_tray: copy ~archive"), "Only system admins")
+	assert.Equal(t, "Mapped :inbox_tray: to copy to ~archive.", command(node1, "admin", "channel1", "/demo_plugin reactions map :inbox_tray: copy ~archive"))
+	assert.Equal(t, "Mapped :white_check_mark: to checklist `review; merge`.", command(node1, "lead", "channel1", "/demo_plugin reactions map :white_check_mark: checklist review;  merge --channel"))
+	assert.Contains(t, command(node1, "lead", "channel2", "/demo_plugin reactions map :x: pin --channel"), "Only channel admins")
+
+	react := func(node *Plugin, userID, emoji string, channel *model.Channel) {
+		post := &model.Post{Id: "post_" + channel.Id, ChannelId: channel.Id, Message: "ship it"}
+		require.NoError(t, node.runReactionAction(
+			&model.Reaction{UserId: userID, PostId: post.Id, EmojiName: emoji},
+			&model.User{Id: userID, Username: userID},
+			post,
+			channel,
+		))
+	}
+	town := &model.Channel{Id: "channel1", Name: "town-square", Type: model.ChannelTypeOpen}
+	private := &model.Channel{Id: "channel2", Name: "secret", Type: model.ChannelTypePrivate}
+
+	t.Run("once per post across the cluster", func(t *testing.T) {
+		posts = nil
+		react(node2, "user1", "inbox_tray", town)
+		react(node1, "user2", "inbox_tray", town)
+		react(node2, "user1", "white_check_mark", town)
+		react(node2, "user1", "white_check_mark", private)
+
+		require.Len(t, posts, 2)
+		assert.Equal(t, "archive", posts[0].ChannelId)
+		assert.Contains(t, posts[0].Message, "Copied by @user1 from ~town-square: http://localhost/_redirect/pl/post_channel1\n\n> ship it")
+		assert.Equal(t, "post_channel1", posts[1].RootId)
+		assert.Equal(t, "Checklist started by @user1:\n- [ ] review\n- [ ] merge\n", posts[1].Message)
+	})
+
+	t.Run("global mappings don't copy out of private channels", func(t *testing.T) {
+		posts = nil
+		react(node1, "user1", "inbox_tray", private)
+		assert.Empty(t, posts)
+	})
+
+	assert.Equal(t, "Mapped :bell: to notify @john.", command(node1, "admin", "channel1", "/demo_plugin reactions map :bell: notify @john"))
+
+	t.Run("failed actions run again", func(t *testing.T) {
+		posts = nil
+		post := &model.Post{Id: "post_channel1", ChannelId: "channel1", Message: "ship it"}
+		err := node1.runReactionAction(&model.Reaction{UserId: "user1", PostId: post.Id, EmojiName: "bell"}, &model.User{Id: "user1", Username: "user1"}, post, town)
+		require.Error(t, err)
+		assert.Empty(t, posts)
+
+		react(node2, "user2", "bell", town)
+		react(node1, "user1", "bell", town)
+		require.Len(t, posts, 1)
+		assert.Equal(t, "dm3", posts[0].ChannelId)
+		assert.Equal(t, "@user2 reacted with :bell: to a post in ~town-square: http://localhost/_redirect/pl/post_channel1", posts[0].Message)
+	})
+
+	t.Run("global mappings don't notify about private channels", func(t *testing.T) {
+		posts = nil
+		react(node2, "user1", "bell", private)
+		assert.Empty(t, posts)
+	})
+
+	t.Run("unmap", func(t *testing.T) {
+		assert.Equal(t, "Unmapped :inbox_tray:.", command(node2, "admin", "channel1", "/demo_plugin reactions unmap :inbox_tray:"))
+		actions, err := node1.getReactionActions()
+		require.NoError(t, err)
+		require.Len(t, actions, 2)
+		assert.Equal(t, "white_check_mark", actions[0].Emoji)
+	})
+}
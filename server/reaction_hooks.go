@@ -12,11 +12,19 @@ import (
 // Note that this method will be called for reactions added by plugins, including the plugin that
 // added the reaction.
 //
-// This demo implementation logs a message to the demo channel whenever a reaction is added to a post.
+// This demo implementation logs a message to the demo channel whenever a reaction is added to a post,
+// runs the action the emoji is mapped to, if any, counts votes on polls and karma.
 func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reaction) {
+	event := newHookEvent(hookReactionHasBeenAdded)
+	event.UserID = reaction.UserId
+	event.PostID = reaction.PostId
+	event.ChannelID = reaction.ChannelId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookReactionHasBeenAdded) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -27,6 +35,7 @@ func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reactio
 			"user_id", reaction.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -37,6 +46,7 @@ func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reactio
 			"post_id", reaction.PostId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -47,18 +57,57 @@ func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reactio
 			"channel_id", post.ChannelId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
+	event.TeamID = channel.TeamId
+
+	postURL := p.permalink(reaction.PostId)
+	data := hookTemplateData{
+		User:      newTemplateUser(user),
+		Channel:   newTemplateChannel(channel),
+		Post:      newTemplatePost(post),
+		Emoji:     reaction.EmojiName,
+		Permalink: postURL,
+	}
+
+	if err := p.runReactionAction(reaction, user, post, channel); err != nil {
+		p.API.LogError(
+			"Failed to run reaction action",
+			"post_id", post.Id,
+			"emoji", reaction.EmojiName,
+			"error", err.Error(),
+		)
+		event.fail(err)
+	}
+
+	if err := p.recordPollVote(reaction, post); err != nil {
+		p.API.LogError(
+			"Failed to record poll vote",
+			"post_id", post.Id,
+			"error", err.Error(),
+		)
+		event.fail(err)
+	}
+
+	if err := p.recordKarma(reaction, post, channel); err != nil {
+		p.API.LogError(
+			"Failed to record karma",
+			"post_id", post.Id,
+			"error", err.Error(),
+		)
+		event.fail(err)
+	}
 
-	postURL := fmt.Sprintf("%s/_redirect/pl/%s", *p.API.GetConfig().ServiceSettings.SiteURL, reaction.PostId)
 	msg := fmt.Sprintf("ReactionHasBeenAdded: @%s, :%s:, [<jump to convo>](%s)", user.Username, reaction.EmojiName, postURL)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookReactionHasBeenAdded, channel.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post ReactionHasBeenAdded message",
 			"channel_id", channel.Id,
 			"user_id", user.Id,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
 
@@ -67,11 +116,19 @@ func (p *Plugin) ReactionHasBeenAdded(c *plugin.Context, reaction *model.Reactio
 // Note that this method will be called for reactions removed by plugins, including the plugin that
 // removed the reaction.
 //
-// This demo implementation logs a message to the demo channel whenever reaction is removed from a post.
+// This demo implementation logs a message to the demo channel whenever reaction is removed from a post,
+// withdraws votes on polls and reverses karma.
 func (p *Plugin) ReactionHasBeenRemoved(c *plugin.Context, reaction *model.Reaction) {
+	event := newHookEvent(hookReactionHasBeenRemoved)
+	event.UserID = reaction.UserId
+	event.PostID = reaction.PostId
+	event.ChannelID = reaction.ChannelId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookReactionHasBeenRemoved) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -82,6 +139,7 @@ func (p *Plugin) ReactionHasBeenRemoved(c *plugin.Context, reaction *model.React
 			"user_id", reaction.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -92,6 +150,7 @@ func (p *Plugin) ReactionHasBeenRemoved(c *plugin.Context, reaction *model.React
 			"post_id", reaction.PostId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
@@ -102,17 +161,46 @@ func (p *Plugin) ReactionHasBeenRemoved(c *plugin.Context, reaction *model.React
 			"channel_id", post.ChannelId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
+	event.TeamID = channel.TeamId
+
+	postURL := p.permalink(reaction.PostId)
+	data := hookTemplateData{
+		User:      newTemplateUser(user),
+		Channel:   newTemplateChannel(channel),
+		Post:      newTemplatePost(post),
+		Emoji:     reaction.EmojiName,
+		Permalink: postURL,
+	}
+
+	if err := p.withdrawPollVote(reaction, post); err != nil {
+		p.API.LogError(
+			"Failed to withdraw poll vote",
+			"post_id", post.Id,
+			"error", err.Error(),
+		)
+		event.fail(err)
+	}
+
+	if err := p.reverseKarma(reaction); err != nil {
+		p.API.LogError(
+			"Failed to reverse karma",
+			"post_id", post.Id,
+			"error", err.Error(),
+		)
+		event.fail(err)
+	}
 
-	postURL := fmt.Sprintf("%s/_redirect/pl/%s", *p.API.GetConfig().ServiceSettings.SiteURL, reaction.PostId)
 	msg := fmt.Sprintf("ReactionHasBeenRemoved: @%s, :%s:, [<jump to convo>](%s)", user.Username, reaction.EmojiName, postURL)
-	if err := p.postPluginMessage(channel.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookReactionHasBeenRemoved, channel.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post ReactionHasBeenRemoved message",
 			"channel_id", channel.Id,
 			"user_id", user.Id,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
@@ -0,0 +1,801 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	// redactionPolicyKeyPrefix prefixes the KV keys of the per team redaction policies.
+	redactionPolicyKeyPrefix = "redaction_policy_"
+
+	// redactionPolicyClusterEventID identifies the cluster events notifying the other plugin
+	// instances that the redaction policy of a team changed.
+	redactionPolicyClusterEventID = "redaction_policy_changed"
+
+	// redactionAuditKeyPrefix prefixes the KV keys of the redaction audit records. Keys embed the
+	// team and a zero padded timestamp.
+	redactionAuditKeyPrefix = "redaction_audit_"
+
+	// redactionAuditIndexKeyPrefix prefixes the KV keys of the per team indexes of the audit
+	// records, so that listing them doesn't scan the KV store.
+	redactionAuditIndexKeyPrefix = "redaction_audit_index_"
+
+	redactionAuditRetention = 90 * 24 * time.Hour
+	redactionAuditPageSize  = 20
+
+	// redactionAuditIndexSize is the maximum number of records indexed per team, the oldest ones
+	// being dropped first.
+	redactionAuditIndexSize = 1000
+)
+
+const (
+	redactionActionOff    = "off"
+	redactionActionMask   = "mask"
+	redactionActionReject = "reject"
+	redactionActionRoute  = "route"
+)
+
+var redactionActions = []string{
+	redactionActionOff,
+	redactionActionMask,
+	redactionActionReject,
+	redactionActionRoute,
+}
+
+// Sources of the redacted content, recorded in the audit records.
+const (
+	redactionSourcePost       = "post"
+	redactionSourcePostUpdate = "post_update"
+	redactionSourceDialog     = "dialog"
+)
+
+// piiDetector finds a kind of personal data in text.
+type piiDetector struct {
+	Name string
+
+	re *regexp.Regexp
+
+	// group is the capture group holding the personal data, when the pattern also matches
+	// surrounding text.
+	group int
+
+	// valid optionally checks a match, e.g. the checksum of a card number.
+	valid func(match string) bool
+}
+
+// builtinPIIDetectors are the detectors available to every team, in priority order: a card
+// number isn't reported as a phone number as well.
+var builtinPIIDetectors = []*piiDetector{
+	{
+		Name: "email",
+		re:   regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`),
+	},
+	{
+		Name:  "credit_card",
+		re:    regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`),
+		valid: luhnValid,
+	},
+	{
+		Name:  "iban",
+		re:    regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`),
+		valid: ibanValid,
+	},
+	{
+		Name:  "phone",
+		re:    regexp.MustCompile(`(?:^|[^\w+])(\+?\(?\d[\d ().\-]{6,}\d)\b`),
+		group: 1,
+		valid: func(match string) bool {
+			digits := len(onlyDigits(match))
+			return digits >= 9 && digits <= 15
+		},
+	},
+}
+
+var customPatternNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func onlyDigits(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+// luhnValid reports whether the digits of s pass the Luhn checksum of card numbers.
+func luhnValid(s string) bool {
+	digits := onlyDigits(s)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	for i := range len(digits) {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
+
+// ibanValid reports whether s, ignoring spaces, passes the mod 97 checksum of IBANs.
+func ibanValid(s string) bool {
+	iban := strings.ReplaceAll(s, " ", "")
+	if len(iban) < 15 || len(iban) > 34 {
+		return false
+	}
+
+	var numeric strings.Builder
+	for _, r := range iban[4:] + iban[:4] {
+		switch {
+		case r >= '0' && r <= '9':
+			numeric.WriteRune(r)
+		case r >= 'A' && r <= 'Z':
+			fmt.Fprintf(&numeric, "%d", r-'A'+10)
+		default:
+			return false
+		}
+	}
+
+	n, ok := new(big.Int).SetString(numeric.String(), 10)
+	if !ok {
+		return false
+	}
+
+	return new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// piiFinding locates personal data found in text.
+type piiFinding struct {
+	Detector   string
+	Start, End int
+}
+
+// findPII returns the non overlapping personal data found by the detectors, in order of
+// appearance. Earlier detectors take precedence over later ones.
+func findPII(text string, detectors []*piiDetector) []piiFinding {
+	var findings []piiFinding
+	for _, detector := range detectors {
+		for _, loc := range detector.re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := loc[2*detector.group], loc[2*detector.group+1]
+			if start < 0 || start == end {
+				continue
+			}
+			if detector.valid != nil && !detector.valid(text[start:end]) {
+				continue
+			}
+
+			overlaps := slices.ContainsFunc(findings, func(f piiFinding) bool {
+				return start < f.End && f.Start < end
+			})
+			if !overlaps {
+				findings = append(findings, piiFinding{Detector: detector.Name, Start: start, End: end})
+			}
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+
+	return findings
+}
+
+// maskPII replaces the findings in text with a placeholder naming the kind of personal data.
+func maskPII(text string, findings []piiFinding) string {
+	var sb strings.Builder
+	last := 0
+	for _, finding := range findings {
+		sb.WriteString(text[last:finding.Start])
+		fmt.Fprintf(&sb, "[REDACTED:%s]", finding.Detector)
+		last = finding.End
+	}
+	sb.WriteString(text[last:])
+
+	return sb.String()
+}
+
+// countFindings counts the findings by detector.
+func countFindings(findings []piiFinding) map[string]int {
+	counts := make(map[string]int)
+	for _, finding := range findings {
+		counts[finding.Detector]++
+	}
+
+	return counts
+}
+
+// describeFindings lists the kinds of personal data found, e.g. "email, phone".
+func describeFindings(counts map[string]int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// redactionPolicy defines how the personal data found in the posts and dialog submissions of a
+// team is handled.
+type redactionPolicy struct {
+	// Action is one of off, mask, reject or route. Route masks the personal data and posts the
+	// original content to the compliance channel.
+	Action string `json:"action"`
+
+	// Detectors restricts the builtin detectors used, all of them being used when empty.
+	Detectors []string `json:"detectors,omitempty"`
+
+	// CustomPatterns maps names to regular expressions matching additional personal data, e.g.
+	// employee numbers.
+	CustomPatterns map[string]string `json:"custom_patterns,omitempty"`
+
+	ComplianceChannelID string `json:"compliance_channel_id,omitempty"`
+
+	detectors []*piiDetector
+}
+
+// defaultRedactionPolicy applies to the teams without a redaction policy.
+func defaultRedactionPolicy() *redactionPolicy {
+	policy := &redactionPolicy{Action: redactionActionOff}
+	_ = policy.compile()
+
+	return policy
+}
+
+// compile validates the policy and builds its detectors.
+func (r *redactionPolicy) compile() error {
+	if !slices.Contains(redactionActions, r.Action) {
+		return errors.Errorf("unknown action %q", r.Action)
+	}
+	if r.Action == redactionActionRoute && r.ComplianceChannelID == "" {
+		return errors.New("the route action requires a compliance channel")
+	}
+
+	r.detectors = nil
+	for _, detector := range builtinPIIDetectors {
+		if len(r.Detectors) == 0 || slices.Contains(r.Detectors, detector.Name) {
+			r.detectors = append(r.detectors, detector)
+		}
+	}
+	for _, name := range r.Detectors {
+		if !slices.ContainsFunc(builtinPIIDetectors, func(d *piiDetector) bool { return d.Name == name }) {
+			return errors.Errorf("unknown detector %q", name)
+		}
+	}
+
+	names := make([]string, 0, len(r.CustomPatterns))
+	for name := range r.CustomPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !customPatternNameRegexp.MatchString(name) {
+			return errors.Errorf("invalid pattern name %q, expected lowercase letters, digits and underscores", name)
+		}
+		if slices.ContainsFunc(builtinPIIDetectors, func(d *piiDetector) bool { return d.Name == name }) {
+			return errors.Errorf("pattern name %q is a builtin detector", name)
+		}
+
+		re, err := regexp.Compile(r.CustomPatterns[name])
+		if err != nil {
+			return errors.Wrapf(err, "invalid pattern %s", name)
+		}
+		r.detectors = append(r.detectors, &piiDetector{Name: name, re: re})
+	}
+
+	return nil
+}
+
+// update applies the arguments of /demo_plugin redaction set to the policy.
+func (r *redactionPolicy) update(args map[string]string) error {
+	for key, value := range args {
+		switch {
+		case key == "action":
+			r.Action = value
+		case key == "detectors":
+			r.Detectors = nil
+			if value != "all" {
+				for _, name := range strings.Split(value, ",") {
+					if name = strings.TrimSpace(name); name != "" {
+						r.Detectors = append(r.Detectors, name)
+					}
+				}
+			}
+		case key == "compliance_channel":
+			r.ComplianceChannelID = value
+		case strings.HasPrefix(key, "pattern."):
+			name := strings.TrimPrefix(key, "pattern.")
+			if value == "" {
+				delete(r.CustomPatterns, name)
+				continue
+			}
+			if r.CustomPatterns == nil {
+				r.CustomPatterns = make(map[string]string)
+			}
+			r.CustomPatterns[name] = value
+		default:
+			return errors.Errorf("unknown argument %q", key)
+		}
+	}
+
+	return r.compile()
+}
+
+// describe summarizes the policy in Markdown.
+func (r *redactionPolicy) describe() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "- Action: `%s`\n", r.Action)
+
+	detectors := "all"
+	if len(r.Detectors) > 0 {
+		detectors = strings.Join(r.Detectors, ", ")
+	}
+	fmt.Fprintf(&sb, "- Detectors: %s\n", detectors)
+
+	names := make([]string, 0, len(r.CustomPatterns))
+	for name := range r.CustomPatterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&sb, "- Pattern `%s`: `%s`\n", name, r.CustomPatterns[name])
+	}
+
+	if r.ComplianceChannelID != "" {
+		fmt.Fprintf(&sb, "- Compliance channel: `%s`\n", r.ComplianceChannelID)
+	}
+
+	return sb.String()
+}
+
+func unmarshalRedactionPolicy(data []byte) (*redactionPolicy, error) {
+	if len(data) == 0 {
+		return defaultRedactionPolicy(), nil
+	}
+
+	var policy redactionPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal redaction policy")
+	}
+	if err := policy.compile(); err != nil {
+		return nil, errors.Wrap(err, "invalid redaction policy")
+	}
+
+	return &policy, nil
+}
+
+// getRedactionPolicy returns the redaction policy of the team. Policies are cached, since they
+// are needed for every post.
+func (p *Plugin) getRedactionPolicy(teamID string) (*redactionPolicy, error) {
+	if teamID == "" {
+		return defaultRedactionPolicy(), nil
+	}
+
+	p.redactionPoliciesLock.RLock()
+	policy, ok := p.redactionPolicies[teamID]
+	p.redactionPoliciesLock.RUnlock()
+
+	if ok {
+		return policy, nil
+	}
+
+	var data []byte
+	if err := p.client.KV.Get(redactionPolicyKeyPrefix+teamID, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get redaction policy")
+	}
+
+	policy, err := unmarshalRedactionPolicy(data)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cacheRedactionPolicy(teamID, policy)
+
+	return policy, nil
+}
+
+func (p *Plugin) cacheRedactionPolicy(teamID string, policy *redactionPolicy) {
+	p.redactionPoliciesLock.Lock()
+	defer p.redactionPoliciesLock.Unlock()
+
+	if p.redactionPolicies == nil {
+		p.redactionPolicies = make(map[string]*redactionPolicy)
+	}
+	if policy == nil {
+		delete(p.redactionPolicies, teamID)
+		return
+	}
+	p.redactionPolicies[teamID] = policy
+}
+
+// updateRedactionPolicy atomically applies the arguments to the redaction policy of the team,
+// then notifies the other plugin instances. Invalid arguments are reported as is, so that they can
+// be shown to the user.
+func (p *Plugin) updateRedactionPolicy(teamID string, args map[string]string) (*redactionPolicy, error) {
+	var policy *redactionPolicy
+	var invalid error
+	err := p.updateKV(redactionPolicyKeyPrefix+teamID, 0, func(oldValue []byte) (any, error) {
+		var err error
+		policy, err = unmarshalRedactionPolicy(oldValue)
+		if err != nil {
+			return nil, err
+		}
+		if invalid = policy.update(args); invalid != nil {
+			return nil, invalid
+		}
+
+		return policy, nil
+	})
+	if invalid != nil {
+		return nil, invalid
+	} else if err != nil {
+		return nil, errors.Wrap(err, "failed to save redaction policy")
+	}
+
+	p.cacheRedactionPolicy(teamID, policy)
+
+	if err := p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id:   redactionPolicyClusterEventID,
+		Data: []byte(teamID),
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	}); err != nil {
+		p.API.LogWarn("Failed to broadcast redaction policy change", "team_id", teamID, "err", err.Error())
+	}
+
+	return policy, nil
+}
+
+// redactionAuditRecord records that personal data was handled by a redaction policy. It never
+// holds the personal data itself.
+type redactionAuditRecord struct {
+	ID        string         `json:"id"`
+	Timestamp int64          `json:"timestamp"`
+	TeamID    string         `json:"team_id"`
+	ChannelID string         `json:"channel_id"`
+	UserID    string         `json:"user_id"`
+	PostID    string         `json:"post_id,omitempty"`
+	Source    string         `json:"source"`
+	Action    string         `json:"action"`
+	Findings  map[string]int `json:"findings"`
+}
+
+func (r *redactionAuditRecord) key() string {
+	return fmt.Sprintf("%s%s_%013d_%s", redactionAuditKeyPrefix, r.TeamID, r.Timestamp, r.ID)
+}
+
+// redactionAuditIndexEntry is the key of an audit record in the index of its team.
+type redactionAuditIndexEntry struct {
+	Key       string `json:"key"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// auditRedaction persists an audit record of a redaction and adds it to the index of the team,
+// dropping the expired records from the index.
+func (p *Plugin) auditRedaction(record *redactionAuditRecord) {
+	record.ID = model.NewId()
+	record.Timestamp = model.GetMillis()
+
+	if _, err := p.client.KV.Set(record.key(), record, pluginapi.SetExpiry(redactionAuditRetention)); err != nil {
+		p.API.LogError("Failed to record redaction audit record", "team_id", record.TeamID, "err", err.Error())
+		return
+	}
+
+	expired := record.Timestamp - redactionAuditRetention.Milliseconds()
+	if err := p.updateKV(redactionAuditIndexKeyPrefix+record.TeamID, redactionAuditRetention, func(oldValue []byte) (any, error) {
+		var entries []redactionAuditIndexEntry
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &entries); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal redaction audit index")
+			}
+		}
+
+		entries = slices.DeleteFunc(entries, func(entry redactionAuditIndexEntry) bool { return entry.Timestamp < expired })
+		entries = append(entries, redactionAuditIndexEntry{Key: record.key(), Timestamp: record.Timestamp})
+		if len(entries) > redactionAuditIndexSize {
+			entries = entries[len(entries)-redactionAuditIndexSize:]
+		}
+
+		return entries, nil
+	}); err != nil {
+		p.API.LogError("Failed to index redaction audit record", "team_id", record.TeamID, "err", err.Error())
+	}
+}
+
+// listRedactionAuditRecords returns the most recent audit records of the team, latest first.
+func (p *Plugin) listRedactionAuditRecords(teamID string, limit int) ([]*redactionAuditRecord, error) {
+	var entries []redactionAuditIndexEntry
+	if err := p.client.KV.Get(redactionAuditIndexKeyPrefix+teamID, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to get redaction audit index")
+	}
+	// Records are indexed in the order they are saved, which breaks ties between timestamps.
+	slices.Reverse(entries)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Timestamp > entries[j].Timestamp })
+
+	records := []*redactionAuditRecord{}
+	for _, entry := range entries {
+		if len(records) == limit {
+			break
+		}
+
+		var record redactionAuditRecord
+		if err := p.client.KV.Get(entry.Key, &record); err != nil {
+			return nil, errors.Wrapf(err, "failed to get redaction audit record %s", entry.Key)
+		}
+
+		// The record may have expired since it was indexed.
+		if record.ID != "" {
+			records = append(records, &record)
+		}
+	}
+
+	return records, nil
+}
+
+// redactPost applies the redaction policy of the team of the channel to the post. It returns the
+// post to save, or nil and the reason if the post is rejected.
+func (p *Plugin) redactPost(post *model.Post, source string, event *hookEvent) (*model.Post, string) {
+	channel, appErr := p.API.GetChannel(post.ChannelId)
+	if appErr != nil {
+		p.API.LogError("Failed to get channel for redaction", "channel_id", post.ChannelId, "err", appErr.Error())
+		return post, ""
+	}
+
+	policy, err := p.getRedactionPolicy(channel.TeamId)
+	if err != nil {
+		p.API.LogError("Failed to get redaction policy", "team_id", channel.TeamId, "err", err.Error())
+		return post, ""
+	}
+	if policy.Action == redactionActionOff {
+		return post, ""
+	}
+
+	findings := findPII(post.Message, policy.detectors)
+	if len(findings) == 0 {
+		return post, ""
+	}
+
+	counts := countFindings(findings)
+	p.auditRedaction(&redactionAuditRecord{
+		TeamID:    channel.TeamId,
+		ChannelID: post.ChannelId,
+		UserID:    post.UserId,
+		PostID:    post.Id,
+		Source:    source,
+		Action:    policy.Action,
+		Findings:  counts,
+	})
+
+	if policy.Action == redactionActionReject {
+		message := fmt.Sprintf("Your message contains personal data (%s) and was rejected.", describeFindings(counts))
+		p.API.SendEphemeralPost(post.UserId, &model.Post{
+			UserId:    p.getConfiguration().demoUserID,
+			ChannelId: post.ChannelId,
+			Message:   message,
+		})
+
+		event.reject(fmt.Sprintf("personal data: %s", describeFindings(counts)))
+		return nil, message
+	}
+
+	if policy.Action == redactionActionRoute {
+		p.routeToCompliance(policy, channel.TeamId, post.UserId, channel, counts, post.Message)
+	}
+
+	post = post.Clone()
+	post.Message = maskPII(post.Message, findings)
+
+	return post, ""
+}
+
+// redactDialogSubmission applies the redaction policy of the team to the text values of a dialog
+// submission, before they are posted. As submissions are posted publicly by the bot, they are
+// masked even if the team has no redaction policy. It returns the errors to show in the dialog if
+// the policy rejects personal data.
+func (p *Plugin) redactDialogSubmission(request *model.SubmitDialogRequest) map[string]string {
+	policy, err := p.getRedactionPolicy(request.TeamId)
+	if err != nil {
+		p.API.LogError("Failed to get redaction policy", "team_id", request.TeamId, "err", err.Error())
+		policy = defaultRedactionPolicy()
+	}
+
+	action := policy.Action
+	if action == redactionActionOff {
+		action = redactionActionMask
+	}
+
+	counts := make(map[string]int)
+	original := make(map[string]string)
+	errs := make(map[string]string)
+	for field, value := range request.Submission {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		findings := findPII(text, policy.detectors)
+		if len(findings) == 0 {
+			continue
+		}
+
+		fieldCounts := countFindings(findings)
+		for detector, count := range fieldCounts {
+			counts[detector] += count
+		}
+		original[field] = text
+
+		if action == redactionActionReject {
+			errs[field] = fmt.Sprintf("This field must not contain personal data (%s).", describeFindings(fieldCounts))
+			continue
+		}
+		request.Submission[field] = maskPII(text, findings)
+	}
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	p.auditRedaction(&redactionAuditRecord{
+		TeamID:    request.TeamId,
+		ChannelID: request.ChannelId,
+		UserID:    request.UserId,
+		Source:    redactionSourceDialog,
+		Action:    action,
+		Findings:  counts,
+	})
+
+	if action == redactionActionReject {
+		return errs
+	}
+
+	if action == redactionActionRoute {
+		fields := make([]string, 0, len(original))
+		for field := range original {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		var sb strings.Builder
+		for _, field := range fields {
+			fmt.Fprintf(&sb, "- **%s:** %s\n", field, original[field])
+		}
+
+		channel := &model.Channel{Id: request.ChannelId, Name: request.ChannelId}
+		if c, appErr := p.API.GetChannel(request.ChannelId); appErr == nil {
+			channel = c
+		}
+		p.routeToCompliance(policy, request.TeamId, request.UserId, channel, counts, sb.String())
+	}
+
+	return nil
+}
+
+// routeToCompliance posts the original content holding personal data to the compliance channel
+// of the policy, provided it still belongs to the team of the policy.
+func (p *Plugin) routeToCompliance(policy *redactionPolicy, teamID, userID string, channel *model.Channel, counts map[string]int, content string) {
+	complianceChannel, appErr := p.API.GetChannel(policy.ComplianceChannelID)
+	if appErr != nil {
+		p.API.LogError("Failed to get the compliance channel", "channel_id", policy.ComplianceChannelID, "err", appErr.Error())
+		return
+	}
+	if complianceChannel.TeamId != teamID {
+		p.API.LogWarn("Not routing redacted content to a compliance channel outside of the team", "team_id", teamID, "channel_id", policy.ComplianceChannelID)
+		return
+	}
+
+	author := userID
+	if user, appErr := p.API.GetUser(userID); appErr == nil {
+		author = "@" + user.Username
+	}
+
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: policy.ComplianceChannelID,
+		Message: fmt.Sprintf("Redacted personal data (%s) from content by %s in ~%s:\n\n%s",
+			describeFindings(counts), author, channel.Name, content),
+	}); appErr != nil {
+		p.API.LogError("Failed to route redacted content to the compliance channel", "channel_id", policy.ComplianceChannelID, "err", appErr.Error())
+	}
+}
+
+// checkComplianceChannel checks that the compliance channel belongs to the team and that the user
+// setting it can read it, since it receives the personal data of the team. It returns the
+// explanation for the user if not.
+func (p *Plugin) checkComplianceChannel(userID, teamID, channelID string) string {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil || channel.TeamId != teamID || !p.API.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel) {
+		return fmt.Sprintf("Invalid compliance channel `%s`: it must be a channel of this team you can read.", channelID)
+	}
+
+	return ""
+}
+
+func (p *Plugin) executeCommandRedaction(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if !p.API.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionManageTeam) {
+		return respond("Only team admins can manage the redaction policy of the team.")
+	}
+
+	if len(params) == 0 || params[0] == "show" {
+		policy, err := p.getRedactionPolicy(args.TeamId)
+		if err != nil {
+			p.API.LogError("Failed to get redaction policy", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to get the redaction policy of the team.")
+		}
+		return respond("Redaction policy of this team:\n" + policy.describe())
+	}
+
+	switch action := params[0]; {
+	case action == "set" && len(params) >= 2:
+		// Patterns may contain spaces, so parse the raw command rather than the fields.
+		_, rawArgs, _ := strings.Cut(args.Command, " set ")
+		updates, err := parseKeyValueArgs(rawArgs)
+		if err != nil {
+			return respond(fmt.Sprintf("Invalid policy: %s", err.Error()))
+		}
+
+		if channelID := updates["compliance_channel"]; channelID != "" {
+			if msg := p.checkComplianceChannel(args.UserId, args.TeamId, channelID); msg != "" {
+				return respond(msg)
+			}
+		}
+
+		policy, err := p.updateRedactionPolicy(args.TeamId, updates)
+		if err != nil {
+			p.API.LogWarn("Failed to update redaction policy", "team_id", args.TeamId, "err", err.Error())
+			return respond(fmt.Sprintf("Failed to update the redaction policy: %s.", err.Error()))
+		}
+		return respond("Updated the redaction policy of this team:\n" + policy.describe())
+	case action == "audit" && len(params) == 1:
+		records, err := p.listRedactionAuditRecords(args.TeamId, redactionAuditPageSize)
+		if err != nil {
+			p.API.LogError("Failed to list redaction audit records", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to list the redaction audit records.")
+		}
+		return respond(redactionAuditSummary(records))
+	default:
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+}
+
+// redactionAuditSummary lists the audit records in Markdown.
+func redactionAuditSummary(records []*redactionAuditRecord) string {
+	if len(records) == 0 {
+		return "No redaction audit records."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Time | User | Channel | Source | Action | Personal data |\n")
+	sb.WriteString("|------|------|---------|--------|--------|---------------|\n")
+	for _, record := range records {
+		fmt.Fprintf(&sb, "| %s | `%s` | `%s` | %s | %s | %s |\n",
+			time.UnixMilli(record.Timestamp).UTC().Format(time.RFC3339), record.UserID, record.ChannelID,
+			record.Source, record.Action, describeFindings(record.Findings))
+	}
+
+	return sb.String()
+}
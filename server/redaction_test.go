@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestChecksums(t *testing.T) {
+	assert.True(t, luhnValid("4111 1111 1111 1111"))
+	assert.True(t, luhnValid("5500-0000-0000-0004"))
+	assert.False(t, luhnValid("4111 1111 1111 1112"))
+	assert.False(t, luhnValid("1234"))
+
+	assert.True(t, ibanValid("DE89 3704 0044 0532 0130 00"))
+	assert.True(t, ibanValid("GB29NWBK60161331926819"))
+	assert.False(t, ibanValid("DE89 3704 0044 0532 0130 01"))
+}
+
+func TestFindPII(t *testing.T) {
+	text := "Mail jane.doe@example.com or call +1 (555) 123-4567, card 4111 1111 1111 1111, IBAN DE89 3704 0044 0532 0130 00. Order 12345, tel 4111 1111 1111 1112."
+
+	findings := findPII(text, builtinPIIDetectors)
+	var found []string
+	for _, finding := range findings {
+		found = append(found, finding.Detector+"="+text[finding.Start:finding.End])
+	}
+	assert.Equal(t, []string{
+		"email=jane.doe@example.com",
+		"phone=+1 (555) 123-4567",
+		"credit_card=4111 1111 1111 1111",
+		"iban=DE89 3704 0044 0532 0130 00",
+	}, found, "invalid card numbers aren't redacted")
+
+	assert.Equal(t,
+		"Mail [REDACTED:email] or call [REDACTED:phone], card [REDACTED:credit_card], IBAN [REDACTED:iban]. Order 12345, tel 4111 1111 1111 1112.",
+		maskPII(text, findings))
+
+	assert.Empty(t, findPII("Meeting on 2024-01-15 at 10:30, room 42.", builtinPIIDetectors))
+}
+
+func TestRedactionPolicy(t *testing.T) {
+	policy := defaultRedactionPolicy()
+	require.NoError(t, policy.update(map[string]string{
+		"action":            redactionActionMask,
+		"detectors":         "email, iban",
+		"pattern.employee":  `\bEMP-\d{6}\b`,
+		"pattern.badge_num": `\bB\d{4}\b`,
+	}))
+
+	var names []string
+	for _, detector := range policy.detectors {
+		names = append(names, detector.Name)
+	}
+	assert.Equal(t, []string{"email", "iban", "badge_num", "employee"}, names)
+
+	findings := findPII("EMP-123456 (a@b.io) called 555 123 4567", policy.detectors)
+	assert.Equal(t, map[string]int{"employee": 1, "email": 1}, countFindings(findings))
+
+	require.NoError(t, policy.update(map[string]string{"pattern.badge_num": "", "detectors": "all"}))
+	assert.Len(t, policy.detectors, len(builtinPIIDetectors)+1)
+
+	for name, args := range map[string]map[string]string{
+		"unknown action":        {"action": "delete"},
+		"unknown detector":      {"detectors": "ssn"},
+		"route without channel": {"action": redactionActionRoute},
+		"invalid pattern":       {"pattern.x": "("},
+		"invalid pattern name":  {"pattern.Bad Name": "x"},
+		"builtin pattern name":  {"pattern.email": "x"},
+		"unknown argument":      {"color": "red"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			policy := defaultRedactionPolicy()
+			assert.Error(t, policy.update(args))
+		})
+	}
+}
+
+func TestRedactionCommand(t *testing.T) {
+	cluster := newFakeCluster()
+	node := cluster.addNode(t)
+
+	api := node.API.(*fakeNodeAPI).API
+	api.On("HasPermissionToTeam", "admin", "team1", model.PermissionManageTeam).Return(true)
+	api.On("GetChannel", "compliance").Return(&model.Channel{Id: "compliance", TeamId: "team1"}, nil)
+	api.On("GetChannel", "secret").Return(&model.Channel{Id: "secret", TeamId: "team1"}, nil)
+	api.On("GetChannel", "elsewhere").Return(&model.Channel{Id: "elsewhere", TeamId: "team2"}, nil)
+	api.On("HasPermissionToChannel", "admin", "compliance", model.PermissionReadChannel).Return(true)
+	api.On("HasPermissionToChannel", "admin", "secret", model.PermissionReadChannel).Return(false)
+	api.On("HasPermissionToChannel", "admin", "elsewhere", model.PermissionReadChannel).Return(true)
+	api.On("PublishPluginClusterEvent", mock.Anything, mock.Anything).Return(nil)
+
+	set := func(channelID string) string {
+		t.Helper()
+		response := node.executeCommandRedaction(&model.CommandArgs{
+			UserId:  "admin",
+			TeamId:  "team1",
+			Command: "/demo_plugin redaction set action=route compliance_channel=" + channelID,
+		}, []string{"set", "action=route", "compliance_channel=" + channelID})
+		return response.Text
+	}
+
+	assert.Contains(t, set("compliance"), "Updated the redaction policy of this team")
+	for _, channelID := range []string{"secret", "elsewhere"} {
+		assert.Equal(t, "Invalid compliance channel `"+channelID+"`: it must be a channel of this team you can read.", set(channelID))
+	}
+
+	policy, err := node.getRedactionPolicy("team1")
+	require.NoError(t, err)
+	assert.Equal(t, "compliance", policy.ComplianceChannelID)
+}
+
+func TestRedactionHooks(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	var posts, ephemeralPosts []*model.Post
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		node.initializeAPI()
+
+		api := node.API.(*fakeNodeAPI).API
+		api.On("GetChannel", "channel1").Return(&model.Channel{Id: "channel1", Name: "general", TeamId: "team1", Type: model.ChannelTypeOpen}, nil)
+		api.On("GetChannel", "compliance").Return(&model.Channel{Id: "compliance", Name: "compliance", TeamId: "team1", Type: model.ChannelTypePrivate}, nil)
+		api.On("GetChannel", "elsewhere").Return(&model.Channel{Id: "elsewhere", Name: "elsewhere", TeamId: "team2", Type: model.ChannelTypeOpen}, nil)
+		api.On("GetUser", "user1").Return(&model.User{Id: "user1", Username: "jane"}, nil)
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+		api.On("SendEphemeralPost", "user1", mock.Anything).Run(func(args mock.Arguments) {
+			ephemeralPosts = append(ephemeralPosts, args.Get(1).(*model.Post))
+		}).Return(&model.Post{})
+	}
+
+	setAction := func(args map[string]string) {
+		t.Helper()
+		_, err := node1.updateRedactionPolicy("team1", args)
+		require.NoError(t, err)
+	}
+	message := "Call me at +1 (555) 123-4567"
+	newPost := func() *model.Post {
+		return &model.Post{Id: model.NewId(), ChannelId: "channel1", UserId: "user1", Message: message}
+	}
+	audited := func() []string {
+		t.Helper()
+		records, err := node2.listRedactionAuditRecords("team1", 100)
+		require.NoError(t, err)
+		var actions []string
+		for _, record := range records {
+			actions = append(actions, record.Source+":"+record.Action)
+		}
+		return actions
+	}
+
+	t.Run("off by default", func(t *testing.T) {
+		post, reason := node2.MessageWillBePosted(nil, newPost())
+		require.NotNil(t, post)
+		assert.Empty(t, reason)
+		assert.Equal(t, message, post.Message)
+		assert.Empty(t, audited())
+	})
+
+	t.Run("mask", func(t *testing.T) {
+		setAction(map[string]string{"action": redactionActionMask})
+
+		post, reason := node2.MessageWillBePosted(nil, newPost())
+		require.NotNil(t, post)
+		assert.Empty(t, reason)
+		assert.Equal(t, "Call me at [REDACTED:phone]", post.Message)
+
+		post, reason = node1.MessageWillBeUpdated(nil, newPost(), &model.Post{ChannelId: "channel1", UserId: "user1", Message: "Call me"})
+		require.NotNil(t, post)
+		assert.Empty(t, reason)
+		assert.Equal(t, "Call me at [REDACTED:phone]", post.Message)
+
+		assert.ElementsMatch(t, []string{redactionSourcePost + ":mask", redactionSourcePostUpdate + ":mask"}, audited())
+		assert.Empty(t, posts)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		setAction(map[string]string{"action": redactionActionReject})
+
+		post, reason := node2.MessageWillBePosted(nil, newPost())
+		assert.Nil(t, post)
+		assert.Equal(t, "Your message contains personal data (phone) and was rejected.", reason)
+		require.Len(t, ephemeralPosts, 1)
+		assert.Equal(t, reason, ephemeralPosts[0].Message)
+
+		post, _ = node1.MessageWillBeUpdated(nil, newPost(), &model.Post{ChannelId: "channel1", UserId: "user1", Message: "Call me"})
+		assert.Nil(t, post, "the post is kept in its previous state")
+		assert.Empty(t, posts)
+	})
+
+	t.Run("route", func(t *testing.T) {
+		setAction(map[string]string{"action": redactionActionRoute, "compliance_channel": "compliance"})
+
+		post, reason := node2.MessageWillBePosted(nil, newPost())
+		require.NotNil(t, post)
+		assert.Empty(t, reason)
+		assert.Equal(t, "Call me at [REDACTED:phone]", post.Message)
+
+		require.Len(t, posts, 1)
+		assert.Equal(t, "compliance", posts[0].ChannelId)
+		assert.Equal(t, "Redacted personal data (phone) from content by @jane in ~general:\n\n"+message, posts[0].Message)
+
+		posts = nil
+		setAction(map[string]string{"compliance_channel": "elsewhere"})
+		node2.API.(*fakeNodeAPI).API.On("LogWarn", "Not routing redacted content to a compliance channel outside of the team", "team_id", "team1", "channel_id", "elsewhere").Once()
+
+		post, _ = node2.MessageWillBePosted(nil, newPost())
+		require.NotNil(t, post)
+		assert.Equal(t, "Call me at [REDACTED:phone]", post.Message)
+		assert.Empty(t, posts, "the content isn't routed outside of the team")
+	})
+
+	t.Run("audit", func(t *testing.T) {
+		kvLists := cluster.kvLists
+		records, err := node1.listRedactionAuditRecords("team1", 2)
+		require.NoError(t, err)
+		assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned")
+
+		require.Len(t, records, 2)
+		assert.GreaterOrEqual(t, records[0].Timestamp, records[1].Timestamp, "latest first")
+		assert.Equal(t, redactionActionRoute, records[0].Action)
+	})
+
+	submit := func(node *Plugin, path string, request *model.SubmitDialogRequest) *model.SubmitDialogResponse {
+		t.Helper()
+		data, err := json.Marshal(request)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		r.Header.Set("Mattermost-User-ID", request.UserId)
+		node.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response model.SubmitDialogResponse
+		if w.Body.Len() > 0 {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+		return &response
+	}
+
+	t.Run("dialog submission", func(t *testing.T) {
+		setAction(map[string]string{"action": redactionActionMask})
+		posts = nil
+
+		response := submit(node1, "/dialog/3", &model.SubmitDialogRequest{
+			UserId:     "user1",
+			ChannelId:  "channel1",
+			TeamId:     "team1",
+			Submission: map[string]any{"notes": "Mail jane.doe@example.com", "count": float64(3)},
+		})
+		assert.Empty(t, response.Errors)
+		require.Len(t, posts, 1)
+		assert.Contains(t, posts[0].Message, "- notes: Mail [REDACTED:email]")
+		assert.NotContains(t, posts[0].Message, "jane.doe@example.com")
+
+		setAction(map[string]string{"action": redactionActionReject})
+		posts = nil
+		response = submit(node2, "/dialog/3", &model.SubmitDialogRequest{
+			UserId:     "user1",
+			ChannelId:  "channel1",
+			TeamId:     "team1",
+			Submission: map[string]any{"notes": "Mail jane.doe@example.com"},
+		})
+		assert.Equal(t, map[string]string{"notes": "This field must not contain personal data (email)."}, response.Errors)
+		assert.Empty(t, posts)
+	})
+
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	commandTriggerRemind = "remind"
+
+	// remindersKeyPrefix prefixes the KV keys of the queue of the reminders waiting to be
+	// delivered.
+	remindersKeyPrefix = "reminders_"
+
+	// remindersInterval is how often the reminders job looks for reminders that are due.
+	remindersInterval = 30 * time.Second
+
+	// reminderMaxAttempts is how many times the delivery of a reminder is attempted before
+	// giving up, reminderRetryDelay apart.
+	reminderMaxAttempts = 3
+	reminderRetryDelay  = time.Minute
+
+	maxRemindersPerUser = 50
+	maxRemindAhead      = 365 * 24 * time.Hour
+)
+
+// reminder is a message delivered by the bot once its time has come, either to a user by
+// direct message or in a channel.
+type reminder struct {
+	ID        string `json:"id"`
+	CreatorID string `json:"creator_id"`
+	UserID    string `json:"user_id,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	Message   string `json:"message"`
+	RemindAt  int64  `json:"remind_at"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (r *reminder) dueKey() (string, string) {
+	return r.CreatorID, r.ID
+}
+
+func (r *reminder) dueAt() int64 {
+	return r.RemindAt
+}
+
+func (p *Plugin) remindersQueue() *dueQueue[*reminder] {
+	return &dueQueue[*reminder]{
+		p:           p,
+		keyPrefix:   remindersKeyPrefix,
+		name:        "reminder",
+		maxPerOwner: maxRemindersPerUser,
+		maxAttempts: reminderMaxAttempts,
+		retryDelay:  reminderRetryDelay,
+	}
+}
+
+// listReminders returns the reminders created by the user, or by all the users if creatorID is
+// empty, soonest first.
+func (p *Plugin) listReminders(creatorID string) ([]*reminder, error) {
+	if creatorID == "" {
+		return p.remindersQueue().listAll()
+	}
+
+	return p.remindersQueue().list(creatorID)
+}
+
+// saveReminder stores the reminder to be delivered at its time.
+func (p *Plugin) saveReminder(r *reminder) error {
+	err := p.remindersQueue().add(r)
+	if errors.Is(err, errDueQueueFull) {
+		return errors.Errorf("you can't have more than %d reminders", maxRemindersPerUser)
+	} else if err != nil {
+		return errors.Wrap(err, "failed to save reminder")
+	}
+
+	return nil
+}
+
+// deleteReminder deletes the reminder created by the user, returning false if it doesn't exist
+// or was already delivered.
+func (p *Plugin) deleteReminder(creatorID, id string) (bool, error) {
+	_, deleted, err := p.remindersQueue().take(creatorID, id)
+
+	return deleted, err
+}
+
+// RemindersJob delivers the reminders that are due, with the buttons to complete or snooze them.
+// Like ScheduledPostsJob, it takes each reminder from the queue before delivering it, so that it
+// is delivered only once. Failed deliveries are retried, up to reminderMaxAttempts.
+func (p *Plugin) RemindersJob() {
+	p.remindersQueue().deliverDue(time.Now(), p.postReminder, nil)
+}
+
+func (p *Plugin) postReminder(r *reminder) error {
+	channelID := r.ChannelID
+	if r.UserID != "" {
+		dm, appErr := p.API.GetDirectChannel(r.UserID, p.botID)
+		if appErr != nil {
+			return errors.Wrapf(appErr, "failed to get direct channel of user %s", r.UserID)
+		}
+		channelID = dm.Id
+	}
+
+	context, err := reminderContext(r)
+	if err != nil {
+		return err
+	}
+
+	action := func(name, snooze string) *model.PostAction {
+		actionContext := model.StringInterface{}
+		for k, v := range context {
+			actionContext[k] = v
+		}
+		url := fmt.Sprintf("/plugins/%s/interactive/reminder/complete", manifest.Id)
+		if snooze != "" {
+			actionContext["snooze"] = snooze
+			url = fmt.Sprintf("/plugins/%s/interactive/reminder/snooze", manifest.Id)
+		}
+
+		return &model.PostAction{
+			Type: model.PostActionTypeButton,
+			Name: name,
+			Integration: &model.PostActionIntegration{
+				URL:     url,
+				Context: actionContext,
+			},
+		}
+	}
+
+	post := &model.Post{
+		UserId:    p.botID,
+		ChannelId: channelID,
+		Message:   p.reminderMessage(r),
+	}
+	model.ParseSlackAttachment(post, []*model.SlackAttachment{{
+		Actions: []*model.PostAction{
+			action("Complete", ""),
+			action("Snooze 10m", "10m"),
+			action("Snooze 1h", "1h"),
+		},
+	}})
+
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return errors.Wrap(appErr, "failed to create reminder post")
+	}
+
+	return nil
+}
+
+// reminderMessage formats the reminder as posted by the bot.
+func (p *Plugin) reminderMessage(r *reminder) string {
+	from := ""
+	if r.CreatorID != r.UserID {
+		if creator, appErr := p.API.GetUser(r.CreatorID); appErr == nil {
+			from = fmt.Sprintf(" from @%s", creator.Username)
+		}
+	}
+
+	return fmt.Sprintf(":alarm_clock: Reminder%s:\n%s", from, quoteMarkdown(r.Message))
+}
+
+// reminderContext stores the reminder in the context of the buttons of the reminder post, so
+// that it can be snoozed without keeping delivered reminders in the KV store.
+func reminderContext(r *reminder) (model.StringInterface, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal reminder")
+	}
+
+	return model.StringInterface{"reminder": string(data)}, nil
+}
+
+func reminderFromContext(context map[string]any) (*reminder, error) {
+	data, ok := context["reminder"].(string)
+	if !ok {
+		return nil, errors.New("missing reminder")
+	}
+
+	var r reminder
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal reminder")
+	}
+	if r.ID == "" || r.CreatorID == "" {
+		return nil, errors.New("invalid reminder")
+	}
+
+	return &r, nil
+}
+
+// handleReminderAction completes or snoozes a delivered reminder. Only the user reminded, or the
+// members of the channel reminded, can do so.
+func (p *Plugin) handleReminderAction(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-ID")
+	if userID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	var request model.PostActionIntegrationRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		p.API.LogError("Failed to decode PostActionIntegrationRequest", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	rem, err := reminderFromContext(request.Context)
+	if err != nil {
+		p.API.LogError("Failed to get reminder of action", "err", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if (rem.UserID != "" && rem.UserID != userID) ||
+		(rem.ChannelID != "" && !p.API.HasPermissionToChannel(userID, rem.ChannelID, model.PermissionReadChannel)) {
+		p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "This reminder isn't yours."})
+		return
+	}
+
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		p.API.LogError("Failed to get user for reminder action", "err", appErr.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var status string
+	switch mux.Vars(r)["action"] {
+	case "complete":
+		status = fmt.Sprintf(":white_check_mark: Completed by @%s.", user.Username)
+
+	case "snooze":
+		snooze, _ := request.Context["snooze"].(string)
+		duration, err := time.ParseDuration(snooze)
+		if err != nil || duration <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		remindAt := time.Now().Add(duration)
+		rem.RemindAt = remindAt.UnixMilli()
+		if err := p.remindersQueue().put(rem); err != nil {
+			p.API.LogError("Failed to snooze reminder", "id", rem.ID, "err", err.Error())
+			p.writeJSON(w, &model.PostActionIntegrationResponse{EphemeralText: "Failed to snooze the reminder."})
+			return
+		}
+		status = fmt.Sprintf(":zzz: Snoozed by @%s until %s.", user.Username, remindAt.In(p.userLocation(userID)).Format("Mon Jan 2 15:04 MST"))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	p.writeJSON(w, &model.PostActionIntegrationResponse{
+		Update: &model.Post{
+			Message: p.reminderMessage(rem) + "\n\n" + status,
+			Props:   model.StringInterface{},
+		},
+	})
+}
+
+func getCommandRemindAutocompleteData() *model.AutocompleteData {
+	command := model.NewAutocompleteData(commandTriggerRemind, "[me|@user|~channel] [when] [text]", "Set a reminder.")
+
+	list := model.NewAutocompleteData("list", "", "List the reminders you set.")
+	command.AddCommand(list)
+
+	deleteCommand := model.NewAutocompleteData("delete", "[id]", "Delete a reminder you set.")
+	deleteCommand.AddTextArgument("Id of the reminder", "[id]", "")
+	command.AddCommand(deleteCommand)
+
+	command.AddTextArgument("Who to remind, when, e.g. in 10m, 17:30 or tomorrow 9:00, and what about", "[me|@user|~channel] [when] [text]", "")
+
+	return command
+}
+
+func (p *Plugin) executeCommandRemind(args *model.CommandArgs) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	location := p.userLocation(args.UserId)
+
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args.Command), "/"+commandTriggerRemind))
+	target, rest := nextWord(text)
+	switch {
+	case target == "" || target == "help":
+		return respond("Usage: `/remind me|@user|~channel <when> <text>`, e.g. `/remind me in 10m to check the build` or `/remind ~town-square tomorrow 9:00 Standup!`. Times are in your timezone. Also `/remind list` and `/remind delete <id>`.")
+
+	case target == "list":
+		reminders, err := p.listReminders(args.UserId)
+		if err != nil {
+			p.API.LogError("Failed to list reminders", "err", err.Error())
+			return respond("Failed to list your reminders.")
+		}
+		return respond(p.remindersSummary(reminders, location))
+
+	case target == "delete":
+		id, extra := nextWord(rest)
+		if id == "" || extra != "" {
+			return respond("Usage: `/remind delete <id>`.")
+		}
+		deleted, err := p.deleteReminder(args.UserId, id)
+		if err != nil {
+			p.API.LogError("Failed to delete reminder", "id", id, "err", err.Error())
+			return respond("Failed to delete the reminder.")
+		}
+		if !deleted {
+			return respond(fmt.Sprintf("You have no pending reminder with id `%s`.", id))
+		}
+		return respond(fmt.Sprintf("Deleted reminder `%s`.", id))
+	}
+
+	now := time.Now()
+	r := &reminder{
+		ID:        model.NewId(),
+		CreatorID: args.UserId,
+		CreatedAt: now.UnixMilli(),
+	}
+
+	var recipient string
+	switch {
+	case target == "me":
+		r.UserID = args.UserId
+		recipient = "you"
+
+	case strings.HasPrefix(target, "@"):
+		user, appErr := p.API.GetUserByUsername(strings.TrimPrefix(target, "@"))
+		if appErr != nil {
+			return respond(fmt.Sprintf("Unknown user %s.", target))
+		}
+		r.UserID = user.Id
+		recipient = "@" + user.Username
+
+	case strings.HasPrefix(target, "~"):
+		channel, err := p.findChannel(args.TeamId, target)
+		if err != nil {
+			return respond(fmt.Sprintf("Unknown channel %s.", target))
+		}
+		if !p.API.HasPermissionToChannel(args.UserId, channel.Id, model.PermissionCreatePost) {
+			return respond(fmt.Sprintf("You can't post in ~%s.", channel.Name))
+		}
+		r.ChannelID = channel.Id
+		recipient = "~" + channel.Name
+
+	default:
+		return respond(fmt.Sprintf("Unknown recipient %s, expected me, @user or ~channel.", target))
+	}
+
+	remindAt, message, err := parseWhen(rest, now, location)
+	if err != nil {
+		return respond(fmt.Sprintf("Invalid time: %s.", err.Error()))
+	}
+	if word, afterTo := nextWord(message); word == "to" && afterTo != "" {
+		message = afterTo
+	}
+	if strings.TrimSpace(message) == "" {
+		return respond("Please provide the text of the reminder, e.g. `/remind me in 10m to check the build`.")
+	}
+	if remindAt.Sub(now) > maxRemindAhead {
+		return respond("Reminders can't be set more than a year ahead.")
+	}
+	r.Message = message
+	r.RemindAt = remindAt.UnixMilli()
+
+	if err := p.saveReminder(r); err != nil {
+		p.API.LogError("Failed to save reminder", "err", err.Error())
+		return respond(fmt.Sprintf("Failed to set the reminder: %s.", err.Error()))
+	}
+
+	return respond(fmt.Sprintf("I will remind %s on %s, id `%s`.", recipient, remindAt.Format("Mon Jan 2 2006 15:04 MST"), r.ID))
+}
+
+// remindersSummary describes the pending reminders, with their times in the given location.
+func (p *Plugin) remindersSummary(reminders []*reminder, location *time.Location) string {
+	if len(reminders) == 0 {
+		return "You have no pending reminders."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Id | When | Who | Text |\n|:---|:-----|:----|:-----|\n")
+	for _, r := range reminders {
+		recipient := r.UserID
+		switch {
+		case r.UserID == r.CreatorID:
+			recipient = "you"
+		case r.UserID != "":
+			if user, appErr := p.API.GetUser(r.UserID); appErr == nil {
+				recipient = "@" + user.Username
+			}
+		default:
+			recipient = r.ChannelID
+			if channel, appErr := p.API.GetChannel(r.ChannelID); appErr == nil {
+				recipient = "~" + channel.Name
+			}
+		}
+
+		fmt.Fprintf(&sb, "| `%s` | %s | %s | %s |\n",
+			r.ID,
+			time.UnixMilli(r.RemindAt).In(location).Format("Mon Jan 2 2006 15:04 MST"),
+			recipient,
+			strings.ReplaceAll(excerpt(r.Message, postExcerptLength), "|", `\|`),
+		)
+	}
+
+	return sb.String()
+}
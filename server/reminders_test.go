@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestReminders(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	var posts []*model.Post
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		node.initializeAPI()
+		api := node.API.(*fakeNodeAPI).API
+		api.On("GetDirectChannel", "user2", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		api.On("GetUser", "user1").Return(&model.User{Id: "user1", Username: "jane"}, nil)
+		api.On("GetUser", "user2").Return(&model.User{Id: "user2", Username: "john"}, nil)
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+	}
+
+	now := time.Now()
+	require.NoError(t, node1.saveReminder(&reminder{ID: "due", CreatorID: "user1", UserID: "user2", Message: "check the build", RemindAt: now.Add(-time.Second).UnixMilli()}))
+	require.NoError(t, node1.saveReminder(&reminder{ID: "deleted", CreatorID: "user1", UserID: "user2", Message: "never mind", RemindAt: now.Add(-time.Second).UnixMilli()}))
+	require.NoError(t, node1.saveReminder(&reminder{ID: "later", CreatorID: "user1", UserID: "user1", Message: "later", RemindAt: now.Add(time.Hour).UnixMilli()}))
+
+	deleted, err := node2.deleteReminder("user1", "deleted")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	kvLists := cluster.kvLists
+	node2.RemindersJob()
+	node1.RemindersJob()
+	assert.Equal(t, kvLists, cluster.kvLists, "the KV store isn't scanned")
+
+	require.Len(t, posts, 1)
+	assert.Equal(t, "dm", posts[0].ChannelId)
+	assert.Equal(t, "bot", posts[0].UserId)
+	assert.Contains(t, posts[0].Message, "Reminder from @jane:\n> check the build")
+	attachments := posts[0].Attachments()
+	require.Len(t, attachments, 1)
+	require.Len(t, attachments[0].Actions, 3)
+
+	act := func(node *Plugin, userID string, action *model.PostAction) *model.PostActionIntegrationResponse {
+		body, err := json.Marshal(&model.PostActionIntegrationRequest{UserId: userID, Context: action.Integration.Context})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, action.Integration.URL[len("/plugins/"+manifest.Id):], bytes.NewReader(body))
+		r.Header.Set("Mattermost-User-ID", userID)
+		node.ServeHTTP(nil, w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response model.PostActionIntegrationResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return &response
+	}
+
+	t.Run("only the user reminded can act", func(t *testing.T) {
+		response := act(node1, "user1", attachments[0].Actions[0])
+		assert.Equal(t, "This reminder isn't yours.", response.EphemeralText)
+		assert.Nil(t, response.Update)
+	})
+
+	t.Run("snooze", func(t *testing.T) {
+		response := act(node1, "user2", attachments[0].Actions[2])
+		require.NotNil(t, response.Update)
+		assert.Contains(t, response.Update.Message, "Snoozed by @john until")
+		assert.Empty(t, response.Update.Attachments())
+
+		reminders, err := node2.listReminders("user1")
+		require.NoError(t, err)
+		require.Len(t, reminders, 2)
+		i := slices.IndexFunc(reminders, func(r *reminder) bool { return r.ID == "due" })
+		require.GreaterOrEqual(t, i, 0)
+		assert.InDelta(t, time.Now().Add(time.Hour).UnixMilli(), reminders[i].RemindAt, float64(time.Minute.Milliseconds()))
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		response := act(node2, "user2", attachments[0].Actions[0])
+		require.NotNil(t, response.Update)
+		assert.Contains(t, response.Update.Message, "Completed by @john.")
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin"
+)
+
+const (
+	// runtimeStateKey is the KV key of the runtime state shared by the plugin instances.
+	runtimeStateKey = "runtime_state"
+
+	// runtimeStateClusterEventID identifies the cluster events broadcasting runtime state
+	// changes.
+	runtimeStateClusterEventID = "runtime_state_changed"
+)
+
+// runtimeState is the state changed at runtime with the /demo_plugin command, as opposed to the
+// plugin settings. It is persisted in the KV store and broadcast to the other plugin instances
+// whenever it changes, so that every node of a cluster behaves the same.
+type runtimeState struct {
+	// Revision increases with every change, so that instances ignore stale updates received out
+	// of order.
+	Revision int64 `json:"revision"`
+
+	// Disabled is whether the plugin hooks are disabled as a whole.
+	Disabled bool `json:"disabled"`
+
+	// HookOverrides tracks the hooks enabled or disabled with /demo_plugin hooks.
+	HookOverrides map[string]bool `json:"hook_overrides"`
+}
+
+// updateRuntimeState atomically applies the given change to the persisted runtime state, then
+// applies the new state locally and broadcasts it to the other plugin instances.
+func (p *Plugin) updateRuntimeState(change func(state *runtimeState)) error {
+	var state runtimeState
+	err := p.updateKV(runtimeStateKey, 0, func(oldValue []byte) (any, error) {
+		state = runtimeState{}
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &state); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal runtime state")
+			}
+		}
+		if state.HookOverrides == nil {
+			state.HookOverrides = make(map[string]bool)
+		}
+
+		change(&state)
+		state.Revision++
+
+		return state, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save runtime state")
+	}
+
+	p.applyRuntimeState(&state)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal runtime state")
+	}
+
+	if err := p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id:   runtimeStateClusterEventID,
+		Data: data,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	}); err != nil {
+		// The state is persisted, so the other instances pick it up when they next activate.
+		p.API.LogWarn("Failed to broadcast runtime state", "err", err.Error())
+	}
+
+	return nil
+}
+
+// loadRuntimeState applies the persisted runtime state, if any. It is called on activation so
+// that an instance joining the cluster, or restarting, picks up the state of the others.
+func (p *Plugin) loadRuntimeState() error {
+	var state runtimeState
+	if err := p.client.KV.Get(runtimeStateKey, &state); err != nil {
+		return errors.Wrap(err, "failed to get runtime state")
+	}
+
+	p.applyRuntimeState(&state)
+
+	return nil
+}
+
+// applyRuntimeState wraps setConfiguration to apply the given runtime state, unless a more
+// recent state was already applied.
+func (p *Plugin) applyRuntimeState(state *runtimeState) {
+	p.runtimeStateLock.Lock()
+	defer p.runtimeStateLock.Unlock()
+
+	var configuration = p.getConfiguration().Clone()
+	if state.Revision < configuration.runtimeRevision {
+		return
+	}
+
+	configuration.runtimeRevision = state.Revision
+	configuration.disabled = state.Disabled
+	configuration.hookOverrides = make(map[string]bool)
+	for hook, enabled := range state.HookOverrides {
+		configuration.hookOverrides[hook] = enabled
+	}
+
+	p.setConfiguration(configuration)
+}
+
+// setLoadedConfiguration wraps setConfiguration to store the configuration loaded from the
+// plugin settings, carrying over the runtime state currently applied. Loading the settings takes
+// a while, and a runtime state received from another instance in the meantime would otherwise
+// be overwritten by the one the loaded configuration was cloned with.
+func (p *Plugin) setLoadedConfiguration(configuration *configuration) {
+	p.runtimeStateLock.Lock()
+	defer p.runtimeStateLock.Unlock()
+
+	current := p.getConfiguration()
+	configuration.runtimeRevision = current.runtimeRevision
+	configuration.disabled = current.disabled
+	configuration.hookOverrides = current.hookOverrides
+
+	p.setConfiguration(configuration)
+}
+
+// OnPluginClusterEvent is invoked when an intra-cluster plugin event is received.
+//
+// This demo implementation applies the runtime state changes made on the other plugin
+// instances, such as hooks being disabled with /demo_plugin false. It reloads the moderation
+// rules, the redaction and slow mode policies, the keyword subscriptions and the reaction actions
+// when they change, and the encryption keys of channels when they are rotated.
+func (p *Plugin) OnPluginClusterEvent(c *plugin.Context, ev model.PluginClusterEvent) {
+	switch ev.Id {
+	case runtimeStateClusterEventID:
+		var state runtimeState
+		if err := json.Unmarshal(ev.Data, &state); err != nil {
+			p.API.LogError("Failed to unmarshal runtime state cluster event", "err", err.Error())
+			return
+		}
+
+		p.applyRuntimeState(&state)
+	case moderationRulesClusterEventID:
+		if _, err := p.loadModerationRules(); err != nil {
+			p.API.LogError("Failed to reload moderation rules", "err", err.Error())
+		}
+	case channelKeysClusterEventID:
+		// Drop the cached keys, they are reloaded from the KV store when next needed.
+		p.cacheChannelKeys(string(ev.Data), nil)
+	case redactionPolicyClusterEventID:
+		p.cacheRedactionPolicy(string(ev.Data), nil)
+	case slowModeClusterEventID:
+		if _, err := p.loadSlowModePolicies(); err != nil {
+			p.API.LogError("Failed to reload slow mode policies", "err", err.Error())
+		}
+	case watchSubscriptionsClusterEventID:
+		if _, err := p.loadWatchIndex(); err != nil {
+			p.API.LogError("Failed to reload keyword subscriptions", "err", err.Error())
+		}
+	case reactionActionsClusterEventID:
+		if _, err := p.loadReactionActions(); err != nil {
+			p.API.LogError("Failed to reload reaction actions", "err", err.Error())
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+// fakeCluster simulates several plugin instances sharing a KV store and exchanging cluster
+// events.
+type fakeCluster struct {
+	mu    sync.Mutex
+	kv    map[string][]byte
+	nodes []*Plugin
+
+	// ttls are the expiries of the keys set with one, in seconds.
+	ttls map[string]int64
+
+	// dropEvents simulates cluster events getting lost.
+	dropEvents bool
+
+	// kvLists counts the pages of keys listed, i.e. the scans of the KV store.
+	kvLists int
+
+	// failedKeyPrefix simulates failures to set the keys starting with it, if not empty.
+	failedKeyPrefix string
+}
+
+// fakeNodeAPI is the API of a single plugin instance of a fakeCluster.
+type fakeNodeAPI struct {
+	*plugintest.API
+	cluster *fakeCluster
+	node    *Plugin
+}
+
+func (a *fakeNodeAPI) KVGet(key string) ([]byte, *model.AppError) {
+	a.cluster.mu.Lock()
+	defer a.cluster.mu.Unlock()
+
+	return a.cluster.kv[key], nil
+}
+
+func (a *fakeNodeAPI) KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError) {
+	a.cluster.mu.Lock()
+	defer a.cluster.mu.Unlock()
+
+	if a.cluster.failedKeyPrefix != "" && strings.HasPrefix(key, a.cluster.failedKeyPrefix) {
+		return false, &model.AppError{Message: "failed to set " + key}
+	}
+	if options.Atomic && !bytes.Equal(a.cluster.kv[key], options.OldValue) {
+		return false, nil
+	}
+	delete(a.cluster.ttls, key)
+	if value == nil {
+		delete(a.cluster.kv, key)
+		return true, nil
+	}
+	a.cluster.kv[key] = value
+	if options.ExpireInSeconds > 0 {
+		a.cluster.ttls[key] = options.ExpireInSeconds
+	}
+
+	return true, nil
+}
+
+func (a *fakeNodeAPI) KVCompareAndDelete(key string, oldValue []byte) (bool, *model.AppError) {
+	a.cluster.mu.Lock()
+	defer a.cluster.mu.Unlock()
+
+	if !bytes.Equal(a.cluster.kv[key], oldValue) {
+		return false, nil
+	}
+	delete(a.cluster.kv, key)
+
+	return true, nil
+}
+
+func (a *fakeNodeAPI) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.cluster.mu.Lock()
+	defer a.cluster.mu.Unlock()
+
+	a.cluster.kvLists++
+	keys := make([]string, 0, len(a.cluster.kv))
+	for key := range a.cluster.kv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	start := min(page*perPage, len(keys))
+	return keys[start:min(start+perPage, len(keys))], nil
+}
+
+func (a *fakeNodeAPI) PublishPluginClusterEvent(ev model.PluginClusterEvent, opts model.PluginClusterEventSendOptions) error {
+	if a.cluster.dropEvents {
+		return nil
+	}
+
+	for _, node := range a.cluster.nodes {
+		if node != a.node {
+			node.OnPluginClusterEvent(nil, ev)
+		}
+	}
+
+	return nil
+}
+
+func newFakeCluster() *fakeCluster {
+	return &fakeCluster{kv: make(map[string][]byte), ttls: make(map[string]int64)}
+}
+
+// addNode starts a new plugin instance in the cluster, loading the persisted runtime state as
+// on activation.
+func (c *fakeCluster) addNode(t *testing.T) *Plugin {
+	node := &Plugin{}
+	api := &fakeNodeAPI{API: &plugintest.API{}, cluster: c, node: node}
+	node.SetAPI(api)
+	node.client = pluginapi.NewClient(api, nil)
+
+	require.NoError(t, node.loadRuntimeState())
+	c.nodes = append(c.nodes, node)
+
+	return node
+}
+
+func TestRuntimeStatePropagation(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	node3 := cluster.addNode(t)
+
+	t.Run("disabling hooks applies to every node", func(t *testing.T) {
+		require.NoError(t, node1.setEnabled(false))
+
+		for _, node := range cluster.nodes {
+			assert.True(t, node.getConfiguration().disabled)
+		}
+	})
+
+	t.Run("hook toggles apply to every node", func(t *testing.T) {
+		require.NoError(t, node2.setHookEnabled(hookMessageHasBeenPosted, false))
+		require.NoError(t, node3.setEnabled(true))
+
+		for _, node := range cluster.nodes {
+			configuration := node.getConfiguration()
+			assert.False(t, configuration.disabled)
+			assert.False(t, configuration.isHookEnabled(hookMessageHasBeenPosted))
+			assert.True(t, configuration.isHookEnabled(hookMessageHasBeenUpdated))
+		}
+	})
+
+	t.Run("stale events are ignored", func(t *testing.T) {
+		revision := node1.getConfiguration().runtimeRevision
+
+		node1.OnPluginClusterEvent(nil, model.PluginClusterEvent{
+			Id:   runtimeStateClusterEventID,
+			Data: []byte(`{"revision":1,"disabled":true}`),
+		})
+
+		configuration := node1.getConfiguration()
+		assert.Equal(t, revision, configuration.runtimeRevision)
+		assert.False(t, configuration.disabled)
+		assert.False(t, configuration.isHookEnabled(hookMessageHasBeenPosted))
+	})
+
+	t.Run("new nodes load the persisted state", func(t *testing.T) {
+		cluster.dropEvents = true
+		defer func() { cluster.dropEvents = false }()
+
+		require.NoError(t, node1.setEnabled(false))
+		assert.False(t, node2.getConfiguration().disabled)
+
+		node4 := cluster.addNode(t)
+		configuration := node4.getConfiguration()
+		assert.True(t, configuration.disabled)
+		assert.False(t, configuration.isHookEnabled(hookMessageHasBeenPosted))
+		assert.Equal(t, node1.getConfiguration().runtimeRevision, configuration.runtimeRevision)
+	})
+
+	t.Run("concurrent changes are all applied", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i, hook := range []string{hookUserHasJoinedTeam, hookUserHasLeftTeam, hookUserHasLoggedIn} {
+			wg.Add(1)
+			go func(node *Plugin, hook string) {
+				defer wg.Done()
+				assert.NoError(t, node.setHookEnabled(hook, false))
+			}(cluster.nodes[i], hook)
+		}
+		wg.Wait()
+
+		node5 := cluster.addNode(t)
+		configuration := node5.getConfiguration()
+		assert.Equal(t, map[string]bool{
+			hookMessageHasBeenPosted: false,
+			hookUserHasJoinedTeam:    false,
+			hookUserHasLeftTeam:      false,
+			hookUserHasLoggedIn:      false,
+		}, configuration.hookOverrides)
+	})
+}
+
+func TestRuntimeStateDuringConfigurationChange(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	api := node1.API.(*fakeNodeAPI).API
+	api.On("LoadPluginConfiguration", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*configuration).Username = "demo"
+	}).Return(nil)
+	api.On("GetUserByUsername", "demo").Run(func(args mock.Arguments) {
+		// Another instance changes the runtime state while this one loads its configuration.
+		require.NoError(t, node2.setEnabled(false))
+		require.NoError(t, node2.setHookEnabled(hookUserHasJoinedTeam, false))
+	}).Return(&model.User{Id: "demo"}, nil)
+	api.On("GetTeams").Return([]*model.Team{}, nil)
+	api.On("GetServerVersion").Return("9.0.0")
+	api.On("EnsureBotUser", mock.Anything).Return("bot", nil)
+	api.On("GetBundlePath").Return("..", nil)
+	api.On("SetProfileImage", "bot", mock.Anything).Return(nil)
+	api.On("LogDebug", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+
+	require.NoError(t, node1.OnConfigurationChange())
+
+	configuration := node1.getConfiguration()
+	assert.Equal(t, "demo", configuration.Username)
+	assert.True(t, configuration.disabled)
+	assert.False(t, configuration.isHookEnabled(hookUserHasJoinedTeam))
+	assert.Equal(t, node2.getConfiguration().runtimeRevision, configuration.runtimeRevision)
+
+	require.NoError(t, node2.setEnabled(true))
+	assert.False(t, node1.getConfiguration().disabled)
+	assert.Equal(t, "demo", node1.getConfiguration().Username)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	commandTriggerSchedule = "schedule"
+
+	// scheduledPostsKeyPrefix prefixes the KV keys of the queue of the posts waiting to be
+	// delivered.
+	scheduledPostsKeyPrefix = "scheduled_posts_"
+
+	// scheduledPostsInterval is how often the delivery job looks for scheduled posts that are due.
+	scheduledPostsInterval = 30 * time.Second
+
+	// scheduledPostMaxAttempts is how many times the delivery of a scheduled post is attempted
+	// before giving up, scheduledPostRetryDelay apart.
+	scheduledPostMaxAttempts = 3
+	scheduledPostRetryDelay  = time.Minute
+
+	maxScheduledPostsPerUser = 50
+	maxScheduleAhead         = 365 * 24 * time.Hour
+)
+
+// scheduledPost is a post to be created as the user once its time has come.
+type scheduledPost struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	ChannelID string `json:"channel_id"`
+	RootID    string `json:"root_id,omitempty"`
+	Message   string `json:"message"`
+	PostAt    int64  `json:"post_at"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (s *scheduledPost) dueKey() (string, string) {
+	return s.UserID, s.ID
+}
+
+func (s *scheduledPost) dueAt() int64 {
+	return s.PostAt
+}
+
+func (p *Plugin) scheduledPostsQueue() *dueQueue[*scheduledPost] {
+	return &dueQueue[*scheduledPost]{
+		p:           p,
+		keyPrefix:   scheduledPostsKeyPrefix,
+		name:        "scheduled post",
+		maxPerOwner: maxScheduledPostsPerUser,
+		maxAttempts: scheduledPostMaxAttempts,
+		retryDelay:  scheduledPostRetryDelay,
+	}
+}
+
+// listScheduledPosts returns the scheduled posts of the user, or of all the users if userID is
+// empty, soonest first.
+func (p *Plugin) listScheduledPosts(userID string) ([]*scheduledPost, error) {
+	if userID == "" {
+		return p.scheduledPostsQueue().listAll()
+	}
+
+	return p.scheduledPostsQueue().list(userID)
+}
+
+// schedulePost stores the post to be delivered at the given time.
+func (p *Plugin) schedulePost(scheduled *scheduledPost) error {
+	err := p.scheduledPostsQueue().add(scheduled)
+	if errors.Is(err, errDueQueueFull) {
+		return errors.Errorf("you can't have more than %d scheduled posts", maxScheduledPostsPerUser)
+	} else if err != nil {
+		return errors.Wrap(err, "failed to save scheduled post")
+	}
+
+	return nil
+}
+
+// cancelScheduledPost deletes the scheduled post of the user, returning false if it doesn't
+// exist or was already delivered.
+func (p *Plugin) cancelScheduledPost(userID, id string) (bool, error) {
+	_, cancelled, err := p.scheduledPostsQueue().take(userID, id)
+
+	return cancelled, err
+}
+
+// ScheduledPostsJob delivers the scheduled posts that are due. It runs on only one plugin
+// instance at a time, and each post is additionally taken from the queue before being created,
+// so that a post is never delivered twice, even if cancelled concurrently. Failed deliveries are
+// retried, up to scheduledPostMaxAttempts, after which the user is notified.
+func (p *Plugin) ScheduledPostsJob() {
+	p.scheduledPostsQueue().deliverDue(time.Now(), p.deliverScheduledPost, func(scheduled *scheduledPost, err error) {
+		reason := err.Error()
+		var appErr *model.AppError
+		if errors.As(err, &appErr) {
+			reason = appErr.Message
+		}
+		p.notifyScheduledPostFailure(scheduled, reason)
+	})
+}
+
+// deliverScheduledPost creates the scheduled post as the user, or notifies the user if they
+// are no longer allowed to post in the channel.
+func (p *Plugin) deliverScheduledPost(scheduled *scheduledPost) error {
+	if !p.API.HasPermissionToChannel(scheduled.UserID, scheduled.ChannelID, model.PermissionCreatePost) {
+		p.notifyScheduledPostFailure(scheduled, "you are no longer allowed to post in the channel")
+		return nil
+	}
+
+	if _, appErr := p.API.CreatePost(&model.Post{
+		UserId:    scheduled.UserID,
+		ChannelId: scheduled.ChannelID,
+		RootId:    scheduled.RootID,
+		Message:   scheduled.Message,
+	}); appErr != nil {
+		return appErr
+	}
+
+	return nil
+}
+
+// notifyScheduledPostFailure lets the user know by direct message that the scheduled post
+// couldn't be delivered, including its message so that it isn't lost.
+func (p *Plugin) notifyScheduledPostFailure(scheduled *scheduledPost, reason string) {
+	dm, appErr := p.API.GetDirectChannel(scheduled.UserID, p.botID)
+	if appErr != nil {
+		p.API.LogError("Failed to get direct channel", "user_id", scheduled.UserID, "err", appErr.Error())
+		return
+	}
+
+	if _, appErr = p.API.CreatePost(&model.Post{
+		UserId:    p.botID,
+		ChannelId: dm.Id,
+		Message:   fmt.Sprintf("Your scheduled post couldn't be delivered: %s. Its message was:\n\n%s", reason, quoteMarkdown(scheduled.Message)),
+	}); appErr != nil {
+		p.API.LogError("Failed to notify user of failed scheduled post", "user_id", scheduled.UserID, "err", appErr.Error())
+	}
+}
+
+// quoteMarkdown formats the text as a Markdown block quote.
+func quoteMarkdown(text string) string {
+	return "> " + strings.ReplaceAll(text, "\n", "\n> ")
+}
+
+func getCommandScheduleAutocompleteData() *model.AutocompleteData {
+	command := model.NewAutocompleteData(commandTriggerSchedule, "[when] [message]", "Schedule a post in this channel.")
+
+	list := model.NewAutocompleteData("list", "", "List your scheduled posts.")
+	command.AddCommand(list)
+
+	cancel := model.NewAutocompleteData("cancel", "[id]", "Cancel a scheduled post.")
+	cancel.AddTextArgument("Id of the scheduled post", "[id]", "")
+	command.AddCommand(cancel)
+
+	command.AddTextArgument("When to post, e.g. in 10m, 17:30, tomorrow 9:00 or 2024-01-15 17:30, followed by the message", "[when] [message]", "")
+
+	return command
+}
+
+func (p *Plugin) executeCommandSchedule(args *model.CommandArgs) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	location := p.userLocation(args.UserId)
+
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args.Command), "/"+commandTriggerSchedule))
+	action, rest := nextWord(text)
+	switch action {
+	case "", "help":
+		return respond("Usage: `/schedule <when> <message>`, e.g. `/schedule in 2h Standup notes are up`, `/schedule tomorrow 9:00 Good morning`, or `/schedule 2024-01-15 17:30 Happy release day`. Times are in your timezone. Also `/schedule list` and `/schedule cancel <id>`.")
+
+	case "list":
+		scheduledPosts, err := p.listScheduledPosts(args.UserId)
+		if err != nil {
+			p.API.LogError("Failed to list scheduled posts", "err", err.Error())
+			return respond("Failed to list your scheduled posts.")
+		}
+		return respond(p.scheduledPostsSummary(scheduledPosts, location))
+
+	case "cancel":
+		id, extra := nextWord(rest)
+		if id == "" || extra != "" {
+			return respond("Usage: `/schedule cancel <id>`.")
+		}
+		cancelled, err := p.cancelScheduledPost(args.UserId, id)
+		if err != nil {
+			p.API.LogError("Failed to cancel scheduled post", "id", id, "err", err.Error())
+			return respond("Failed to cancel the scheduled post.")
+		}
+		if !cancelled {
+			return respond(fmt.Sprintf("You have no scheduled post with id `%s`.", id))
+		}
+		return respond(fmt.Sprintf("Cancelled scheduled post `%s`.", id))
+	}
+
+	now := time.Now()
+	postAt, message, err := parseWhen(text, now, location)
+	if err != nil {
+		return respond(fmt.Sprintf("Invalid time: %s.", err.Error()))
+	}
+	if strings.TrimSpace(message) == "" {
+		return respond("Please provide the message to post, e.g. `/schedule in 2h Standup notes are up`.")
+	}
+	if postAt.Sub(now) > maxScheduleAhead {
+		return respond("Posts can't be scheduled more than a year ahead.")
+	}
+
+	scheduled := &scheduledPost{
+		ID:        model.NewId(),
+		UserID:    args.UserId,
+		ChannelID: args.ChannelId,
+		RootID:    args.RootId,
+		Message:   message,
+		PostAt:    postAt.UnixMilli(),
+		CreatedAt: now.UnixMilli(),
+	}
+	if err := p.schedulePost(scheduled); err != nil {
+		p.API.LogError("Failed to schedule post", "err", err.Error())
+		return respond(fmt.Sprintf("Failed to schedule the post: %s.", err.Error()))
+	}
+
+	return respond(fmt.Sprintf("Scheduled your post for %s, id `%s`.", postAt.Format("Mon Jan 2 2006 15:04 MST"), scheduled.ID))
+}
+
+// scheduledPostsSummary describes the scheduled posts, with their times in the given location.
+func (p *Plugin) scheduledPostsSummary(scheduledPosts []*scheduledPost, location *time.Location) string {
+	if len(scheduledPosts) == 0 {
+		return "You have no scheduled posts."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| Id | When | Channel | Message |\n|:---|:-----|:--------|:--------|\n")
+	for _, scheduled := range scheduledPosts {
+		channelName := scheduled.ChannelID
+		if channel, appErr := p.API.GetChannel(scheduled.ChannelID); appErr == nil {
+			channelName = "~" + channel.Name
+			if channel.IsGroupOrDirect() {
+				channelName = "direct message"
+			}
+		}
+
+		fmt.Fprintf(&sb, "| `%s` | %s | %s | %s |\n",
+			scheduled.ID,
+			time.UnixMilli(scheduled.PostAt).In(location).Format("Mon Jan 2 2006 15:04 MST"),
+			channelName,
+			strings.ReplaceAll(excerpt(scheduled.Message, postExcerptLength), "|", `\|`),
+		)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestScheduledPosts(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+
+	var lock sync.Mutex
+	var posts []*model.Post
+	for _, node := range cluster.nodes {
+		node.botID = "bot"
+		api := node.API.(*fakeNodeAPI).API
+		api.On("HasPermissionToChannel", "user1", "channel1", model.PermissionCreatePost).Return(true)
+		api.On("HasPermissionToChannel", "user1", "channel2", model.PermissionCreatePost).Return(false)
+		api.On("GetDirectChannel", "user1", "bot").Return(&model.Channel{Id: "dm"}, nil)
+		api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+			lock.Lock()
+			defer lock.Unlock()
+			posts = append(posts, args.Get(0).(*model.Post))
+		}).Return(&model.Post{}, nil)
+	}
+
+	now := time.Now()
+	schedule := func(id, channelID string, postAt time.Time) {
+		require.NoError(t, node1.schedulePost(&scheduledPost{
+			ID:        id,
+			UserID:    "user1",
+			ChannelID: channelID,
+			Message:   "message " + id,
+			PostAt:    postAt.UnixMilli(),
+			CreatedAt: now.UnixMilli(),
+		}))
+	}
+	schedule("due", "channel1", now.Add(-time.Second))
+	schedule("later", "channel1", now.Add(time.Hour))
+	schedule("cancelled", "channel1", now.Add(-time.Second))
+	schedule("forbidden", "channel2", now.Add(-time.Second))
+
+	cancelled, err := node2.cancelScheduledPost("user1", "cancelled")
+	require.NoError(t, err)
+	assert.True(t, cancelled)
+	cancelled, err = node2.cancelScheduledPost("user2", "later")
+	require.NoError(t, err)
+	assert.False(t, cancelled, "users can only cancel their own scheduled posts")
+
+	// Both nodes running the job concurrently deliver each post once.
+	var wg sync.WaitGroup
+	for _, node := range cluster.nodes {
+		wg.Add(1)
+		go func(node *Plugin) {
+			defer wg.Done()
+			node.ScheduledPostsJob()
+		}(node)
+	}
+	wg.Wait()
+
+	require.Len(t, posts, 2)
+	byChannel := map[string]*model.Post{}
+	for _, post := range posts {
+		byChannel[post.ChannelId] = post
+	}
+	assert.Equal(t, "user1", byChannel["channel1"].UserId)
+	assert.Equal(t, "message due", byChannel["channel1"].Message)
+	assert.Equal(t, "bot", byChannel["dm"].UserId)
+	assert.Contains(t, byChannel["dm"].Message, "> message forbidden")
+
+	pending, err := node2.listScheduledPosts("user1")
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, "later", pending[0].ID)
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// secretGameKeyPrefix prefixes the KV keys of the current rounds of the secrets of each team.
+	secretGameKeyPrefix = "secret_game_"
+
+	// secretScoresKeyPrefix prefixes the KV keys of the leaderboard of each team.
+	secretScoresKeyPrefix = "secret_scores_"
+
+	secretKindPhrase = "phrase"
+	secretKindNumber = "number"
+
+	secretLeaderboardSize = 10
+)
+
+// secretKinds are the kinds of secrets hidden in each team, rotating independently.
+var secretKinds = []string{secretKindPhrase, secretKindNumber}
+
+// errSecretsUnchanged aborts the update of the rounds of a team when no round ends.
+var errSecretsUnchanged = errors.New("secrets unchanged")
+
+var secretNumberRegexp = regexp.MustCompile(`\b\d+\b`)
+
+// secretRound is the current round of a kind of secret in a team.
+type secretRound struct {
+	Round     int   `json:"round"`
+	StartedAt int64 `json:"started_at"`
+}
+
+// secretGame tracks the current round of each kind of secret of a team. The secrets themselves
+// are derived from the Random Secret setting, the team and the round, so they are never stored.
+type secretGame struct {
+	Rounds map[string]*secretRound `json:"rounds"`
+}
+
+func decodeSecretGame(data []byte) (*secretGame, error) {
+	game := &secretGame{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, game); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal secret game")
+		}
+	}
+	if game.Rounds == nil {
+		game.Rounds = make(map[string]*secretRound)
+	}
+	for _, kind := range secretKinds {
+		if game.Rounds[kind] == nil {
+			game.Rounds[kind] = &secretRound{Round: 1}
+		}
+	}
+
+	return game, nil
+}
+
+// secretScore is the number of secrets of each kind found by a user.
+type secretScore struct {
+	Phrases     int   `json:"phrases"`
+	Numbers     int   `json:"numbers"`
+	LastFoundAt int64 `json:"last_found_at"`
+}
+
+func (s *secretScore) total() int {
+	return s.Phrases + s.Numbers
+}
+
+// deriveSecret returns the secret of the given kind of a team's round, keyed with the Random
+// Secret setting. Secret numbers are drawn between 1 and maxNumber. The secret is empty when the
+// kind of secret is disabled.
+func deriveSecret(key, teamID, kind string, round, maxNumber int) string {
+	if key == "" || (kind == secretKindNumber && maxNumber <= 0) {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s:%s:%d", teamID, kind, round)
+	sum := mac.Sum(nil)
+
+	if kind == secretKindNumber {
+		return strconv.FormatUint(1+binary.BigEndian.Uint64(sum)%uint64(maxNumber), 10)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// containsSecret reports whether the message contains the secret. Secret numbers only match
+// whole numbers, so that 23 isn't found in 1234.
+func containsSecret(message, kind, secret string) bool {
+	if secret == "" {
+		return false
+	}
+
+	if kind == secretKindNumber {
+		for _, number := range secretNumberRegexp.FindAllString(message, -1) {
+			if number == secret {
+				return true
+			}
+		}
+		return false
+	}
+
+	return strings.Contains(message, secret)
+}
+
+func (p *Plugin) getSecretGame(teamID string) (*secretGame, error) {
+	var data []byte
+	if err := p.client.KV.Get(secretGameKeyPrefix+teamID, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get secret game")
+	}
+
+	return decodeSecretGame(data)
+}
+
+// advanceSecrets starts the next round of the secrets of the team for which advance returns
+// true, and returns the rounds that ended by kind. Rounds are advanced atomically, so a round
+// ends only once even when several plugin instances advance it concurrently.
+func (p *Plugin) advanceSecrets(teamID string, now int64, advance func(kind string, round *secretRound) bool) (map[string]int, error) {
+	var ended map[string]int
+	err := p.updateKV(secretGameKeyPrefix+teamID, 0, func(oldValue []byte) (any, error) {
+		game, err := decodeSecretGame(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		ended = make(map[string]int)
+		changed := false
+		for _, kind := range secretKinds {
+			round := game.Rounds[kind]
+			if advance(kind, round) {
+				ended[kind] = round.Round
+				game.Rounds[kind] = &secretRound{Round: round.Round + 1, StartedAt: now}
+				changed = true
+			} else if round.StartedAt == 0 {
+				// Rounds start on the first update of the game.
+				round.StartedAt = now
+				changed = true
+			}
+		}
+		if !changed {
+			return nil, errSecretsUnchanged
+		}
+
+		return game, nil
+	})
+	if err != nil && err != errSecretsUnchanged {
+		return nil, err
+	}
+
+	return ended, nil
+}
+
+// creditSecretFinder adds the secret found to the user's score in the team's leaderboard.
+func (p *Plugin) creditSecretFinder(teamID, userID, kind string, now int64) error {
+	return p.updateKV(secretScoresKeyPrefix+teamID, 0, func(oldValue []byte) (any, error) {
+		scores := make(map[string]*secretScore)
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &scores); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal secret scores")
+			}
+		}
+
+		score := scores[userID]
+		if score == nil {
+			score = &secretScore{}
+			scores[userID] = score
+		}
+		if kind == secretKindNumber {
+			score.Numbers++
+		} else {
+			score.Phrases++
+		}
+		score.LastFoundAt = now
+
+		return scores, nil
+	})
+}
+
+// checkSecrets credits the author of the post with the secrets of the team it contains, if
+// first to find them, and starts a new round for each.
+func (p *Plugin) checkSecrets(post *model.Post, user *model.User, teamID string) error {
+	configuration := p.getConfiguration()
+	if teamID == "" || configuration.RandomSecret == "" {
+		return nil
+	}
+
+	game, err := p.getSecretGame(teamID)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]int)
+	for _, kind := range secretKinds {
+		round := game.Rounds[kind].Round
+		if containsSecret(post.Message, kind, deriveSecret(configuration.RandomSecret, teamID, kind, round, configuration.SecretNumber)) {
+			found[kind] = round
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	now := model.GetMillis()
+	ended, err := p.advanceSecrets(teamID, now, func(kind string, round *secretRound) bool {
+		foundRound, ok := found[kind]
+		return ok && round.Round == foundRound
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range secretKinds {
+		round, ok := ended[kind]
+		if !ok {
+			// Someone else found it first.
+			continue
+		}
+
+		if err := p.creditSecretFinder(teamID, user.Id, kind, now); err != nil {
+			return err
+		}
+
+		msg := fmt.Sprintf("@%s is the first to find the secret %s of round %d! A new secret %s is now hidden.", user.Username, kind, round, kind)
+		if kind == secretKindPhrase && configuration.SecretMessage != "" {
+			msg += "\n" + configuration.SecretMessage
+		}
+		if err := p.postPluginMessage(teamID, msg); err != nil {
+			return errors.Wrap(err, "failed to post secret found message")
+		}
+	}
+
+	return nil
+}
+
+// rotateExpiredSecrets starts a new round of the secrets nobody found within the configured
+// rotation interval, in every team.
+func (p *Plugin) rotateExpiredSecrets() {
+	configuration := p.getConfiguration()
+	if configuration.RandomSecret == "" || configuration.SecretRotationHours <= 0 {
+		return
+	}
+
+	now := model.GetMillis()
+	expiredAt := now - (time.Duration(configuration.SecretRotationHours) * time.Hour).Milliseconds()
+	for teamID := range configuration.demoChannelIDs {
+		ended, err := p.advanceSecrets(teamID, now, func(kind string, round *secretRound) bool {
+			return round.StartedAt != 0 && round.StartedAt <= expiredAt
+		})
+		if err != nil {
+			p.API.LogError("Failed to rotate secrets", "team_id", teamID, "err", err.Error())
+			continue
+		}
+
+		for _, kind := range secretKinds {
+			if round, ok := ended[kind]; ok {
+				msg := fmt.Sprintf("Nobody found the secret %s of round %d in time. A new secret %s is now hidden.", kind, round, kind)
+				if err := p.postPluginMessage(teamID, msg); err != nil {
+					p.API.LogError("Failed to post secret rotation message", "team_id", teamID, "err", err.Error())
+				}
+			}
+		}
+	}
+}
+
+// secretLeaderboard returns the scores of the team, best first, and the earliest to reach a
+// score first among equals.
+func (p *Plugin) secretLeaderboard(teamID string) ([]string, map[string]*secretScore, error) {
+	scores := make(map[string]*secretScore)
+	if err := p.client.KV.Get(secretScoresKeyPrefix+teamID, &scores); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to get secret scores")
+	}
+
+	userIDs := make([]string, 0, len(scores))
+	for userID := range scores {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Slice(userIDs, func(i, j int) bool {
+		a, b := scores[userIDs[i]], scores[userIDs[j]]
+		if a.total() != b.total() {
+			return a.total() > b.total()
+		}
+		if a.LastFoundAt != b.LastFoundAt {
+			return a.LastFoundAt < b.LastFoundAt
+		}
+		return userIDs[i] < userIDs[j]
+	})
+
+	return userIDs, scores, nil
+}
+
+func (p *Plugin) executeCommandSecrets(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	configuration := p.getConfiguration()
+	if configuration.RandomSecret == "" {
+		return respond("The secret hunt is disabled, generate a Random Secret in the plugin settings to enable it.")
+	}
+
+	switch {
+	case len(params) == 0 || (params[0] == "leaderboard" && len(params) == 1):
+		game, err := p.getSecretGame(args.TeamId)
+		if err != nil {
+			p.API.LogError("Failed to get secret game", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to get the secret hunt of the team.")
+		}
+		userIDs, scores, err := p.secretLeaderboard(args.TeamId)
+		if err != nil {
+			p.API.LogError("Failed to get secret leaderboard", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to get the leaderboard of the team.")
+		}
+
+		return respond(p.secretLeaderboardSummary(game, userIDs, scores))
+	case params[0] == "rotate" && len(params) <= 2:
+		if !p.API.HasPermissionToTeam(args.UserId, args.TeamId, model.PermissionManageTeam) {
+			return respond("Only team admins can rotate the secrets of the team.")
+		}
+
+		kinds := secretKinds
+		if len(params) == 2 {
+			if params[1] != secretKindPhrase && params[1] != secretKindNumber {
+				return respond(fmt.Sprintf("Unknown secret %s, expected %s.", params[1], strings.Join(secretKinds, " or ")))
+			}
+			kinds = params[1:]
+		}
+
+		_, err := p.advanceSecrets(args.TeamId, model.GetMillis(), func(kind string, _ *secretRound) bool {
+			return slices.Contains(kinds, kind)
+		})
+		if err != nil {
+			p.API.LogError("Failed to rotate secrets", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to rotate the secrets of the team.")
+		}
+
+		return respond(fmt.Sprintf("Rotated the secret %s of the team.", strings.Join(kinds, " and ")))
+	case params[0] == "reveal" && len(params) == 1:
+		// System admins could already read the Random Secret in the System Console.
+		if !p.isSystemAdmin(args.UserId) {
+			return respond("Only system admins can reveal the secrets of the team.")
+		}
+
+		game, err := p.getSecretGame(args.TeamId)
+		if err != nil {
+			p.API.LogError("Failed to get secret game", "team_id", args.TeamId, "err", err.Error())
+			return respond("Failed to get the secret hunt of the team.")
+		}
+
+		var sb strings.Builder
+		for _, kind := range secretKinds {
+			round := game.Rounds[kind].Round
+			secret := deriveSecret(configuration.RandomSecret, args.TeamId, kind, round, configuration.SecretNumber)
+			if secret == "" {
+				fmt.Fprintf(&sb, "- Secret %s: disabled\n", kind)
+				continue
+			}
+			fmt.Fprintf(&sb, "- Secret %s of round %d: `%s`\n", kind, round, secret)
+		}
+
+		return respond(sb.String())
+	default:
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+}
+
+// secretLeaderboardSummary renders the current rounds and the best scores of a team in Markdown,
+// without the secrets.
+func (p *Plugin) secretLeaderboardSummary(game *secretGame, userIDs []string, scores map[string]*secretScore) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "The secret phrase is in round %d and the secret number in round %d.\n\n",
+		game.Rounds[secretKindPhrase].Round, game.Rounds[secretKindNumber].Round)
+
+	if len(userIDs) == 0 {
+		sb.WriteString("Nobody found a secret yet.")
+		return sb.String()
+	}
+
+	sb.WriteString("| Rank | User | Phrases | Numbers | Total |\n")
+	sb.WriteString("|------|------|---------|---------|-------|\n")
+	for i, userID := range userIDs {
+		if i == secretLeaderboardSize {
+			break
+		}
+
+		username := userID
+		if user, appErr := p.API.GetUser(userID); appErr == nil {
+			username = "@" + user.Username
+		}
+
+		score := scores[userID]
+		fmt.Fprintf(&sb, "| %d | %s | %d | %d | %d |\n", i+1, username, score.Phrases, score.Numbers, score.total())
+	}
+
+	return sb.String()
+}
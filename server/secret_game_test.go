@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestDeriveSecret(t *testing.T) {
+	phrase := deriveSecret("key", "team1", secretKindPhrase, 1, 100)
+	assert.Len(t, phrase, 16)
+	assert.Equal(t, phrase, deriveSecret("key", "team1", secretKindPhrase, 1, 100))
+	assert.NotEqual(t, phrase, deriveSecret("key", "team2", secretKindPhrase, 1, 100))
+	assert.NotEqual(t, phrase, deriveSecret("key", "team1", secretKindPhrase, 2, 100))
+	assert.NotEqual(t, phrase, deriveSecret("other", "team1", secretKindPhrase, 1, 100))
+
+	for round := 1; round <= 50; round++ {
+		number := deriveSecret("key", "team1", secretKindNumber, round, 3)
+		assert.Contains(t, []string{"1", "2", "3"}, number)
+	}
+
+	assert.Empty(t, deriveSecret("", "team1", secretKindPhrase, 1, 100))
+	assert.Empty(t, deriveSecret("key", "team1", secretKindNumber, 1, 0))
+}
+
+func TestContainsSecret(t *testing.T) {
+	assert.True(t, containsSecret("found it: abcXYZ!", secretKindPhrase, "abcXYZ"))
+	assert.False(t, containsSecret("nothing here", secretKindPhrase, ""))
+
+	assert.True(t, containsSecret("is it 23?", secretKindNumber, "23"))
+	assert.False(t, containsSecret("is it 1234?", secretKindNumber, "23"))
+	assert.False(t, containsSecret("is it v23?", secretKindNumber, "23"))
+}
+
+func TestSecretGame(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	for _, node := range cluster.nodes {
+		configuration := node.getConfiguration().Clone()
+		configuration.RandomSecret = "key"
+		configuration.SecretNumber = 1000000
+		configuration.SecretRotationHours = 1
+		configuration.demoChannelIDs = map[string]string{"team1": "demo"}
+		node.setConfiguration(configuration)
+
+		api := node.API.(*fakeNodeAPI).API
+		api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+		api.On("GetUser", "user1").Return(&model.User{Id: "user1", Username: "jane"}, nil)
+		api.On("GetUser", "user2").Return(&model.User{Id: "user2", Username: "john"}, nil)
+	}
+
+	jane := &model.User{Id: "user1", Username: "jane"}
+	john := &model.User{Id: "user2", Username: "john"}
+	phrase := deriveSecret("key", "team1", secretKindPhrase, 1, 1000000)
+	number := deriveSecret("key", "team1", secretKindNumber, 1, 1000000)
+
+	t.Run("first finder is credited", func(t *testing.T) {
+		require.NoError(t, node1.checkSecrets(&model.Post{Message: "the phrase is " + phrase}, jane, "team1"))
+		require.NoError(t, node2.checkSecrets(&model.Post{Message: "the phrase is " + phrase}, john, "team1"))
+		require.NoError(t, node2.checkSecrets(&model.Post{Message: "the number is " + number}, john, "team1"))
+
+		game, err := node1.getSecretGame("team1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, game.Rounds[secretKindPhrase].Round)
+		assert.Equal(t, 2, game.Rounds[secretKindNumber].Round)
+
+		userIDs, scores, err := node1.secretLeaderboard("team1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user1", "user2"}, userIDs)
+		assert.Equal(t, 1, scores["user1"].Phrases)
+		assert.Equal(t, 0, scores["user1"].Numbers)
+		assert.Equal(t, 1, scores["user2"].Numbers)
+
+		summary := node1.secretLeaderboardSummary(game, userIDs, scores)
+		assert.Contains(t, summary, "| 1 | @jane | 1 | 0 | 1 |")
+		assert.NotContains(t, summary, phrase)
+	})
+
+	t.Run("unfound secrets rotate", func(t *testing.T) {
+		game, err := node1.getSecretGame("team1")
+		require.NoError(t, err)
+		game.Rounds[secretKindPhrase].StartedAt = model.GetMillis() - 2*60*60*1000
+		_, err = node1.client.KV.Set(secretGameKeyPrefix+"team1", game)
+		require.NoError(t, err)
+
+		node2.rotateExpiredSecrets()
+
+		game, err = node1.getSecretGame("team1")
+		require.NoError(t, err)
+		assert.Equal(t, 3, game.Rounds[secretKindPhrase].Round)
+		assert.Equal(t, 2, game.Rounds[secretKindNumber].Round)
+	})
+}
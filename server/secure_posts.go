@@ -0,0 +1,319 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	securePrefix = "[SECURE]"
+
+	// encryptedPrefixFormat prefixes the ciphertext stored in place of the message of [SECURE]
+	// posts, identifying the key it was encrypted with.
+	encryptedPrefixFormat = "[ENCRYPTED:%s]"
+
+	// channelKeysKeyPrefix prefixes the KV keys of the per channel encryption keys.
+	channelKeysKeyPrefix = "secure_keys_"
+
+	// channelKeysClusterEventID identifies the cluster events notifying the other plugin
+	// instances that the keys of a channel were rotated.
+	channelKeysClusterEventID = "secure_keys_rotated"
+
+	// channelKeySize is the size of the AES-256 keys, in bytes.
+	channelKeySize = 32
+)
+
+var encryptedMessageRegexp = regexp.MustCompile(`^\[ENCRYPTED:([a-z0-9]+)\](.*)$`)
+
+// channelKeys holds the encryption keys of a channel. Posts are encrypted with the current key,
+// while previous keys are kept to decrypt the posts encrypted before a rotation.
+type channelKeys struct {
+	Current string            `json:"current"`
+	Keys    map[string][]byte `json:"keys"`
+}
+
+// newChannelKey generates a random key and its id.
+func newChannelKey() (string, []byte, error) {
+	key := make([]byte, channelKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", nil, errors.Wrap(err, "failed to generate key")
+	}
+
+	return model.NewId()[:8], key, nil
+}
+
+// encryptMessage encrypts the message with AES-GCM, binding the ciphertext to the channel so
+// that it can't be replayed in another channel.
+func encryptMessage(channelID, keyID string, key []byte, message string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", errors.Wrap(err, "failed to generate nonce")
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(message), []byte(channelID))
+
+	return fmt.Sprintf(encryptedPrefixFormat, keyID) + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptMessage decrypts the ciphertext produced by encryptMessage.
+func decryptMessage(channelID string, key []byte, ciphertext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decode ciphertext")
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	message, err := gcm.Open(nil, nonce, sealed, []byte(channelID))
+	if err != nil {
+		return "", errors.Wrap(err, "failed to decrypt message")
+	}
+
+	return string(message), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cipher")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCM")
+	}
+
+	return gcm, nil
+}
+
+// getChannelKeys returns the keys of the channel, or nil if the channel has none yet. Keys are
+// cached, since they are needed for every post delivered by MessagesWillBeConsumed.
+func (p *Plugin) getChannelKeys(channelID string) (*channelKeys, error) {
+	p.channelKeysLock.RLock()
+	keys, ok := p.channelKeys[channelID]
+	p.channelKeysLock.RUnlock()
+
+	if ok {
+		return keys, nil
+	}
+
+	keys = nil
+	if err := p.client.KV.Get(channelKeysKeyPrefix+channelID, &keys); err != nil {
+		return nil, errors.Wrap(err, "failed to get channel keys")
+	}
+
+	// Only cache existing keys, so that keys created on another instance are picked up.
+	if keys != nil {
+		p.cacheChannelKeys(channelID, keys)
+	}
+
+	return keys, nil
+}
+
+func (p *Plugin) cacheChannelKeys(channelID string, keys *channelKeys) {
+	p.channelKeysLock.Lock()
+	defer p.channelKeysLock.Unlock()
+
+	if p.channelKeys == nil {
+		p.channelKeys = make(map[string]*channelKeys)
+	}
+	if keys == nil {
+		delete(p.channelKeys, channelID)
+		return
+	}
+	p.channelKeys[channelID] = keys
+}
+
+// updateChannelKeys atomically generates a key for the channel, either if it has none yet or to
+// rotate the current one, and returns the updated keys.
+func (p *Plugin) updateChannelKeys(channelID string, rotate bool) (*channelKeys, error) {
+	var keys *channelKeys
+	err := p.updateKV(channelKeysKeyPrefix+channelID, 0, func(oldValue []byte) (any, error) {
+		keys = &channelKeys{Keys: make(map[string][]byte)}
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, keys); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal channel keys")
+			}
+			if !rotate {
+				return oldValue, nil
+			}
+		}
+
+		keyID, key, err := newChannelKey()
+		if err != nil {
+			return nil, err
+		}
+		keys.Keys[keyID] = key
+		keys.Current = keyID
+
+		return keys, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save channel keys")
+	}
+
+	p.cacheChannelKeys(channelID, keys)
+
+	if rotate {
+		if err := p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+			Id:   channelKeysClusterEventID,
+			Data: []byte(channelID),
+		}, model.PluginClusterEventSendOptions{
+			SendType: model.PluginClusterEventSendTypeReliable,
+		}); err != nil {
+			p.API.LogWarn("Failed to broadcast channel key rotation", "channel_id", channelID, "err", err.Error())
+		}
+	}
+
+	return keys, nil
+}
+
+// encryptSecurePost encrypts the message of [SECURE] posts with the current key of the channel,
+// when encryption is enabled.
+func (p *Plugin) encryptSecurePost(post *model.Post, event *hookEvent) (*model.Post, string) {
+	if !p.getConfiguration().EnableSecureEncryption || !strings.HasPrefix(post.Message, securePrefix) {
+		return post, ""
+	}
+
+	keys, err := p.getChannelKeys(post.ChannelId)
+	if err == nil && keys == nil {
+		keys, err = p.updateChannelKeys(post.ChannelId, false)
+	}
+	if err != nil {
+		p.API.LogError("Failed to get channel keys", "channel_id", post.ChannelId, "err", err.Error())
+		event.fail(err)
+		return nil, "Failed to encrypt the message."
+	}
+
+	message := strings.TrimPrefix(post.Message, securePrefix)
+	ciphertext, err := encryptMessage(post.ChannelId, keys.Current, keys.Keys[keys.Current], message)
+	if err != nil {
+		p.API.LogError("Failed to encrypt message", "channel_id", post.ChannelId, "err", err.Error())
+		event.fail(err)
+		return nil, "Failed to encrypt the message."
+	}
+
+	post = post.Clone()
+	post.Message = ciphertext
+
+	return post, ""
+}
+
+// decryptPost decrypts the message of a post encrypted by encryptSecurePost, restoring the
+// [SECURE] prefix so that edits are encrypted again.
+func (p *Plugin) decryptPost(post *model.Post) {
+	matches := encryptedMessageRegexp.FindStringSubmatch(post.Message)
+	if matches == nil {
+		return
+	}
+	keyID, ciphertext := matches[1], matches[2]
+
+	keys, err := p.getChannelKeys(post.ChannelId)
+	if err != nil {
+		p.API.LogWarn("Failed to get channel keys", "channel_id", post.ChannelId, "err", err.Error())
+		return
+	}
+
+	var key []byte
+	if keys != nil {
+		key = keys.Keys[keyID]
+	}
+	if key == nil {
+		p.API.LogWarn("Unknown encryption key", "channel_id", post.ChannelId, "post_id", post.Id, "key_id", keyID)
+		return
+	}
+
+	message, err := decryptMessage(post.ChannelId, key, ciphertext)
+	if err != nil {
+		p.API.LogWarn("Failed to decrypt post", "post_id", post.Id, "err", err.Error())
+		return
+	}
+
+	post.Message = securePrefix + message
+}
+
+func (p *Plugin) executeCommandSecure(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if len(params) == 0 || params[0] == "status" {
+		keys, err := p.getChannelKeys(args.ChannelId)
+		if err != nil {
+			p.API.LogError("Failed to get channel keys", "channel_id", args.ChannelId, "err", err.Error())
+			return respond("Failed to get the encryption keys of this channel.")
+		}
+
+		return respond(channelKeysSummary(keys, p.getConfiguration().EnableSecureEncryption))
+	}
+
+	if params[0] != "rotate" || len(params) != 1 {
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+
+	if !p.isSystemAdmin(args.UserId) {
+		return respond("Only system admins can rotate the encryption keys.")
+	}
+
+	keys, err := p.updateChannelKeys(args.ChannelId, true)
+	if err != nil {
+		p.API.LogError("Failed to rotate channel key", "channel_id", args.ChannelId, "err", err.Error())
+		return respond("Failed to rotate the encryption key of this channel.")
+	}
+
+	return respond(fmt.Sprintf("Rotated the encryption key of this channel, new [SECURE] posts are encrypted with key `%s`. Previous keys are kept to decrypt existing posts.", keys.Current))
+}
+
+// channelKeysSummary describes the encryption keys of a channel in Markdown, without revealing
+// them.
+func channelKeysSummary(keys *channelKeys, enabled bool) string {
+	var sb strings.Builder
+	if !enabled {
+		sb.WriteString("_Encryption of [SECURE] posts is disabled in the plugin settings._\n\n")
+	}
+
+	if keys == nil || len(keys.Keys) == 0 {
+		sb.WriteString("This channel has no encryption key yet, one is generated for the first [SECURE] post.")
+		return sb.String()
+	}
+
+	keyIDs := make([]string, 0, len(keys.Keys))
+	for keyID := range keys.Keys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+
+	fmt.Fprintf(&sb, "New [SECURE] posts are encrypted with key `%s`. Keys of this channel:\n", keys.Current)
+	for _, keyID := range keyIDs {
+		fmt.Fprintf(&sb, "- `%s`\n", keyID)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestEncryptMessage(t *testing.T) {
+	_, key, err := newChannelKey()
+	require.NoError(t, err)
+
+	ciphertext, err := encryptMessage("channel1", "key1", key, " hello")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ciphertext, "[ENCRYPTED:key1]"))
+	assert.NotContains(t, ciphertext, "hello")
+
+	matches := encryptedMessageRegexp.FindStringSubmatch(ciphertext)
+	require.NotNil(t, matches)
+
+	message, err := decryptMessage("channel1", key, matches[2])
+	require.NoError(t, err)
+	assert.Equal(t, " hello", message)
+
+	_, err = decryptMessage("channel2", key, matches[2])
+	assert.Error(t, err, "ciphertexts are bound to their channel")
+
+	_, otherKey, err := newChannelKey()
+	require.NoError(t, err)
+	_, err = decryptMessage("channel1", otherKey, matches[2])
+	assert.Error(t, err)
+}
+
+func TestSecurePosts(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	for _, node := range cluster.nodes {
+		configuration := node.getConfiguration().Clone()
+		configuration.EnableSecureEncryption = true
+		node.setConfiguration(configuration)
+	}
+
+	post := func(node *Plugin, message string) *model.Post {
+		encrypted, reason := node.encryptSecurePost(&model.Post{Id: model.NewId(), ChannelId: "channel1", Message: message}, newHookEvent(hookMessageWillBePosted))
+		require.NotNil(t, encrypted, reason)
+		return encrypted
+	}
+
+	consume := func(node *Plugin, post *model.Post) string {
+		return node.MessagesWillBeConsumed([]*model.Post{post.Clone()})[0].Message
+	}
+
+	plain := post(node1, "hello")
+	assert.Equal(t, "hello", plain.Message, "only [SECURE] posts are encrypted")
+
+	first := post(node1, "[SECURE] first")
+	assert.True(t, strings.HasPrefix(first.Message, "[ENCRYPTED:"))
+	assert.NotContains(t, first.Message, "first")
+
+	t.Run("posts are decrypted by every node", func(t *testing.T) {
+		assert.Equal(t, "[SECURE] first", consume(node1, first))
+		assert.Equal(t, "[SECURE] first", consume(node2, first))
+		assert.Equal(t, "hello", consume(node2, plain))
+	})
+
+	t.Run("rotation keeps previous keys", func(t *testing.T) {
+		keys, err := node2.updateChannelKeys("channel1", true)
+		require.NoError(t, err)
+		assert.Len(t, keys.Keys, 2)
+
+		// node1 dropped its cached keys when notified of the rotation.
+		second := post(node1, "[SECURE] second")
+		assert.Contains(t, second.Message, "[ENCRYPTED:"+keys.Current+"]")
+		assert.NotContains(t, first.Message, "[ENCRYPTED:"+keys.Current+"]")
+
+		for _, node := range cluster.nodes {
+			assert.Equal(t, "[SECURE] first", consume(node, first))
+			assert.Equal(t, "[SECURE] second", consume(node, second))
+		}
+	})
+}
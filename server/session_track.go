@@ -1,43 +1,169 @@
 package main
 
 import (
+	"encoding/json"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
 )
 
 // Logic to track session -> connection mapping via websocket messages due to
 // arguments coming from the slash commands does not bring the connection ID
 // to the plugin.
+//
+// The mapping is kept in the KV store, so that a slash command handled by any node of a
+// cluster finds the connections of the session, whichever node they are connected to. A
+// session can have several connections, e.g. one per browser tab.
+
+const (
+	// sessionConnsKeyPrefix prefixes the KV keys mapping a session to its connections.
+	sessionConnsKeyPrefix = "session_conns_"
+
+	// connSessionKeyPrefix prefixes the KV keys mapping a connection back to its session, so
+	// that disconnections are cleaned up without scanning every session.
+	connSessionKeyPrefix = "conn_session_"
+
+	// sessionTrackingTTL is how long a connection is tracked after its last websocket message,
+	// expiring the connections whose disconnection was missed, e.g. when a node crashed.
+	sessionTrackingTTL = 24 * time.Hour
+
+	// sessionTrackingRefresh is how often the tracking of an active connection is refreshed,
+	// to avoid writing to the KV store on every websocket message.
+	sessionTrackingRefresh = 10 * time.Minute
+)
+
+// sessionConns maps the connections of a session to the time, in milliseconds, they were last
+// seen.
+type sessionConns map[string]int64
 
 func (p *Plugin) initializeSessionTracking() {
-	p.sessionToConn = make(map[string]string)
-	p.sessionToConnMu = sync.RWMutex{}
+	p.trackedConns = make(map[string]time.Time)
+	p.trackedConnsMu = sync.Mutex{}
 }
 
 func (p *Plugin) WebSocketMessageHasBeenPosted(webConnID, userID string, req *model.WebSocketRequest) {
-	if req.Session.Id != "" {
-		p.sessionToConnMu.Lock()
-		p.sessionToConn[req.Session.Id] = webConnID
-		p.sessionToConnMu.Unlock()
+	if req.Session.Id == "" {
+		return
+	}
+
+	// Only the node the connection is attached to receives its messages, so a local cache is
+	// enough to throttle the refreshes.
+	p.trackedConnsMu.Lock()
+	lastTracked, ok := p.trackedConns[webConnID]
+	if ok && time.Since(lastTracked) < sessionTrackingRefresh {
+		p.trackedConnsMu.Unlock()
+		return
+	}
+	p.trackedConns[webConnID] = time.Now()
+	p.trackedConnsMu.Unlock()
+
+	if err := p.trackConnection(req.Session.Id, webConnID); err != nil {
+		p.API.LogWarn("Failed to track websocket connection", "session_id", req.Session.Id, "err", err.Error())
+
+		p.trackedConnsMu.Lock()
+		delete(p.trackedConns, webConnID)
+		p.trackedConnsMu.Unlock()
 	}
 }
 
 func (p *Plugin) OnWebSocketDisconnect(webConnID, userID string) {
-	p.sessionToConnMu.Lock()
-	defer p.sessionToConnMu.Unlock()
+	p.trackedConnsMu.Lock()
+	delete(p.trackedConns, webConnID)
+	p.trackedConnsMu.Unlock()
 
-	for sid, cid := range p.sessionToConn {
-		if cid == webConnID {
-			delete(p.sessionToConn, sid)
-		}
+	if err := p.untrackConnection(webConnID); err != nil {
+		p.API.LogWarn("Failed to untrack websocket connection", "connection_id", webConnID, "err", err.Error())
+	}
+}
+
+// GetConnectionIDsForSession returns the ids of the connections of the given session, across
+// every node of the cluster, most recently seen first.
+func (p *Plugin) GetConnectionIDsForSession(sessionID string) ([]string, error) {
+	var conns sessionConns
+	if err := p.client.KV.Get(sessionConnsKeyPrefix+sessionID, &conns); err != nil {
+		return nil, errors.Wrap(err, "failed to get session connections")
 	}
+
+	conns.expire(time.Now())
+
+	connIDs := make([]string, 0, len(conns))
+	for connID := range conns {
+		connIDs = append(connIDs, connID)
+	}
+	sort.Slice(connIDs, func(i, j int) bool {
+		return conns[connIDs[i]] > conns[connIDs[j]]
+	})
+
+	return connIDs, nil
 }
 
-func (p *Plugin) GetConnectionIDForSession(sessionID string) (string, bool) {
-	p.sessionToConnMu.RLock()
-	defer p.sessionToConnMu.RUnlock()
+// trackConnection records the connection of the session, along with the reverse index.
+func (p *Plugin) trackConnection(sessionID, connID string) error {
+	if _, err := p.client.KV.Set(connSessionKeyPrefix+connID, []byte(sessionID), pluginapi.SetExpiry(sessionTrackingTTL)); err != nil {
+		return errors.Wrap(err, "failed to set connection session")
+	}
 
-	connID, ok := p.sessionToConn[sessionID]
-	return connID, ok
+	return p.updateSessionConns(sessionID, func(conns sessionConns) {
+		conns[connID] = model.GetMillis()
+	})
+}
+
+// untrackConnection removes the connection from the connections of its session, looked up
+// with the reverse index.
+func (p *Plugin) untrackConnection(connID string) error {
+	var sessionID []byte
+	if err := p.client.KV.Get(connSessionKeyPrefix+connID, &sessionID); err != nil {
+		return errors.Wrap(err, "failed to get connection session")
+	}
+	if len(sessionID) == 0 {
+		return nil
+	}
+
+	if err := p.updateSessionConns(string(sessionID), func(conns sessionConns) {
+		delete(conns, connID)
+	}); err != nil {
+		return err
+	}
+
+	if err := p.client.KV.Delete(connSessionKeyPrefix + connID); err != nil {
+		return errors.Wrap(err, "failed to delete connection session")
+	}
+
+	return nil
+}
+
+// updateSessionConns atomically applies the given change to the connections of the session,
+// dropping the expired ones and deleting the key once no connection is left.
+func (p *Plugin) updateSessionConns(sessionID string, change func(conns sessionConns)) error {
+	return p.updateKV(sessionConnsKeyPrefix+sessionID, sessionTrackingTTL, func(oldValue []byte) (any, error) {
+		conns := make(sessionConns)
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &conns); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal session connections")
+			}
+		}
+
+		change(conns)
+		conns.expire(time.Now())
+
+		if len(conns) == 0 {
+			return nil, nil
+		}
+
+		return conns, nil
+	})
+}
+
+// expire drops the connections not seen within sessionTrackingTTL.
+func (c sessionConns) expire(now time.Time) {
+	for connID, lastSeen := range c {
+		if now.Sub(time.UnixMilli(lastSeen)) > sessionTrackingTTL {
+			delete(c, connID)
+		}
+	}
 }
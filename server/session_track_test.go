@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestSessionTracking(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node1.initializeSessionTracking()
+	node2 := cluster.addNode(t)
+	node2.initializeSessionTracking()
+
+	request := func(sessionID string) *model.WebSocketRequest {
+		return &model.WebSocketRequest{Session: model.Session{Id: sessionID}}
+	}
+
+	node1.WebSocketMessageHasBeenPosted("conn1", "user1", request("session1"))
+	node2.WebSocketMessageHasBeenPosted("conn2", "user1", request("session1"))
+	node2.WebSocketMessageHasBeenPosted("conn3", "user2", request("session2"))
+
+	t.Run("connections are found from any node", func(t *testing.T) {
+		connIDs, err := node1.GetConnectionIDsForSession("session1")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"conn1", "conn2"}, connIDs)
+
+		connIDs, err = node1.GetConnectionIDsForSession("session2")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"conn3"}, connIDs)
+	})
+
+	t.Run("disconnections clean up both indexes", func(t *testing.T) {
+		node2.OnWebSocketDisconnect("conn2", "user1")
+
+		connIDs, err := node1.GetConnectionIDsForSession("session1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"conn1"}, connIDs)
+		assert.NotContains(t, cluster.kv, connSessionKeyPrefix+"conn2")
+
+		node1.OnWebSocketDisconnect("conn1", "user1")
+		assert.NotContains(t, cluster.kv, sessionConnsKeyPrefix+"session1")
+		assert.NotContains(t, cluster.kv, connSessionKeyPrefix+"conn1")
+
+		// Unknown connections are ignored.
+		node1.OnWebSocketDisconnect("conn4", "user1")
+	})
+
+	t.Run("stale connections expire", func(t *testing.T) {
+		conns := sessionConns{
+			"fresh": model.GetMillis(),
+			"stale": model.GetMillis() - (sessionTrackingTTL + time.Minute).Milliseconds(),
+		}
+		conns.expire(time.Now())
+		assert.Contains(t, conns, "fresh")
+		assert.NotContains(t, conns, "stale")
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// slowModePoliciesKey is the KV key of the slow mode policies, by channel id. Only the channels
+	// in slow mode have one.
+	slowModePoliciesKey = "slow_mode_policies"
+
+	// slowModeLastPostKeyPrefix prefixes the KV keys of the time users last posted in a channel
+	// in slow mode, expiring after the interval of the channel.
+	slowModeLastPostKeyPrefix = "slow_mode_last_post_"
+
+	// slowModeClusterEventID identifies the cluster events notifying the other plugin instances
+	// that the slow mode policy of a channel changed. The data of the event is the channel id.
+	slowModeClusterEventID = "slow_mode_changed"
+
+	maxSlowModeInterval = 6 * time.Hour
+)
+
+// slowModePolicy is the minimum interval between two posts of a user in a channel. Slow mode is
+// off when the interval is zero.
+type slowModePolicy struct {
+	IntervalSeconds int    `json:"interval_seconds"`
+	UpdatedBy       string `json:"updated_by,omitempty"`
+	UpdatedAt       int64  `json:"updated_at,omitempty"`
+}
+
+func (s *slowModePolicy) interval() time.Duration {
+	return time.Duration(s.IntervalSeconds) * time.Second
+}
+
+// parseSlowModeInterval parses an interval such as 30s or 5m, rounded to the second. "off" and
+// "0" turn slow mode off.
+func parseSlowModeInterval(value string) (time.Duration, error) {
+	if value == "off" || value == "0" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Errorf("invalid interval %q, expected e.g. 30s, 5m or off", value)
+	}
+	interval = interval.Round(time.Second)
+	if interval < time.Second || interval > maxSlowModeInterval {
+		return 0, errors.Errorf("the interval must be between 1s and %s", maxSlowModeInterval)
+	}
+
+	return interval, nil
+}
+
+// formatWait formats the time left to wait, rounded up to the second.
+func formatWait(wait time.Duration) string {
+	return (wait + time.Second - 1).Truncate(time.Second).String()
+}
+
+// getSlowModePolicy returns the slow mode policy of the channel, loading the policies from the KV
+// store the first time. The policy is off for channels without one.
+func (p *Plugin) getSlowModePolicy(channelID string) (*slowModePolicy, error) {
+	p.slowModePoliciesLock.RLock()
+	policies, loaded := p.slowModePolicies, p.slowModePoliciesLoaded
+	p.slowModePoliciesLock.RUnlock()
+
+	if !loaded {
+		var err error
+		if policies, err = p.loadSlowModePolicies(); err != nil {
+			return nil, err
+		}
+	}
+
+	if policy, ok := policies[channelID]; ok {
+		return policy, nil
+	}
+	return &slowModePolicy{}, nil
+}
+
+// loadSlowModePolicies reloads the slow mode policies from the KV store.
+func (p *Plugin) loadSlowModePolicies() (map[string]*slowModePolicy, error) {
+	var policies map[string]*slowModePolicy
+	if err := p.client.KV.Get(slowModePoliciesKey, &policies); err != nil {
+		return nil, errors.Wrap(err, "failed to get slow mode policies")
+	}
+
+	p.slowModePoliciesLock.Lock()
+	p.slowModePolicies = policies
+	p.slowModePoliciesLoaded = true
+	p.slowModePoliciesLock.Unlock()
+
+	return policies, nil
+}
+
+// setSlowMode atomically sets the slow mode interval of the channel, then reloads the policies
+// locally and notifies the other plugin instances.
+func (p *Plugin) setSlowMode(channelID, userID string, interval time.Duration) error {
+	err := p.updateKV(slowModePoliciesKey, 0, func(oldValue []byte) (any, error) {
+		policies := make(map[string]*slowModePolicy)
+		if len(oldValue) > 0 {
+			if err := json.Unmarshal(oldValue, &policies); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal slow mode policies")
+			}
+		}
+
+		if interval == 0 {
+			delete(policies, channelID)
+		} else {
+			policies[channelID] = &slowModePolicy{
+				IntervalSeconds: int(interval / time.Second),
+				UpdatedBy:       userID,
+				UpdatedAt:       model.GetMillis(),
+			}
+		}
+		if len(policies) == 0 {
+			return nil, nil
+		}
+
+		return policies, nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save slow mode policy")
+	}
+
+	if _, err := p.loadSlowModePolicies(); err != nil {
+		return err
+	}
+
+	if err := p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id:   slowModeClusterEventID,
+		Data: []byte(channelID),
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	}); err != nil {
+		p.API.LogWarn("Failed to broadcast slow mode change", "channel_id", channelID, "err", err.Error())
+	}
+
+	return nil
+}
+
+// errSlowModeWait rejects a post made before the end of the slow mode interval.
+type errSlowModeWait struct {
+	wait time.Duration
+}
+
+func (e *errSlowModeWait) Error() string {
+	return fmt.Sprintf("slow mode, wait %s", formatWait(e.wait))
+}
+
+// recordSlowModePost atomically records that the user posts in the channel now, or returns an
+// errSlowModeWait if the user last posted less than the interval ago. The time of the last post
+// is shared by the plugin instances, so only one of concurrent posts is accepted.
+func (p *Plugin) recordSlowModePost(channelID, userID string, interval time.Duration, now time.Time) error {
+	return p.updateKV(slowModeLastPostKeyPrefix+channelID+"_"+userID, interval, func(oldValue []byte) (any, error) {
+		if len(oldValue) > 0 {
+			var lastPostAt int64
+			if err := json.Unmarshal(oldValue, &lastPostAt); err != nil {
+				return nil, errors.Wrap(err, "failed to unmarshal last post time")
+			}
+			if wait := time.UnixMilli(lastPostAt).Add(interval).Sub(now); wait > 0 {
+				return nil, &errSlowModeWait{wait: wait}
+			}
+		}
+
+		return now.UnixMilli(), nil
+	})
+}
+
+// enforceSlowMode rejects the post if the channel is in slow mode and the user posted in the
+// channel less than the interval ago, letting the user know how long to wait. Channel admins
+// and system messages, such as the user joining the channel, are exempt.
+func (p *Plugin) enforceSlowMode(post *model.Post, event *hookEvent) (*model.Post, string) {
+	if post.IsSystemMessage() {
+		return post, ""
+	}
+
+	policy, err := p.getSlowModePolicy(post.ChannelId)
+	if err != nil {
+		p.API.LogError("Failed to get slow mode policy", "channel_id", post.ChannelId, "err", err.Error())
+		return post, ""
+	}
+	if policy.IntervalSeconds == 0 {
+		return post, ""
+	}
+
+	if p.API.HasPermissionToChannel(post.UserId, post.ChannelId, model.PermissionManageChannelRoles) {
+		return post, ""
+	}
+
+	err = p.recordSlowModePost(post.ChannelId, post.UserId, policy.interval(), time.Now())
+	var waitErr *errSlowModeWait
+	if errors.As(err, &waitErr) {
+		p.API.SendEphemeralPost(post.UserId, &model.Post{
+			UserId:    p.getConfiguration().demoUserID,
+			ChannelId: post.ChannelId,
+			RootId:    post.RootId,
+			Message:   fmt.Sprintf("Slow mode is on in this channel, you can post once every %s. Please wait %s before posting again.", policy.interval(), formatWait(waitErr.wait)),
+		})
+
+		event.reject("slow mode")
+		return nil, "slow mode"
+	} else if err != nil {
+		p.API.LogError("Failed to record post in slow mode", "channel_id", post.ChannelId, "err", err.Error())
+	}
+
+	return post, ""
+}
+
+func (p *Plugin) executeCommandSlowMode(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if len(params) > 2 {
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+
+	channel, appErr := p.API.GetChannel(args.ChannelId)
+	if appErr != nil {
+		p.API.LogError("Failed to get channel", "channel_id", args.ChannelId, "err", appErr.Error())
+		return respond("Failed to get the channel.")
+	}
+	if len(params) > 0 && strings.HasPrefix(params[0], "~") {
+		found, err := p.findChannel(args.TeamId, params[0])
+		if err != nil {
+			return respond(fmt.Sprintf("Unknown channel %s.", params[0]))
+		}
+		channel = found
+		params = params[1:]
+	}
+
+	if len(params) == 0 {
+		policy, err := p.getSlowModePolicy(channel.Id)
+		if err != nil {
+			p.API.LogError("Failed to get slow mode policy", "channel_id", channel.Id, "err", err.Error())
+			return respond("Failed to get the slow mode of the channel.")
+		}
+		if policy.IntervalSeconds == 0 {
+			return respond(fmt.Sprintf("Slow mode is off in ~%s.", channel.Name))
+		}
+		return respond(fmt.Sprintf("Slow mode is on in ~%s, users can post once every %s.", channel.Name, policy.interval()))
+	}
+
+	if !p.API.HasPermissionToChannel(args.UserId, channel.Id, model.PermissionManageChannelRoles) {
+		return respond("Only channel admins can change the slow mode of the channel.")
+	}
+
+	interval, err := parseSlowModeInterval(params[0])
+	if err != nil {
+		return respond(fmt.Sprintf("Invalid slow mode: %s.", err.Error()))
+	}
+
+	if err := p.setSlowMode(channel.Id, args.UserId, interval); err != nil {
+		p.API.LogError("Failed to set slow mode", "channel_id", channel.Id, "err", err.Error())
+		return respond("Failed to set the slow mode of the channel.")
+	}
+
+	if interval == 0 {
+		return respond(fmt.Sprintf("Turned slow mode off in ~%s.", channel.Name))
+	}
+	return respond(fmt.Sprintf("Turned slow mode on in ~%s, users can post once every %s. Channel admins are exempt.", channel.Name, interval))
+}
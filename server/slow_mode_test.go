@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestParseSlowModeInterval(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"30s":    30 * time.Second,
+		"1m30s":  90 * time.Second,
+		"1.4s":   time.Second,
+		"off":    0,
+		"0":      0,
+		"6h":     6 * time.Hour,
+		"500ms":  time.Second,
+		"2h0m0s": 2 * time.Hour,
+	} {
+		interval, err := parseSlowModeInterval(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, interval, value)
+	}
+
+	for _, value := range []string{"", "30", "-5s", "100ms", "7h", "soon"} {
+		_, err := parseSlowModeInterval(value)
+		assert.Error(t, err, value)
+	}
+
+	assert.Equal(t, "13s", formatWait(12*time.Second+time.Millisecond))
+	assert.Equal(t, "12s", formatWait(12*time.Second))
+}
+
+func TestSlowMode(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	for _, node := range cluster.nodes {
+		api := node.API.(*fakeNodeAPI).API
+		api.On("HasPermissionToChannel", "admin", "channel1", model.PermissionManageChannelRoles).Return(true)
+		api.On("HasPermissionToChannel", mock.Anything, "channel1", model.PermissionManageChannelRoles).Return(false)
+		api.On("SendEphemeralPost", mock.Anything, mock.Anything).Return(&model.Post{})
+	}
+
+	post := func(node *Plugin, userID string) bool {
+		accepted, _ := node.enforceSlowMode(&model.Post{ChannelId: "channel1", UserId: userID}, newHookEvent(hookMessageWillBePosted))
+		return accepted != nil
+	}
+
+	// node2 caches the channel not being in slow mode before it is turned on.
+	assert.True(t, post(node2, "user1"))
+	assert.True(t, post(node2, "user1"))
+
+	require.NoError(t, node1.setSlowMode("channel1", "admin", time.Minute))
+
+	t.Run("one post per interval across the cluster", func(t *testing.T) {
+		assert.True(t, post(node2, "user1"))
+		assert.False(t, post(node1, "user1"))
+		assert.True(t, post(node1, "user2"), "intervals are per user")
+		assert.True(t, post(node1, "admin"), "channel admins are exempt")
+		assert.True(t, post(node1, "admin"))
+	})
+
+	t.Run("system messages are exempt", func(t *testing.T) {
+		join := func(node *Plugin) bool {
+			accepted, _ := node.enforceSlowMode(&model.Post{ChannelId: "channel1", UserId: "user5", Type: model.PostTypeJoinChannel}, newHookEvent(hookMessageWillBePosted))
+			return accepted != nil
+		}
+		assert.True(t, join(node1))
+		assert.True(t, join(node2))
+		assert.True(t, post(node1, "user5"), "system messages don't count towards the interval")
+		assert.True(t, join(node2))
+	})
+
+	t.Run("concurrent posts", func(t *testing.T) {
+		var accepted atomic.Int32
+		var wg sync.WaitGroup
+		for i := range 4 {
+			wg.Add(1)
+			go func(node *Plugin) {
+				defer wg.Done()
+				if post(node, "user3") {
+					accepted.Add(1)
+				}
+			}(cluster.nodes[i%2])
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(1), accepted.Load())
+	})
+
+	t.Run("posting again after the interval", func(t *testing.T) {
+		require.NoError(t, node1.recordSlowModePost("channel1", "user4", time.Minute, time.Now().Add(-time.Minute)))
+		assert.True(t, post(node2, "user4"))
+	})
+
+	t.Run("turning slow mode off", func(t *testing.T) {
+		require.NoError(t, node2.setSlowMode("channel1", "admin", 0))
+		assert.True(t, post(node1, "user1"))
+
+		for range 10 {
+			accepted, _ := node1.enforceSlowMode(&model.Post{ChannelId: model.NewId(), UserId: "user1"}, newHookEvent(hookMessageWillBePosted))
+			assert.NotNil(t, accepted)
+		}
+		assert.Empty(t, node1.slowModePolicies, "only the channels in slow mode are cached")
+	})
+}
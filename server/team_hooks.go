@@ -11,11 +11,18 @@ import (
 // actor is not nil, the user was added to the team by the actor.
 //
 // This demo implementation logs a message to the demo channel in the team whenever a user
-// joins the team.
+// joins the team, unless onboarding is enabled, in which case the bot welcomes the user with a
+// direct message starting the onboarding instead.
 func (p *Plugin) UserHasJoinedTeam(c *plugin.Context, teamMember *model.TeamMember, actor *model.User) {
+	event := newHookEvent(hookUserHasJoinedTeam)
+	event.TeamID = teamMember.TeamId
+	event.UserID = teamMember.UserId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookUserHasJoinedTeam) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -26,16 +33,46 @@ func (p *Plugin) UserHasJoinedTeam(c *plugin.Context, teamMember *model.TeamMemb
 			"user_id", teamMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
+	if configuration.EnableOnboarding {
+		if user.IsBot || user.Id == configuration.demoUserID {
+			return
+		}
+
+		team, err := p.API.GetTeam(teamMember.TeamId)
+		if err != nil {
+			p.API.LogError(
+				"Failed to query team",
+				"team_id", teamMember.TeamId,
+				"error", err.Error(),
+			)
+			event.fail(err)
+			return
+		}
+
+		if err := p.startOnboarding(user, team); err != nil {
+			p.API.LogError(
+				"Failed to start onboarding",
+				"user_id", teamMember.UserId,
+				"error", err.Error(),
+			)
+			event.fail(err)
+		}
+		return
+	}
+
+	data := hookTemplateData{User: newTemplateUser(user)}
 	msg := fmt.Sprintf("UserHasJoinedTeam: @%s", user.Username)
-	if err := p.postPluginMessage(teamMember.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookUserHasJoinedTeam, teamMember.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post UserHasJoinedTeam message",
 			"user_id", teamMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
 
@@ -45,9 +82,15 @@ func (p *Plugin) UserHasJoinedTeam(c *plugin.Context, teamMember *model.TeamMemb
 // This demo implementation logs a message to the demo channel in the team whenever a user
 // leaves the team.
 func (p *Plugin) UserHasLeftTeam(c *plugin.Context, teamMember *model.TeamMember, actor *model.User) {
+	event := newHookEvent(hookUserHasLeftTeam)
+	event.TeamID = teamMember.TeamId
+	event.UserID = teamMember.UserId
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookUserHasLeftTeam) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -58,15 +101,18 @@ func (p *Plugin) UserHasLeftTeam(c *plugin.Context, teamMember *model.TeamMember
 			"user_id", teamMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
+	data := hookTemplateData{User: newTemplateUser(user)}
 	msg := fmt.Sprintf("UserHasLeftTeam: @%s", user.Username)
-	if err := p.postPluginMessage(teamMember.TeamId, msg); err != nil {
+	if err := p.postHookMessage(hookUserHasLeftTeam, teamMember.TeamId, data, msg); err != nil {
 		p.API.LogError(
 			"Failed to post UserHasLeftTeam message",
 			"user_id", teamMember.UserId,
 			"error", err.Error(),
 		)
+		event.fail(err)
 	}
 }
@@ -12,9 +12,14 @@ import (
 // This demo implementation logs a message to the demo channel in the team whenever a new user
 // is created.
 func (p *Plugin) UserHasBeenCreated(c *plugin.Context, user *model.User) {
+	event := newHookEvent(hookUserHasBeenCreated)
+	event.UserID = user.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookUserHasBeenCreated) {
+		event.Outcome = eventOutcomeSkipped
 		return
 	}
 
@@ -24,17 +29,20 @@ func (p *Plugin) UserHasBeenCreated(c *plugin.Context, user *model.User) {
 			"Failed to query teams UserHasBeenCreated",
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
 	for _, team := range teams {
+		data := hookTemplateData{User: newTemplateUser(user), Team: newTemplateTeam(team)}
 		msg := fmt.Sprintf("@%s has been created. ID: `%s`", user.Username, user.Id)
-		if err := p.postPluginMessage(team.Id, msg); err != nil {
+		if err := p.postHookMessage(hookUserHasBeenCreated, team.Id, data, msg); err != nil {
 			p.API.LogError(
 				"Failed to post UserHasBeenCreated message",
 				"channel_id", configuration.demoChannelIDs[team.Id],
 				"error", err.Error(),
 			)
+			event.fail(err)
 		}
 	}
 }
@@ -42,11 +50,30 @@ func (p *Plugin) UserHasBeenCreated(c *plugin.Context, user *model.User) {
 // UserHasBeenDeactivated is invoked when a user is made inactive.
 //
 // This demo implementation logs a message to the demo channel in the team whenever a user
-// is deactivated.
+// is deactivated, unless an Offboarding Report Channel is configured. The offboarding report
+// of the user is posted there instead, listing what they leave behind with the actions to
+// reassign it.
 func (p *Plugin) UserHasBeenDeactivated(c *plugin.Context, user *model.User) {
+	event := newHookEvent(hookUserHasBeenDeactivated)
+	event.UserID = user.Id
+	defer p.recordEvent(event)
+
 	configuration := p.getConfiguration()
 
-	if configuration.disabled {
+	if !configuration.isHookEnabled(hookUserHasBeenDeactivated) {
+		event.Outcome = eventOutcomeSkipped
+		return
+	}
+
+	if configuration.OffboardingReportChannel != "" {
+		if err := p.postOffboardingReport(user); err != nil {
+			p.API.LogError(
+				"Failed to post offboarding report",
+				"user_id", user.Id,
+				"error", err.Error(),
+			)
+			event.fail(err)
+		}
 		return
 	}
 
@@ -56,17 +83,20 @@ func (p *Plugin) UserHasBeenDeactivated(c *plugin.Context, user *model.User) {
 			"Failed to query teams UserHasBeenDeactivated",
 			"error", err.Error(),
 		)
+		event.fail(err)
 		return
 	}
 
 	for _, team := range teams {
+		data := hookTemplateData{User: newTemplateUser(user), Team: newTemplateTeam(team)}
 		msg := fmt.Sprintf("@%s has been deactivated. ID: `%s`", user.Username, user.Id)
-		if err := p.postPluginMessage(team.Id, msg); err != nil {
+		if err := p.postHookMessage(hookUserHasBeenDeactivated, team.Id, data, msg); err != nil {
 			p.API.LogError(
 				"Failed to post UserHasBeenDeactivated message",
 				"channel_id", configuration.demoChannelIDs[team.Id],
 				"error", err.Error(),
 			)
+			event.fail(err)
 		}
 	}
 }
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+const (
+	// watchSubscriptionsKey is the KV key of the keyword subscriptions of every user.
+	watchSubscriptionsKey = "watch_subscriptions"
+
+	// watchSubscriptionsClusterEventID identifies the cluster events notifying the other plugin
+	// instances that the keyword subscriptions changed.
+	watchSubscriptionsClusterEventID = "watch_subscriptions_changed"
+
+	maxWatchSubscriptionsPerUser = 25
+	maxWatchPatternLength        = 200
+)
+
+// watchSubscription is a keyword, or a regular expression between slashes, a user is alerted
+// of when it occurs in a post, optionally only in a channel.
+type watchSubscription struct {
+	UserID    string `json:"user_id"`
+	Pattern   string `json:"pattern"`
+	ChannelID string `json:"channel_id,omitempty"`
+
+	// keyword is the normalized keyword, words separated by single spaces, if not a regexp.
+	keyword string
+	re      *regexp.Regexp
+}
+
+// compile validates the pattern of the subscription. Keywords match whole words, ignoring case
+// and punctuation, while regular expressions are case insensitive.
+func (s *watchSubscription) compile() error {
+	if len(s.Pattern) > maxWatchPatternLength {
+		return errors.Errorf("patterns are limited to %d characters", maxWatchPatternLength)
+	}
+
+	if len(s.Pattern) > 2 && strings.HasPrefix(s.Pattern, "/") && strings.HasSuffix(s.Pattern, "/") {
+		re, err := regexp.Compile("(?i)" + s.Pattern[1:len(s.Pattern)-1])
+		if err != nil {
+			return errors.Wrap(err, "invalid regular expression")
+		}
+		s.re = re
+		return nil
+	}
+
+	s.keyword = strings.Join(watchWords(s.Pattern), " ")
+	if s.keyword == "" {
+		return errors.New("keywords must contain a letter or a digit")
+	}
+
+	return nil
+}
+
+// watchWords splits the text into lower case words.
+func watchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// watchMatcher matches posts against a set of subscriptions. Single word keywords are looked up
+// by word, so that the cost of matching a post doesn't grow with the number of keywords, and
+// regular expressions are only evaluated one by one when their union matches.
+type watchMatcher struct {
+	words    map[string][]*watchSubscription
+	phrases  []*watchSubscription
+	regexps  []*watchSubscription
+	anyRegex *regexp.Regexp
+}
+
+func newWatchMatcher(subscriptions []*watchSubscription) *watchMatcher {
+	matcher := &watchMatcher{words: make(map[string][]*watchSubscription)}
+
+	var sources []string
+	for _, subscription := range subscriptions {
+		switch {
+		case subscription.re != nil:
+			matcher.regexps = append(matcher.regexps, subscription)
+			sources = append(sources, "(?:"+subscription.re.String()+")")
+		case strings.Contains(subscription.keyword, " "):
+			matcher.phrases = append(matcher.phrases, subscription)
+		default:
+			matcher.words[subscription.keyword] = append(matcher.words[subscription.keyword], subscription)
+		}
+	}
+
+	if len(sources) > 0 {
+		// The union may be too large to compile, the patterns being evaluated one by one then.
+		matcher.anyRegex, _ = regexp.Compile(strings.Join(sources, "|"))
+	}
+
+	return matcher
+}
+
+// match returns the subscriptions matching the message, words being its lower case words.
+func (m *watchMatcher) match(message string, words []string) []*watchSubscription {
+	var matches []*watchSubscription
+	for _, word := range words {
+		matches = append(matches, m.words[word]...)
+	}
+
+	if len(m.phrases) > 0 {
+		text := " " + strings.Join(words, " ") + " "
+		for _, subscription := range m.phrases {
+			if strings.Contains(text, " "+subscription.keyword+" ") {
+				matches = append(matches, subscription)
+			}
+		}
+	}
+
+	if len(m.regexps) > 0 && (m.anyRegex == nil || m.anyRegex.MatchString(message)) {
+		for _, subscription := range m.regexps {
+			if subscription.re.MatchString(message) {
+				matches = append(matches, subscription)
+			}
+		}
+	}
+
+	return matches
+}
+
+// watchIndex matches posts against every subscription, those limited to a channel being only
+// matched against the posts of that channel.
+type watchIndex struct {
+	subscriptions []*watchSubscription
+	global        *watchMatcher
+	byChannel     map[string]*watchMatcher
+}
+
+func newWatchIndex(subscriptions []*watchSubscription) *watchIndex {
+	var global []*watchSubscription
+	byChannel := make(map[string][]*watchSubscription)
+	for _, subscription := range subscriptions {
+		if subscription.ChannelID == "" {
+			global = append(global, subscription)
+		} else {
+			byChannel[subscription.ChannelID] = append(byChannel[subscription.ChannelID], subscription)
+		}
+	}
+
+	index := &watchIndex{
+		subscriptions: subscriptions,
+		global:        newWatchMatcher(global),
+		byChannel:     make(map[string]*watchMatcher),
+	}
+	for channelID, channelSubscriptions := range byChannel {
+		index.byChannel[channelID] = newWatchMatcher(channelSubscriptions)
+	}
+
+	return index
+}
+
+// match returns the patterns matching the post, by subscriber.
+func (i *watchIndex) match(channelID, message string) map[string][]string {
+	words := watchWords(message)
+
+	matches := i.global.match(message, words)
+	if matcher, ok := i.byChannel[channelID]; ok {
+		matches = append(matches, matcher.match(message, words)...)
+	}
+
+	patterns := make(map[string][]string)
+	for _, subscription := range matches {
+		if !slices.Contains(patterns[subscription.UserID], subscription.Pattern) {
+			patterns[subscription.UserID] = append(patterns[subscription.UserID], subscription.Pattern)
+		}
+	}
+
+	return patterns
+}
+
+// forUser returns the subscriptions of the user.
+func (i *watchIndex) forUser(userID string) []*watchSubscription {
+	var subscriptions []*watchSubscription
+	for _, subscription := range i.subscriptions {
+		if subscription.UserID == userID {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+
+	return subscriptions
+}
+
+func unmarshalWatchSubscriptions(data []byte) ([]*watchSubscription, error) {
+	var subscriptions []*watchSubscription
+	if len(data) == 0 {
+		return subscriptions, nil
+	}
+
+	if err := json.Unmarshal(data, &subscriptions); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal keyword subscriptions")
+	}
+	for _, subscription := range subscriptions {
+		if err := subscription.compile(); err != nil {
+			return nil, errors.Wrapf(err, "invalid keyword subscription %q", subscription.Pattern)
+		}
+	}
+
+	return subscriptions, nil
+}
+
+// getWatchIndex returns the index of the keyword subscriptions, loading them from the KV store
+// the first time.
+func (p *Plugin) getWatchIndex() (*watchIndex, error) {
+	p.watchIndexLock.RLock()
+	index := p.watchIndex
+	p.watchIndexLock.RUnlock()
+
+	if index != nil {
+		return index, nil
+	}
+
+	return p.loadWatchIndex()
+}
+
+// loadWatchIndex reloads the keyword subscriptions from the KV store and rebuilds their index.
+func (p *Plugin) loadWatchIndex() (*watchIndex, error) {
+	var data []byte
+	if err := p.client.KV.Get(watchSubscriptionsKey, &data); err != nil {
+		return nil, errors.Wrap(err, "failed to get keyword subscriptions")
+	}
+
+	subscriptions, err := unmarshalWatchSubscriptions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	index := newWatchIndex(subscriptions)
+
+	p.watchIndexLock.Lock()
+	p.watchIndex = index
+	p.watchIndexLock.Unlock()
+
+	return index, nil
+}
+
+// updateWatchSubscriptions atomically applies the given change to the stored keyword
+// subscriptions, then rebuilds the index locally and notifies the other plugin instances.
+func (p *Plugin) updateWatchSubscriptions(change func(subscriptions []*watchSubscription) ([]*watchSubscription, error)) error {
+	err := p.updateKV(watchSubscriptionsKey, 0, func(oldValue []byte) (any, error) {
+		subscriptions, err := unmarshalWatchSubscriptions(oldValue)
+		if err != nil {
+			return nil, err
+		}
+
+		return change(subscriptions)
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to save keyword subscriptions")
+	}
+
+	if _, err := p.loadWatchIndex(); err != nil {
+		return err
+	}
+
+	if err := p.API.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id: watchSubscriptionsClusterEventID,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	}); err != nil {
+		p.API.LogWarn("Failed to broadcast keyword subscriptions change", "err", err.Error())
+	}
+
+	return nil
+}
+
+// notifyWatchers sends a direct message from the bot to the users subscribed to keywords the
+// post matches, if they are members of the channel.
+func (p *Plugin) notifyWatchers(post *model.Post, author *model.User, channel *model.Channel) error {
+	if post.IsSystemMessage() {
+		return nil
+	}
+
+	index, err := p.getWatchIndex()
+	if err != nil {
+		return err
+	}
+	if len(index.subscriptions) == 0 {
+		return nil
+	}
+
+	matches := index.match(post.ChannelId, post.Message)
+	delete(matches, post.UserId)
+
+	userIDs := make([]string, 0, len(matches))
+	for userID := range matches {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	for _, userID := range userIDs {
+		if _, appErr := p.API.GetChannelMember(channel.Id, userID); appErr != nil {
+			continue
+		}
+
+		dm, appErr := p.API.GetDirectChannel(userID, p.botID)
+		if appErr != nil {
+			return errors.Wrapf(appErr, "failed to get direct channel of user %s", userID)
+		}
+
+		var quoted []string
+		for _, pattern := range matches[userID] {
+			quoted = append(quoted, fmt.Sprintf("`%s`", pattern))
+		}
+
+		location := "~" + channel.Name
+		if channel.IsGroupOrDirect() {
+			location = "a direct message"
+		}
+
+		msg := fmt.Sprintf("@%s mentioned %s in %s: %s\n> %s",
+			author.Username, strings.Join(quoted, ", "), location, p.permalink(post.Id), excerpt(post.Message, postExcerptLength))
+		if _, appErr := p.API.CreatePost(&model.Post{
+			UserId:    p.botID,
+			ChannelId: dm.Id,
+			Message:   msg,
+		}); appErr != nil {
+			return errors.Wrapf(appErr, "failed to alert user %s", userID)
+		}
+	}
+
+	return nil
+}
+
+func (p *Plugin) executeCommandWatch(args *model.CommandArgs, params []string) *model.CommandResponse {
+	respond := func(text string) *model.CommandResponse {
+		return &model.CommandResponse{
+			ResponseType: model.CommandResponseTypeEphemeral,
+			Text:         text,
+		}
+	}
+
+	if len(params) == 0 || (params[0] == "list" && len(params) == 1) {
+		index, err := p.getWatchIndex()
+		if err != nil {
+			p.API.LogError("Failed to get keyword subscriptions", "err", err.Error())
+			return respond("Failed to get your keyword subscriptions.")
+		}
+		return respond(p.watchSubscriptionsSummary(index.forUser(args.UserId)))
+	}
+
+	action := params[0]
+	if (action != "add" && action != "remove") || len(params) < 2 {
+		return respond(fmt.Sprintf("Unknown command action: %s", args.Command))
+	}
+
+	// Patterns may contain spaces, so parse the raw command rather than the fields.
+	_, pattern, _ := strings.Cut(args.Command, " "+action+" ")
+	pattern = strings.TrimSpace(pattern)
+
+	subscription := &watchSubscription{UserID: args.UserId}
+	if i := strings.LastIndex(pattern, " ~"); i >= 0 && !strings.Contains(pattern[i+1:], " ") {
+		channel, err := p.findChannel(args.TeamId, pattern[i+1:])
+		if err != nil {
+			return respond(fmt.Sprintf("Unknown channel %s.", pattern[i+1:]))
+		}
+		if _, appErr := p.API.GetChannelMember(channel.Id, args.UserId); appErr != nil && action == "add" {
+			return respond(fmt.Sprintf("You must be a member of %s to watch it.", pattern[i+1:]))
+		}
+		subscription.ChannelID = channel.Id
+		pattern = strings.TrimSpace(pattern[:i])
+	}
+	subscription.Pattern = pattern
+
+	same := func(other *watchSubscription) bool {
+		return other.UserID == subscription.UserID && other.Pattern == subscription.Pattern && other.ChannelID == subscription.ChannelID
+	}
+
+	if action == "remove" {
+		errNotFound := errors.New("not watching")
+		err := p.updateWatchSubscriptions(func(subscriptions []*watchSubscription) ([]*watchSubscription, error) {
+			i := slices.IndexFunc(subscriptions, same)
+			if i < 0 {
+				return nil, errNotFound
+			}
+			return slices.Delete(subscriptions, i, i+1), nil
+		})
+		if errors.Is(err, errNotFound) {
+			return respond(fmt.Sprintf("You aren't watching `%s`.", pattern))
+		} else if err != nil {
+			p.API.LogError("Failed to remove keyword subscription", "err", err.Error())
+			return respond("Failed to remove the keyword subscription.")
+		}
+		return respond(fmt.Sprintf("Stopped watching `%s`.", pattern))
+	}
+
+	if err := subscription.compile(); err != nil {
+		return respond(fmt.Sprintf("Invalid pattern: %s", err.Error()))
+	}
+
+	var invalid error
+	err := p.updateWatchSubscriptions(func(subscriptions []*watchSubscription) ([]*watchSubscription, error) {
+		if slices.ContainsFunc(subscriptions, same) {
+			invalid = errors.Errorf("you are already watching `%s`", pattern)
+			return nil, invalid
+		}
+		count := 0
+		for _, other := range subscriptions {
+			if other.UserID == subscription.UserID {
+				count++
+			}
+		}
+		if count >= maxWatchSubscriptionsPerUser {
+			invalid = errors.Errorf("you can't watch more than %d patterns", maxWatchSubscriptionsPerUser)
+			return nil, invalid
+		}
+		return append(subscriptions, subscription), nil
+	})
+	if invalid != nil {
+		return respond(fmt.Sprintf("Failed to watch `%s`: %s.", pattern, invalid.Error()))
+	} else if err != nil {
+		p.API.LogError("Failed to add keyword subscription", "err", err.Error())
+		return respond("Failed to add the keyword subscription.")
+	}
+
+	return respond(fmt.Sprintf("Watching `%s`, you'll get a direct message from the bot when it's posted in a channel you are a member of.", pattern))
+}
+
+// watchSubscriptionsSummary lists the subscriptions of a user in Markdown.
+func (p *Plugin) watchSubscriptionsSummary(subscriptions []*watchSubscription) string {
+	if len(subscriptions) == 0 {
+		return "You aren't watching any keyword. Use `/demo_plugin watch add <pattern> [~channel]` to get alerted of posts containing it."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("You are watching:\n")
+	for _, subscription := range subscriptions {
+		scope := "every channel"
+		if subscription.ChannelID != "" {
+			scope = fmt.Sprintf("`%s`", subscription.ChannelID)
+			if channel, appErr := p.API.GetChannel(subscription.ChannelID); appErr == nil {
+				scope = "~" + channel.Name
+			}
+		}
+		fmt.Fprintf(&sb, "- `%s` in %s\n", subscription.Pattern, scope)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+func TestWatchIndex(t *testing.T) {
+	subscription := func(userID, pattern, channelID string) *watchSubscription {
+		s := &watchSubscription{UserID: userID, Pattern: pattern, ChannelID: channelID}
+		require.NoError(t, s.compile())
+		return s
+	}
+
+	subscriptions := []*watchSubscription{
+		subscription("user1", "Deploy", ""),
+		subscription("user1", "release notes", ""),
+		subscription("user2", `/v\d+\.\d+/`, ""),
+		subscription("user2", "deploy", "channel2"),
+	}
+	for i := range 500 {
+		subscriptions = append(subscriptions, subscription("user3", fmt.Sprintf("keyword%d", i), ""))
+	}
+	index := newWatchIndex(subscriptions)
+
+	assert.Equal(t, map[string][]string{
+		"user1": {"Deploy", "release notes"},
+		"user2": {`/v\d+\.\d+/`},
+	}, index.match("channel1", "DEPLOY of V1.2 done, see the Release-Notes!"))
+
+	assert.Equal(t, map[string][]string{
+		"user1": {"Deploy"},
+		"user2": {"deploy"},
+	}, index.match("channel2", "deploy"), "channel subscriptions only match in their channel")
+
+	assert.Equal(t, map[string][]string{"user3": {"keyword42"}}, index.match("channel1", "keyword42"))
+	assert.Empty(t, index.match("channel1", "deployment of release 1.2 notes"), "keywords match whole words")
+
+	for _, pattern := range []string{"/(/", "!!!", string(make([]byte, maxWatchPatternLength+1))} {
+		assert.Error(t, (&watchSubscription{Pattern: pattern}).compile(), pattern)
+	}
+}
+
+func TestNotifyWatchers(t *testing.T) {
+	cluster := newFakeCluster()
+	node1 := cluster.addNode(t)
+	node2 := cluster.addNode(t)
+	node2.botID = "bot"
+
+	api := node2.API.(*fakeNodeAPI).API
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: model.NewPointer("http://localhost")}})
+	api.On("GetChannelMember", "channel1", "member").Return(&model.ChannelMember{}, nil)
+	api.On("GetChannelMember", "channel1", "outsider").Return(nil, &model.AppError{Message: "not a member"})
+	api.On("GetDirectChannel", "member", "bot").Return(&model.Channel{Id: "dm"}, nil)
+	var alerts []*model.Post
+	api.On("CreatePost", mock.Anything).Run(func(args mock.Arguments) {
+		alerts = append(alerts, args.Get(0).(*model.Post))
+	}).Return(&model.Post{}, nil)
+
+	// The index of node2 is built before the subscriptions are added on node1.
+	_, err := node2.getWatchIndex()
+	require.NoError(t, err)
+
+	for _, userID := range []string{"member", "outsider", "author"} {
+		require.NoError(t, node1.updateWatchSubscriptions(func(subscriptions []*watchSubscription) ([]*watchSubscription, error) {
+			subscription := &watchSubscription{UserID: userID, Pattern: "outage"}
+			require.NoError(t, subscription.compile())
+			return append(subscriptions, subscription), nil
+		}))
+	}
+
+	post := &model.Post{Id: "post1", ChannelId: "channel1", UserId: "author", Message: "Major outage in progress"}
+	require.NoError(t, node2.notifyWatchers(post, &model.User{Id: "author", Username: "jane"}, &model.Channel{Id: "channel1", Name: "town-square", Type: model.ChannelTypeOpen}))
+
+	require.Len(t, alerts, 1, "only members of the channel are alerted, not the author")
+	assert.Equal(t, "dm", alerts[0].ChannelId)
+	assert.Equal(t, "bot", alerts[0].UserId)
+	assert.Contains(t, alerts[0].Message, "@jane mentioned `outage` in ~town-square: http://localhost/_redirect/pl/post1")
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+)
+
+const (
+	// webhookDeliveriesKeyPrefix prefixes the KV keys of the queue of deliveries waiting to be
+	// retried.
+	webhookDeliveriesKeyPrefix = "webhook_deliveries_"
+
+	// webhookDeadLetterKeyPrefix prefixes the KV keys of deliveries that exhausted their attempts.
+	webhookDeadLetterKeyPrefix = "webhook_deadletter_"
+
+	// webhookDeadLetterRetention is how long dead letters are kept before expiring.
+	webhookDeadLetterRetention = 7 * 24 * time.Hour
+
+	// webhookInitialBackoff is the delay before the first retry of a failed delivery. The
+	// delay doubles with each further attempt, up to webhookMaxBackoff.
+	webhookInitialBackoff = 30 * time.Second
+	webhookMaxBackoff     = time.Hour
+
+	// webhookRetryInterval is how often the retry job looks for deliveries that are due.
+	webhookRetryInterval = 30 * time.Second
+
+	webhookSignatureHeader = "X-Demo-Plugin-Signature"
+	webhookEventHeader     = "X-Demo-Plugin-Event"
+	webhookDeliveryHeader  = "X-Demo-Plugin-Delivery"
+)
+
+// webhookHooks are the hooks whose events are forwarded to the webhook endpoints.
+var webhookHooks = map[string]bool{
+	hookMessageHasBeenPosted:   true,
+	hookMessageHasBeenUpdated:  true,
+	hookMessageHasBeenDeleted:  true,
+	hookReactionHasBeenAdded:   true,
+	hookReactionHasBeenRemoved: true,
+	hookUserHasJoinedChannel:   true,
+	hookUserHasLeftChannel:     true,
+	hookUserHasJoinedTeam:      true,
+	hookUserHasLeftTeam:        true,
+	hookUserHasBeenCreated:     true,
+	hookUserHasBeenDeactivated: true,
+	hookUserHasLoggedIn:        true,
+	hookFileWillBeUploaded:     true,
+}
+
+var webhookHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookEndpoint is an HTTP endpoint hook events are forwarded to.
+type webhookEndpoint struct {
+	URL    string
+	Secret string
+}
+
+// parseWebhookEndpoints parses the EventWebhooks setting, which lists one endpoint per line as
+// the URL followed by the secret used to sign the deliveries. Blank lines are ignored.
+func parseWebhookEndpoints(setting string) ([]webhookEndpoint, error) {
+	var endpoints []webhookEndpoint
+	for i, line := range strings.Split(setting, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 2 {
+			return nil, errors.Errorf("line %d: expected a URL followed by a secret", i+1)
+		}
+
+		u, err := url.Parse(fields[0])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.Errorf("line %d: invalid webhook URL %q", i+1, fields[0])
+		}
+
+		endpoints = append(endpoints, webhookEndpoint{
+			URL:    fields[0],
+			Secret: fields[1],
+		})
+	}
+
+	return endpoints, nil
+}
+
+// webhookDelivery is a hook event waiting to be delivered to a webhook endpoint. Deliveries
+// only reference their endpoint by URL so that secrets are never persisted in the KV store.
+type webhookDelivery struct {
+	ID            string          `json:"id"`
+	EndpointURL   string          `json:"endpoint_url"`
+	Hook          string          `json:"hook"`
+	Payload       json.RawMessage `json:"payload"`
+	Attempts      int             `json:"attempts"`
+	CreatedAt     int64           `json:"created_at"`
+	NextAttemptAt int64           `json:"next_attempt_at"`
+	LastError     string          `json:"last_error,omitempty"`
+}
+
+// Deliveries are queued by themselves, rather than with the other deliveries of their endpoint,
+// so that an endpoint failing doesn't make its deliveries contend on a single key.
+func (d *webhookDelivery) dueKey() (string, string) {
+	return d.ID, d.ID
+}
+
+func (d *webhookDelivery) dueAt() int64 {
+	return d.NextAttemptAt
+}
+
+// webhookDeliveriesQueue is the queue of the deliveries waiting to be retried. The deliveries
+// reschedule themselves when they fail, so the queue only attempts each one once.
+func (p *Plugin) webhookDeliveriesQueue() *dueQueue[*webhookDelivery] {
+	return &dueQueue[*webhookDelivery]{
+		p:           p,
+		keyPrefix:   webhookDeliveriesKeyPrefix,
+		name:        "webhook delivery",
+		maxAttempts: 1,
+	}
+}
+
+// signWebhookPayload returns the hex encoded HMAC-SHA256 of the payload using the secret.
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before retrying a delivery that failed the given number of
+// times.
+func webhookBackoff(attempts int) time.Duration {
+	backoff := webhookInitialBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+
+	return backoff
+}
+
+// sendWebhook posts the delivery's payload to the endpoint, signed with the endpoint's secret.
+func sendWebhook(client *http.Client, endpoint webhookEndpoint, delivery *webhookDelivery) error {
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return errors.Wrap(err, "failed to create request")
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookEventHeader, delivery.Hook)
+	req.Header.Set(webhookDeliveryHeader, delivery.ID)
+	req.Header.Set(webhookSignatureHeader, "sha256="+signWebhookPayload(endpoint.Secret, delivery.Payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send request")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// forwardEvent delivers the event to every configured webhook endpoint. Deliveries are only
+// queued in the KV store once their first attempt fails, so that forwarding an event doesn't
+// write to the KV store.
+func (p *Plugin) forwardEvent(event *hookEvent) {
+	if !webhookHooks[event.Hook] || event.Outcome == eventOutcomeSkipped {
+		return
+	}
+
+	endpoints := p.getConfiguration().webhookEndpoints
+	if len(endpoints) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		p.API.LogWarn("Failed to marshal hook event for webhooks", "hook", event.Hook, "err", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, endpoint := range endpoints {
+		delivery := &webhookDelivery{
+			ID:          model.NewId(),
+			EndpointURL: endpoint.URL,
+			Hook:        event.Hook,
+			Payload:     payload,
+			CreatedAt:   now.UnixMilli(),
+		}
+
+		go p.attemptWebhookDelivery(delivery)
+	}
+}
+
+// attemptWebhookDelivery tries to deliver a delivery once. Failed deliveries are queued to be
+// retried with exponential backoff until they exhaust their attempts, at which point they are
+// moved to the dead letters.
+func (p *Plugin) attemptWebhookDelivery(delivery *webhookDelivery) {
+	configuration := p.getConfiguration()
+
+	var endpoint *webhookEndpoint
+	for i := range configuration.webhookEndpoints {
+		if configuration.webhookEndpoints[i].URL == delivery.EndpointURL {
+			endpoint = &configuration.webhookEndpoints[i]
+			break
+		}
+	}
+
+	var err error
+	if endpoint == nil {
+		err = errors.New("endpoint is no longer configured")
+		delivery.Attempts = configuration.WebhookMaxAttempts
+	} else {
+		err = sendWebhook(webhookHTTPClient, *endpoint, delivery)
+		delivery.Attempts++
+	}
+
+	if err == nil {
+		return
+	}
+
+	delivery.LastError = err.Error()
+	if delivery.Attempts < configuration.WebhookMaxAttempts {
+		delivery.NextAttemptAt = time.Now().Add(webhookBackoff(delivery.Attempts)).UnixMilli()
+		if err = p.webhookDeliveriesQueue().put(delivery); err != nil {
+			p.API.LogWarn("Failed to queue webhook delivery", "delivery_id", delivery.ID, "err", err.Error())
+		}
+		return
+	}
+
+	p.API.LogWarn("Giving up on webhook delivery", "delivery_id", delivery.ID, "url", delivery.EndpointURL, "err", delivery.LastError)
+	if _, err = p.client.KV.Set(webhookDeadLetterKeyPrefix+delivery.ID, delivery, pluginapi.SetExpiry(webhookDeadLetterRetention)); err != nil {
+		p.API.LogWarn("Failed to store webhook dead letter", "delivery_id", delivery.ID, "err", err.Error())
+	}
+}
+
+// WebhookRetryJob retries the queued webhook deliveries that are due. It is scheduled on a
+// cluster.Job, so only one plugin instance retries deliveries at a time.
+func (p *Plugin) WebhookRetryJob() {
+	p.webhookDeliveriesQueue().deliverDue(time.Now(), func(delivery *webhookDelivery) error {
+		p.attemptWebhookDelivery(delivery)
+		return nil
+	}, nil)
+}
+
+// listDeadLetters returns the dead letters, oldest first.
+func (p *Plugin) listDeadLetters() ([]*webhookDelivery, error) {
+	keys, err := p.listKeysWithPrefix(webhookDeadLetterKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]*webhookDelivery, 0, len(keys))
+	for _, key := range keys {
+		var delivery webhookDelivery
+		if err := p.client.KV.Get(key, &delivery); err != nil {
+			return nil, errors.Wrapf(err, "failed to get dead letter %s", key)
+		}
+		if delivery.ID == "" {
+			continue
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].CreatedAt < deliveries[j].CreatedAt
+	})
+
+	return deliveries, nil
+}
+
+// retryDeadLetter moves a dead letter back into the delivery queue with a fresh set of attempts.
+func (p *Plugin) retryDeadLetter(id string) error {
+	var delivery webhookDelivery
+	if err := p.client.KV.Get(webhookDeadLetterKeyPrefix+id, &delivery); err != nil {
+		return errors.Wrap(err, "failed to get dead letter")
+	}
+	if delivery.ID == "" {
+		return errors.Errorf("no dead letter with id %s", id)
+	}
+
+	delivery.Attempts = 0
+	delivery.NextAttemptAt = time.Now().UnixMilli()
+	if err := p.webhookDeliveriesQueue().put(&delivery); err != nil {
+		return errors.Wrap(err, "failed to queue delivery")
+	}
+
+	return p.client.KV.Delete(webhookDeadLetterKeyPrefix + id)
+}
+
+// webhooksSummary describes the configured endpoints, the delivery queue and the dead letters
+// in Markdown.
+func (p *Plugin) webhooksSummary() (string, error) {
+	configuration := p.getConfiguration()
+
+	pending, err := p.webhookDeliveriesQueue().listAll()
+	if err != nil {
+		return "", err
+	}
+	deadLetters, err := p.listDeadLetters()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("#### Webhook endpoints\n")
+	if len(configuration.webhookEndpoints) == 0 {
+		sb.WriteString("_No webhook endpoints are configured._\n")
+	}
+	for _, endpoint := range configuration.webhookEndpoints {
+		fmt.Fprintf(&sb, "- %s\n", endpoint.URL)
+	}
+
+	fmt.Fprintf(&sb, "\n%d deliveries are waiting to be retried.\n", len(pending))
+
+	sb.WriteString("\n#### Dead letters\n")
+	if len(deadLetters) == 0 {
+		sb.WriteString("_There are no dead letters._\n")
+		return sb.String(), nil
+	}
+
+	sb.WriteString("| ID | Endpoint | Hook | Attempts | Last error |\n")
+	sb.WriteString("|----|----------|------|----------|------------|\n")
+	for _, delivery := range deadLetters {
+		fmt.Fprintf(&sb, "| %s | %s | %s | %d | %s |\n", delivery.ID, delivery.EndpointURL, delivery.Hook, delivery.Attempts, delivery.LastError)
+	}
+
+	return sb.String(), nil
+}
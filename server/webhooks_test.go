@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWebhookEndpoints(t *testing.T) {
+	t.Run("valid endpoints", func(t *testing.T) {
+		endpoints, err := parseWebhookEndpoints("https://example.com/a secret1\n\n  http://localhost:8080/b   secret2  \n")
+		require.NoError(t, err)
+		assert.Equal(t, []webhookEndpoint{
+			{URL: "https://example.com/a", Secret: "secret1"},
+			{URL: "http://localhost:8080/b", Secret: "secret2"},
+		}, endpoints)
+	})
+
+	t.Run("missing secret", func(t *testing.T) {
+		_, err := parseWebhookEndpoints("https://example.com/a")
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid URL", func(t *testing.T) {
+		_, err := parseWebhookEndpoints("ftp://example.com/a secret")
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	assert.Equal(t, webhookInitialBackoff, webhookBackoff(1))
+	assert.Equal(t, 2*webhookInitialBackoff, webhookBackoff(2))
+	assert.Equal(t, 4*webhookInitialBackoff, webhookBackoff(3))
+	assert.Equal(t, webhookMaxBackoff, webhookBackoff(100))
+}
+
+func TestSendWebhook(t *testing.T) {
+	delivery := &webhookDelivery{
+		ID:      "delivery-id",
+		Hook:    hookMessageHasBeenPosted,
+		Payload: []byte(`{"hook":"MessageHasBeenPosted"}`),
+	}
+
+	t.Run("signed delivery", func(t *testing.T) {
+		var received *http.Request
+		var body []byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = r
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		err := sendWebhook(server.Client(), webhookEndpoint{URL: server.URL, Secret: "s3cret"}, delivery)
+		require.NoError(t, err)
+		require.NotNil(t, received)
+
+		mac := hmac.New(sha256.New, []byte("s3cret"))
+		mac.Write(body)
+		expectedSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+		assert.Equal(t, http.MethodPost, received.Method)
+		assert.Equal(t, []byte(delivery.Payload), body)
+		assert.Equal(t, "application/json", received.Header.Get("Content-Type"))
+		assert.Equal(t, expectedSignature, received.Header.Get(webhookSignatureHeader))
+		assert.Equal(t, hookMessageHasBeenPosted, received.Header.Get(webhookEventHeader))
+		assert.Equal(t, "delivery-id", received.Header.Get(webhookDeliveryHeader))
+	})
+
+	t.Run("failing receiver", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		err := sendWebhook(server.Client(), webhookEndpoint{URL: server.URL, Secret: "s3cret"}, delivery)
+		assert.Error(t, err)
+	})
+
+	t.Run("unreachable receiver", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		server.Close()
+
+		client := &http.Client{Timeout: time.Second}
+		err := sendWebhook(client, webhookEndpoint{URL: server.URL, Secret: "s3cret"}, delivery)
+		assert.Error(t, err)
+	})
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	cluster := newFakeCluster()
+	node := cluster.addNode(t)
+	config := node.getConfiguration().Clone()
+	config.webhookEndpoints = []webhookEndpoint{{URL: server.URL, Secret: "s3cret"}}
+	config.WebhookMaxAttempts = 3
+	node.setConfiguration(config)
+	node.API.(*fakeNodeAPI).API.On("LogWarn", "Giving up on webhook delivery", "delivery_id", "d2", "url", server.URL, "err", mock.Anything)
+
+	queue := node.webhookDeliveriesQueue()
+	// makeDue makes the queued delivery due, rather than waiting for its backoff.
+	makeDue := func(id string) *webhookDelivery {
+		t.Helper()
+		delivery, found, err := queue.take(id, id)
+		require.NoError(t, err)
+		require.True(t, found)
+		assert.Greater(t, delivery.NextAttemptAt, time.Now().UnixMilli())
+		delivery.NextAttemptAt = time.Now().UnixMilli()
+		require.NoError(t, queue.put(delivery))
+		return delivery
+	}
+
+	t.Run("failed deliveries are retried", func(t *testing.T) {
+		node.attemptWebhookDelivery(&webhookDelivery{ID: "d1", EndpointURL: server.URL, Hook: hookMessageHasBeenPosted, Payload: []byte(`{}`)})
+		delivery := makeDue("d1")
+		assert.Equal(t, 1, delivery.Attempts)
+
+		failing.Store(false)
+		node.WebhookRetryJob()
+		assert.Equal(t, int32(2), requests.Load())
+
+		pending, err := queue.listAll()
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+	})
+
+	t.Run("deliveries exhausting their attempts are dead letters", func(t *testing.T) {
+		failing.Store(true)
+		node.attemptWebhookDelivery(&webhookDelivery{ID: "d2", EndpointURL: server.URL, Hook: hookMessageHasBeenPosted, Payload: []byte(`{}`)})
+		makeDue("d2")
+		node.WebhookRetryJob()
+		makeDue("d2")
+		node.WebhookRetryJob()
+
+		pending, err := queue.listAll()
+		require.NoError(t, err)
+		assert.Empty(t, pending)
+
+		deadLetters, err := node.listDeadLetters()
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, 3, deadLetters[0].Attempts)
+		assert.Equal(t, "unexpected status code 500", deadLetters[0].LastError)
+
+		require.NoError(t, node.retryDeadLetter("d2"))
+		pending, err = queue.listAll()
+		require.NoError(t, err)
+		require.Len(t, pending, 1)
+		assert.Zero(t, pending[0].Attempts)
+	})
+
+	t.Run("the retry job doesn't list the KV store", func(t *testing.T) {
+		lists := cluster.kvLists
+		node.WebhookRetryJob()
+		assert.Equal(t, lists, cluster.kvLists)
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// defaultTimeOfDay is the time used when only a day is given, in minutes since midnight.
+const defaultTimeOfDay = 9 * 60
+
+var relativeDurationRegexp = regexp.MustCompile(`^(?:(\d+)d)?(?:(\d+)h)?(?:(\d+)m)?(?:(\d+)s)?$`)
+
+// parseWhen parses the time at the beginning of the text, in the given location, and returns it
+// along with the rest of the text. Supported are durations relative to now, e.g. "in 10m" or
+// "1d2h", and absolute times, e.g. "17:30", "tomorrow 9:00", "2024-01-15 17:30" or "2024-01-15",
+// optionally prefixed with "at" or "on". Times of the day already past today are tomorrow, and
+// days without a time are at 9:00.
+func parseWhen(text string, now time.Time, location *time.Location) (time.Time, string, error) {
+	now = now.In(location)
+
+	word, rest := nextWord(text)
+	word = strings.ToLower(word)
+	if word == "" {
+		return time.Time{}, "", errors.New("missing time")
+	}
+
+	if word == "in" {
+		word, rest = nextWord(rest)
+		duration, ok := parseRelativeDuration(word)
+		if !ok {
+			return time.Time{}, "", errors.Errorf("invalid duration %q, expected e.g. 10m, 2h or 1d", word)
+		}
+		return now.Add(duration), rest, nil
+	}
+	if duration, ok := parseRelativeDuration(word); ok {
+		return now.Add(duration), rest, nil
+	}
+
+	if word == "at" || word == "on" {
+		word, rest = nextWord(rest)
+		word = strings.ToLower(word)
+	}
+
+	var day time.Time
+	switch {
+	case word == "today":
+		day = now
+	case word == "tomorrow":
+		day = now.AddDate(0, 0, 1)
+	default:
+		if date, err := time.ParseInLocation(time.DateOnly, word, location); err == nil {
+			day = date
+			break
+		}
+
+		minutes, err := parseClock(word)
+		if err != nil {
+			return time.Time{}, "", errors.Errorf("invalid time %q, expected e.g. in 10m, 17:30, tomorrow 9:00 or 2024-01-15 17:30", word)
+		}
+		when := atTimeOfDay(now, minutes)
+		if !when.After(now) {
+			when = atTimeOfDay(now.AddDate(0, 0, 1), minutes)
+		}
+		return when, rest, nil
+	}
+
+	// The time of the day is optional after a day.
+	minutes := defaultTimeOfDay
+	next, nextRest := nextWord(rest)
+	if strings.EqualFold(next, "at") {
+		next, nextRest = nextWord(nextRest)
+	}
+	if clock, err := parseClock(next); err == nil {
+		minutes, rest = clock, nextRest
+	}
+
+	when := atTimeOfDay(day, minutes)
+	if !when.After(now) {
+		return time.Time{}, "", errors.Errorf("%s is in the past", when.Format("2006-01-02 15:04 MST"))
+	}
+
+	return when, rest, nil
+}
+
+// parseRelativeDuration parses a duration in days, hours, minutes and seconds, e.g. 1d12h or 90m.
+func parseRelativeDuration(value string) (time.Duration, bool) {
+	matches := relativeDurationRegexp.FindStringSubmatch(value)
+	if value == "" || matches == nil {
+		return 0, false
+	}
+
+	var duration time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if matches[i+1] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return 0, false
+		}
+		duration += time.Duration(n) * unit
+	}
+
+	return duration, duration > 0
+}
+
+// atTimeOfDay returns the given time of the day, in minutes since midnight, on the day of t.
+func atTimeOfDay(t time.Time, minutes int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, minutes, 0, 0, t.Location())
+}
+
+// nextWord returns the first word of the text, and the rest of the text after the spaces
+// following it.
+func nextWord(text string) (string, string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	end := strings.IndexFunc(text, unicode.IsSpace)
+	if end < 0 {
+		return text, ""
+	}
+
+	return text[:end], strings.TrimLeftFunc(text[end:], unicode.IsSpace)
+}
+
+// userLocation returns the location of the preferred timezone of the user, UTC by default.
+func (p *Plugin) userLocation(userID string) *time.Location {
+	user, appErr := p.API.GetUser(userID)
+	if appErr != nil {
+		return time.UTC
+	}
+
+	location, err := time.LoadLocation(model.GetPreferredTimezone(user.Timezone))
+	if err != nil {
+		return time.UTC
+	}
+
+	return location
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWhen(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+	// A Monday afternoon in Paris.
+	now := time.Date(2024, time.January, 15, 14, 0, 0, 0, paris)
+
+	for text, expected := range map[string]struct {
+		when time.Time
+		rest string
+	}{
+		"in 10m hello":               {now.Add(10 * time.Minute), "hello"},
+		"1d2h  hello  world":         {now.Add(26 * time.Hour), "hello  world"},
+		"90s":                        {now.Add(90 * time.Second), ""},
+		"17:30 hello":                {time.Date(2024, time.January, 15, 17, 30, 0, 0, paris), "hello"},
+		"at 9:00 hello":              {time.Date(2024, time.January, 16, 9, 0, 0, 0, paris), "hello"},
+		"tomorrow hello":             {time.Date(2024, time.January, 16, 9, 0, 0, 0, paris), "hello"},
+		"Tomorrow at 18:15 hello":    {time.Date(2024, time.January, 16, 18, 15, 0, 0, paris), "hello"},
+		"today 23:00 hello":          {time.Date(2024, time.January, 15, 23, 0, 0, 0, paris), "hello"},
+		"2024-02-01 hello":           {time.Date(2024, time.February, 1, 9, 0, 0, 0, paris), "hello"},
+		"on 2024-02-01 12:00 hello":  {time.Date(2024, time.February, 1, 12, 0, 0, 0, paris), "hello"},
+		"2024-03-31 02:30 dst":       {time.Date(2024, time.March, 31, 2, 30, 0, 0, paris), "dst"},
+		"in 1d 2024-01-01 is a date": {now.Add(24 * time.Hour), "2024-01-01 is a date"},
+	} {
+		when, rest, err := parseWhen(text, now.UTC(), paris)
+		require.NoError(t, err, text)
+		assert.True(t, expected.when.Equal(when), "%s: expected %s, got %s", text, expected.when, when)
+		assert.Equal(t, paris, when.Location(), text)
+		assert.Equal(t, expected.rest, rest, text)
+	}
+
+	for _, text := range []string{"", "in", "in soon", "0m", "soon hello", "25:00", "today 13:00", "2024-01-01 hello", "2024-13-01"} {
+		_, _, err := parseWhen(text, now, paris)
+		assert.Error(t, err, text)
+	}
+}
@@ -2,6 +2,7 @@ import {id as pluginId} from './manifest';
 
 // Namespace your actions to avoid collisions.
 export const STATUS_CHANGE = pluginId + '_status_change';
+export const HOOKS_CHANGE = pluginId + '_hooks_change';
 
 export const OPEN_ROOT_MODAL = pluginId + '_open_root_modal';
 export const CLOSE_ROOT_MODAL = pluginId + '_close_root_modal';
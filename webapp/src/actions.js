@@ -1,7 +1,7 @@
 import {getConfig} from 'mattermost-redux/selectors/entities/general';
 
 import {id as pluginId} from './manifest';
-import {STATUS_CHANGE, OPEN_ROOT_MODAL, CLOSE_ROOT_MODAL, SUBMENU} from './action_types';
+import {STATUS_CHANGE, HOOKS_CHANGE, OPEN_ROOT_MODAL, CLOSE_ROOT_MODAL, SUBMENU} from './action_types';
 
 export const openRootModal = (subMenuText = '') => (dispatch) => {
     dispatch({
@@ -48,10 +48,20 @@ export const getStatus = () => async (dispatch, getState) => {
             type: STATUS_CHANGE,
             data: r.enabled,
         });
+        dispatch({
+            type: HOOKS_CHANGE,
+            data: r.hooks,
+        });
     });
 };
 
-export const websocketStatusChange = (message) => (dispatch) => dispatch({
-    type: STATUS_CHANGE,
-    data: message.data.enabled,
-});
+export const websocketStatusChange = (message) => (dispatch) => {
+    dispatch({
+        type: STATUS_CHANGE,
+        data: message.data.enabled,
+    });
+    dispatch({
+        type: HOOKS_CHANGE,
+        data: message.data.hooks,
+    });
+};
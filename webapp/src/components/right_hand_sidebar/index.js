@@ -3,12 +3,13 @@ import {connect} from 'react-redux';
 import {getCurrentTeam} from 'mattermost-redux/selectors/entities/teams';
 import {getCurrentChannel} from 'mattermost-redux/selectors/entities/channels';
 
-import {isEnabled} from 'selectors';
+import {getHooks, isEnabled} from 'selectors';
 
 import RHSView from './rhs_view';
 
 const mapStateToProps = (state) => ({
     enabled: isEnabled(state),
+    hooks: getHooks(state),
     team: getCurrentTeam(state),
     channel: getCurrentChannel(state),
 });
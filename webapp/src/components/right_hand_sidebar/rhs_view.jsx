@@ -5,7 +5,7 @@ import {FormattedMessage} from 'react-intl';
 
 import {id as pluginId} from '../../manifest';
 
-export default function RHSView({team, channel}) {
+export default function RHSView({team, channel, hooks}) {
     const [autoPopout, setAutoPopout] = useState(false);
     const popoutSupported = Boolean(window.WebappUtils?.popouts?.popoutRhsPlugin);
 
@@ -60,6 +60,16 @@ export default function RHSView({team, channel}) {
             <br/>
             <hr/>
             <br/>
+            <strong>{'Hooks'}</strong>
+            <ul>
+                {Object.keys(hooks || {}).sort().map((hook) => (
+                    <li key={hook}>
+                        {`${hook}: ${hooks[hook] ? 'enabled' : 'disabled'}`}
+                    </li>
+                ))}
+            </ul>
+            <hr/>
+            <br/>
             <strong>{'Pop Out RHS Demo'}</strong>
             <br/>
             <br/>
@@ -92,6 +102,7 @@ export default function RHSView({team, channel}) {
 RHSView.propTypes = {
     team: PropTypes.object.isRequired,
     channel: PropTypes.object.isRequired,
+    hooks: PropTypes.objectOf(PropTypes.bool),
 };
 
 const style = {
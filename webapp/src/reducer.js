@@ -1,6 +1,6 @@
 import {combineReducers} from 'redux';
 
-import {STATUS_CHANGE, OPEN_ROOT_MODAL, CLOSE_ROOT_MODAL, SUBMENU} from './action_types';
+import {STATUS_CHANGE, HOOKS_CHANGE, OPEN_ROOT_MODAL, CLOSE_ROOT_MODAL, SUBMENU} from './action_types';
 
 const enabled = (state = false, action) => {
     switch (action.type) {
@@ -12,6 +12,17 @@ const enabled = (state = false, action) => {
     }
 };
 
+// hooks maps each hook name to whether it is currently enabled.
+const hooks = (state = {}, action) => {
+    switch (action.type) {
+    case HOOKS_CHANGE:
+        return action.data || {};
+
+    default:
+        return state;
+    }
+};
+
 const rootModalVisible = (state = false, action) => {
     switch (action.type) {
     case OPEN_ROOT_MODAL:
@@ -35,6 +46,7 @@ const subMenu = (state = '', action) => {
 
 export default combineReducers({
     enabled,
+    hooks,
     rootModalVisible,
     subMenu,
 });
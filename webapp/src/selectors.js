@@ -4,6 +4,8 @@ const getPluginState = (state) => state['plugins-' + pluginId] || {};
 
 export const isEnabled = (state) => getPluginState(state).enabled;
 
+export const getHooks = (state) => getPluginState(state).hooks;
+
 export const isRootModalVisible = (state) => getPluginState(state).rootModalVisible;
 
 export const subMenu = (state) => getPluginState(state).subMenu;